@@ -0,0 +1,707 @@
+package music_watch
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrActivityPub       = errors.New("invalid ActivityPub settings")
+	ErrInvalidSignature  = errors.New("invalid HTTP signature")
+	errUnsupportedObject = errors.New("unsupported activity")
+)
+
+const (
+	activityStreams     = "https://www.w3.org/ns/activitystreams"
+	activityStreamsType = "application/activity+json"
+	publicAudience      = activityStreams + "#Public"
+	// Number of notes listed in the outbox
+	outboxSize = 20
+	// Largest inbox request that is read
+	maxInboxBody = 1 << 20
+	// Signed requests older than this are rejected, so that they cannot be replayed
+	maxSignatureAge = 12 * time.Hour
+)
+
+// Settings for publishing plays to the Fediverse
+type ActivityPubConfig struct {
+	// The public address of the HTTP interface, e.g. https://music.example.com; empty disables publishing.
+	// The actor is found as @<username>@<host>.
+	URL      string `json:"url"`
+	Username string `json:"username"` // Defaults to "music"
+	Name     string `json:"name"`
+	Summary  string `json:"summary"`
+	// Collect plays for this many minutes into a single note; 0 publishes a note for each play
+	BatchMinutes int `json:"batchMinutes"`
+}
+
+// A minimal ActivityPub actor that publishes plays as notes to its followers
+type ActivityPub struct {
+	Client *http.Client
+
+	config  ActivityPubConfig
+	base    string // config.URL without a trailing slash
+	db      *sql.DB
+	key     *rsa.PrivateKey
+	events  chan Event
+	flushes chan chan error
+	cancel  context.CancelFunc
+	done    chan struct{}
+
+	lock    sync.Mutex
+	lastErr error
+}
+
+// Set up the actor, creating its key on first use
+func NewActivityPub(ctx context.Context, config ActivityPubConfig, db *sql.DB) (*ActivityPub, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || len(u.Host) == 0 {
+		return nil, fmt.Errorf("%w: %q is not an HTTP URL", ErrActivityPub, config.URL)
+	}
+	if len(config.Username) == 0 {
+		config.Username = "music"
+	}
+	a := ActivityPub{config: config, base: strings.TrimSuffix(config.URL, "/"), db: db}
+	if a.key, err = activityPubKey(ctx, db); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// Load the actor's signing key, or create it if there is none
+func activityPubKey(ctx context.Context, db *sql.DB) (*rsa.PrivateKey, error) {
+	var encoded string
+	err := db.QueryRowContext(ctx, "SELECT pem FROM ActivityPubKey ORDER BY id DESC LIMIT 1").Scan(&encoded)
+	if errors.Is(err, sql.ErrNoRows) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+		_, err = db.ExecContext(ctx, "INSERT INTO ActivityPubKey (pem, created) VALUES (?, ?)", encoded, time.Now().Format(time.DateTime))
+		return key, err
+	} else if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode([]byte(encoded))
+	if block == nil {
+		return nil, fmt.Errorf("%w: the stored key is not PEM", ErrActivityPub)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: the stored key is not an RSA key", ErrActivityPub)
+	}
+	return key, nil
+}
+
+func (a *ActivityPub) actorID() string {
+	return a.base + "/ap/actor"
+}
+
+func (a *ActivityPub) keyID() string {
+	return a.actorID() + "#main-key"
+}
+
+func (a *ActivityPub) noteID(id int64) string {
+	return fmt.Sprintf("%s/ap/notes/%d", a.base, id)
+}
+
+// Add the actor's documents and inbox to the HTTP interface
+func (a *ActivityPub) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /.well-known/webfinger", a.serveWebFinger)
+	mux.HandleFunc("GET /ap/actor", a.serveActor)
+	mux.HandleFunc("POST /ap/inbox", a.serveInbox)
+	mux.HandleFunc("GET /ap/outbox", a.serveOutbox)
+	mux.HandleFunc("GET /ap/followers", a.serveFollowers)
+	mux.HandleFunc("GET /ap/notes/{id}", a.serveNote)
+}
+
+func writeActivity(w http.ResponseWriter, contentType string, v any) {
+	w.Header().Set("Content-Type", contentType)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (a *ActivityPub) serveWebFinger(w http.ResponseWriter, r *http.Request) {
+	host := strings.TrimPrefix(strings.TrimPrefix(a.base, "https://"), "http://")
+	subject := "acct:" + a.config.Username + "@" + host
+	if r.URL.Query().Get("resource") != subject && r.URL.Query().Get("resource") != a.actorID() {
+		http.NotFound(w, r)
+		return
+	}
+	writeActivity(w, "application/jrd+json", map[string]any{
+		"subject": subject,
+		"aliases": []string{a.actorID()},
+		"links": []map[string]string{
+			{"rel": "self", "type": activityStreamsType, "href": a.actorID()},
+		},
+	})
+}
+
+func (a *ActivityPub) serveActor(w http.ResponseWriter, r *http.Request) {
+	der, err := x509.MarshalPKIXPublicKey(&a.key.PublicKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	name := a.config.Name
+	if len(name) == 0 {
+		name = a.config.Username
+	}
+	writeActivity(w, activityStreamsType, map[string]any{
+		"@context":                  []string{activityStreams, "https://w3id.org/security/v1"},
+		"id":                        a.actorID(),
+		"type":                      "Service",
+		"preferredUsername":         a.config.Username,
+		"name":                      name,
+		"summary":                   html.EscapeString(a.config.Summary),
+		"url":                       a.base + "/",
+		"inbox":                     a.base + "/ap/inbox",
+		"outbox":                    a.base + "/ap/outbox",
+		"followers":                 a.base + "/ap/followers",
+		"manuallyApprovesFollowers": false,
+		"discoverable":              true,
+		"publicKey": map[string]string{
+			"id":           a.keyID(),
+			"owner":        a.actorID(),
+			"publicKeyPem": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		},
+	})
+}
+
+func (a *ActivityPub) serveFollowers(w http.ResponseWriter, r *http.Request) {
+	var count int
+	if err := a.db.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM ActivityPubFollower").Scan(&count); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Followers are not listed, only counted
+	writeActivity(w, activityStreamsType, map[string]any{
+		"@context":   activityStreams,
+		"id":         a.base + "/ap/followers",
+		"type":       "OrderedCollection",
+		"totalItems": count,
+	})
+}
+
+// A published note, as stored
+type activityNote struct {
+	id        int64
+	content   string
+	published time.Time
+}
+
+func (a *ActivityPub) note(n activityNote) map[string]any {
+	return map[string]any{
+		"id":           a.noteID(n.id),
+		"type":         "Note",
+		"attributedTo": a.actorID(),
+		"content":      n.content,
+		"published":    n.published.UTC().Format(time.RFC3339),
+		"to":           []string{publicAudience},
+		"cc":           []string{a.base + "/ap/followers"},
+	}
+}
+
+func (a *ActivityPub) create(n activityNote) map[string]any {
+	return map[string]any{
+		"@context":  activityStreams,
+		"id":        a.noteID(n.id) + "/activity",
+		"type":      "Create",
+		"actor":     a.actorID(),
+		"published": n.published.UTC().Format(time.RFC3339),
+		"to":        []string{publicAudience},
+		"cc":        []string{a.base + "/ap/followers"},
+		"object":    a.note(n),
+	}
+}
+
+func (a *ActivityPub) serveNote(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	n := activityNote{id: id}
+	var published string
+	err = a.db.QueryRowContext(r.Context(), "SELECT content, strftime('%Y-%m-%d %H:%M:%S', published) FROM ActivityPubNote WHERE id = ?", id).Scan(&n.content, &published)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	n.published, _ = time.ParseInLocation(time.DateTime, published, time.Local)
+	note := a.note(n)
+	note["@context"] = activityStreams
+	writeActivity(w, activityStreamsType, note)
+}
+
+func (a *ActivityPub) serveOutbox(w http.ResponseWriter, r *http.Request) {
+	var total int
+	if err := a.db.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM ActivityPubNote").Scan(&total); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rows, err := a.db.QueryContext(r.Context(), "SELECT id, content, strftime('%Y-%m-%d %H:%M:%S', published) FROM ActivityPubNote ORDER BY id DESC LIMIT ?", outboxSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	items := []any{}
+	for rows.Next() {
+		var n activityNote
+		var published string
+		if err := rows.Scan(&n.id, &n.content, &published); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		n.published, _ = time.ParseInLocation(time.DateTime, published, time.Local)
+		activity := a.create(n)
+		delete(activity, "@context")
+		items = append(items, activity)
+	}
+	// Only the latest notes are listed
+	writeActivity(w, activityStreamsType, map[string]any{
+		"@context":     activityStreams,
+		"id":           a.base + "/ap/outbox",
+		"type":         "OrderedCollection",
+		"totalItems":   total,
+		"orderedItems": items,
+	})
+}
+
+// The fields of incoming activities that are used
+type inboxActivity struct {
+	ID     string          `json:"id"`
+	Type   string          `json:"type"`
+	Actor  string          `json:"actor"`
+	Object json.RawMessage `json:"object"`
+}
+
+// The fields of remote actors that are used
+type remoteActor struct {
+	ID        string `json:"id"`
+	Inbox     string `json:"inbox"`
+	Endpoints struct {
+		SharedInbox string `json:"sharedInbox"`
+	} `json:"endpoints"`
+	PublicKey struct {
+		ID           string `json:"id"`
+		Owner        string `json:"owner"`
+		PublicKeyPem string `json:"publicKeyPem"`
+	} `json:"publicKey"`
+}
+
+func (a *ActivityPub) serveInbox(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxInboxBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var activity inboxActivity
+	if err := json.Unmarshal(body, &activity); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	actor, err := a.verifyRequest(r, body)
+	if err != nil || actor.ID != activity.Actor {
+		slog.DebugContext(r.Context(), "Rejected inbox request", "Actor", activity.Actor, "Error", err)
+		http.Error(w, "the request is not signed by the activity's actor", http.StatusUnauthorized)
+		return
+	}
+	switch err := a.handleActivity(r.Context(), activity, actor); {
+	case errors.Is(err, errUnsupportedObject):
+		// Replies, likes and boosts are not stored
+		w.WriteHeader(http.StatusAccepted)
+	case err != nil:
+		slog.ErrorContext(r.Context(), "Unable to handle activity", "Type", activity.Type, "Actor", activity.Actor, "Error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (a *ActivityPub) handleActivity(ctx context.Context, activity inboxActivity, actor *remoteActor) error {
+	switch activity.Type {
+	case "Follow":
+		var object string
+		if json.Unmarshal(activity.Object, &object) != nil || object != a.actorID() {
+			return errUnsupportedObject
+		}
+		inbox := actor.Inbox
+		if len(actor.Endpoints.SharedInbox) > 0 {
+			inbox = actor.Endpoints.SharedInbox
+		}
+		_, err := a.db.ExecContext(
+			ctx,
+			`INSERT INTO ActivityPubFollower (actor, inbox, followed) VALUES (?, ?, ?)
+			ON CONFLICT (actor) DO UPDATE SET inbox = excluded.inbox`,
+			actor.ID, inbox, time.Now().Format(time.DateTime),
+		)
+		if err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "New follower", "Actor", actor.ID)
+		accept := map[string]any{
+			"@context": activityStreams,
+			"id":       fmt.Sprintf("%s/ap/accepts/%d", a.base, time.Now().UnixNano()),
+			"type":     "Accept",
+			"actor":    a.actorID(),
+			"object":   activity,
+		}
+		go func() {
+			if err := a.deliver(context.WithoutCancel(ctx), actor.Inbox, accept); err != nil {
+				slog.WarnContext(ctx, "Unable to accept follower", "Actor", actor.ID, "Error", err)
+			}
+		}()
+		return nil
+	case "Undo":
+		var undone inboxActivity
+		if json.Unmarshal(activity.Object, &undone) != nil || undone.Type != "Follow" {
+			return errUnsupportedObject
+		}
+		_, err := a.db.ExecContext(ctx, "DELETE FROM ActivityPubFollower WHERE actor = ?", actor.ID)
+		if err == nil {
+			slog.InfoContext(ctx, "Lost follower", "Actor", actor.ID)
+		}
+		return err
+	}
+	return errUnsupportedObject
+}
+
+// Check the request's signature, returning the actor that signed it
+func (a *ActivityPub) verifyRequest(r *http.Request, body []byte) (*remoteActor, error) {
+	params := make(map[string]string)
+	for _, part := range strings.Split(r.Header.Get("Signature"), ",") {
+		if name, value, ok := strings.Cut(strings.TrimSpace(part), "="); ok {
+			params[name] = strings.Trim(value, `"`)
+		}
+	}
+	signature, err := base64.StdEncoding.DecodeString(params["signature"])
+	if err != nil || len(params["keyId"]) == 0 || len(params["headers"]) == 0 {
+		return nil, fmt.Errorf("%w: missing signature", ErrInvalidSignature)
+	}
+	headers := strings.Fields(params["headers"])
+	for _, required := range []string{"(request-target)", "host", "date", "digest"} {
+		if !containsFold(headers, required) {
+			return nil, fmt.Errorf("%w: %s is not signed", ErrInvalidSignature, required)
+		}
+	}
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil || time.Since(date).Abs() > maxSignatureAge {
+		return nil, fmt.Errorf("%w: the date is missing or too old", ErrInvalidSignature)
+	}
+	if r.Header.Get("Digest") != bodyDigest(body) {
+		return nil, fmt.Errorf("%w: the digest does not match the body", ErrInvalidSignature)
+	}
+	actor, err := a.fetchActor(r.Context(), params["keyId"])
+	if err != nil {
+		return nil, err
+	}
+	if actor.PublicKey.ID != params["keyId"] || actor.PublicKey.Owner != actor.ID {
+		return nil, fmt.Errorf("%w: the key does not belong to the actor", ErrInvalidSignature)
+	}
+	block, _ := pem.Decode([]byte(actor.PublicKey.PublicKeyPem))
+	if block == nil {
+		return nil, fmt.Errorf("%w: the actor's key is not PEM", ErrInvalidSignature)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: only RSA keys are supported", ErrInvalidSignature)
+	}
+	digest := sha256.Sum256([]byte(signingString(r, headers)))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, errors.Join(ErrInvalidSignature, err)
+	}
+	return actor, nil
+}
+
+func containsFold(values []string, target string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func bodyDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Build the string that is signed for the listed headers
+func signingString(r *http.Request, headers []string) string {
+	lines := make([]string, len(headers))
+	for i, name := range headers {
+		name = strings.ToLower(name)
+		switch name {
+		case "(request-target)":
+			lines[i] = name + ": " + strings.ToLower(r.Method) + " " + r.URL.RequestURI()
+		case "host":
+			lines[i] = name + ": " + r.Host
+		default:
+			lines[i] = name + ": " + r.Header.Get(name)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Sign a request as the actor
+func (a *ActivityPub) sign(r *http.Request, body []byte) error {
+	r.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	r.Host = r.URL.Host
+	headers := []string{"(request-target)", "host", "date"}
+	if body != nil {
+		r.Header.Set("Digest", bodyDigest(body))
+		headers = append(headers, "digest")
+	}
+	digest := sha256.Sum256([]byte(signingString(r, headers)))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Signature", fmt.Sprintf(
+		`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		a.keyID(), strings.Join(headers, " "), base64.StdEncoding.EncodeToString(signature),
+	))
+	return nil
+}
+
+func (a *ActivityPub) client() *http.Client {
+	if a.Client != nil {
+		return a.Client
+	}
+	return scrobbleClient
+}
+
+// Get a remote actor's document; requests are signed, as some servers require it
+func (a *ActivityPub) fetchActor(ctx context.Context, id string) (*remoteActor, error) {
+	id, _, _ = strings.Cut(id, "#")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, id, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", activityStreamsType)
+	req.Header.Set("User-Agent", "music-watcher")
+	if err := a.sign(req, nil); err != nil {
+		return nil, err
+	}
+	resp, err := a.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching actor %s returned %s", id, resp.Status)
+	}
+	var actor remoteActor
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxInboxBody)).Decode(&actor); err != nil {
+		return nil, err
+	}
+	if len(actor.Inbox) == 0 {
+		return nil, fmt.Errorf("actor %s has no inbox", id)
+	}
+	return &actor, nil
+}
+
+// Post an activity to an inbox
+func (a *ActivityPub) deliver(ctx context.Context, inbox string, activity any) error {
+	body, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, inbox, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", activityStreamsType)
+	req.Header.Set("User-Agent", "music-watcher")
+	if err := a.sign(req, body); err != nil {
+		return err
+	}
+	resp, err := a.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("delivering to %s returned %s", inbox, resp.Status)
+	}
+	return nil
+}
+
+// Describe the plays in a note
+func noteContent(plays []Event) string {
+	describe := func(m *Metadata) string {
+		s := "<b>" + html.EscapeString(m.Title) + "</b>"
+		if len(m.Artist) > 0 {
+			s += " by " + html.EscapeString(strings.Join(m.Artist, ", "))
+		}
+		return s
+	}
+	if len(plays) == 1 {
+		return "<p>Listening to " + describe(plays[0].Track) + "</p>"
+	}
+	lines := make([]string, len(plays))
+	for i, play := range plays {
+		lines[i] = describe(play.Track)
+	}
+	return "<p>Recently played:<br>" + strings.Join(lines, "<br>") + "</p>"
+}
+
+// Store a note for the plays and send it to every follower
+func (a *ActivityPub) publish(ctx context.Context, plays []Event) error {
+	n := activityNote{content: noteContent(plays), published: time.Now()}
+	res, err := a.db.ExecContext(ctx, "INSERT INTO ActivityPubNote (content, published) VALUES (?, ?)", n.content, n.published.Format(time.DateTime))
+	if err != nil {
+		return err
+	}
+	if n.id, err = res.LastInsertId(); err != nil {
+		return err
+	}
+	// Followers on the same server share an inbox
+	inboxes, err := queryStrings(ctx, a.db, "SELECT DISTINCT inbox FROM ActivityPubFollower")
+	if err != nil {
+		return err
+	}
+	activity := a.create(n)
+	var errs []error
+	for _, inbox := range inboxes {
+		errs = append(errs, a.deliver(ctx, inbox, activity))
+	}
+	slog.DebugContext(ctx, "Published note", "Plays", len(plays), "Inboxes", len(inboxes))
+	return errors.Join(errs...)
+}
+
+func (a *ActivityPub) Name() string {
+	return "activitypub"
+}
+
+func (a *ActivityPub) Init(ctx context.Context) error {
+	// Batched plays are still published by Flush after the caller's context is cancelled
+	ctx, a.cancel = context.WithCancel(context.WithoutCancel(ctx))
+	a.events = make(chan Event, subscriberBuffer)
+	a.flushes = make(chan chan error)
+	a.done = make(chan struct{})
+	go a.run(ctx)
+	return nil
+}
+
+func (a *ActivityPub) Store(ctx context.Context, e Event) error {
+	if e.Type != EventScrobble {
+		return nil
+	}
+	select {
+	case a.events <- e:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Publish the plays collected for the current batch
+func (a *ActivityPub) Flush(ctx context.Context) error {
+	result := make(chan error, 1)
+	select {
+	case a.flushes <- result:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (a *ActivityPub) Close() error {
+	a.cancel()
+	<-a.done
+	return nil
+}
+
+func (a *ActivityPub) health() (int, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return 0, a.lastErr
+}
+
+func (a *ActivityPub) run(ctx context.Context) {
+	defer close(a.done)
+	var pending []Event
+	var batch <-chan time.Time
+	if a.config.BatchMinutes > 0 {
+		ticker := time.NewTicker(time.Duration(a.config.BatchMinutes) * time.Minute)
+		defer ticker.Stop()
+		batch = ticker.C
+	}
+	publish := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		err := a.publish(ctx, pending)
+		if err != nil {
+			// The note is in the outbox, so followers can still find it
+			slog.WarnContext(ctx, "Unable to deliver note to every follower", "Error", err)
+		}
+		pending = nil
+		a.lock.Lock()
+		a.lastErr = err
+		a.lock.Unlock()
+		return err
+	}
+	for {
+		select {
+		case e := <-a.events:
+			pending = append(pending, e)
+			if batch == nil {
+				publish()
+			}
+		case <-batch:
+			publish()
+		case result := <-a.flushes:
+			result <- publish()
+		case <-ctx.Done():
+			if len(pending) > 0 {
+				slog.WarnContext(ctx, "Stopping with plays that were not published", "Count", len(pending))
+			}
+			return
+		}
+	}
+}
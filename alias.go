@@ -0,0 +1,117 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidAlias = errors.New("invalid alias")
+
+// Another name of a person
+type PersonAlias struct {
+	Alias  string
+	Person string
+}
+
+// Get every alias, by the person's name
+func GetPersonAliases(ctx context.Context, db *sql.DB) ([]PersonAlias, error) {
+	rows, err := db.QueryContext(
+		ctx,
+		"SELECT a.alias, p.name FROM Person_Alias a JOIN Person p ON p.id = a.person ORDER BY p.name, a.alias",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var aliases []PersonAlias
+	for rows.Next() {
+		var alias PersonAlias
+		if err := rows.Scan(&alias.Alias, &alias.Person); err != nil {
+			return nil, err
+		}
+		aliases = append(aliases, alias)
+	}
+	return aliases, rows.Err()
+}
+
+// Make the names aliases of the person, creating the person if there is none with the name.
+// The tracks and albums of persons with the names are moved to the person, and names later stored
+// for tracks are stored as the person, so that "Beatles" and "The Beatles" are one artist.
+func MergePersons(ctx context.Context, db *sql.DB, name string, aliases ...string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var canonical string
+	err = tx.QueryRowContext(ctx, "SELECT p.name FROM Person_Alias a JOIN Person p ON p.id = a.person WHERE a.alias = ?", name).Scan(&canonical)
+	if err == nil {
+		return fmt.Errorf("%w: %q is already an alias of %q", ErrInvalidAlias, name, canonical)
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+	keep, err := getPerson(ctx, tx, name, nil)
+	if err != nil {
+		return err
+	}
+	for _, alias := range aliases {
+		if len(alias) == 0 || alias == name {
+			return fmt.Errorf("%w: %q cannot be an alias of %q", ErrInvalidAlias, alias, name)
+		}
+		var duplicate int64
+		err := tx.QueryRowContext(ctx, "SELECT id FROM Person WHERE name = ?", alias).Scan(&duplicate)
+		if err == nil {
+			if err := mergePerson(ctx, tx, keep, duplicate); err != nil {
+				return err
+			}
+		} else if err != sql.ErrNoRows {
+			return err
+		}
+		_, err = tx.ExecContext(
+			ctx,
+			"INSERT INTO Person_Alias (alias, person) VALUES (?, ?) ON CONFLICT (alias) DO UPDATE SET person = excluded.person",
+			alias,
+			keep,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	// Albums are told apart by their artists' names, which may have changed
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE Album SET artistKey = NULL WHERE id IN (
+			SELECT t.album FROM Track t JOIN Track_Person tp ON tp.track = t.id WHERE tp.person = ?
+		)`,
+		keep,
+	)
+	if err != nil {
+		return err
+	}
+	if err := backfillAlbumArtistKeys(ctx, tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Move the tracks, albums and aliases of the duplicate to the person that is kept, and delete the duplicate
+func mergePerson(ctx context.Context, tx *sql.Tx, keep, duplicate int64) error {
+	for _, stmt := range []string{
+		"UPDATE Person SET mbid = COALESCE(mbid, (SELECT d.mbid FROM Person d WHERE d.id = ?2)) WHERE id = ?1",
+		// Relations without a role are not unique, so those the kept person has are left out
+		`INSERT OR IGNORE INTO Track_Person (track, person, role) SELECT track, ?1, role FROM Track_Person d
+		WHERE person = ?2 AND NOT EXISTS (SELECT 1 FROM Track_Person k WHERE k.track = d.track AND k.person = ?1 AND k.role IS d.role)`,
+		"DELETE FROM Track_Person WHERE person = ?2",
+		"INSERT OR IGNORE INTO Album_Person (album, person) SELECT album, ?1 FROM Album_Person WHERE person = ?2",
+		"DELETE FROM Album_Person WHERE person = ?2",
+		"UPDATE Person_Alias SET person = ?1 WHERE person = ?2",
+		"DELETE FROM Person WHERE id = ?2",
+	} {
+		if _, err := tx.ExecContext(ctx, stmt, keep, duplicate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
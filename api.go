@@ -0,0 +1,399 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidQuery = errors.New("invalid query")
+
+// Page sizes for the history API
+const (
+	defaultAPILimit = 50
+	maxAPILimit     = 500
+)
+
+// A play returned by the history API
+type Listen struct {
+	ID        int64    `json:"id"`
+	UID       string   `json:"uid,omitempty"` // A ULID, which identifies the play across databases, unlike its ID
+	Timestamp string   `json:"timestamp"`     // RFC 3339
+	Title     string   `json:"title"`
+	Album     string   `json:"album,omitempty"`
+	Artists   []string `json:"artists,omitempty"`
+	Url       string   `json:"url,omitempty"`
+	// The MusicBrainz release group of the album, if it is known
+	ReleaseGroup string `json:"releaseGroup,omitempty"`
+	// Where the album's cover was downloaded from, if it has been
+	Cover string `json:"cover,omitempty"`
+	// The year the album was released, if it is known
+	Year int `json:"year,omitempty"`
+}
+
+// Selects plays from the history; zero values are not filtered on
+type ListenQuery struct {
+	From   time.Time
+	To     time.Time
+	Artist string
+	Limit  int
+	Offset int
+}
+
+// Number of plays of an artist, genre, player or device, or of the albums or tracks with a title, returned by the history API
+type TopItem struct {
+	Name  string `json:"name"`
+	Plays int    `json:"plays"`
+}
+
+// The items that can be ranked by the number of plays
+// Queries of the top items, counting the plays of a query of tracks and how many plays each stands for; see countedPlays
+var topItemQueries = map[string]string{
+	// Persons are grouped by name, so that a track with two persons of the same name is counted once
+	"artists": `SELECT tp.name, SUM(l.plays) AS plays
+		FROM (%s) l
+		JOIN (SELECT DISTINCT tp.track, p.name FROM Track_Person tp JOIN Person p ON p.id = tp.person) tp ON tp.track = l.track
+		GROUP BY tp.name`,
+	"albums": `SELECT a.title, SUM(l.plays) AS plays
+		FROM (%s) l
+		JOIN Track t ON t.id = l.track
+		JOIN Album a ON a.id = t.album
+		WHERE COALESCE(a.title, '') != '' GROUP BY a.title`,
+	"players": `SELECT l.player, SUM(l.plays) AS plays
+		FROM (%s) l
+		WHERE l.player IS NOT NULL GROUP BY l.player`,
+	"devices": `SELECT l.device, SUM(l.plays) AS plays
+		FROM (%s) l
+		WHERE l.device IS NOT NULL GROUP BY l.device`,
+	"tracks": `SELECT t.title, SUM(l.plays) AS plays
+		FROM (%s) l
+		JOIN Track t ON t.id = l.track
+		GROUP BY t.title`,
+	"genres": `SELECT g.name, SUM(l.plays) AS plays
+		FROM (%s) l
+		JOIN Track_Genre tg ON tg.track = l.track
+		JOIN Genre g ON g.id = tg.genre
+		GROUP BY g.name`,
+}
+
+// Build the conditions on TrackLogAll l shared by the history queries, which match the context's user's plays
+func playConditions(ctx context.Context, from, to time.Time) (string, []any) {
+	conditions := []string{"l.guest IS NULL", "l.user IS ?"}
+	args := []any{userArg(ctx)}
+	if !from.IsZero() {
+		conditions = append(conditions, "l.timestamp >= ?")
+		args = append(args, formatTimestamp(from))
+	}
+	if !to.IsZero() {
+		conditions = append(conditions, "l.timestamp < ?")
+		args = append(args, formatTimestamp(to))
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// Build a query of the tracks of the plays matching the context's user and the time range, with the player, the device,
+// and the number of plays each row stands for.
+// The default user's plays that were pruned are included from their daily counts, which have neither player nor device,
+// and are counted from the start of their day in UTC.
+func countedPlays(ctx context.Context, from, to time.Time) (string, []any) {
+	where, args := playConditions(ctx, from, to)
+	query := "SELECT l.track, l.player, COALESCE(l.device, l.host) AS device, 1 AS plays FROM TrackLogAll l WHERE " + where
+	if len(playUser(ctx)) > 0 {
+		return query, args
+	}
+	query += " UNION ALL SELECT d.track, NULL, NULL, d.plays FROM DailyPlays d WHERE TRUE"
+	if !from.IsZero() {
+		query += " AND d.day || 'T00:00:00Z' >= ?"
+		args = append(args, formatTimestamp(from))
+	}
+	if !to.IsZero() {
+		query += " AND d.day || 'T00:00:00Z' < ?"
+		args = append(args, formatTimestamp(to))
+	}
+	return query, args
+}
+
+// Get a page of plays matching the query, newest first, and the number of plays that match
+func QueryListens(ctx context.Context, db *sql.DB, q ListenQuery) ([]Listen, int, error) {
+	where, args := playConditions(ctx, q.From, q.To)
+	if len(q.Artist) > 0 {
+		where += " AND l.track IN (SELECT tp.track FROM Track_Person tp JOIN Person p ON p.id = tp.person WHERE p.name = ?)"
+		args = append(args, q.Artist)
+	}
+	var total int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM TrackLogAll l WHERE "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := db.QueryContext(
+		ctx,
+		`SELECT l.id, COALESCE(l.uid, ''), strftime('%Y-%m-%dT%H:%M:%SZ', l.timestamp), COALESCE(t.title, ''), COALESCE(a.title, ''), COALESCE(t.url, ''),
+			COALESCE(
+				a.releaseGroup,
+				(SELECT b.releaseGroup FROM Album b WHERE b.groupKey = a.groupKey AND b.releaseGroup IS NOT NULL LIMIT 1),
+				''
+			),
+			COALESCE(a.coverUrl, ''),
+			COALESCE(a.year, 0),
+			COALESCE((
+				SELECT group_concat(p.name, char(31))
+				FROM Person p
+				WHERE p.id IN (SELECT tp.person FROM Track_Person tp WHERE tp.track = t.id)
+			), '')
+		FROM TrackLogAll l
+		JOIN Track t ON t.id = l.track
+		LEFT JOIN Album a ON a.id = t.album
+		WHERE `+where+`
+		ORDER BY l.timestamp DESC, l.id DESC LIMIT ? OFFSET ?`,
+		append(args, q.Limit, q.Offset)...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	listens := []Listen{}
+	for rows.Next() {
+		var l Listen
+		var timestamp, artists string
+		if err := rows.Scan(&l.ID, &l.UID, &timestamp, &l.Title, &l.Album, &l.Url, &l.ReleaseGroup, &l.Cover, &l.Year, &artists); err != nil {
+			return nil, 0, err
+		}
+		// Timestamps are stored in UTC, and given in local time with its offset
+		l.Timestamp = timestamp
+		if t, err := time.Parse(timestampLayout, timestamp); err == nil {
+			l.Timestamp = t.Local().Format(time.RFC3339)
+		}
+		if len(artists) > 0 {
+			l.Artists = strings.Split(artists, "\x1f")
+		}
+		listens = append(listens, l)
+	}
+	return listens, total, rows.Err()
+}
+
+// Get the most played artists, albums, tracks, genres, players or devices between from and to
+func GetTopItems(ctx context.Context, db *sql.DB, kind string, from, to time.Time, limit, offset int) ([]TopItem, error) {
+	query, ok := topItemQueries[kind]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown kind %q", ErrInvalidQuery, kind)
+	}
+	plays, args := countedPlays(ctx, from, to)
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(query, plays)+" ORDER BY plays DESC, 1 LIMIT ? OFFSET ?", append(args, limit, offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []TopItem{}
+	for rows.Next() {
+		var item TopItem
+		if err := rows.Scan(&item.Name, &item.Plays); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// Parse a time given as RFC 3339, a local date and time, or a local date
+func parseAPITime(value string) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{time.DateTime, time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: %q is not a time", ErrInvalidQuery, value)
+}
+
+// Get the range of times selected by the request's from and to parameters,
+// or by a period ending now: day, week, month, year or all
+func parseAPIRange(r *http.Request) (from, to time.Time, err error) {
+	query := r.URL.Query()
+	if from, err = parseAPITime(query.Get("from")); err != nil {
+		return
+	}
+	if to, err = parseAPITime(query.Get("to")); err != nil {
+		return
+	}
+	if period := query.Get("period"); len(period) > 0 {
+		from, err = PeriodStart(time.Now(), period)
+	}
+	return
+}
+
+// Get the start of a period ending at now: day, week, month, year or all
+func PeriodStart(now time.Time, period string) (time.Time, error) {
+	switch period {
+	case "day":
+		return now.AddDate(0, 0, -1), nil
+	case "week":
+		return now.AddDate(0, 0, -7), nil
+	case "month":
+		return now.AddDate(0, -1, 0), nil
+	case "year":
+		return now.AddDate(-1, 0, 0), nil
+	case "all":
+		return time.Time{}, nil
+	}
+	return time.Time{}, fmt.Errorf("%w: unknown period %q", ErrInvalidQuery, period)
+}
+
+// Get the page selected by the request's limit and offset parameters
+func parseAPIPage(r *http.Request) (limit, offset int, err error) {
+	limit = defaultAPILimit
+	query := r.URL.Query()
+	if v := query.Get("limit"); len(v) > 0 {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			return 0, 0, fmt.Errorf("%w: limit must be a positive number", ErrInvalidQuery)
+		}
+		limit = min(limit, maxAPILimit)
+	}
+	if v := query.Get("offset"); len(v) > 0 {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("%w: offset must not be negative", ErrInvalidQuery)
+		}
+	}
+	return limit, offset, nil
+}
+
+// Get the context of the request, with the user whose plays are asked for by its user parameter, if any
+func apiContext(r *http.Request) context.Context {
+	if user := r.URL.Query().Get("user"); len(user) > 0 {
+		return WithUser(r.Context(), user)
+	}
+	return r.Context()
+}
+
+func writeAPIResponse(w http.ResponseWriter, r *http.Request, v any, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrInvalidQuery) {
+			status = http.StatusBadRequest
+		} else if errors.Is(err, ErrUnauthorized) {
+			status = http.StatusUnauthorized
+		} else if errors.Is(err, ErrUnknownReport) || errors.Is(err, sql.ErrNoRows) {
+			status = http.StatusNotFound
+		} else {
+			slog.ErrorContext(r.Context(), "Unable to answer API request", "Path", r.URL.Path, "Error", err)
+		}
+		w.WriteHeader(status)
+		v = map[string]string{"error": err.Error()}
+	}
+	json.NewEncoder(w).Encode(v)
+}
+
+// Add the history API to the mux
+func registerAPI(mux *http.ServeMux, c *Controller) {
+	mux.HandleFunc("GET /api/now", func(w http.ResponseWriter, r *http.Request) {
+		var track *Metadata
+		if current := c.NowPlaying(); current != nil && c.Shareable(r.Context(), Event{Type: EventNowPlaying, Track: current}) {
+			track = current
+		}
+		writeAPIResponse(w, r, map[string]any{"track": track}, nil)
+	})
+	mux.HandleFunc("GET /api/listens", func(w http.ResponseWriter, r *http.Request) {
+		q := ListenQuery{Artist: r.URL.Query().Get("artist")}
+		var err error
+		if q.From, q.To, err = parseAPIRange(r); err != nil {
+			writeAPIResponse(w, r, nil, err)
+			return
+		}
+		if q.Limit, q.Offset, err = parseAPIPage(r); err != nil {
+			writeAPIResponse(w, r, nil, err)
+			return
+		}
+		listens, total, err := c.history.QueryListens(apiContext(r), q)
+		writeAPIResponse(w, r, map[string]any{"listens": listens, "total": total, "limit": q.Limit, "offset": q.Offset}, err)
+	})
+	mux.HandleFunc("GET /api/top/{kind}", func(w http.ResponseWriter, r *http.Request) {
+		from, to, err := parseAPIRange(r)
+		if err != nil {
+			writeAPIResponse(w, r, nil, err)
+			return
+		}
+		limit, offset, err := parseAPIPage(r)
+		if err != nil {
+			writeAPIResponse(w, r, nil, err)
+			return
+		}
+		kind := r.PathValue("kind")
+		items, err := c.history.GetTopItems(apiContext(r), kind, from, to, limit, offset)
+		writeAPIResponse(w, r, map[string]any{kind: items, "limit": limit, "offset": offset}, err)
+	})
+	mux.HandleFunc("GET /api/release-groups", func(w http.ResponseWriter, r *http.Request) {
+		from, to, err := parseAPIRange(r)
+		if err != nil {
+			writeAPIResponse(w, r, nil, err)
+			return
+		}
+		limit, offset, err := parseAPIPage(r)
+		if err != nil {
+			writeAPIResponse(w, r, nil, err)
+			return
+		}
+		groups, err := GetTopReleaseGroups(apiContext(r), c.db, from, to, limit, offset)
+		writeAPIResponse(w, r, map[string]any{"releaseGroups": groups, "limit": limit, "offset": offset}, err)
+	})
+	// Lists are limited by the limit parameter; an artist or album without plays is not found
+	mux.HandleFunc("GET /api/artists/{name}", func(w http.ResponseWriter, r *http.Request) {
+		limit, _, err := parseAPIPage(r)
+		if err != nil {
+			writeAPIResponse(w, r, nil, err)
+			return
+		}
+		stats, err := GetArtistStats(apiContext(r), c.db, r.PathValue("name"), limit)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = fmt.Errorf("no plays of artist %q: %w", r.PathValue("name"), err)
+		}
+		if err != nil {
+			writeAPIResponse(w, r, nil, err)
+			return
+		}
+		writeAPIResponse(w, r, map[string]any{"artist": stats, "listenedMs": stats.Listened.Milliseconds()}, nil)
+	})
+	mux.HandleFunc("GET /api/albums/{title}", func(w http.ResponseWriter, r *http.Request) {
+		limit, _, err := parseAPIPage(r)
+		if err != nil {
+			writeAPIResponse(w, r, nil, err)
+			return
+		}
+		stats, err := GetAlbumStats(apiContext(r), c.db, r.PathValue("title"), limit)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = fmt.Errorf("no plays of album %q: %w", r.PathValue("title"), err)
+		}
+		if err != nil {
+			writeAPIResponse(w, r, nil, err)
+			return
+		}
+		writeAPIResponse(w, r, map[string]any{"album": stats, "listenedMs": stats.Listened.Milliseconds()}, nil)
+	})
+	// The same report as the diag command reads over D-Bus
+	mux.HandleFunc("GET /api/diag", func(w http.ResponseWriter, r *http.Request) {
+		writeAPIResponse(w, r, GetDiagnostics(), nil)
+	})
+	mux.HandleFunc("GET /api/export.csv", func(w http.ResponseWriter, r *http.Request) {
+		from, to, err := parseAPIRange(r)
+		if err != nil {
+			writeAPIResponse(w, r, nil, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="listens.csv"`)
+		// The response has started once rows are written, so a failure can only end it early
+		if _, err := ExportCSV(apiContext(r), c.db, from, to, w); err != nil {
+			slog.ErrorContext(r.Context(), "Unable to export plays", "Path", r.URL.Path, "Error", err)
+		}
+	})
+}
@@ -0,0 +1,189 @@
+package music_watch
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// Settings for saving the artwork that players give in mpris:artUrl
+type ArtworkConfig struct {
+	// Save the artwork of tracks as they are stored
+	Cache bool `json:"cache"`
+	// Where artwork is saved; defaults to music-watcher/artwork in the XDG cache directory
+	Dir string `json:"dir"`
+}
+
+// File extensions of the image types that are saved
+var artworkExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"image/bmp":  ".bmp",
+}
+
+// Saves the artwork of tracks, named by the hash of its contents so that
+// artwork shared by the tracks of an album is only saved once
+type Artwork struct {
+	Config ArtworkConfig
+	Client *http.Client
+	dir    string
+}
+
+func NewArtwork(config ArtworkConfig) (*Artwork, error) {
+	dir, err := artworkDir(config.Dir, "artwork")
+	if err != nil {
+		return nil, err
+	}
+	return &Artwork{Config: config, dir: dir}, nil
+}
+
+// Read the artwork at a file, http or https URL
+func (a *Artwork) read(ctx context.Context, rawURL string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	switch u.Scheme {
+	case "file":
+		// Some players write artwork to temporary files that are removed when the track changes
+		f, err := os.Open(u.Path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		err = copyArtwork(&buf, f)
+		return buf.Bytes(), err
+	case "http", "https":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("User-Agent", "music-watcher")
+		client := a.Client
+		if client == nil {
+			client = scrobbleClient
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, httpStatusError("artwork server", resp)
+		}
+		err = copyArtwork(&buf, resp.Body)
+		return buf.Bytes(), err
+	default:
+		return nil, fmt.Errorf("unsupported artwork URL scheme %q", u.Scheme)
+	}
+}
+
+// Save the artwork at the URL, returning its path in the cache directory
+func (a *Artwork) save(ctx context.Context, rawURL string) (string, error) {
+	data, err := a.read(ctx, rawURL)
+	if err != nil {
+		return "", err
+	}
+	ext, ok := artworkExtensions[http.DetectContentType(data)]
+	if !ok {
+		return "", fmt.Errorf("artwork is not an image, but %s", http.DetectContentType(data))
+	}
+	hash := sha256.Sum256(data)
+	path := filepath.Join(a.dir, hex.EncodeToString(hash[:])+ext)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	tmp, err := os.CreateTemp(a.dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return path, os.Rename(tmp.Name(), path)
+}
+
+// Save the artwork of the stored track, and record it for the track and its album
+func (a *Artwork) Store(ctx context.Context, db *sql.DB, m *Metadata) error {
+	if len(m.ArtUrl) == 0 {
+		return nil
+	}
+	var trackID int64
+	var albumID sql.NullInt64
+	var artURL, artPath sql.NullString
+	track, args := trackIDQuery(m)
+	err := db.QueryRowContext(
+		ctx, "SELECT id, album, artUrl, artPath FROM Track WHERE id = "+track, args...,
+	).Scan(&trackID, &albumID, &artURL, &artPath)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if artURL.String == m.ArtUrl && artPath.Valid {
+		if _, err := os.Stat(artPath.String); err == nil {
+			return nil
+		}
+	}
+	path, err := a.save(ctx, m.ArtUrl)
+	if err != nil {
+		return err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "UPDATE Track SET artUrl = ?, artPath = ? WHERE id = ?", m.ArtUrl, path, trackID); err != nil {
+		return err
+	}
+	if albumID.Valid {
+		if _, err := tx.ExecContext(ctx, "UPDATE Album SET artPath = ? WHERE id = ?", path, albumID.Int64); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Save the artwork of each track as it is stored, until the context is cancelled.
+// Downloads are done in the background rather than while storing.
+func (a *Artwork) Run(ctx context.Context, db *sql.DB, events *EventHub) {
+	subscription, unsubscribe := events.Subscribe()
+	defer unsubscribe()
+	for {
+		select {
+		case e, ok := <-subscription:
+			if !ok {
+				return
+			}
+			if e.Type != EventScrobble {
+				continue
+			}
+			if err := a.Store(ctx, db, e.Track); err != nil && ctx.Err() == nil {
+				slog.WarnContext(ctx, "Unable to save artwork", "Title", e.Track.Title, "Url", e.Track.ArtUrl, "Error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
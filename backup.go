@@ -0,0 +1,225 @@
+package music_watch
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	ErrInvalidCompression = errors.New("invalid compression")
+	ErrInvalidBackup      = errors.New("invalid backup")
+)
+
+// How backups are compressed
+const (
+	BackupUncompressed = "none"
+	BackupGzip         = "gzip"
+	BackupZstd         = "zstd"
+)
+
+// The extension added to the names of backups with each compression
+var backupExtensions = map[string]string{
+	BackupUncompressed: "",
+	BackupGzip:         ".gz",
+	BackupZstd:         ".zst",
+}
+
+// The backups written to a directory are named by the time they were taken, so that they sort oldest first
+const (
+	backupPrefix     = "music-watcher-"
+	backupTimeLayout = "20060102-150405"
+)
+
+// Get the path of a backup taken at the time in the directory
+func BackupPath(dir string, t time.Time, compression string) (string, error) {
+	ext, ok := backupExtensions[compression]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrInvalidCompression, compression)
+	}
+	return filepath.Join(dir, backupPrefix+t.UTC().Format(backupTimeLayout)+".db"+ext), nil
+}
+
+// Move the copy of the database to the backup's path, compressing it.
+// The backup is written beside its path first, so that an interrupted backup does not replace an earlier one.
+func WriteBackup(snapshot, path, compression string) error {
+	if compression == BackupUncompressed {
+		return os.Rename(snapshot, path)
+	}
+	defer os.Remove(snapshot)
+	in, err := os.Open(snapshot)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	partial := path + ".partial"
+	out, err := os.OpenFile(partial, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(partial)
+	defer out.Close()
+	var w io.WriteCloser
+	switch compression {
+	case BackupGzip:
+		w = gzip.NewWriter(out)
+	case BackupZstd:
+		if w, err = zstd.NewWriter(out); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidCompression, compression)
+	}
+	if _, err := io.Copy(w, in); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(partial, path)
+}
+
+// Remove all but the newest backups in the directory, returning the paths of those removed
+func RotateBackups(dir string, keep int) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, backupPrefix) && isBackupName(name) {
+			backups = append(backups, name)
+		}
+	}
+	// Names sort by the time the backups were taken, whatever their compression
+	slices.SortFunc(backups, func(a, b string) int { return strings.Compare(b, a) })
+	var removed []string
+	for _, name := range backups[min(keep, len(backups)):] {
+		path := filepath.Join(dir, name)
+		if err := os.Remove(path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
+
+func isBackupName(name string) bool {
+	for _, ext := range backupExtensions {
+		if stamp, ok := strings.CutSuffix(strings.TrimPrefix(name, backupPrefix), ".db"+ext); ok {
+			if _, err := time.Parse(backupTimeLayout, stamp); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Copy the backup to the path, decompressing it if it was compressed
+func ExtractBackup(backup, path string) error {
+	in, err := os.Open(backup)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	// Backups are recognized by their contents rather than their names, which may have been changed
+	r := bufio.NewReader(in)
+	magic, _ := r.Peek(4)
+	var src io.Reader = r
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		src = gz
+	case bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		src = zr
+	}
+	if _, err := io.Copy(out, src); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// Check that the database is an intact music-watcher database that this version can read:
+// that SQLite finds no corruption, that it has plays, and that it was not written by a newer version
+func ValidateBackup(ctx context.Context, db *sql.DB) error {
+	problems, err := queryStrings(ctx, db, "PRAGMA integrity_check")
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidBackup, err)
+	}
+	if len(problems) != 1 || problems[0] != "ok" {
+		return fmt.Errorf("%w: %s", ErrInvalidBackup, strings.Join(problems, "; "))
+	}
+	var hasPlays bool
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'TrackLog'").Scan(&hasPlays); err != nil {
+		return err
+	}
+	if !hasPlays {
+		return fmt.Errorf("%w: it is not a music-watcher database", ErrInvalidBackup)
+	}
+	var version int
+	if err := db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("%w: it was written by a newer version, with schema version %d rather than %d", ErrInvalidBackup, version, SchemaVersion)
+	}
+	return nil
+}
+
+// Get the number of plays and a hash of them, by their IDs, tracks and times,
+// which are the same for a backup and the database it was taken from if no plays were stored, changed or removed since
+func PlayChecksum(ctx context.Context, db *sql.DB) (int64, string, error) {
+	rows, err := db.QueryContext(
+		ctx,
+		"SELECT l.id, COALESCE(t.title, ''), COALESCE(t.url, ''), strftime('%Y-%m-%dT%H:%M:%SZ', l.timestamp) FROM "+trackLogView+" l LEFT JOIN Track t ON t.id = l.track ORDER BY l.id",
+	)
+	if err != nil {
+		return 0, "", err
+	}
+	defer rows.Close()
+	hash := sha256.New()
+	var count int64
+	for rows.Next() {
+		var id int64
+		var title, url, timestamp string
+		if err := rows.Scan(&id, &title, &url, &timestamp); err != nil {
+			return 0, "", err
+		}
+		fmt.Fprintf(hash, "%d\x00%s\x00%s\x00%s\n", id, title, url, timestamp)
+		count++
+	}
+	return count, hex.EncodeToString(hash.Sum(nil)), rows.Err()
+}
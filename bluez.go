@@ -0,0 +1,187 @@
+package music_watch
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	dbus "github.com/godbus/dbus/v5"
+)
+
+const bluezName = "org.bluez"
+const bluezPlayerInterface = "org.bluez.MediaPlayer1"
+const objectManagerInterface = "org.freedesktop.DBus.ObjectManager"
+const interfacesAddedSignal = objectManagerInterface + ".InterfacesAdded"
+const interfacesRemovedSignal = objectManagerInterface + ".InterfacesRemoved"
+
+// The player name reported for tracks played over Bluetooth
+const bluetoothPlayerName = "bluetooth"
+
+// Reads tracks from devices such as phones playing through this computer over Bluetooth,
+// using the AVRCP players that BlueZ exposes on the system bus
+type BluezSource struct {
+	Conn *dbus.Conn // The system bus
+}
+
+// What is known about one BlueZ player
+type bluezPlayer struct {
+	track   *Metadata
+	playing bool
+	logged  bool // Whether the track has been sent; tracks are only logged once they play
+}
+
+func (s *BluezSource) Run(ctx context.Context, events chan<- Event) error {
+	conn := s.Conn
+	slog.InfoContext(ctx, "Starting monitor of Bluetooth players")
+	if err := conn.AddMatchSignalContext(
+		ctx,
+		dbus.WithMatchSender(bluezName),
+		dbus.WithMatchInterface(propertiesChangedName),
+		dbus.WithMatchMember("PropertiesChanged"),
+		dbus.WithMatchArg(0, bluezPlayerInterface),
+	); err != nil {
+		return err
+	}
+	if err := conn.AddMatchSignalContext(
+		ctx,
+		dbus.WithMatchSender(bluezName),
+		dbus.WithMatchInterface(objectManagerInterface),
+	); err != nil {
+		return err
+	}
+	signals := make(chan *dbus.Signal, 10)
+	conn.Signal(signals)
+	defer conn.RemoveSignal(signals)
+
+	players := make(map[dbus.ObjectPath]*bluezPlayer)
+	var objects map[dbus.ObjectPath]map[string]map[string]dbus.Variant
+	if err := conn.Object(bluezName, "/").CallWithContext(ctx, objectManagerInterface+".GetManagedObjects", 0).Store(&objects); err != nil {
+		// BlueZ may not be running yet; players will be picked up when it starts
+		slog.WarnContext(ctx, "Unable to list Bluetooth players", "Error", err)
+	}
+	for path, interfaces := range objects {
+		if properties, ok := interfaces[bluezPlayerInterface]; ok {
+			if err := updateBluezPlayer(ctx, events, players, path, properties); err != nil {
+				return nil
+			}
+		}
+	}
+
+	for {
+		select {
+		case sig := <-signals:
+			if err := handleBluezSignal(ctx, events, players, sig); err != nil {
+				return nil
+			}
+		case <-ctx.Done():
+			finishAllPlays(ctx, events, bluetoothPlayerName+":")
+			return nil
+		}
+	}
+}
+
+// Only returns an error when the context is cancelled
+func handleBluezSignal(ctx context.Context, events chan<- Event, players map[dbus.ObjectPath]*bluezPlayer, sig *dbus.Signal) error {
+	switch sig.Name {
+	case propertySignal:
+		if len(sig.Body) < 2 {
+			recordDropped("Invalid signal body", "Path", sig.Path)
+			return nil
+		}
+		changed, ok := sig.Body[1].(map[string]dbus.Variant)
+		if !ok {
+			recordDropped("Invalid signal body", "Path", sig.Path)
+			return nil
+		}
+		return updateBluezPlayer(ctx, events, players, sig.Path, changed)
+	case interfacesAddedSignal:
+		if len(sig.Body) != 2 {
+			return nil
+		}
+		path, _ := sig.Body[0].(dbus.ObjectPath)
+		interfaces, _ := sig.Body[1].(map[string]map[string]dbus.Variant)
+		if properties, ok := interfaces[bluezPlayerInterface]; ok {
+			slog.DebugContext(ctx, "Detected new Bluetooth player", "Path", path)
+			return updateBluezPlayer(ctx, events, players, path, properties)
+		}
+	case interfacesRemovedSignal:
+		if len(sig.Body) != 2 {
+			return nil
+		}
+		path, _ := sig.Body[0].(dbus.ObjectPath)
+		interfaces, _ := sig.Body[1].([]string)
+		for _, i := range interfaces {
+			if i == bluezPlayerInterface {
+				slog.DebugContext(ctx, "Bluetooth player disconnected", "Path", path)
+				delete(players, path)
+				finishPlay(ctx, events, bluezTimerName(path))
+			}
+		}
+	}
+	return nil
+}
+
+func updateBluezPlayer(ctx context.Context, events chan<- Event, players map[dbus.ObjectPath]*bluezPlayer, path dbus.ObjectPath, properties map[string]dbus.Variant) error {
+	name := bluezTimerName(path)
+	player, ok := players[path]
+	if !ok {
+		player = &bluezPlayer{}
+		players[path] = player
+	}
+	if v, ok := properties["Status"]; ok {
+		// Can also be "stopped," "paused," "forward-seek," "reverse-seek," or "error"
+		status, _ := v.Value().(string)
+		player.playing = status == "playing"
+		setPlaying(ctx, events, name, player.playing)
+	}
+	position := time.Duration(-1)
+	if v, ok := properties["Position"]; ok {
+		if ms, ok := v.Value().(uint32); ok {
+			position = time.Duration(ms) * time.Millisecond
+		}
+	}
+	if v, ok := properties["Track"]; ok {
+		if track, ok := v.Value().(map[string]dbus.Variant); ok {
+			metadata := parseBluezTrack(track)
+			if metadata.Cleared() {
+				if player.track != nil {
+					// Devices clear the track when playback stops
+					player.track = nil
+					finishPlay(ctx, events, name)
+				}
+			} else if player.track == nil || !player.track.IsSameTrack(metadata) {
+				player.track = metadata
+				player.logged = false
+				startPlay(ctx, events, name, metadata, player.playing, max(position, 0))
+				position = -1
+			}
+		}
+	}
+	if position >= 0 {
+		seekPlay(name, position)
+	}
+	if player.playing && player.track != nil && !player.logged {
+		player.logged = true
+		return sendTrack(ctx, events, player.track)
+	}
+	return nil
+}
+
+func parseBluezTrack(track map[string]dbus.Variant) *Metadata {
+	metadata := Metadata{Player: bluetoothPlayerName}
+	metadata.Title, _ = getAny[string](track["Title"])
+	metadata.Album, _ = getAny[string](track["Album"])
+	if artist, _ := getAny[string](track["Artist"]); len(strings.TrimSpace(artist)) > 0 {
+		metadata.Artist = []string{artist}
+	}
+	if duration, err := getAny[uint32](track["Duration"]); err == nil {
+		metadata.Length = (time.Duration(duration) * time.Millisecond).Microseconds()
+	}
+	metadata.Clean()
+	return &metadata
+}
+
+func bluezTimerName(path dbus.ObjectPath) string {
+	return bluetoothPlayerName + ":" + string(path)
+}
@@ -0,0 +1,155 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// Returned by Lookup when offline and nothing is cached for the key
+var ErrNotCached = errors.New("lookup is not cached")
+
+const (
+	defaultCacheTTL         = 30 * 24 * time.Hour
+	defaultCacheNegativeTTL = 7 * 24 * time.Hour
+)
+
+// Settings for caching the results of external lookups, such as MusicBrainz
+type CacheConfig struct {
+	// How long found results are used before being looked up again; default 30
+	TTLDays int `json:"ttlDays"`
+	// How long to remember that nothing was found; default 7
+	NegativeTTLDays int `json:"negativeTtlDays"`
+	// Only use cached results, even if they have expired
+	Offline bool `json:"offline"`
+}
+
+// Stores the results of external lookups in the database
+type LookupCache struct {
+	db          *sql.DB
+	ttl         time.Duration
+	negativeTTL time.Duration
+	offline     bool
+}
+
+func NewLookupCache(db *sql.DB, config CacheConfig) *LookupCache {
+	c := LookupCache{
+		db:          db,
+		ttl:         time.Duration(config.TTLDays) * 24 * time.Hour,
+		negativeTTL: time.Duration(config.NegativeTTLDays) * 24 * time.Hour,
+		offline:     config.Offline,
+	}
+	if c.ttl <= 0 {
+		c.ttl = defaultCacheTTL
+	}
+	if c.negativeTTL <= 0 {
+		c.negativeTTL = defaultCacheNegativeTTL
+	}
+	return &c
+}
+
+// Get the result of looking up key with the service, calling fetch if it is not cached or has expired.
+// fetch reports whether anything was found; results that were not found are cached too.
+// If fetch fails, an expired result is used instead, so lookups keep working while offline.
+func Lookup[T any](ctx context.Context, c *LookupCache, service, key string, fetch func(ctx context.Context) (T, bool, error)) (T, bool, error) {
+	var value T
+	var data []byte
+	var found, fresh bool
+	err := c.db.QueryRowContext(
+		ctx,
+		"SELECT value, found, expires > ? FROM LookupCache WHERE service = ? AND key = ?",
+		time.Now().Format(time.DateTime),
+		service,
+		key,
+	).Scan(&data, &found, &fresh)
+	cached := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return value, false, err
+	}
+	if cached && (c.offline || fresh) {
+		if found {
+			if err := json.Unmarshal(data, &value); err != nil {
+				return value, false, err
+			}
+		}
+		return value, found, nil
+	}
+	if c.offline {
+		return value, false, ErrNotCached
+	}
+	fetched, fetchedFound, err := fetch(ctx)
+	if err != nil {
+		if cached {
+			slog.WarnContext(ctx, "Lookup failed, using expired result", "Service", service, "Key", key, "Error", err)
+			if found {
+				err = json.Unmarshal(data, &value)
+			} else {
+				err = nil
+			}
+			return value, found, err
+		}
+		return value, false, err
+	}
+	if err := c.store(ctx, service, key, fetched, fetchedFound); err != nil {
+		// The lookup itself succeeded, so only warn
+		slog.WarnContext(ctx, "Unable to cache lookup", "Service", service, "Key", key, "Error", err)
+	}
+	return fetched, fetchedFound, nil
+}
+
+func (c *LookupCache) store(ctx context.Context, service, key string, value any, found bool) error {
+	var data []byte
+	ttl := c.negativeTTL
+	if found {
+		var err error
+		if data, err = json.Marshal(value); err != nil {
+			return err
+		}
+		ttl = c.ttl
+	}
+	now := time.Now()
+	_, err := c.db.ExecContext(
+		ctx,
+		`INSERT INTO LookupCache (service, key, value, found, fetched, expires) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (service, key) DO UPDATE SET value = excluded.value, found = excluded.found, fetched = excluded.fetched, expires = excluded.expires`,
+		service,
+		key,
+		data,
+		found,
+		now.Format(time.DateTime),
+		now.Add(ttl).Format(time.DateTime),
+	)
+	return err
+}
+
+// Remove every cached result for the service, or for every service if it is empty
+func (c *LookupCache) Clear(ctx context.Context, service string) (int64, error) {
+	res, err := c.db.ExecContext(ctx, "DELETE FROM LookupCache WHERE ? = '' OR service = ?", service, service)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Remove results that expired more than the TTL ago; recently expired results are kept for offline use
+func (c *LookupCache) Prune(ctx context.Context) (int64, error) {
+	cutoff := time.Now().Add(-c.ttl).Format(time.DateTime)
+	res, err := c.db.ExecContext(ctx, "DELETE FROM LookupCache WHERE expires < ?", cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func RegisterCacheTasks(s *Scheduler, c *LookupCache) {
+	s.Register("prune-cache", func(ctx context.Context) error {
+		removed, err := c.Prune(ctx)
+		if err == nil {
+			slog.InfoContext(ctx, "Pruned lookup cache", "Removed", removed)
+		}
+		return err
+	})
+}
@@ -0,0 +1,123 @@
+package music_watch
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Pairs of quotes that some players put around whole fields
+var surroundingQuotes = [][2]string{
+	{`"`, `"`},
+	{`'`, `'`},
+	{"\u201c", "\u201d"},
+	{"\u2018", "\u2019"},
+	{"\u00ab", "\u00bb"},
+	{"\u300c", "\u300d"},
+}
+
+// Remove junk that players leave in fields, so that it does not create duplicate tracks:
+// byte order marks, leading and trailing whitespace, control and zero-width characters,
+// and quotes around a whole field. Names are also normalized to NFC, with runs of whitespace
+// collapsed to a space, so that "Sigur Ro\u0301s " and "Sigur Rós" are the same artist.
+// Empty names are removed from lists.
+// Sources should clean tracks before comparing them.
+func (m *Metadata) Clean() {
+	m.Title = cleanField(m.Title, true)
+	m.Album = cleanField(m.Album, true)
+	m.Artist = cleanList(m.Artist)
+	m.Featured = cleanList(m.Featured)
+	m.AlbumArtist = cleanList(m.AlbumArtist)
+	m.Composer = cleanList(m.Composer)
+	m.Url = cleanField(m.Url, false)
+	m.TrackId = cleanField(m.TrackId, false)
+	m.Lyrics = cleanField(m.Lyrics, false)
+	m.ArtUrl = cleanField(m.ArtUrl, false)
+	m.Genre = cleanList(m.Genre)
+	m.AlbumId = cleanField(m.AlbumId, false)
+}
+
+func cleanList(values []string) []string {
+	cleaned := values[:0]
+	for _, value := range values {
+		if value = cleanField(value, true); len(value) > 0 {
+			cleaned = append(cleaned, value)
+		}
+	}
+	if len(cleaned) == 0 {
+		return nil
+	}
+	return cleaned
+}
+
+// Clean a field; names are also unquoted and normalized
+func cleanField(value string, name bool) string {
+	// A byte order mark is never meaningful inside a field
+	value = strings.ReplaceAll(value, "\ufeff", "")
+	if name {
+		value = normalizeName(value)
+	}
+	for {
+		trimmed := strings.TrimFunc(value, isJunk)
+		if name {
+			trimmed = trimQuotes(trimmed)
+		}
+		if trimmed == value {
+			return value
+		}
+		value = trimmed
+	}
+}
+
+// Whitespace, control characters and zero-width characters.
+// Zero-width joiners are only junk at the ends, since they join emoji and some scripts.
+func isJunk(r rune) bool {
+	switch r {
+	case '\u200b', '\u200c', '\u200d', '\u2060', '\u00ad': // Zero-width spaces, joiners and soft hyphens
+		return true
+	}
+	return unicode.IsSpace(r) || unicode.IsControl(r)
+}
+
+// Compose characters to NFC, collapse runs of whitespace to a space and remove zero-width spaces and soft hyphens.
+// Zero-width joiners and non-joiners are kept inside names, where they change how emoji and some scripts are shown.
+func normalizeName(value string) string {
+	value = norm.NFC.String(value)
+	var b strings.Builder
+	space := false
+	for _, r := range value {
+		switch {
+		case r == '\u200b' || r == '\u2060' || r == '\u00ad':
+			continue
+		case unicode.IsSpace(r):
+			space = true
+			continue
+		case space:
+			b.WriteRune(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	if space {
+		b.WriteRune(' ')
+	}
+	return b.String()
+}
+
+// Remove a pair of quotes around the whole value, unless the quotes also appear inside,
+// as in "Hello" and "Goodbye"
+func trimQuotes(value string) string {
+	for _, q := range surroundingQuotes {
+		inner, ok := strings.CutPrefix(value, q[0])
+		if !ok {
+			continue
+		}
+		inner, ok = strings.CutSuffix(inner, q[1])
+		if !ok || len(inner) == 0 || strings.Contains(inner, q[0]) || strings.Contains(inner, q[1]) {
+			continue
+		}
+		return inner
+	}
+	return value
+}
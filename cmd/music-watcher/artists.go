@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	music "github.com/inventor500/music-watcher"
+)
+
+// List the aliases of artists, or merge artists under one name
+func artistsCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected \"aliases\" or \"merge\"")
+	}
+	switch args[0] {
+	case "aliases":
+		return artistsAliasesCommand(args[1:])
+	case "merge":
+		return artistsMergeCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown artists command %q", args[0])
+	}
+}
+
+func artistsAliasesCommand(args []string) error {
+	flags := flag.NewFlagSet("artists aliases", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	flags.Parse(args)
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	aliases, err := music.GetPersonAliases(context.Background(), db)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "Artist\tAlias")
+	for _, a := range aliases {
+		fmt.Fprintf(w, "%s\t%s\n", a.Person, a.Alias)
+	}
+	return w.Flush()
+}
+
+func artistsMergeCommand(args []string) error {
+	flags := flag.NewFlagSet("artists merge", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [options] NAME ALIAS...\n", flags.Name())
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return music.MergePersons(context.Background(), db, flags.Arg(0), flags.Args()[1:]...)
+}
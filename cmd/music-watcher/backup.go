@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	music "github.com/inventor500/music-watcher"
+)
+
+// The number of pages copied at a time by backups, between which the watcher can write
+const backupStepPages = 1024
+
+// Copy the database to a file or a directory of backups while the watcher may be running
+func backupCommand(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	compression := flags.String("compress", music.BackupUncompressed, "Compress the backup with \"gzip\" or \"zstd\".")
+	keep := flags.Int("keep", 0, "When DEST is a directory, remove all but this many of the newest backups in it; 0 keeps every backup.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [options] DEST\n", flags.Name())
+		fmt.Fprintln(flags.Output(), "DEST is the file to write, or a directory to write a backup named by the time it was taken to.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	dest := flags.Arg(0)
+	dir := ""
+	if info, err := os.Stat(dest); err == nil && info.IsDir() {
+		dir = dest
+		if dest, err = music.BackupPath(dir, time.Now(), *compression); err != nil {
+			return err
+		}
+	} else if *keep > 0 {
+		return fmt.Errorf("-keep needs DEST to be a directory")
+	}
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	// The copy is written beside the backup, so that it can be renamed into place
+	snapshot := dest + ".snapshot"
+	defer os.Remove(snapshot)
+	if err := backupDatabase(context.Background(), db, snapshot); err != nil {
+		return err
+	}
+	if err := music.WriteBackup(snapshot, dest, *compression); err != nil {
+		return err
+	}
+	fmt.Printf("Backed up the database to %s\n", dest)
+	if dir != "" && *keep > 0 {
+		removed, err := music.RotateBackups(dir, *keep)
+		for _, path := range removed {
+			fmt.Printf("Removed %s\n", filepath.Base(path))
+		}
+		return err
+	}
+	return nil
+}
+
+// Copy the pages of the database with the backup's step, which returns whether the copy is done.
+// The copy starts again by itself if another connection writes to the database meanwhile.
+func copyPages(ctx context.Context, step func(n int) (bool, error)) error {
+	for {
+		done, err := step(backupStepPages)
+		if err != nil || done {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	music "github.com/inventor500/music-watcher"
+)
+
+// Clear or prune the cache of external lookups
+func cacheCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected \"clear\" or \"prune\"")
+	}
+	flags := flag.NewFlagSet("cache "+args[0], flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	configPath := flags.String("config", defaultConfigPath(), "The location of the configuration file.")
+	service := flags.String("service", "", "Only clear results from this service, e.g. musicbrainz.")
+	flags.Parse(args[1:])
+	config, err := music.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	cache := music.NewLookupCache(db, config.Cache)
+	var removed int64
+	switch args[0] {
+	case "clear":
+		removed, err = cache.Clear(context.Background(), *service)
+	case "prune":
+		removed, err = cache.Prune(context.Background())
+	default:
+		return fmt.Errorf("unknown cache command %q", args[0])
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Removed %d cached results\n", removed)
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	music "github.com/inventor500/music-watcher"
+)
+
+// Look after the database file
+func dbCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected \"maintain\" or \"doctor\"")
+	}
+	switch args[0] {
+	case "maintain":
+		return dbMaintainCommand(args[1:])
+	case "doctor":
+		return dbDoctorCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown db command %q", args[0])
+	}
+}
+
+func dbMaintainCommand(args []string) error {
+	flags := flag.NewFlagSet("db maintain", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	flags.Parse(args)
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	result, err := music.MaintainDatabase(context.Background(), db)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Vacuumed and analyzed the database: %s before, %s after\n", formatSize(result.SizeBefore), formatSize(result.SizeAfter))
+	if reclaimed := result.SizeBefore - result.SizeAfter; reclaimed > 0 {
+		fmt.Printf("Reclaimed %s\n", formatSize(reclaimed))
+	}
+	if result.CheckpointBusy {
+		fmt.Println("The write-ahead log could not be emptied while the watcher was using it; it will be once the watcher checkpoints it")
+	}
+	return nil
+}
+
+func dbDoctorCommand(args []string) error {
+	flags := flag.NewFlagSet("db doctor", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	repair := flags.Bool("repair", false, "Remove or fix the rows that are found.")
+	flags.Parse(args)
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	problems, err := music.CheckDatabase(context.Background(), db, *repair)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "Check\tFound\tRepaired\tDescription")
+	found := int64(0)
+	for _, p := range problems {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", p.Check, p.Found, p.Repaired, p.Description)
+		found += p.Found
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if found > 0 && !*repair {
+		fmt.Println("Run with -repair to remove or fix these rows; take a backup first")
+	}
+	return nil
+}
+
+// Format a number of bytes in the largest unit that keeps it above 1
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	value, exp := float64(bytes)/unit, 0
+	for value >= unit && exp < 3 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGT"[exp])
+}
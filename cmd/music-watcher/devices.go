@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	music "github.com/inventor500/music-watcher"
+)
+
+// Show how many plays were stored on each machine, by its label or host name
+func devicesCommand(args []string) error {
+	flags := flag.NewFlagSet("devices", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	period := flags.String("period", "all", "The period to count: day, week, month, year or all.")
+	limit := flags.Int("limit", 20, "The number of devices to show.")
+	flags.Parse(args)
+	from, err := music.PeriodStart(time.Now(), *period)
+	if err != nil {
+		return err
+	}
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	devices, err := music.GetTopItems(context.Background(), db, "devices", from, time.Time{}, *limit, 0)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "Device\tPlays")
+	for _, d := range devices {
+		fmt.Fprintf(w, "%s\t%d\n", d.Name, d.Plays)
+	}
+	return w.Flush()
+}
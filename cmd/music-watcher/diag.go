@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+
+	dbus "github.com/godbus/dbus/v5"
+	music "github.com/inventor500/music-watcher"
+)
+
+// Print the running daemon's recent errors, dropped signals and sink latencies
+func diagCommand(args []string) error {
+	flags := flag.NewFlagSet("diag", flag.ExitOnError)
+	flags.Parse(args)
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var report string
+	if err := conn.Object(music.ControlName, music.ControlPath).Call(music.ControlName+".Diagnostics", 0).Store(&report); err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, []byte(report), "", "  "); err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+	_, err = out.WriteTo(os.Stdout)
+	return err
+}
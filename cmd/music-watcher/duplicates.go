@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	music "github.com/inventor500/music-watcher"
+)
+
+// List tracks that are likely duplicates, and merge them after asking
+func duplicatesCommand(args []string) error {
+	flags := flag.NewFlagSet("duplicates", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	merge := flags.Bool("merge", false, "Ask whether to merge each set of duplicates into its most played track.")
+	yes := flags.Bool("yes", false, "With -merge, merge every set without asking.")
+	flags.Parse(args)
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	ctx := context.Background()
+	sets, err := music.FindDuplicateTracks(ctx, db)
+	if err != nil {
+		return err
+	}
+	stdin := bufio.NewReader(os.Stdin)
+	merged := 0
+	for _, set := range sets {
+		printDuplicate("Keep", set.Keep)
+		ids := make([]int64, len(set.Duplicates))
+		for i, track := range set.Duplicates {
+			printDuplicate("Merge", track)
+			ids[i] = track.Id
+		}
+		if !*merge {
+			fmt.Println()
+			continue
+		}
+		if !*yes {
+			fmt.Print("Merge these tracks? [y/N/q] ")
+			answer, err := stdin.ReadString('\n')
+			if err != nil {
+				return err
+			}
+			switch strings.ToLower(strings.TrimSpace(answer)) {
+			case "y", "yes":
+			case "q", "quit":
+				fmt.Printf("Merged %d sets of duplicates\n", merged)
+				return nil
+			default:
+				fmt.Println()
+				continue
+			}
+		}
+		if err := music.MergeTracks(ctx, db, set.Keep.Id, ids...); err != nil {
+			return err
+		}
+		merged++
+		fmt.Println()
+	}
+	if *merge {
+		fmt.Printf("Merged %d sets of duplicates\n", merged)
+	} else {
+		fmt.Printf("Found %d sets of duplicates\n", len(sets))
+	}
+	return nil
+}
+
+func printDuplicate(action string, track music.DuplicateTrack) {
+	fmt.Printf("%s\t%d\t%s - %s\t%s\t%d plays\n", action, track.Id, strings.Join(track.Artists, ", "), track.Title, track.Url, track.Plays)
+}
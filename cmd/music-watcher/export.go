@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	music "github.com/inventor500/music-watcher"
+)
+
+// Write plays as newline-delimited JSON, optionally only those added since the last export, or as CSV
+func exportCommand(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	output := flags.String("o", "-", "The file to write to, or - for stdout.")
+	sinceLast := flags.Bool("since-last", false, "Only export plays added since the last export with the same cursor.")
+	cursor := flags.String("cursor", "default", "The name of the cursor for -since-last, so that several destinations can be kept up to date.")
+	format := flags.String("format", "jsonl", "The format to write: \"jsonl\", or \"csv\" with the timestamp, artist, album, title, duration and player of each play.")
+	fromFlag := flags.String("from", "", "With -format csv, only export plays from this time, as RFC 3339 or a local \"YYYY-MM-DD\" or \"YYYY-MM-DD HH:MM:SS\".")
+	toFlag := flags.String("to", "", "With -format csv, only export plays before this time.")
+	user := flags.String("user", "", "With -format csv, export the plays of this user, rather than the default user's.")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		return fmt.Errorf("received too many arguments: %v", flags.Args())
+	}
+	if *format != "jsonl" && *format != "csv" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	if *format == "csv" && *sinceLast {
+		return fmt.Errorf("-since-last only applies to -format jsonl")
+	}
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	ctx := context.Background()
+	if *format == "csv" {
+		from, err := parseExportTime(*fromFlag)
+		if err != nil {
+			return err
+		}
+		to, err := parseExportTime(*toFlag)
+		if err != nil {
+			return err
+		}
+		return exportCSV(music.WithUser(ctx, *user), db, from, to, *output)
+	}
+	var after int64
+	if *sinceLast {
+		if after, err = music.GetExportCursor(ctx, db, *cursor); err != nil {
+			return err
+		}
+	}
+	var last int64
+	var count int
+	if *output == "-" {
+		if last, count, err = music.ExportPlays(ctx, db, after, os.Stdout); err != nil {
+			return err
+		}
+	} else {
+		// Only create the file once it is complete, so that a failed export is not synced
+		err = writeAtomic(*output, func(w io.Writer) error {
+			last, count, err = music.ExportPlays(ctx, db, after, w)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d plays\n", count)
+	if *sinceLast && count > 0 {
+		// Only advance once the plays have been written, so none are skipped
+		return music.SetExportCursor(ctx, db, *cursor, last)
+	}
+	return nil
+}
+
+func exportCSV(ctx context.Context, db *sql.DB, from, to time.Time, output string) error {
+	var count int
+	var err error
+	if output == "-" {
+		count, err = music.ExportCSV(ctx, db, from, to, os.Stdout)
+	} else {
+		err = writeAtomic(output, func(w io.Writer) error {
+			count, err = music.ExportCSV(ctx, db, from, to, w)
+			return err
+		})
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d plays\n", count)
+	return nil
+}
+
+// Parse a time given as RFC 3339, a local date and time, or a local date; "" is the zero time
+func parseExportTime(value string) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{time.DateTime, time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a time", value)
+}
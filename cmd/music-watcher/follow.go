@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	dbus "github.com/godbus/dbus/v5"
+	music "github.com/inventor500/music-watcher"
+)
+
+// Follow another instance's event stream and mirror what it is playing
+func followCommand(args []string) error {
+	flags := flag.NewFlagSet("follow", flag.ExitOnError)
+	notify := flags.Bool("notify", false, "Show a desktop notification for each new track.")
+	statusFile := flags.String("status-file", "", "Write the current track to this file, for status bars.")
+	name := flags.String("name", "", "The name of the person being followed, used in notifications.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [options] URL\n\nURL is the /events endpoint of the other instance's HTTP server.\n", flags.Name())
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("expected 1 argument, received %d", flags.NArg())
+	}
+	var conn *dbus.Conn
+	if *notify {
+		var err error
+		if conn, err = dbus.SessionBus(); err != nil {
+			return err
+		}
+		defer conn.Close()
+	}
+	summary := "Now playing"
+	if len(*name) > 0 {
+		summary = fmt.Sprintf("%s is listening to", *name)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	var notification uint32
+	var last *music.Metadata
+	return music.Follow(ctx, flags.Arg(0), func(e music.Event) {
+		if e.Type != music.EventNowPlaying || len(e.Track.Title) == 0 || (last != nil && last.IsSameTrack(e.Track)) {
+			return
+		}
+		last = e.Track
+		line := e.Track.Title
+		if len(e.Track.Artist) > 0 {
+			line = fmt.Sprintf("%s - %s", strings.Join(e.Track.Artist, ", "), e.Track.Title)
+		}
+		fmt.Println(line)
+		if len(*statusFile) > 0 {
+			if err := writeFileAtomic(*statusFile, []byte(line+"\n")); err != nil {
+				slog.Error("Unable to write status file", "Path", *statusFile, "Error", err)
+			}
+		}
+		if conn != nil {
+			id, err := music.NotifyTrack(conn, summary, e.Track, notification)
+			if err != nil {
+				slog.Error("Unable to show notification", "Error", err)
+			} else {
+				notification = id
+			}
+		}
+	})
+}
+
+// Replace the file's contents so that readers never see a partial write
+func writeFileAtomic(path string, data []byte) error {
+	return writeAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// Write to a temporary file and rename it to path once write succeeds
+func writeAtomic(path string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	music "github.com/inventor500/music-watcher"
+)
+
+// Show how many plays are in each genre
+func genresCommand(args []string) error {
+	flags := flag.NewFlagSet("genres", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	period := flags.String("period", "all", "The period to count: day, week, month, year or all.")
+	limit := flags.Int("limit", 20, "The number of genres to show.")
+	flags.Parse(args)
+	from, err := music.PeriodStart(time.Now(), *period)
+	if err != nil {
+		return err
+	}
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	genres, err := music.GetTopItems(context.Background(), db, "genres", from, time.Time{}, *limit, 0)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "Genre\tPlays")
+	for _, g := range genres {
+		fmt.Fprintf(w, "%s\t%d\n", g.Name, g.Plays)
+	}
+	return w.Flush()
+}
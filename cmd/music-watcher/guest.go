@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	dbus "github.com/godbus/dbus/v5"
+	music "github.com/inventor500/music-watcher"
+)
+
+// Start or stop a guest session in the running watcher
+func guestCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: guest start [-exclude] [NAME] | guest stop")
+	}
+	switch args[0] {
+	case "start":
+		return guestStartCommand(args[1:])
+	case "stop":
+		return callControl("StopGuest")
+	default:
+		return fmt.Errorf("unknown guest command %q", args[0])
+	}
+}
+
+func guestStartCommand(args []string) error {
+	flags := flag.NewFlagSet("guest start", flag.ExitOnError)
+	exclude := flags.Bool("exclude", false, "Do not log the guest's plays at all, instead of tagging them.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [options] NAME\n       %s -exclude [NAME]\n", flags.Name(), flags.Name())
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() > 1 || (flags.NArg() == 0 && !*exclude) {
+		flags.Usage()
+		os.Exit(2)
+	}
+	return callControl("StartGuest", flags.Arg(0), *exclude)
+}
+
+// Call a method of the running watcher's control interface
+func callControl(method string, args ...any) error {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Object(music.ControlName, music.ControlPath).Call(music.ControlName+"."+method, 0, args...).Err
+	if dbusErr, ok := err.(dbus.Error); ok && dbusErr.Name == "org.freedesktop.DBus.Error.ServiceUnknown" {
+		return fmt.Errorf("the watcher is not running")
+	}
+	return err
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	music "github.com/inventor500/music-watcher"
+)
+
+// Show how many plays are in each language, optionally detecting the languages of existing tracks first
+func languagesCommand(args []string) error {
+	flags := flag.NewFlagSet("languages", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	detect := flags.Bool("detect", false, "Detect the languages of tracks without one from their titles and albums.")
+	flags.Parse(args)
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	ctx := context.Background()
+	if *detect {
+		updated, err := music.DetectTrackLanguages(ctx, db)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Detected the language of %d tracks\n", updated)
+	}
+	counts, err := music.GetLanguageCounts(ctx, db)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "Language\tPlays")
+	for _, c := range counts {
+		name := c.Name
+		if len(name) == 0 {
+			name = "unknown"
+		}
+		fmt.Fprintf(w, "%s\t%d\n", name, c.Plays)
+	}
+	return w.Flush()
+}
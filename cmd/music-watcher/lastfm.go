@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	music "github.com/inventor500/music-watcher"
+)
+
+// Authorize scrobbling to a Last.fm or GNU FM account, printing the session key for the configuration file
+func lastFMAuthCommand(args []string) error {
+	flags := flag.NewFlagSet("lastfm-auth", flag.ExitOnError)
+	configPath := flags.String("config", defaultConfigPath(), "The location of the configuration file.")
+	username := flags.String("username", "", "Log in with this user's password, read from stdin, instead of authorizing in a browser.")
+	flags.Parse(args)
+	config, err := music.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	if err := config.LastFM.Validate(); err != nil {
+		return fmt.Errorf("%w; set lastfm.apiKey and lastfm.secret in %s", err, *configPath)
+	}
+	ctx := context.Background()
+	stdin := bufio.NewReader(os.Stdin)
+	var name, key string
+	if len(*username) > 0 {
+		fmt.Fprintf(os.Stderr, "Password for %s: ", *username)
+		password, err := stdin.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		name, key, err = music.GetLastFMMobileSession(ctx, config.LastFM, *username, strings.TrimSuffix(password, "\n"))
+		if err != nil {
+			return err
+		}
+	} else {
+		token, err := music.GetLastFMToken(ctx, config.LastFM)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Allow music-watcher to scrobble at:\n\n\t%s\n\nThen press Enter.\n", music.LastFMAuthURL(config.LastFM, token))
+		if _, err := stdin.ReadString('\n'); err != nil {
+			return err
+		}
+		if name, key, err = music.GetLastFMSession(ctx, config.LastFM, token); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Authorized as %s. Add this to the lastfm section of %s:\n\n\t\"sessionKey\": %q\n", name, *configPath, key)
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	music "github.com/inventor500/music-watcher"
+)
+
+// List tracks whose local files no longer exist
+func checkUrlsCommand(args []string) error {
+	flags := flag.NewFlagSet("check-urls", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	flags.Parse(args)
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	missing, err := music.FindMissingFiles(context.Background(), db)
+	if err != nil {
+		return err
+	}
+	for _, track := range missing {
+		fmt.Printf("%d\t%s\t%s\n", track.Id, track.Title, track.Path)
+	}
+	return nil
+}
+
+// Rewrite the location of a moved music library
+func relocateCommand(args []string) error {
+	flags := flag.NewFlagSet("relocate", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	dryRun := flags.Bool("dry-run", false, "Show the changes without applying them.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s relocate [options] OLD_PREFIX NEW_PREFIX\n", flags.Name())
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return fmt.Errorf("expected 2 arguments, received %d", flags.NArg())
+	}
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	changes, err := music.RelocateLibrary(context.Background(), db, flags.Arg(0), flags.Arg(1), *dryRun)
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		if change.Merged {
+			fmt.Printf("%s -> %s (merged)\n", change.OldUrl, change.NewUrl)
+		} else {
+			fmt.Printf("%s -> %s\n", change.OldUrl, change.NewUrl)
+		}
+	}
+	if *dryRun {
+		fmt.Printf("%d tracks would be relocated\n", len(changes))
+	} else {
+		fmt.Printf("Relocated %d tracks\n", len(changes))
+	}
+	return nil
+}
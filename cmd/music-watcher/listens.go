@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	music "github.com/inventor500/music-watcher"
+)
+
+// List, correct, delete or restore listens, or show the changes made to them
+func listensCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected \"list\", \"edit\", \"delete\", \"undo\", \"purge\" or \"audit\"")
+	}
+	switch args[0] {
+	case "list":
+		return listensListCommand(args[1:])
+	case "edit":
+		return listensEditCommand(args[1:])
+	case "delete":
+		return listensDeleteCommand(args[1:])
+	case "undo":
+		return listensUndoCommand(args[1:])
+	case "purge":
+		return listensPurgeCommand(args[1:])
+	case "audit":
+		return listensAuditCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown listens command %q", args[0])
+	}
+}
+
+func listensListCommand(args []string) error {
+	flags := flag.NewFlagSet("listens list", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	limit := flags.Int("limit", 20, "The number of listens to show.")
+	artist := flags.String("artist", "", "Only show listens of this artist.")
+	user := flags.String("user", "", "Only show the listens of this user, rather than the default user's.")
+	flags.Parse(args)
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	listens, _, err := music.QueryListens(music.WithUser(context.Background(), *user), db, music.ListenQuery{Artist: *artist, Limit: *limit})
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTime\tArtists\tTitle\tAlbum")
+	for _, l := range listens {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", l.ID, l.Timestamp, strings.Join(l.Artists, ", "), l.Title, l.Album)
+	}
+	return w.Flush()
+}
+
+func listensEditCommand(args []string) error {
+	flags := flag.NewFlagSet("listens edit", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	title := flags.String("title", "", "Move the listen to the track with this title at the same URL.")
+	artists := flags.String("artist", "", "Replace the artists of the listen's track with this comma separated list.")
+	album := flags.String("album", "", "Replace the album of the listen's track.")
+	played := flags.String("time", "", "When the track was played, as RFC 3339 or a local \"YYYY-MM-DD HH:MM:SS\".")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [options] ID\n", flags.Name())
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	id, err := strconv.ParseInt(flags.Arg(0), 10, 64)
+	if err != nil {
+		return err
+	}
+	edit := music.ListenEdit{Title: *title, Album: *album}
+	if len(*artists) > 0 {
+		for _, name := range strings.Split(*artists, ",") {
+			edit.Artists = append(edit.Artists, strings.TrimSpace(name))
+		}
+	}
+	if len(*played) > 0 {
+		if edit.Timestamp, err = parseListenTime(*played); err != nil {
+			return err
+		}
+	}
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	change, err := music.EditListen(context.Background(), db, id, edit)
+	if err != nil {
+		return err
+	}
+	printListenChange(change)
+	return nil
+}
+
+func listensDeleteCommand(args []string) error {
+	flags := flag.NewFlagSet("listens delete", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [options] ID...\n", flags.Name())
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	ids := make([]int64, flags.NArg())
+	for i, arg := range flags.Args() {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return err
+		}
+		ids[i] = id
+	}
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	changes, err := music.DeleteListens(context.Background(), db, ids...)
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		printListenChange(&change)
+	}
+	return nil
+}
+
+func listensUndoCommand(args []string) error {
+	flags := flag.NewFlagSet("listens undo", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [options] [ID...]\n", flags.Name())
+		fmt.Fprintln(flags.Output(), "Restores the deleted listens, or those deleted most recently.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	ids := make([]int64, flags.NArg())
+	for i, arg := range flags.Args() {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return err
+		}
+		ids[i] = id
+	}
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	changes, err := music.RestoreListens(context.Background(), db, ids...)
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		printListenChange(&change)
+	}
+	fmt.Printf("Restored %d listens\n", len(changes))
+	return nil
+}
+
+func listensPurgeCommand(args []string) error {
+	flags := flag.NewFlagSet("listens purge", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	olderThan := flags.Duration("older-than", 0, "Only purge listens deleted at least this long ago.")
+	flags.Parse(args)
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	changes, err := music.PurgeListens(context.Background(), db, time.Now().Add(-*olderThan))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Purged %d deleted listens\n", len(changes))
+	return nil
+}
+
+func listensAuditCommand(args []string) error {
+	flags := flag.NewFlagSet("listens audit", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	limit := flags.Int("limit", 20, "The number of changes to show.")
+	flags.Parse(args)
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	changes, err := music.GetListenChanges(context.Background(), db, *limit)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "Changed\tListen\tAction\tBefore\tAfter")
+	for _, c := range changes {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", c.Changed.Local().Format(time.DateTime), c.Play, c.Action, describeListen(c.Before), describeListen(c.After))
+	}
+	return w.Flush()
+}
+
+// Parse a time given as RFC 3339, or a local date and time
+func parseListenTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(time.DateTime, value, time.Local)
+}
+
+func describeListen(l *music.AuditedListen) string {
+	if l == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%s %s - %s", l.Timestamp.Local().Format(time.DateTime), strings.Join(l.Artists, ", "), l.Title)
+}
+
+func printListenChange(c *music.ListenChange) {
+	switch {
+	case c.After == nil:
+		fmt.Printf("Deleted listen %d: %s\n", c.Play, describeListen(c.Before))
+		return
+	case c.Before == nil:
+		fmt.Printf("Restored listen %d: %s\n", c.Play, describeListen(c.After))
+		return
+	}
+	fmt.Printf("Changed listen %d: %s -> %s\n", c.Play, describeListen(c.Before), describeListen(c.After))
+}
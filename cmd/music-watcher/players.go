@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	dbus "github.com/godbus/dbus/v5"
+	music "github.com/inventor500/music-watcher"
+)
+
+// List or set whether each player's plays are logged, or show how much each player was used
+func playersCommand(args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return playersListCommand(args)
+	}
+	switch args[0] {
+	case "list":
+		return playersListCommand(args[1:])
+	case "set":
+		return playersSetCommand(args[1:])
+	case "stats":
+		return playersStatsCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown players command %q", args[0])
+	}
+}
+
+func playersListCommand(args []string) error {
+	flags := flag.NewFlagSet("players list", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	flags.Parse(args)
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	policies, err := music.GetPlayerPolicies(context.Background(), db)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "Player\tPolicy\tUpdated")
+	for _, p := range policies {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", p.Player, p.Policy, p.Updated)
+	}
+	// Plays waiting for a decision are only known to the running watcher
+	if conn, err := dbus.SessionBus(); err == nil {
+		defer conn.Close()
+		var pending map[string]uint32
+		if err := conn.Object(music.ControlName, music.ControlPath).Call(music.ControlName+".PendingPlayers", 0).Store(&pending); err == nil {
+			for player, count := range pending {
+				fmt.Fprintf(w, "%s\tpending (%d plays)\t\n", player, count)
+			}
+		}
+	}
+	return w.Flush()
+}
+
+// Show how many plays came from each player
+func playersStatsCommand(args []string) error {
+	flags := flag.NewFlagSet("players stats", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	period := flags.String("period", "all", "The period to count: day, week, month, year or all.")
+	limit := flags.Int("limit", 20, "The number of players to show.")
+	flags.Parse(args)
+	from, err := music.PeriodStart(time.Now(), *period)
+	if err != nil {
+		return err
+	}
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	players, err := music.GetTopItems(context.Background(), db, "players", from, time.Time{}, *limit, 0)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "Player\tPlays")
+	for _, p := range players {
+		fmt.Fprintf(w, "%s\t%d\n", p.Name, p.Plays)
+	}
+	return w.Flush()
+}
+
+func playersSetCommand(args []string) error {
+	flags := flag.NewFlagSet("players set", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [options] PLAYER always|session|never\n", flags.Name())
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	player, policy := flags.Arg(0), music.PlayerPolicy(flags.Arg(1))
+	// Prefer the running watcher, so that held plays are stored or discarded
+	if conn, err := dbus.SessionBus(); err == nil {
+		defer conn.Close()
+		err := conn.Object(music.ControlName, music.ControlPath).Call(music.ControlName+".SetPlayerPolicy", 0, player, string(policy)).Err
+		if err == nil {
+			return nil
+		} else if dbusErr, ok := err.(dbus.Error); !ok || dbusErr.Name != "org.freedesktop.DBus.Error.ServiceUnknown" {
+			return err
+		}
+	}
+	if policy == music.PolicySession {
+		return fmt.Errorf("the watcher is not running, so there is no session to set the policy for")
+	}
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return music.SetPlayerPolicy(context.Background(), db, player, policy)
+}
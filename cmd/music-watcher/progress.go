@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	music "github.com/inventor500/music-watcher"
+)
+
+// Show the resume positions of podcasts and audiobooks
+func progressCommand(args []string) error {
+	flags := flag.NewFlagSet("progress", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	all := flags.Bool("all", false, "Include finished episodes and books.")
+	flags.Parse(args)
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	progress, err := music.GetProgress(context.Background(), db, *all)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "Title\tArtist\tPosition\tLength\tDone\tListened\tLast played")
+	for _, p := range progress {
+		fmt.Fprintf(
+			w,
+			"%s\t%s\t%s\t%s\t%.0f%%\t%s\t%s\n",
+			p.Title,
+			p.Artists,
+			p.Position.Truncate(time.Second),
+			p.Length.Truncate(time.Second),
+			p.Completion*100,
+			p.Listened.Truncate(time.Second),
+			p.Updated,
+		)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	music "github.com/inventor500/music-watcher"
+)
+
+// Apply the retention policy, rolling old plays into daily counts
+func pruneCommand(args []string) error {
+	flags := flag.NewFlagSet("prune", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	configPath := flags.String("config", defaultConfigPath(), "The location of the configuration file.")
+	keepDays := flags.Int("keep-days", -1, "Prune plays older than this many days, instead of the configured retention.")
+	maxSize := flags.Int("max-size-mb", -1, "Prune the oldest plays while the database uses more than this many megabytes, instead of the configured limit.")
+	flags.Parse(args)
+	config, err := music.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	retention := config.Retention
+	if *keepDays >= 0 {
+		retention.KeepDays = *keepDays
+	}
+	if *maxSize >= 0 {
+		retention.MaxSizeMB = *maxSize
+	}
+	if retention.KeepDays == 0 && retention.MaxSizeMB == 0 {
+		return fmt.Errorf("no retention policy is configured")
+	}
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	result, err := music.PrunePlays(context.Background(), db, retention)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Removed %d plays, %d of which were rolled into daily counts\n", result.Removed, result.Rolled)
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	music "github.com/inventor500/music-watcher"
+)
+
+// Show the most played albums, counting the plays of all of their editions together
+func releaseGroupsCommand(args []string) error {
+	flags := flag.NewFlagSet("release-groups", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	period := flags.String("period", "all", "The period to count: day, week, month, year or all.")
+	limit := flags.Int("limit", 20, "The number of albums to show.")
+	user := flags.String("user", "", "Count the plays of this user, rather than the default user's.")
+	flags.Parse(args)
+	from, err := music.PeriodStart(time.Now(), *period)
+	if err != nil {
+		return err
+	}
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	groups, err := music.GetTopReleaseGroups(music.WithUser(context.Background(), *user), db, from, time.Time{}, *limit, 0)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "Album\tEditions\tPlays")
+	for _, g := range groups {
+		fmt.Fprintf(w, "%s\t%d\t%d\n", g.Title, g.Editions, g.Plays)
+	}
+	return w.Flush()
+}
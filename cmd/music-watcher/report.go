@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	dbus "github.com/godbus/dbus/v5"
+	music "github.com/inventor500/music-watcher"
+)
+
+// Collects repeated -p name=value flags
+type paramFlag map[string]string
+
+func (p paramFlag) String() string {
+	return fmt.Sprint(map[string]string(p))
+}
+
+func (p paramFlag) Set(value string) error {
+	name, val, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("expected name=value, received %q", value)
+	}
+	p[name] = val
+	return nil
+}
+
+// List or run the reports defined in the configuration file
+func reportCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected \"list\", \"run\", \"digest\" or \"wrapped\"")
+	}
+	switch args[0] {
+	case "list":
+		return reportListCommand(args[1:])
+	case "run":
+		return reportRunCommand(args[1:])
+	case "digest":
+		return reportDigestCommand(args[1:])
+	case "wrapped":
+		return reportWrappedCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown report command %q", args[0])
+	}
+}
+
+func reportListCommand(args []string) error {
+	flags := flag.NewFlagSet("report list", flag.ExitOnError)
+	configPath := flags.String("config", defaultConfigPath(), "The location of the configuration file.")
+	flags.Parse(args)
+	config, err := music.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(config.Reports))
+	for name := range config.Reports {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		report := config.Reports[name]
+		fmt.Printf("%s(%s)\t%s\n", name, strings.Join(report.Params, ", "), report.Description)
+	}
+	return nil
+}
+
+func reportRunCommand(args []string) error {
+	flags := flag.NewFlagSet("report run", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	configPath := flags.String("config", defaultConfigPath(), "The location of the configuration file.")
+	asJson := flags.Bool("json", false, "Print the result as JSON.")
+	params := make(paramFlag)
+	flags.Var(params, "p", "A report parameter as name=value. May be repeated.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [options] NAME\n", flags.Name())
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("expected 1 argument, received %d", flags.NArg())
+	}
+	config, err := music.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	report, ok := config.Reports[flags.Arg(0)]
+	if !ok {
+		return fmt.Errorf("%w: %s", music.ErrUnknownReport, flags.Arg(0))
+	}
+	db, err := openReadOnlyDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	result, err := music.RunReport(context.Background(), db, report, params)
+	if err != nil {
+		return err
+	}
+	if *asJson {
+		return json.NewEncoder(os.Stdout).Encode(result)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(result.Columns, "\t"))
+	for _, row := range result.Rows {
+		for i, value := range row {
+			if i > 0 {
+				fmt.Fprint(w, "\t")
+			}
+			if value != nil {
+				fmt.Fprint(w, value)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+	return w.Flush()
+}
+
+// Print or mail a summary of recent plays
+func reportDigestCommand(args []string) error {
+	flags := flag.NewFlagSet("report digest", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	configPath := flags.String("config", defaultConfigPath(), "The location of the configuration file.")
+	period := flags.String("period", "", "The period to summarize: day, week, month or year. Defaults to the configured period, or week.")
+	asHTML := flags.Bool("html", false, "Render the digest as HTML.")
+	mail := flags.Bool("mail", false, "Mail the digest using the configured settings instead of printing it.")
+	user := flags.String("user", "", "Summarize the plays of this user, rather than the default user's.")
+	flags.Parse(args)
+	config, err := music.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	if len(*period) > 0 {
+		config.Digest.Period = *period
+	}
+	db, err := openReadOnlyDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	digest, err := config.Digest.Build(music.WithUser(context.Background(), *user), db)
+	if err != nil {
+		return err
+	}
+	if !*mail {
+		return digest.Render(os.Stdout, *asHTML || config.Digest.HTML)
+	}
+	// The session bus is only needed for a password in the keyring
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		conn = nil
+	} else {
+		defer conn.Close()
+	}
+	return music.MailDigest(conn, config.Digest.Mail, digest, *asHTML || config.Digest.HTML)
+}
+
+// Write the recap of a year as an HTML page
+func reportWrappedCommand(args []string) error {
+	flags := flag.NewFlagSet("report wrapped", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	year := flags.Int("year", time.Now().Year(), "The year to recap.")
+	limit := flags.Int("limit", 10, "The number of entries in each list.")
+	output := flags.String("o", "-", "The file to write the page to, or - for stdout.")
+	user := flags.String("user", "", "Recap the plays of this user, rather than the default user's.")
+	flags.Parse(args)
+	db, err := openReadOnlyDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	wrapped, err := music.GetWrapped(music.WithUser(context.Background(), *user), db, *year, *limit)
+	if err != nil {
+		return err
+	}
+	if *output == "-" {
+		return wrapped.Render(os.Stdout)
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := wrapped.Render(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Open the database so that no statement can modify it
+func openReadOnlyDB(path string) (*sql.DB, error) {
+	path, err := resolveDBPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return sql.Open(sqliteDriver, readOnlyDSN(path))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	music "github.com/inventor500/music-watcher"
+)
+
+// Parse the stored raw metadata of tracks again, with the current tag and rewrite settings
+func reprocessCommand(args []string) error {
+	flags := flag.NewFlagSet("reprocess", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	configPath := flags.String("config", defaultConfigPath(), "The location of the configuration file.")
+	dryRun := flags.Bool("dry-run", false, "Show the changes without applying them.")
+	flags.Parse(args)
+	config, err := music.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	rewriter, err := music.NewRewriter(config.Rewrite)
+	if err != nil {
+		return err
+	}
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	changes, err := music.ReprocessTracks(context.Background(), db, func(m *music.Metadata) {
+		if config.Tags.Read {
+			if err := music.ReadFileTags(m); err != nil {
+				fmt.Printf("Unable to read the tags of %s: %s\n", m.Url, err)
+			}
+		}
+		rewriter.Apply(m)
+	}, *dryRun)
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		switch {
+		case change.Merged:
+			fmt.Printf("%d\t%s -> %s (merged)\n", change.Id, change.OldTitle, change.Title)
+		case change.OldTitle != change.Title:
+			fmt.Printf("%d\t%s -> %s\n", change.Id, change.OldTitle, change.Title)
+		default:
+			fmt.Printf("%d\t%s\n", change.Id, change.Title)
+		}
+	}
+	if *dryRun {
+		fmt.Printf("%d tracks would be changed\n", len(changes))
+	} else {
+		fmt.Printf("Changed %d tracks\n", len(changes))
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	music "github.com/inventor500/music-watcher"
+)
+
+// Replace the database with a backup after checking it, or compare a backup's plays with the database's
+func restoreCommand(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	verify := flags.Bool("verify", false, "Check the backup and compare its plays with the database's, without restoring it.")
+	yes := flags.Bool("yes", false, "Restore the backup without asking.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [options] SRC\n", flags.Name())
+		fmt.Fprintln(flags.Output(), "SRC is a backup written by the backup command, which may be compressed.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	path, err := resolveDBPath(*dbPath)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	// The backup is extracted beside the database, which has room for a copy of it
+	extracted, err := os.CreateTemp(filepath.Dir(path), "restore-*.db")
+	if err != nil {
+		return err
+	}
+	extracted.Close()
+	defer func() {
+		for _, suffix := range []string{"", "-journal", "-wal", "-shm"} {
+			os.Remove(extracted.Name() + suffix)
+		}
+	}()
+	if err := music.ExtractBackup(flags.Arg(0), extracted.Name()); err != nil {
+		return err
+	}
+	backupCount, backupHash, err := checkBackup(ctx, extracted.Name())
+	if err != nil {
+		return err
+	}
+	db, err := createDB(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	count, hash, err := music.PlayChecksum(ctx, db)
+	if err != nil {
+		return err
+	}
+	if *verify {
+		fmt.Printf("Backup:   %d plays, %s\n", backupCount, backupHash)
+		fmt.Printf("Database: %d plays, %s\n", count, hash)
+		if hash == backupHash {
+			fmt.Println("The backup has the same plays as the database")
+		} else {
+			fmt.Println("The backup's plays differ from the database's")
+		}
+		return nil
+	}
+	if !*yes {
+		fmt.Printf("Replace the database's %d plays with the backup's %d plays? [y/N] ", count, backupCount)
+		answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			return nil
+		}
+	}
+	// The database is kept as it was, in case the wrong backup was restored
+	previous := path + ".before-restore"
+	os.Remove(previous)
+	if err := backupDatabase(ctx, db, previous); err != nil {
+		return err
+	}
+	fmt.Printf("Saved the database as it was to %s\n", previous)
+	if err := restoreDatabase(ctx, db, extracted.Name()); err != nil {
+		return err
+	}
+	if count, hash, err = music.PlayChecksum(ctx, db); err != nil {
+		return err
+	}
+	if hash != backupHash {
+		return fmt.Errorf("the restored database has %d plays, rather than the backup's %d", count, backupCount)
+	}
+	fmt.Printf("Restored %d plays from %s\n", count, flags.Arg(0))
+	return nil
+}
+
+// Validate the extracted backup and bring its tables up to date, returning the number of plays in it and their hash
+func checkBackup(ctx context.Context, path string) (int64, string, error) {
+	db, err := sql.Open(sqliteDriver, dataSourceName(path, nil))
+	if err != nil {
+		return 0, "", err
+	}
+	defer db.Close()
+	if err := music.ValidateBackup(ctx, db); err != nil {
+		return 0, "", err
+	}
+	if err := music.CreateDatabaseStructure(db); err != nil {
+		return 0, "", err
+	}
+	return music.PlayChecksum(ctx, db)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	music "github.com/inventor500/music-watcher"
+)
+
+// Show the listening sessions that started on a day
+func sessionsCommand(args []string) error {
+	flags := flag.NewFlagSet("sessions", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	date := flags.String("date", time.Now().Format(time.DateOnly), "The day to show, as YYYY-MM-DD in local time.")
+	user := flags.String("user", "", "Only show the plays of this user, rather than the default user's.")
+	flags.Parse(args)
+	day, err := time.ParseInLocation(time.DateOnly, *date, time.Local)
+	if err != nil {
+		return err
+	}
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	sessions, err := music.GetListeningSessions(music.WithUser(context.Background(), *user), db, day, day.AddDate(0, 0, 1))
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "Start\tEnd\tLength\tPlays")
+	var total time.Duration
+	for _, s := range sessions {
+		length := s.End.Sub(s.Start)
+		total += length
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", s.Start.Local().Format(time.TimeOnly), s.End.Local().Format(time.TimeOnly), length.Truncate(time.Second), s.Plays)
+	}
+	fmt.Fprintf(w, "Total\t\t%s\t\n", total.Truncate(time.Second))
+	return w.Flush()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	dbus "github.com/godbus/dbus/v5"
+	music "github.com/inventor500/music-watcher"
+)
+
+// Print whether each of the running daemon's sinks is enabled and healthy
+func sinksCommand(args []string) error {
+	flags := flag.NewFlagSet("sinks", flag.ExitOnError)
+	flags.Parse(args)
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var report string
+	if err := conn.Object(music.ControlName, music.ControlPath).Call(music.ControlName+".Sinks", 0).Store(&report); err != nil {
+		return err
+	}
+	var statuses []music.SinkStatus
+	if err := json.Unmarshal([]byte(report), &statuses); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "Sink\tState\tQueued\tError")
+	for _, s := range statuses {
+		state := "ok"
+		switch {
+		case !s.Enabled:
+			state = "disabled"
+		case !s.Healthy:
+			state = "failing"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", s.Name, state, s.Queued, s.Error)
+	}
+	return w.Flush()
+}
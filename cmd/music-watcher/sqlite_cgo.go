@@ -0,0 +1,81 @@
+//go:build !purego
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+
+	music "github.com/inventor500/music-watcher"
+	"github.com/mattn/go-sqlite3"
+)
+
+// The SQLite driver. Build with -tags purego for a driver that does not need CGO.
+const sqliteDriver = "sqlite3"
+
+// Get the data source name for opening the database with foreign keys enforced and the pragmas set.
+// The driver waits up to five seconds for other connections' locks by default.
+func dataSourceName(path string, pragmas []music.SQLitePragma) string {
+	params := url.Values{"_foreign_keys": {"1"}}
+	for _, p := range pragmas {
+		// The driver sets the busy timeout before the others
+		params.Set("_"+p.Name, p.Value)
+	}
+	return path + "?" + params.Encode()
+}
+
+// An in-memory database shared by the connections of the pool
+const memoryDSN = "file:/music-watcher?vfs=memdb&_foreign_keys=1"
+
+// Get the data source name for opening the database so that no statement can modify it
+func readOnlyDSN(path string) string {
+	return fmt.Sprintf("file:%s?mode=ro&_query_only=1", path)
+}
+
+// Copy the database to the path with SQLite's online backup API
+func backupDatabase(ctx context.Context, db *sql.DB, path string) error {
+	return copyDatabase(ctx, db, path, false)
+}
+
+// Replace the contents of the database with the database at the path, with SQLite's online backup API
+func restoreDatabase(ctx context.Context, db *sql.DB, path string) error {
+	return copyDatabase(ctx, db, path, true)
+}
+
+func copyDatabase(ctx context.Context, db *sql.DB, path string, restore bool) error {
+	other, err := sql.Open(sqliteDriver, path)
+	if err != nil {
+		return err
+	}
+	defer other.Close()
+	otherConn, err := other.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer otherConn.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	dstConn, srcConn := otherConn, conn
+	if restore {
+		dstConn, srcConn = conn, otherConn
+	}
+	return dstConn.Raw(func(d any) error {
+		return srcConn.Raw(func(s any) error {
+			backup, err := d.(*sqlite3.SQLiteConn).Backup("main", s.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+			// The driver reports a busy or locked database as not being done, so that step is tried again
+			err = copyPages(ctx, func(n int) (bool, error) { return backup.Step(n) })
+			if finishErr := backup.Finish(); err == nil {
+				err = finishErr
+			}
+			return err
+		})
+	})
+}
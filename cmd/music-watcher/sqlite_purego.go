@@ -0,0 +1,81 @@
+//go:build purego
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	music "github.com/inventor500/music-watcher"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// A pure Go SQLite driver, for building without CGO, such as when cross-compiling
+const sqliteDriver = "sqlite"
+
+// This driver fails at once when another connection holds a lock, so wait as long as the CGO driver does
+const busyTimeoutPragma = "_pragma=busy_timeout(5000)"
+
+// Get the data source name for opening the database with foreign keys enforced and the pragmas set.
+// The driver sets the pragmas in order, so the later ones replace the default busy timeout.
+func dataSourceName(path string, pragmas []music.SQLitePragma) string {
+	dsn := fmt.Sprintf("file:%s?%s&_pragma=foreign_keys(1)", path, busyTimeoutPragma)
+	for _, p := range pragmas {
+		dsn += fmt.Sprintf("&_pragma=%s(%s)", p.Name, p.Value)
+	}
+	return dsn
+}
+
+// An in-memory database shared by the connections of the pool
+const memoryDSN = "file:/music-watcher?vfs=memdb&_pragma=foreign_keys(1)&" + busyTimeoutPragma
+
+// Get the data source name for opening the database so that no statement can modify it
+func readOnlyDSN(path string) string {
+	return fmt.Sprintf("file:%s?mode=ro&_pragma=query_only(1)&%s", path, busyTimeoutPragma)
+}
+
+// Copy the database to the path with SQLite's online backup API
+func backupDatabase(ctx context.Context, db *sql.DB, path string) error {
+	return copyDatabase(ctx, db, func(c backupConn) (*sqlite.Backup, error) { return c.NewBackup(path) })
+}
+
+// Replace the contents of the database with the database at the path, with SQLite's online backup API
+func restoreDatabase(ctx context.Context, db *sql.DB, path string) error {
+	return copyDatabase(ctx, db, func(c backupConn) (*sqlite.Backup, error) { return c.NewRestore(path) })
+}
+
+// The driver's connection, which starts backups
+type backupConn interface {
+	NewBackup(dstUri string) (*sqlite.Backup, error)
+	NewRestore(srcUri string) (*sqlite.Backup, error)
+}
+
+func copyDatabase(ctx context.Context, db *sql.DB, start func(c backupConn) (*sqlite.Backup, error)) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(c any) error {
+		backup, err := start(c.(backupConn))
+		if err != nil {
+			return err
+		}
+		err = copyPages(ctx, func(n int) (bool, error) {
+			more, err := backup.Step(int32(n))
+			var e *sqlite.Error
+			if errors.As(err, &e) && (e.Code()&0xff == sqlite3.SQLITE_BUSY || e.Code()&0xff == sqlite3.SQLITE_LOCKED) {
+				// Tried again after waiting
+				return false, nil
+			}
+			return !more && err == nil, err
+		})
+		if finishErr := backup.Finish(); err == nil {
+			err = finishErr
+		}
+		return err
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	music "github.com/inventor500/music-watcher"
+)
+
+// Show the play statistics of an artist or an album
+func statsCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected \"artist\" or \"album\"")
+	}
+	switch args[0] {
+	case "artist":
+		return statsArtistCommand(args[1:])
+	case "album":
+		return statsAlbumCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown stats command %q", args[0])
+	}
+}
+
+func statsArtistCommand(args []string) error {
+	flags := flag.NewFlagSet("stats artist", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	limit := flags.Int("limit", 10, "The number of entries to show in each list.")
+	user := flags.String("user", "", "Count the plays of this user, rather than the default user's.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [options] NAME\n", flags.Name())
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	stats, err := music.GetArtistStats(music.WithUser(context.Background(), *user), db, flags.Arg(0), *limit)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("no plays of artist %q", flags.Arg(0))
+	} else if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	printStatsSummary(w, stats.Name, stats.Plays, stats.Listened, stats.FirstPlayed, stats.LastPlayed, len(stats.Timeline))
+	printNameCounts(w, "Track", stats.TopTracks)
+	printNameCounts(w, "Album", stats.TopAlbums)
+	printNameCounts(w, "Played with", stats.CoListened)
+	return w.Flush()
+}
+
+func statsAlbumCommand(args []string) error {
+	flags := flag.NewFlagSet("stats album", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	limit := flags.Int("limit", 10, "The number of entries to show in each list.")
+	user := flags.String("user", "", "Count the plays of this user, rather than the default user's.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [options] TITLE\n", flags.Name())
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	stats, err := music.GetAlbumStats(music.WithUser(context.Background(), *user), db, flags.Arg(0), *limit)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("no plays of album %q", flags.Arg(0))
+	} else if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	printStatsSummary(w, stats.Title, stats.Plays, stats.Listened, stats.FirstPlayed, stats.LastPlayed, len(stats.Timeline))
+	if len(stats.AlbumArtists) > 0 {
+		fmt.Fprintf(w, "Album artists:\t%s\n", strings.Join(stats.AlbumArtists, ", "))
+	}
+	if stats.VariousArtists {
+		fmt.Fprintln(w, "Compilation:\tyes")
+	}
+	fmt.Fprintln(w, "\nDisc\tNumber\tTrack\tPlays")
+	for _, t := range stats.Tracks {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", optionalNumber(t.Disc), optionalNumber(t.Number), t.Title, t.Plays)
+	}
+	printNameCounts(w, "Artist", stats.Artists)
+	return w.Flush()
+}
+
+func printStatsSummary(w *tabwriter.Writer, name string, plays int, listened time.Duration, first, last string, days int) {
+	fmt.Fprintf(w, "Name:\t%s\n", name)
+	fmt.Fprintf(w, "Plays:\t%d\n", plays)
+	fmt.Fprintf(w, "Listened:\t%s\n", listened.Round(time.Minute))
+	fmt.Fprintf(w, "First played:\t%s\n", first)
+	fmt.Fprintf(w, "Last played:\t%s\n", last)
+	fmt.Fprintf(w, "Days played:\t%d\n", days)
+}
+
+func printNameCounts(w *tabwriter.Writer, heading string, counts []music.NameCount) {
+	if len(counts) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n%s\tPlays\n", heading)
+	for _, c := range counts {
+		fmt.Fprintf(w, "%s\t%d\n", c.Name, c.Plays)
+	}
+}
+
+// Format a disc or track number, which is 0 when it is not known
+func optionalNumber(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprint(n)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+type DatabaseStatus struct {
+	Path  string `json:"path"`
+	Plays int64  `json:"plays"`
+}
+
+// Machine-readable description of the databases found on this system
+type Status struct {
+	Database   DatabaseStatus   `json:"database"`
+	Duplicates []DatabaseStatus `json:"duplicates"`
+	Hint       string           `json:"hint,omitempty"`
+}
+
+const mergeHint = "Multiple music-watcher databases were found. Merge the duplicates into the active database with \"music-watcher sync PATH\", or pass -dbpath so that every instance uses the same file."
+
+// Print the database status as JSON
+func statusCommand(args []string) error {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	flags.Parse(args)
+	status, err := getStatus(*dbPath)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(status)
+}
+
+func getStatus(dbPath string) (*Status, error) {
+	path, err := resolveDBPath(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	status := Status{
+		Database:   DatabaseStatus{Path: path, Plays: countPlays(path)},
+		Duplicates: []DatabaseStatus{},
+	}
+	for _, other := range findOtherDatabases(path) {
+		status.Duplicates = append(status.Duplicates, DatabaseStatus{Path: other, Plays: countPlays(other)})
+	}
+	if len(status.Duplicates) > 0 {
+		status.Hint = mergeHint
+	}
+	return &status, nil
+}
+
+// Log a warning if databases other than the active one exist in standard locations
+func warnDuplicateDatabases(dbPath string) {
+	status, err := getStatus(dbPath)
+	if err != nil {
+		slog.Warn("Unable to check for duplicate databases", "Error", err)
+		return
+	}
+	for _, dup := range status.Duplicates {
+		slog.Warn("Found another music-watcher database", "Path", dup.Path, "Plays", dup.Plays, "Active", status.Database.Path)
+	}
+	if len(status.Duplicates) > 0 {
+		slog.Warn(mergeHint, "Status", "music-watcher status")
+	}
+}
+
+// Find databases in the standard locations that are not the same file as path
+func findOtherDatabases(path string) []string {
+	var candidates []string
+	if xdgPath, ok := os.LookupEnv("XDG_DATA_HOME"); ok {
+		candidates = append(candidates, filepath.Join(xdgPath, "music-watcher", "data.db"))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		candidates = append(candidates, filepath.Join(home, ".local/share", "music-watcher", "data.db"))
+	}
+	active, err := os.Stat(path)
+	var found []string
+	for _, candidate := range candidates {
+		stat, statErr := os.Stat(candidate)
+		if statErr != nil || stat.IsDir() {
+			continue
+		}
+		if err == nil && os.SameFile(active, stat) {
+			continue
+		}
+		if isDuplicate(found, stat) {
+			continue
+		}
+		found = append(found, candidate)
+	}
+	return found
+}
+
+func isDuplicate(paths []string, stat os.FileInfo) bool {
+	for _, path := range paths {
+		if other, err := os.Stat(path); err == nil && os.SameFile(other, stat) {
+			return true
+		}
+	}
+	return false
+}
+
+// Count the plays in the database, returning -1 if it could not be read
+func countPlays(path string) int64 {
+	db, err := sql.Open(sqliteDriver, readOnlyDSN(path))
+	if err != nil {
+		return -1
+	}
+	defer db.Close()
+	var count int64
+	// Databases from older versions have no view of every partition
+	if err := db.QueryRow("SELECT COUNT(*) FROM TrackLogAll").Scan(&count); err != nil {
+		if err := db.QueryRow("SELECT COUNT(*) FROM TrackLog").Scan(&count); err != nil {
+			return -1
+		}
+	}
+	return count
+}
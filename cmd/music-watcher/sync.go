@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	music "github.com/inventor500/music-watcher"
+)
+
+// Exchange the plays that the database and another database, or another watcher, do not have in common
+func syncCommand(args []string) error {
+	flags := flag.NewFlagSet("sync", flag.ExitOnError)
+	dbPath := flags.String("dbpath", defaultDBPath(), "The location of the database file.")
+	token := flags.String("token", os.Getenv("MUSIC_WATCHER_SYNC_TOKEN"), "The other watcher's http.syncToken, when PEER is a URL. Defaults to $MUSIC_WATCHER_SYNC_TOKEN.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [options] PEER\n", flags.Name())
+		fmt.Fprintln(flags.Output(), "PEER is the path of another database, or the URL of another watcher's HTTP interface, such as http://desktop:8265.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	db, err := createDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	name := flags.Arg(0)
+	var peer music.SyncPeer
+	if strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://") {
+		if len(*token) == 0 {
+			return fmt.Errorf("a token is needed to sync with %s", name)
+		}
+		peer = music.HTTPPeer{URL: name, Token: *token}
+	} else {
+		// The database is remembered by its full path, however it was given
+		if name, err = filepath.Abs(name); err != nil {
+			return err
+		}
+		if _, err := os.Stat(name); err != nil {
+			return err
+		}
+		other, err := createDB(name)
+		if err != nil {
+			return err
+		}
+		defer other.Close()
+		peer = music.DatabasePeer{DB: other}
+	}
+	result, err := music.SyncPlays(context.Background(), db, name, peer)
+	fmt.Printf("Sent %d plays and received %d plays\n", result.Sent, result.Received)
+	return err
+}
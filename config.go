@@ -0,0 +1,117 @@
+package music_watch
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// Settings read from the configuration file
+type Config struct {
+	// Map of task name to the cron expression it runs on
+	Schedule map[string]string `json:"schedule"`
+	Session  SessionConfig     `json:"session"`
+	// The machine that plays are recorded as being played on
+	Device DeviceConfig `json:"device"`
+	// How the SQLite database is opened
+	SQLite SQLiteConfig `json:"sqlite"`
+	// How long plays are kept, applied by the prune task and command
+	Retention RetentionConfig `json:"retention"`
+	// Named SQL queries that can be run as reports
+	Reports map[string]ReportConfig `json:"reports"`
+	// Which tracks are podcasts or audiobooks whose progress should be tracked
+	Progress ProgressConfig `json:"progress"`
+	// Plays that are left out of the history
+	Filter FilterConfig `json:"filter"`
+	// A file that plays are appended to as well as the database
+	JSONL JSONLConfig `json:"jsonl"`
+	// Which tracks are podcasts, and whether they are logged with music
+	Podcasts PodcastConfig `json:"podcasts"`
+	Webhook  WebhookConfig `json:"webhook"`
+	Consent  ConsentConfig `json:"consent"`
+	HTTP     HTTPConfig    `json:"http"`
+	// Services that plays are mirrored to
+	ListenBrainz   ListenBrainzConfig   `json:"listenbrainz"`
+	LastFM         LastFMConfig         `json:"lastfm"`
+	Maloja         MalojaConfig         `json:"maloja"`
+	Hooks          []HookConfig         `json:"hooks"`
+	MQTT           MQTTConfig           `json:"mqtt"`
+	Discord        DiscordConfig        `json:"discord"`
+	NowPlayingFile NowPlayingFileConfig `json:"nowPlayingFile"`
+	// Messages to a chat about new albums, the day's plays and errors
+	Notify      NotifyConfig      `json:"notify"`
+	ActivityPub ActivityPubConfig `json:"activityPub"`
+	// The summary of recent plays mailed by the digest task
+	Digest DigestConfig `json:"digest"`
+	// Set a sink to false to stop sending to it without removing its settings
+	Sinks map[string]bool `json:"sinks"`
+	// External lookups used to fill in missing metadata
+	Cache       CacheConfig       `json:"cache"`
+	MusicBrainz MusicBrainzConfig `json:"musicbrainz"`
+	CoverArt    CoverArtConfig    `json:"coverArt"`
+	Artwork     ArtworkConfig     `json:"artwork"`
+	Tags        TagsConfig        `json:"tags"`
+	// Find and replace rules that clean up fields before tracks are stored
+	Rewrite  RewriteConfig  `json:"rewrite"`
+	Language LanguageConfig `json:"language"`
+	Tracing  TracingConfig  `json:"tracing"`
+}
+
+// Settings for excluding plays while the user is away, and for grouping plays into listening sessions
+type SessionConfig struct {
+	// Do not log plays while the screen is locked
+	PauseWhenLocked bool `json:"pauseWhenLocked"`
+	// Do not log plays after the session has been idle for this many minutes; 0 disables
+	IdleMinutes int `json:"idleMinutes"`
+	// Plays starting less than this many minutes after the previous play ended are in the same listening session;
+	// defaults to 30. Plays stored before sessions were recorded are grouped with the default.
+	GapMinutes int `json:"gapMinutes"`
+}
+
+// Get the gap that ends a listening session
+func (c SessionConfig) Gap() time.Duration {
+	if c.GapMinutes <= 0 {
+		return defaultSessionGap
+	}
+	return time.Duration(c.GapMinutes) * time.Minute
+}
+
+// How plays stored by this watcher are told apart from plays stored on other machines
+type DeviceConfig struct {
+	// The name recorded with each play; defaults to the host name
+	Host string `json:"host"`
+	// A label shown in statistics in place of the host name, such as "laptop"
+	Label string `json:"label"`
+}
+
+// Get the host name recorded with each play, or "" if it cannot be found
+func (c DeviceConfig) HostName() string {
+	if len(c.Host) > 0 {
+		return c.Host
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// Read the configuration file, returning an empty configuration if it does not exist
+func LoadConfig(path string) (*Config, error) {
+	var config Config
+	if len(path) == 0 {
+		return &config, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &config, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
@@ -0,0 +1,434 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	dbus "github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const ControlName = "org.inventor500.MusicWatcher"
+const ControlPath = "/org/inventor500/MusicWatcher"
+
+var ErrAlreadyRunning = errors.New("another instance already owns the control interface")
+var ErrInvalidGuest = errors.New("guest sessions that are logged need a name")
+
+// The inhibitor used for guest sessions whose plays are not logged
+const guestInhibitor = "guest"
+
+// State shared between the watcher and the control interface
+type Controller struct {
+	Events     *EventHub
+	db         *sql.DB
+	history    Store // Answers queries of the history; the SQLite database unless another store is set
+	started    time.Time
+	lock       sync.Mutex
+	paused     bool
+	inhibitors map[string]bool // Reasons other than private mode that logging is suspended
+	nowPlaying *Metadata
+	scrobbles  uint64
+	guest      string        // The name of the current guest session, if plays are being tagged
+	consent    *Consent      // Optional; holds plays from players without a policy
+	store      StoreCallback // The wrapped callback, for storing held plays
+	sinks      *SinkRegistry // Optional; reported by the control interface
+	user       string        // The user whose plays the control interface shows; "" for the default user
+}
+
+func NewController(db *sql.DB) *Controller {
+	return &Controller{Events: NewEventHub(), db: db, history: &SQLiteStore{DB: db}, started: time.Now(), inhibitors: make(map[string]bool)}
+}
+
+// Set the store that the HTTP and gRPC interfaces query for plays
+func (c *Controller) SetStore(store Store) {
+	c.history = store
+}
+
+// Set the user whose plays the control interface shows,
+// as the HTTP and gRPC servers show the plays of the user in their context
+func (c *Controller) SetUser(user string) {
+	c.user = user
+	if store, ok := c.history.(*SQLiteStore); ok {
+		store.User = user
+	}
+}
+
+// Wrap the callback so that it respects the controller's state
+func (c *Controller) Wrap(callback StoreCallback) StoreCallback {
+	c.lock.Lock()
+	c.store = callback
+	c.lock.Unlock()
+	return func(ctx context.Context, m *Metadata) error {
+		c.lock.Lock()
+		c.nowPlaying = m
+		paused := c.paused
+		inhibitors := c.inhibitorList()
+		guest := c.guest
+		c.lock.Unlock()
+		span := trace.SpanFromContext(ctx)
+		if paused {
+			slog.DebugContext(ctx, "Logging is paused, not storing track", "Title", m.Title, "Player", m.Player)
+			span.AddEvent("Logging is paused")
+			return nil
+		}
+		if len(inhibitors) > 0 {
+			slog.DebugContext(ctx, "Logging is inhibited, not storing track", "Title", m.Title, "Player", m.Player, "Reasons", inhibitors)
+			span.AddEvent("Logging is inhibited", trace.WithAttributes(attribute.StringSlice("reasons", inhibitors)))
+			return nil
+		}
+		if c.consent != nil {
+			if allowed, err := c.consent.Allowed(ctx, m); err != nil || !allowed {
+				span.AddEvent("Play is held for consent")
+				return err
+			}
+		}
+		if len(guest) > 0 {
+			ctx = withGuest(ctx, guest)
+		}
+		// Published only once the track will be logged, so subscribers do not learn of plays that are not
+		c.Events.Publish(Event{Type: EventNowPlaying, Time: time.Now(), Track: m, Guest: guest})
+		return c.storeTrack(ctx, m)
+	}
+}
+
+func (c *Controller) storeTrack(ctx context.Context, m *Metadata) error {
+	start := time.Now()
+	err := c.store(ctx, m)
+	recordLatency("store", time.Since(start))
+	if errors.Is(err, ErrDuplicatePlay) {
+		slog.InfoContext(ctx, "Track was already stored for this play, skipping it", "Title", m.Title, "Player", m.Player)
+		trace.SpanFromContext(ctx).AddEvent("Track was already stored")
+		return nil
+	} else if errors.Is(err, ErrFiltered) {
+		slog.DebugContext(ctx, "Track was filtered out, not logging it", "Title", m.Title, "Player", m.Player, "Reason", err)
+		trace.SpanFromContext(ctx).AddEvent("Track was filtered out")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	c.scrobbles++
+	c.lock.Unlock()
+	c.Events.Publish(Event{Type: EventScrobble, Time: playedAt(ctx), Track: m, Guest: guestSession(ctx), trace: trace.SpanContextFromContext(ctx)})
+	return nil
+}
+
+// Publish changes in playback reported by the watcher, such as a track being paused.
+// Now playing events are published when the track is received instead.
+func (c *Controller) PublishPlayback(ctx context.Context, e Event) {
+	if e.Type != EventNowPlaying {
+		c.Events.Publish(e)
+	}
+}
+
+// Get whether the event can be sent outside the machine, such as to a scrobbling service
+func (c *Controller) Shareable(ctx context.Context, e Event) bool {
+	switch e.Type {
+	case EventScrobble:
+		// Guests' plays stay out of the user's accounts
+		return len(e.Guest) == 0
+	case EventNowPlaying, EventPaused, EventResumed, EventPlayFinished:
+		return c.Sharing(ctx, e.Track)
+	}
+	return false
+}
+
+// Get whether the track would be logged now, without holding it for consent
+func (c *Controller) Sharing(ctx context.Context, m *Metadata) bool {
+	if !c.Logging() || len(c.Guest()) > 0 {
+		return false
+	}
+	return c.consent == nil || c.consent.Known(ctx, m.Player)
+}
+
+// Hold plays from players without a policy until one is chosen with DecidePlayer
+func (c *Controller) SetConsent(consent *Consent) {
+	c.consent = consent
+}
+
+// Set a player's policy, storing the plays that were held for it if it is now logged
+func (c *Controller) DecidePlayer(ctx context.Context, player string, policy PlayerPolicy) error {
+	if c.consent == nil {
+		return SetPlayerPolicy(ctx, c.db, player, policy)
+	}
+	plays, err := c.consent.Decide(ctx, player, policy)
+	if err != nil {
+		return err
+	}
+	for _, play := range plays {
+		if err := c.storeTrack(withPlayedAt(ctx, play.time), play.track); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Controller) SetSinks(sinks *SinkRegistry) {
+	c.sinks = sinks
+}
+
+func (c *Controller) SetPaused(paused bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.paused != paused {
+		slog.Info("Changed logging state", "Paused", paused)
+	}
+	c.paused = paused
+}
+
+// Switch between logging and private mode, returning the new state
+func (c *Controller) Toggle() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.paused = !c.paused
+	slog.Info("Changed logging state", "Paused", c.paused)
+	return c.paused
+}
+
+// Toggle private mode whenever one of the signals is received, until the context is cancelled
+func (c *Controller) ToggleOnSignal(ctx context.Context, sigs ...os.Signal) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, sigs...)
+	defer signal.Stop(sigChan)
+	for {
+		select {
+		case <-sigChan:
+			c.Toggle()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Suspend or resume logging for a reason other than private mode, such as the screen being locked
+func (c *Controller) SetInhibited(reason string, inhibited bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.inhibitors[reason] != inhibited {
+		slog.Info("Changed logging inhibitor", "Reason", reason, "Inhibited", inhibited)
+	}
+	if inhibited {
+		c.inhibitors[reason] = true
+	} else {
+		delete(c.inhibitors, reason)
+	}
+}
+
+// Start a guest session, for when someone else is using the machine.
+// Their plays are either tagged with the guest's name, which keeps them out of stats and sinks,
+// or not logged at all.
+func (c *Controller) StartGuest(name string, exclude bool) error {
+	if len(name) == 0 && !exclude {
+		return ErrInvalidGuest
+	}
+	c.StopGuest()
+	if exclude {
+		c.SetInhibited(guestInhibitor, true)
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.guest = name
+	slog.Info("Started guest session", "Guest", name)
+	return nil
+}
+
+// End the guest session, if there is one
+func (c *Controller) StopGuest() {
+	c.SetInhibited(guestInhibitor, false)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.guest) > 0 {
+		slog.Info("Stopped guest session", "Guest", c.guest)
+	}
+	c.guest = ""
+}
+
+// Get the name of the guest whose plays are being tagged, or ""
+func (c *Controller) Guest() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.guest
+}
+
+// Must be called with the lock held
+func (c *Controller) inhibitorList() []string {
+	reasons := make([]string, 0, len(c.inhibitors))
+	for reason := range c.inhibitors {
+		reasons = append(reasons, reason)
+	}
+	slices.Sort(reasons)
+	return reasons
+}
+
+// Get the most recent track reported by a player, or nil
+func (c *Controller) NowPlaying() *Metadata {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.nowPlaying
+}
+
+// Get whether plays are currently being logged
+func (c *Controller) Logging() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return !c.paused && len(c.inhibitors) == 0
+}
+
+func (c *Controller) Paused() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.paused
+}
+
+// Publish the control interface on the bus
+func (c *Controller) Export(conn *dbus.Conn) error {
+	iface := controlInterface{c}
+	if err := conn.Export(iface, ControlPath, ControlName); err != nil {
+		return err
+	}
+	node := introspect.Node{
+		Name: ControlPath,
+		Interfaces: []introspect.Interface{
+			introspect.IntrospectData,
+			{Name: ControlName, Methods: introspect.Methods(iface)},
+		},
+	}
+	if err := conn.Export(introspect.NewIntrospectable(&node), ControlPath, "org.freedesktop.DBus.Introspectable"); err != nil {
+		return err
+	}
+	reply, err := conn.RequestName(ControlName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		return err
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		return ErrAlreadyRunning
+	}
+	slog.Info("Exported control interface", "Name", ControlName, "Path", ControlPath)
+	return nil
+}
+
+// The methods exposed over D-Bus
+type controlInterface struct {
+	c *Controller
+}
+
+func (i controlInterface) PauseLogging() *dbus.Error {
+	i.c.SetPaused(true)
+	return nil
+}
+
+func (i controlInterface) ResumeLogging() *dbus.Error {
+	i.c.SetPaused(false)
+	return nil
+}
+
+func (i controlInterface) Status() (map[string]dbus.Variant, *dbus.Error) {
+	i.c.lock.Lock()
+	defer i.c.lock.Unlock()
+	return map[string]dbus.Variant{
+		"Logging":   dbus.MakeVariant(!i.c.paused && len(i.c.inhibitors) == 0),
+		"Private":   dbus.MakeVariant(i.c.paused),
+		"Inhibited": dbus.MakeVariant(i.c.inhibitorList()),
+		"Guest":     dbus.MakeVariant(i.c.guest),
+		"Scrobbles": dbus.MakeVariant(i.c.scrobbles),
+		"Started":   dbus.MakeVariant(i.c.started.Unix()),
+	}, nil
+}
+
+// Tag plays with the guest's name until StopGuest, or do not log them if exclude is set
+func (i controlInterface) StartGuest(name string, exclude bool) *dbus.Error {
+	if err := i.c.StartGuest(name, exclude); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return nil
+}
+
+func (i controlInterface) StopGuest() *dbus.Error {
+	i.c.StopGuest()
+	return nil
+}
+
+func (i controlInterface) NowPlaying() (map[string]dbus.Variant, *dbus.Error) {
+	i.c.lock.Lock()
+	defer i.c.lock.Unlock()
+	m := i.c.nowPlaying
+	if m == nil {
+		return map[string]dbus.Variant{}, nil
+	}
+	return map[string]dbus.Variant{
+		"Player": dbus.MakeVariant(m.Player),
+		"Title":  dbus.MakeVariant(m.Title),
+		"Album":  dbus.MakeVariant(m.Album),
+		"Artist": dbus.MakeVariant(strings.Join(m.Artist, ", ")),
+		"Url":    dbus.MakeVariant(m.Url),
+	}, nil
+}
+
+// Get the recent errors, dropped signals and sink latencies as JSON
+func (i controlInterface) Diagnostics() (string, *dbus.Error) {
+	data, err := json.Marshal(GetDiagnostics())
+	if err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+	return string(data), nil
+}
+
+// Get the state of each sink as JSON
+func (i controlInterface) Sinks() (string, *dbus.Error) {
+	statuses := []SinkStatus{}
+	if i.c.sinks != nil {
+		statuses = i.c.sinks.Status()
+	}
+	data, err := json.Marshal(statuses)
+	if err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+	return string(data), nil
+}
+
+func (i controlInterface) SetPlayerPolicy(player, policy string) *dbus.Error {
+	if err := i.c.DecidePlayer(context.Background(), player, PlayerPolicy(policy)); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return nil
+}
+
+// Get the number of plays held for each player without a policy
+func (i controlInterface) PendingPlayers() (map[string]uint32, *dbus.Error) {
+	if i.c.consent == nil {
+		return map[string]uint32{}, nil
+	}
+	return i.c.consent.Pending(), nil
+}
+
+func (i controlInterface) LastScrobbles(n uint32) ([]Play, *dbus.Error) {
+	ctx := context.Background()
+	if len(i.c.user) > 0 {
+		ctx = WithUser(ctx, i.c.user)
+	}
+	listens, _, err := i.c.history.QueryListens(ctx, ListenQuery{Limit: int(n)})
+	if err != nil {
+		return nil, dbus.MakeFailedError(err)
+	}
+	plays := make([]Play, 0, len(listens))
+	for _, l := range listens {
+		play := Play{Timestamp: l.Timestamp, Title: l.Title, Album: l.Album, Artists: strings.Join(l.Artists, ", ")}
+		if t, err := time.Parse(time.RFC3339, l.Timestamp); err == nil {
+			play.Timestamp = t.Local().Format(time.DateTime)
+		}
+		plays = append(plays, play)
+	}
+	return plays, nil
+}
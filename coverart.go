@@ -0,0 +1,231 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	defaultCoverArtURL = "https://coverartarchive.org"
+	// Larger downloads are abandoned, so a misbehaving server cannot fill the disk
+	maxArtworkSize = 20 << 20
+)
+
+var errNoArtwork = errors.New("no artwork")
+
+// Settings for downloading album covers from the Cover Art Archive
+type CoverArtConfig struct {
+	// Download the covers of albums once their MusicBrainz IDs are known
+	Fetch bool `json:"fetch"`
+	// Where covers are saved; defaults to music-watcher/covers in the XDG cache directory
+	Dir string `json:"dir"`
+	// The width of the thumbnails: 250, 500 or 1200; defaults to 500
+	Size int `json:"size"`
+	// The server to use; defaults to https://coverartarchive.org
+	URL string `json:"url"`
+}
+
+// Downloads album covers by their release or release group MBIDs
+type CoverArt struct {
+	Config CoverArtConfig
+	Cache  *LookupCache
+	Client *http.Client
+	dir    string
+}
+
+// Find the cache directory in the XDG cache directory, creating it if needed
+func artworkDir(dir, name string) (string, error) {
+	if len(dir) == 0 {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(cache, "music-watcher", name)
+	}
+	return dir, os.MkdirAll(dir, 0755)
+}
+
+func NewCoverArt(config CoverArtConfig, cache *LookupCache) (*CoverArt, error) {
+	switch config.Size {
+	case 0:
+		config.Size = 500
+	case 250, 500, 1200:
+	default:
+		return nil, fmt.Errorf("unsupported cover size %d", config.Size)
+	}
+	dir, err := artworkDir(config.Dir, "covers")
+	if err != nil {
+		return nil, err
+	}
+	return &CoverArt{Config: config, Cache: cache, dir: dir}, nil
+}
+
+// Save the response to a GET of the URL at path, replacing the file only once the download is complete
+func downloadArtwork(ctx context.Context, client *http.Client, url, path string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "music-watcher")
+	if client == nil {
+		client = scrobbleClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errNoArtwork
+	} else if resp.StatusCode != http.StatusOK {
+		return httpStatusError("artwork server", resp)
+	}
+	if resp.ContentLength > maxArtworkSize {
+		return fmt.Errorf("artwork is larger than %d bytes", maxArtworkSize)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := copyArtwork(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Copy the artwork, failing if it is larger than maxArtworkSize
+func copyArtwork(w io.Writer, r io.Reader) error {
+	n, err := io.Copy(w, io.LimitReader(r, maxArtworkSize+1))
+	if err != nil {
+		return err
+	}
+	if n > maxArtworkSize {
+		return fmt.Errorf("artwork is larger than %d bytes", maxArtworkSize)
+	}
+	return nil
+}
+
+// Download the cover of the album if it has an MBID and the cover has not been saved yet
+func (c *CoverArt) FetchAlbum(ctx context.Context, db *sql.DB, albumID int64) error {
+	var release, group, saved sql.NullString
+	err := db.QueryRowContext(ctx, "SELECT releaseMbid, releaseGroup, coverPath FROM Album WHERE id = ?", albumID).Scan(&release, &group, &saved)
+	if err != nil {
+		return err
+	}
+	if saved.Valid {
+		if _, err := os.Stat(saved.String); err == nil {
+			return nil
+		}
+	}
+	base := c.Config.URL
+	if len(base) == 0 {
+		base = defaultCoverArtURL
+	}
+	// The release's own cover is preferred; the release group's is the cover of one of its releases
+	for _, source := range []struct {
+		kind string
+		id   sql.NullString
+	}{{"release", release}, {"release-group", group}} {
+		if !source.id.Valid || len(source.id.String) == 0 {
+			continue
+		}
+		url := fmt.Sprintf("%s/%s/%s/front-%d", strings.TrimSuffix(base, "/"), source.kind, source.id.String, c.Config.Size)
+		path := filepath.Join(c.dir, fmt.Sprintf("%s-%d.jpg", source.id.String, c.Config.Size))
+		fetch := func(ctx context.Context) (bool, bool, error) {
+			err := downloadArtwork(ctx, c.Client, url, path)
+			if errors.Is(err, errNoArtwork) {
+				return false, false, nil
+			}
+			return err == nil, err == nil, err
+		}
+		// The cache remembers which MBIDs have no cover, so they are not requested on every play
+		found, _, err := Lookup(ctx, c.Cache, "coverartarchive", source.kind+"/"+source.id.String, fetch)
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			// Deleted from the cache directory since it was downloaded
+			if found, _, err = fetch(ctx); err != nil {
+				return err
+			} else if !found {
+				continue
+			}
+		}
+		_, err = db.ExecContext(ctx, "UPDATE Album SET coverPath = ?, coverUrl = ? WHERE id = ?", path, url, albumID)
+		return err
+	}
+	return nil
+}
+
+// Download the cover of the album of the stored track
+func (c *CoverArt) FetchTrack(ctx context.Context, db *sql.DB, m *Metadata) error {
+	var albumID sql.NullInt64
+	track, args := trackIDQuery(m)
+	err := db.QueryRowContext(ctx, "SELECT album FROM Track WHERE id = "+track, args...).Scan(&albumID)
+	if err == sql.ErrNoRows || (err == nil && !albumID.Valid) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return c.FetchAlbum(ctx, db, albumID.Int64)
+}
+
+// Download the covers of all albums with MBIDs that do not have one yet
+func (c *CoverArt) FetchMissing(ctx context.Context, db *sql.DB) (int, error) {
+	ids, err := queryStrings(
+		ctx, db,
+		"SELECT id FROM Album WHERE coverPath IS NULL AND (releaseMbid IS NOT NULL OR releaseGroup IS NOT NULL) ORDER BY id",
+	)
+	if err != nil {
+		return 0, err
+	}
+	fetched := 0
+	for _, id := range ids {
+		var albumID int64
+		if _, err := fmt.Sscan(id, &albumID); err != nil {
+			return fetched, err
+		}
+		if err := c.FetchAlbum(ctx, db, albumID); err != nil {
+			return fetched, err
+		}
+		var path sql.NullString
+		if err := db.QueryRowContext(ctx, "SELECT coverPath FROM Album WHERE id = ?", albumID).Scan(&path); err != nil {
+			return fetched, err
+		}
+		if path.Valid {
+			fetched++
+		}
+	}
+	return fetched, nil
+}
+
+// Register the task that downloads the covers of albums enriched while covers were not being fetched
+func RegisterCoverArtTasks(s *Scheduler, db *sql.DB, c *CoverArt) {
+	s.Register("fetch-covers", func(ctx context.Context) error {
+		fetched, err := c.FetchMissing(ctx, db)
+		if err == nil {
+			slog.InfoContext(ctx, "Fetched album covers", "Fetched", fetched)
+		}
+		return err
+	})
+}
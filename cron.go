@@ -0,0 +1,174 @@
+package music_watch
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCron = errors.New("invalid cron expression")
+
+// A parsed five-field cron expression (minute hour day-of-month month day-of-week)
+type CronSchedule struct {
+	minute  uint64
+	hour    uint64
+	dom     uint64
+	month   uint64
+	dow     uint64
+	domStar bool
+	dowStar bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var dayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+func ParseCron(expr string) (*CronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w: expected 5 fields, got %d", ErrInvalidCron, len(fields))
+	}
+	var sched CronSchedule
+	var err error
+	if sched.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, err
+	}
+	if sched.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, err
+	}
+	if sched.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, err
+	}
+	if sched.month, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, err
+	}
+	// 7 is an alias for Sunday
+	if sched.dow, err = parseCronField(fields[4], 0, 7, dayNames); err != nil {
+		return nil, err
+	}
+	if sched.dow&(1<<7) != 0 {
+		sched.dow |= 1
+	}
+	sched.domStar = fields[2] == "*"
+	sched.dowStar = fields[4] == "*"
+	return &sched, nil
+}
+
+// Parse a comma-separated list of values, ranges, and steps into a bit set
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			s, err := strconv.Atoi(stepPart)
+			if err != nil || s <= 0 {
+				return 0, fmt.Errorf("%w: invalid step %q", ErrInvalidCron, stepPart)
+			}
+			step = s
+		}
+		start, end := min, max
+		if rangePart != "*" {
+			from, to, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if start, err = parseCronValue(from, names); err != nil {
+				return 0, err
+			}
+			if isRange {
+				if end, err = parseCronValue(to, names); err != nil {
+					return 0, err
+				}
+			} else if !hasStep {
+				end = start
+			}
+		}
+		if start < min || end > max || start > end {
+			return 0, fmt.Errorf("%w: %q out of range %d-%d", ErrInvalidCron, item, min, max)
+		}
+		for i := start; i <= end; i += step {
+			bits |= 1 << i
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(value string, names map[string]int) (int, error) {
+	if n, ok := names[strings.ToLower(value)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid value %q", ErrInvalidCron, value)
+	}
+	return n, nil
+}
+
+// Get the first time after t that matches the schedule, or the zero time if there is none
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = wallClock(t, t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1)
+	// Give up after five years; this only happens for impossible dates such as 30 February
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = wallClock(t, t.Year(), t.Month()+1, 1, 0, 0)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = wallClock(t, t.Year(), t.Month(), t.Day()+1, 0, 0)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = wallClock(t, t.Year(), t.Month(), t.Day(), t.Hour()+1, 0)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// Get a wall-clock time in the time zone of after, which must be later than it.
+// Fields are compared with the wall-clock time, so times are advanced in it rather than in UTC,
+// which differs by a fraction of an hour in some time zones.
+func wallClock(after time.Time, year int, month time.Month, day, hour, minute int) time.Time {
+	t := time.Date(year, month, day, hour, minute, 0, 0, after.Location())
+	// Times skipped by a daylight saving change can be normalized to before it, so they are moved past it
+	for !t.After(after) {
+		t = t.Add(time.Hour)
+	}
+	return t
+}
+
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	// As in cron, a restricted day-of-month and day-of-week match if either does
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
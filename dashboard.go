@@ -0,0 +1,17 @@
+package music_watch
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// The web dashboard, which reads from the history API and the live feed
+//
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+func registerDashboard(mux *http.ServeMux) {
+	files, _ := fs.Sub(dashboardFiles, "dashboard")
+	mux.Handle("GET /", http.FileServerFS(files))
+}
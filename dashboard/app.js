@@ -0,0 +1,211 @@
+"use strict";
+
+const periodSelect = document.getElementById("period");
+
+async function getJSON(path) {
+	const response = await fetch(path);
+	const body = await response.json();
+	if (!response.ok) {
+		throw new Error(body.error || response.statusText);
+	}
+	return body;
+}
+
+function element(tag, className, text) {
+	const e = document.createElement(tag);
+	if (className) {
+		e.className = className;
+	}
+	if (text !== undefined) {
+		e.textContent = text;
+	}
+	return e;
+}
+
+function showTrack(track) {
+	document.getElementById("now-title").textContent = track ? track.title : "Nothing is playing";
+	const detail = track ? [(track.artist || []).join(", "), track.album].filter(Boolean).join(" — ") : "";
+	document.getElementById("now-detail").textContent = detail;
+}
+
+// Link to the page of the artist or album
+function pageLink(kind, name) {
+	const a = element("a", "", name);
+	a.href = `#/${kind}/${encodeURIComponent(name)}`;
+	return a;
+}
+
+// Fill the list with bars of the items' plays, linking each item to its page if kind is given
+function fillChart(list, items, kind, empty = "No plays in this period") {
+	list.replaceChildren();
+	if (!items || items.length === 0) {
+		list.append(element("li", "empty", empty));
+		return;
+	}
+	const most = items[0].plays;
+	for (const item of items) {
+		const li = element("li");
+		const bar = element("span", "bar");
+		bar.style.width = `${(item.plays / most) * 100}%`;
+		const label = element("span", "label");
+		label.append(kind ? pageLink(kind, item.name) : element("span", "", item.name), element("span", "detail", item.plays));
+		li.append(bar, label);
+		list.append(li);
+	}
+}
+
+// The pages that the items of the top charts link to
+const chartPages = { artists: "artist", albums: "album" };
+
+async function loadChart(kind) {
+	const body = await getJSON(`api/top/${kind}?period=${periodSelect.value}&limit=10`);
+	fillChart(document.getElementById("top-" + kind), body[kind], chartPages[kind]);
+}
+
+async function loadListens() {
+	const body = await getJSON("api/listens?limit=20");
+	const rows = document.getElementById("listens");
+	rows.replaceChildren();
+	for (const listen of body.listens) {
+		const row = element("tr");
+		for (const value of [
+			new Date(listen.timestamp).toLocaleString(),
+			listen.title,
+			(listen.artists || []).join(", "),
+			listen.album || "",
+		]) {
+			row.append(element("td", "", value));
+		}
+		rows.append(row);
+	}
+}
+
+const svgNamespace = "http://www.w3.org/2000/svg";
+
+// Draw the plays of each day from the first day played to the last, including the days without plays
+function sparkline(timeline) {
+	const counts = [];
+	if (timeline && timeline.length > 0) {
+		const plays = new Map(timeline.map((d) => [d.day, d.plays]));
+		const last = new Date(timeline[timeline.length - 1].day + "T00:00:00Z");
+		for (let day = new Date(timeline[0].day + "T00:00:00Z"); day <= last; day.setUTCDate(day.getUTCDate() + 1)) {
+			counts.push(plays.get(day.toISOString().slice(0, 10)) || 0);
+		}
+	}
+	const width = 300;
+	const height = 40;
+	const most = counts.reduce((a, b) => Math.max(a, b), 1);
+	const step = counts.length > 1 ? width / (counts.length - 1) : width;
+	const points = counts.map((n, i) => `${i * step},${height - (n / most) * height}`);
+	if (counts.length === 1) {
+		points.push(`${width},${height - (counts[0] / most) * height}`);
+	}
+	const svg = document.createElementNS(svgNamespace, "svg");
+	svg.setAttribute("class", "sparkline");
+	svg.setAttribute("viewBox", `0 0 ${width} ${height}`);
+	svg.setAttribute("preserveAspectRatio", "none");
+	svg.setAttribute("role", "img");
+	svg.setAttribute("aria-label", `Plays on each of ${counts.length} days`);
+	const line = document.createElementNS(svgNamespace, "polyline");
+	line.setAttribute("points", points.join(" "));
+	svg.append(line);
+	return svg;
+}
+
+function formatListened(ms) {
+	const minutes = Math.round(ms / 60000);
+	return minutes < 60 ? `${minutes} min` : `${Math.floor(minutes / 60)} h ${minutes % 60} min`;
+}
+
+// The artist or album whose page is shown, or null for the overview
+let shownPage = null;
+
+// Show the statistics of the artist or album, which cover all of its plays rather than the period
+async function loadPage() {
+	const { kind, name } = shownPage;
+	document.getElementById("page-title").textContent = name;
+	const detail = document.getElementById("page-detail");
+	// Clear the previous page's statistics, in case these cannot be loaded
+	for (const id of ["page-detail", "page-plays", "page-listened", "page-first", "page-last", "page-timeline", "page-tracks", "page-artists", "page-albums"]) {
+		document.getElementById(id).replaceChildren();
+	}
+	let body;
+	try {
+		body = await getJSON(`api/${kind}s/${encodeURIComponent(name)}?limit=10`);
+	} catch (err) {
+		detail.textContent = err.message;
+		throw err;
+	}
+	const stats = body[kind];
+	if (kind === "album") {
+		const artists = (stats.albumArtists || []).join(", ");
+		detail.textContent = [artists && `By ${artists}`, stats.variousArtists && "Compilation"].filter(Boolean).join(" — ");
+	}
+	document.getElementById("page-plays").textContent = stats.plays;
+	document.getElementById("page-listened").textContent = formatListened(body.listenedMs);
+	document.getElementById("page-first").textContent = stats.firstPlayed;
+	document.getElementById("page-last").textContent = stats.lastPlayed;
+	document.getElementById("page-timeline").replaceChildren(sparkline(stats.timeline));
+	fillChart(document.getElementById("page-tracks"), stats.topTracks, null, "None");
+	// An artist's page lists the artists played on the same days, an album's the artists on it
+	document.getElementById("page-artists-heading").textContent = kind === "artist" ? "Played on the same days" : "Artists";
+	fillChart(document.getElementById("page-artists"), kind === "artist" ? stats.coListened : stats.artists, "artist", "None");
+	document.getElementById("page-albums-section").hidden = kind !== "artist";
+	if (kind === "artist") {
+		fillChart(document.getElementById("page-albums"), stats.topAlbums, "album", "None");
+	}
+}
+
+// Show the page that the location's hash selects, such as #/artist/Name, or the overview
+function route() {
+	const match = location.hash.match(/^#\/(artist|album)\/(.+)$/);
+	shownPage = match ? { kind: match[1], name: decodeURIComponent(match[2]) } : null;
+	document.getElementById("overview").hidden = Boolean(shownPage);
+	document.getElementById("page").hidden = !shownPage;
+	// The pages cover all of the plays, so the period only applies to the overview
+	periodSelect.parentElement.hidden = Boolean(shownPage);
+	if (shownPage) {
+		window.scrollTo(0, 0);
+		loadPage().catch((err) => console.error(err));
+	}
+}
+
+function refresh() {
+	for (const load of [() => loadChart("artists"), () => loadChart("albums"), () => loadChart("tracks"), loadListens]) {
+		load().catch((err) => console.error(err));
+	}
+}
+
+// Follow the live feed, reconnecting if the watcher restarts
+function follow() {
+	const url = new URL("live", location.href);
+	url.protocol = location.protocol === "https:" ? "wss:" : "ws:";
+	const socket = new WebSocket(url);
+	socket.onmessage = (message) => {
+		const e = JSON.parse(message.data);
+		switch (e.type) {
+		case "nowPlaying":
+		case "resumed":
+			showTrack(e.track);
+			break;
+		case "paused":
+		case "playFinished":
+			showTrack(null);
+			break;
+		case "scrobble":
+			refresh();
+			if (shownPage) {
+				loadPage().catch((err) => console.error(err));
+			}
+			break;
+		}
+	};
+	socket.onclose = () => setTimeout(follow, 5000);
+}
+
+periodSelect.addEventListener("change", refresh);
+window.addEventListener("hashchange", route);
+getJSON("api/now").then((body) => showTrack(body.track)).catch((err) => console.error(err));
+route();
+refresh();
+follow();
@@ -4,11 +4,21 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"strings"
 	"time"
 )
 
 var ErrInvalidAlbumName = errors.New("invalid album name")
+var ErrDuplicatePlay = errors.New("track was already stored for this play")
+
+// Plays of a track are taken to be the same play if they are stored less than the track's length
+// apart, less this much so that the next play of a repeated track is not mistaken for it.
+// Tracks without a length use this as the window, which still catches several watchers storing one play.
+const duplicatePlaySlack = 5 * time.Second
 
 // Store the record in the database, creating entries as necessary
 func StoreData(
@@ -17,160 +27,563 @@ func StoreData(
 	conn interface {
 		BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
 	},
-) error {
+) (err error) {
+	ctx, span := tracer.Start(ctx, "StoreData", trackAttributes(data))
+	defer func() {
+		if errors.Is(err, ErrDuplicatePlay) {
+			// Not a failure
+			span.End()
+			return
+		}
+		endSpan(span, err)
+	}()
 	if len(data.Title) == 0 && len(data.Url) == 0 {
 		slog.Info("Received track with no title or url")
 		return nil
 	}
+	return retryLocked(ctx, func() error {
+		return storePlay(ctx, data, conn)
+	}, "Track", data.Title)
+}
+
+// How many times a write is attempted while another connection holds the database's lock,
+// and the wait before the first retry, which doubles after each
+const (
+	busyAttempts = 5
+	busyBackoff  = 200 * time.Millisecond
+)
+
+// Call f until it succeeds or fails for a reason other than the database being locked, waiting between attempts.
+// The arguments are added to the log messages.
+func retryLocked(ctx context.Context, f func() error, args ...any) error {
+	wait := busyBackoff
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if !isBusy(err) {
+			return err
+		} else if attempt == busyAttempts {
+			return fmt.Errorf("database was still locked after %d attempts: %w", attempt, err)
+		}
+		// Jitter keeps watchers that were locked out together from colliding again
+		delay := wait/2 + rand.N(wait)
+		slog.WarnContext(ctx, "Database is locked, retrying", append(args, "Attempt", attempt, "Delay", delay, "Error", err)...)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		wait *= 2
+	}
+}
+
+// Get whether the error is SQLite's SQLITE_BUSY or SQLITE_LOCKED, which the drivers report in their own types.
+// The busy timeout does not cover every case: a transaction that read before another connection wrote
+// fails at once when it tries to write.
+func isBusy(err error) bool {
+	if err == nil {
+		return false
+	}
+	message := err.Error()
+	return strings.Contains(message, "database is locked") || strings.Contains(message, "database table is locked")
+}
+
+// Store the play in a single transaction
+func storePlay(
+	ctx context.Context,
+	data *Metadata,
+	conn interface {
+		BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+	},
+) error {
 	tx, err := conn.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	now := time.Now().Format(time.DateTime)
-	trackIdNumber, err := getTrack(ctx, tx, data.Title, data.TrackId, data.Url, data.Album, [][]string{data.AlbumArtist, data.Artist, data.Composer})
-	if err != nil {
+	if err := insertPlay(ctx, tx, data, nil); err != nil {
 		tx.Rollback()
 		return err
 	}
-	_, err = tx.ExecContext(
+	return tx.Commit()
+}
+
+// Add the play to the log, creating its track if it is new
+func insertPlay(ctx context.Context, tx *sql.Tx, data *Metadata, batch *writeBatch) error {
+	played := playedAt(ctx)
+	device := deviceOf(ctx)
+	trackIdNumber, err := storeTrack(ctx, tx, data, batch)
+	if err != nil {
+		return err
+	}
+	if duplicate, err := isDuplicatePlay(ctx, tx, batch, trackIdNumber, played, data.Length); err != nil {
+		return err
+	} else if duplicate {
+		return ErrDuplicatePlay
+	}
+	res, err := batch.exec(
 		ctx,
-		"INSERT INTO TrackLog (track, timestamp) VALUES (?, ?)",
+		tx,
+		"INSERT INTO TrackLog (uid, track, timestamp, guest, user, host, device, player, desktopEntry) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		newPlayUID(played),
 		trackIdNumber,
-		now,
+		formatTimestamp(played),
+		sql.NullString{String: guestSession(ctx), Valid: len(guestSession(ctx)) > 0},
+		userArg(ctx),
+		sql.NullString{String: device.host, Valid: len(device.host) > 0},
+		sql.NullString{String: device.label, Valid: len(device.label) > 0},
+		sql.NullString{String: PlayerKey(data.Player), Valid: len(data.Player) > 0},
+		sql.NullString{String: data.DesktopEntry, Valid: len(data.DesktopEntry) > 0},
 	)
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
-	return tx.Commit()
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	return assignSession(ctx, tx, batch, id, played)
 }
 
-// Create a mapping for track <-> person, creating person if necessary
-func addPerson(ctx context.Context, tx *sql.Tx, trackId int64, person string) error {
+// Get whether the track's latest play was stored recently enough to be the same play,
+// such as when the watcher restarts partway through a track or another watcher stored it. Deleted plays do not count,
+// so that a track played again after its play was deleted is stored.
+// The latest play is always in TrackLog, so partitions are not searched.
+func isDuplicatePlay(ctx context.Context, tx *sql.Tx, batch *writeBatch, track int64, played time.Time, length int64) (bool, error) {
+	window := max(time.Duration(length)*time.Microsecond-duplicatePlaySlack, duplicatePlaySlack)
+	var duplicate bool
+	err := batch.queryRow(
+		ctx,
+		tx,
+		"SELECT COUNT(*) > 0 FROM TrackLog WHERE track = ? AND timestamp > ? AND timestamp <= ? AND user IS ? AND deleted IS NULL",
+		track,
+		formatTimestamp(played.Add(-window)),
+		formatTimestamp(played),
+		userArg(ctx),
+	).Scan(&duplicate)
+	return duplicate, err
+}
+
+// The layout of the timestamps of plays: RFC 3339 in UTC, so that plays stored in different time zones
+// sort in the order they were played, and SQLite's date and time functions can convert them with 'localtime'
+const timestampLayout = "2006-01-02T15:04:05Z"
+
+// Format the time as the timestamp of a play
+func formatTimestamp(t time.Time) string {
+	return t.UTC().Format(timestampLayout)
+}
+
+type playedAtKey struct{}
+
+// Record plays stored with the context at t rather than the current time, such as for plays that were held back
+func withPlayedAt(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, playedAtKey{}, t)
+}
+
+func playedAt(ctx context.Context) time.Time {
+	if t, ok := ctx.Value(playedAtKey{}).(time.Time); ok {
+		return t
+	}
+	return time.Now()
+}
+
+type guestKey struct{}
+
+// Tag plays stored with the context as belonging to the named guest
+func withGuest(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, guestKey{}, name)
+}
+
+// Get the guest that plays stored with the context belong to, or "" for the user's own plays
+func guestSession(ctx context.Context) string {
+	name, _ := ctx.Value(guestKey{}).(string)
+	return name
+}
+
+type userKey struct{}
+
+// Store and query the plays of the named user, for when several people share a watcher or database.
+// Plays are kept apart by user, while their tracks, albums and persons are shared.
+func WithUser(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, userKey{}, name)
+}
+
+// Get the user that plays stored or queried with the context belong to, or "" for the default user
+func playUser(ctx context.Context) string {
+	name, _ := ctx.Value(userKey{}).(string)
+	return name
+}
+
+type deviceKey struct{}
+
+// Where plays are stored from: the machine's host name and an optional label, such as "laptop"
+type playDevice struct {
+	host, label string
+}
+
+// Record plays stored with the context as played on the host, with the device's label if it is not empty,
+// so that plays from several machines can be told apart once their databases are merged
+func withDevice(ctx context.Context, host, label string) context.Context {
+	return context.WithValue(ctx, deviceKey{}, playDevice{host, label})
+}
+
+// Get the device that plays stored with the context were played on, which is empty if it is not known
+func deviceOf(ctx context.Context) playDevice {
+	device, _ := ctx.Value(deviceKey{}).(playDevice)
+	return device
+}
+
+// Get the value of TrackLog.user for the context's user, which is NULL for the default user,
+// so that the plays stored before there were users are theirs
+func userArg(ctx context.Context) sql.NullString {
+	return sql.NullString{String: playUser(ctx), Valid: len(playUser(ctx)) > 0}
+}
+
+// The roles of persons on a track, kept in Track_Person.role
+const (
+	roleAlbumArtist = "albumArtist"
+	roleArtist      = "artist"
+	roleFeature     = "feature"
+	roleComposer    = "composer"
+)
+
+// Persons with the same role on a track
+type rolePersons struct {
+	role  string
+	names []string
+}
+
+// Get the track's persons by role, in the order they are related to it
+func trackPersons(m *Metadata) []rolePersons {
+	return []rolePersons{
+		{roleAlbumArtist, m.AlbumArtist},
+		{roleArtist, m.Artist},
+		{roleFeature, m.Featured},
+		{roleComposer, m.Composer},
+	}
+}
+
+// Get the person's ID, or that of the person the name is an alias of, creating the person if necessary
+func getPerson(ctx context.Context, tx *sql.Tx, person string, batch *writeBatch) (int64, error) {
+	key := idKey{"Person", person}
+	if id, ok := batch.get(key); ok {
+		return id, nil
+	}
+	var id int64
+	err := batch.queryRow(ctx, tx, "SELECT person FROM Person_Alias WHERE alias = ?", person).Scan(&id)
+	if err == nil {
+		batch.add(key, id)
+		return id, nil
+	} else if err != sql.ErrNoRows {
+		return 0, err
+	}
+	// Updating the person with itself returns its ID, where DO NOTHING would return nothing
+	err = batch.queryRow(
+		ctx,
+		tx,
+		"INSERT INTO Person (name) VALUES (?) ON CONFLICT (name) DO UPDATE SET name = excluded.name RETURNING id",
+		person,
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	batch.add(key, id)
+	return id, nil
+}
+
+// Create a mapping for track <-> person in the role, creating person if necessary
+func addPerson(ctx context.Context, tx *sql.Tx, trackId int64, person, role string, batch *writeBatch) error {
 	// trackId here is the database ID number of the track
 	if len(person) == 0 {
 		return nil
 	}
-	var personId int64
-	err := tx.QueryRowContext(ctx, "SELECT id FROM Person WHERE name = ?", person).Scan(&personId)
-	switch err {
-	case sql.ErrNoRows:
-		res, err := tx.ExecContext(ctx, "INSERT INTO Person (name) VALUES (?)", person)
-		if err != nil {
-			return err
-		}
-		personId, err = res.LastInsertId()
-		if err != nil {
-			return err
-		}
-	case nil: // Use the existing record
-	default:
+	personId, err := getPerson(ctx, tx, person, batch)
+	if err != nil {
 		return err
 	}
-	_, err = tx.ExecContext(
+	_, err = batch.exec(
 		ctx,
-		"INSERT INTO Track_Person (track, person) VALUES (?, ?)",
+		tx,
+		"INSERT INTO Track_Person (track, person, role) VALUES (?, ?, ?) ON CONFLICT (track, person, role) DO NOTHING",
 		trackId,
 		personId,
+		role,
 	)
 	return err
 }
 
+// Get a subquery of the ID of the track's row, as getTrack finds it, and its arguments.
+// A track with a MusicBrainz recording ID is the first track its player gave that ID, so that the recording played
+// from other URLs or players is one track; otherwise, or if no track has the ID yet, it is the track with its URL and
+// title. IDs found by MusicBrainz.Enrich are not matched, since a wrong search result would join different tracks.
+func trackIDQuery(m *Metadata) (string, []any) {
+	return `COALESCE(
+		(SELECT MIN(id) FROM Track WHERE trackId = NULLIF(?, '')),
+		(SELECT id FROM Track WHERE url = ? AND title = ?)
+	)`, []any{m.TrackId, m.Url, m.Title}
+}
+
 // Get the track id, creating the record if necessary
-func getTrack(ctx context.Context, tx *sql.Tx, title, trackId, url, album string, persons [][]string) (int64, error) {
-	// trackId parameter is the string uniquely identifying the track to the music industry, not our database
-	// Because trackId is often not present, (url, title) should uniquely identify the track
+func getTrack(ctx context.Context, tx *sql.Tx, data *Metadata, batch *writeBatch) (int64, error) {
+	// data.TrackId is the string uniquely identifying the track to the music industry, not our database
+	// Because it is often not present, (url, title) should uniquely identify the track when it is not
+	title, url, album := data.Title, data.Url, data.Album
+	persons := trackPersons(data)
+	key := idKey{"Track", trackKey(url, title) + "\x00" + data.TrackId}
+	if id, ok := batch.get(key); ok {
+		return id, nil
+	}
+	var raw sql.NullString
+	if data.Raw != nil {
+		encoded, err := encodeRawMetadata(data.Raw)
+		if err != nil {
+			return 0, err
+		}
+		raw = sql.NullString{String: encoded, Valid: true}
+	}
 	var id int64
-	err := tx.QueryRowContext(ctx, "SELECT id FROM Track WHERE url = ? AND title = ?", url, title).Scan(&id)
+	track, args := trackIDQuery(data)
+	err := batch.queryRow(ctx, tx, "SELECT id FROM Track WHERE id = "+track, args...).Scan(&id)
 	switch err {
 	case sql.ErrNoRows:
 		// Create record
+		var alb sql.NullInt64
 		if len(album) > 0 {
-			alb, err := getAlbum(ctx, tx, album)
+			artists, err := albumArtistKey(ctx, tx, data, batch)
 			if err != nil {
+				return 0, err
+			}
+			if alb.Int64, err = getAlbum(ctx, tx, album, artists, batch); err != nil {
 				slog.ErrorContext(ctx, "Error inserting album into database", "Album", album, "Track", title, "Error", err)
 				return 0, err
 			}
-			res, err := tx.ExecContext(
-				ctx,
-				"INSERT INTO Track (title, trackId, url, album) VALUES (?, ?, ?, ?)",
-				title,
-				trackId,
-				url,
-				alb,
-			)
-			if err != nil {
+			alb.Valid = true
+			if err := insertAlbumPersons(ctx, tx, alb.Int64, persons, batch); err != nil {
 				return 0, err
 			}
-			if id, err := res.LastInsertId(); err == nil {
-				err = insertPersons(ctx, tx, id, persons)
-				return id, err
-			} else {
+		}
+		err := batch.queryRow(
+			ctx,
+			tx,
+			`INSERT INTO Track (title, trackId, url, album, trackNumber, discNumber, raw) VALUES (?, ?, ?, ?, NULLIF(?, 0), NULLIF(?, 0), ?)
+			ON CONFLICT (url, title) DO NOTHING RETURNING id`,
+			title,
+			data.TrackId,
+			url,
+			alb,
+			data.TrackNumber,
+			data.DiscNumber,
+			raw,
+		).Scan(&id)
+		if err == sql.ErrNoRows {
+			// Another connection created the track since it was looked up
+			if err = batch.queryRow(ctx, tx, "SELECT id FROM Track WHERE url = ? AND title = ?", url, title).Scan(&id); err != nil {
 				return 0, err
 			}
-		} else { // No album specified
-			res, err := tx.ExecContext(
+			batch.add(key, id)
+			return id, nil
+		} else if err != nil {
+			return 0, err
+		}
+		if err := insertPersons(ctx, tx, id, persons, batch); err != nil {
+			return 0, err
+		}
+		batch.add(key, id)
+		return id, nil
+	case nil:
+		if err := addRoles(ctx, tx, id, persons, batch); err != nil {
+			return 0, err
+		}
+		if data.TrackNumber > 0 || data.DiscNumber > 0 {
+			// The track was stored before its position was recorded, or by a player that did not give it
+			_, err := batch.exec(
 				ctx,
-				"INSERT INTO Track (title, trackId, url) VALUES (?, ?, ?)",
-				title,
-				trackId,
-				url,
+				tx,
+				`UPDATE Track SET trackNumber = COALESCE(trackNumber, NULLIF(?, 0)), discNumber = COALESCE(discNumber, NULLIF(?, 0))
+				WHERE id = ? AND (trackNumber IS NULL OR discNumber IS NULL)`,
+				data.TrackNumber,
+				data.DiscNumber,
+				id,
 			)
 			if err != nil {
 				return 0, err
 			}
-			if id, err := res.LastInsertId(); err == nil {
-				return id, insertPersons(ctx, tx, id, persons)
-			} else {
+		}
+		if raw.Valid {
+			// The track was stored before its metadata was kept, or from a source other than MPRIS
+			if _, err := batch.exec(ctx, tx, "UPDATE Track SET raw = ? WHERE id = ? AND raw IS NULL", raw, id); err != nil {
 				return 0, err
 			}
 		}
-	case nil:
+		batch.add(key, id)
 		return id, nil
 	default:
 		return 0, err
 	}
 }
 
-func insertPersons(ctx context.Context, tx *sql.Tx, trackId int64, persons [][]string) error {
-	var artSet = make(artistSet)
+func insertPersons(ctx context.Context, tx *sql.Tx, trackId int64, persons []rolePersons, batch *writeBatch) error {
 	for _, set := range persons {
-		for _, person := range set {
-			// Check if the person has been seen before
-			// Some songs have the same person listed as, e.g., the artist and the composer
+		// A person is related once for each role, such as a singer who also wrote the song,
+		// but players sometimes list a name twice
+		var artSet = make(artistSet)
+		for _, person := range set.names {
 			if _, ok := artSet[person]; ok {
 				continue
 			} else {
 				artSet[person] = struct{}{}
 			}
 			// Could potentially add 2 records - One to "Person" and one to "Album_Person"
-			err := addPerson(ctx, tx, trackId, person)
+			err := addPerson(ctx, tx, trackId, person, set.role, batch)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Relate the album to the track's album artists
+func insertAlbumPersons(ctx context.Context, tx *sql.Tx, albumId int64, persons []rolePersons, batch *writeBatch) error {
+	for _, set := range persons {
+		if set.role != roleAlbumArtist {
+			continue
+		}
+		for _, person := range set.names {
+			if len(person) == 0 {
+				continue
+			}
+			personId, err := getPerson(ctx, tx, person, batch)
 			if err != nil {
-				tx.Rollback()
 				return err
 			}
+			_, err = batch.exec(
+				ctx,
+				tx,
+				"INSERT INTO Album_Person (album, person) VALUES (?, ?) ON CONFLICT (album, person) DO NOTHING",
+				albumId,
+				personId,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Relate the persons to a track stored before roles were recorded in their roles,
+// replacing the relations without a role of those that are given one
+func addRoles(ctx context.Context, tx *sql.Tx, trackId int64, persons []rolePersons, batch *writeBatch) error {
+	var missing bool
+	err := batch.queryRow(ctx, tx, "SELECT EXISTS (SELECT 1 FROM Track_Person WHERE track = ? AND role IS NULL)", trackId).Scan(&missing)
+	if err != nil || !missing {
+		return err
+	}
+	if err := insertPersons(ctx, tx, trackId, persons, batch); err != nil {
+		return err
+	}
+	_, err = batch.exec(
+		ctx,
+		tx,
+		`DELETE FROM Track_Person WHERE track = ?1 AND role IS NULL
+		AND person IN (SELECT person FROM Track_Person WHERE track = ?1 AND role IS NOT NULL)`,
+		trackId,
+	)
+	return err
+}
+
+// Get the ID of the track, creating it with its album, persons and genres if it is new
+// The IDs are looked up in and added to the batch, which may be nil.
+func storeTrack(ctx context.Context, tx *sql.Tx, data *Metadata, batch *writeBatch) (int64, error) {
+	id, err := getTrack(ctx, tx, data, batch)
+	if err != nil {
+		return 0, err
+	}
+	// Genres are added to existing tracks too, since players do not always give them
+	return id, insertGenres(ctx, tx, id, data.Genre, batch)
+}
+
+// Relate the track to each genre, inserting genres that have not been seen before
+func insertGenres(ctx context.Context, tx *sql.Tx, trackId int64, genres []string, batch *writeBatch) error {
+	for _, genre := range genres {
+		key := trackGenreKey(trackId, genre)
+		if _, ok := batch.get(key); ok {
+			continue
 		}
+		if _, err := batch.exec(ctx, tx, "INSERT OR IGNORE INTO Genre (name) VALUES (?)", genre); err != nil {
+			return err
+		}
+		_, err := batch.exec(
+			ctx,
+			tx,
+			"INSERT OR IGNORE INTO Track_Genre (track, genre) SELECT ?, id FROM Genre WHERE name = ?",
+			trackId, genre,
+		)
+		if err != nil {
+			return err
+		}
+		batch.add(key, 0)
 	}
 	return nil
 }
 
-// Get the album ID, or insert it if it does not already exist
-func getAlbum(ctx context.Context, tx *sql.Tx, name string) (int64, error) {
+// Get the key of the artists of the track's album, which with its title identifies the album:
+// its album artists, or the track's artists if it has none, by the names they are stored as, sorted and joined
+func albumArtistKey(ctx context.Context, tx *sql.Tx, m *Metadata, batch *writeBatch) (string, error) {
+	artists := slices.Clone(m.AlbumArtist)
+	if len(artists) == 0 {
+		artists = slices.Clone(m.Artist)
+	}
+	for i, name := range artists {
+		err := batch.queryRow(
+			ctx, tx, "SELECT p.name FROM Person_Alias a JOIN Person p ON p.id = a.person WHERE a.alias = ?", name,
+		).Scan(&artists[i])
+		if err != nil && err != sql.ErrNoRows {
+			return "", err
+		}
+	}
+	return artistKey(artists), nil
+}
+
+func artistKey(artists []string) string {
+	artists = slices.DeleteFunc(slices.Clone(artists), func(name string) bool { return len(name) == 0 })
+	slices.Sort(artists)
+	return strings.Join(slices.Compact(artists), "; ")
+}
+
+// Get the ID of the album with the title and artists, or insert it if it does not already exist
+func getAlbum(ctx context.Context, tx *sql.Tx, name, artists string, batch *writeBatch) (int64, error) {
 	if len(name) == 0 {
 		return 0, ErrInvalidAlbumName
 	}
+	key := idKey{"Album", name + "\x00" + artists}
+	if id, ok := batch.get(key); ok {
+		return id, nil
+	}
 	var id int64
-	err := tx.QueryRowContext(ctx, "SELECT id FROM Album WHERE id = ?", id).Scan(&id)
+	err := batch.queryRow(ctx, tx, "SELECT id FROM Album WHERE title = ? AND artistKey = ?", name, artists).Scan(&id)
 	switch err {
 	case sql.ErrNoRows:
 		// Create the album entry
-		res, err := tx.ExecContext(ctx, "INSERT INTO Album (title) VALUES (?)", name)
+		err := batch.queryRow(
+			ctx,
+			tx,
+			"INSERT INTO Album (title, artistKey, groupKey) VALUES (?, ?, ?) ON CONFLICT (title, artistKey) DO NOTHING RETURNING id",
+			name,
+			artists,
+			ReleaseGroupKey(name, artists),
+		).Scan(&id)
+		if err == sql.ErrNoRows {
+			// Another connection created the album since it was looked up
+			err = batch.queryRow(ctx, tx, "SELECT id FROM Album WHERE title = ? AND artistKey = ?", name, artists).Scan(&id)
+		}
 		if err != nil {
 			return 0, err
 		}
-		return res.LastInsertId()
+		batch.add(key, id)
+		return id, nil
 	case nil:
 		// No error
+		batch.add(key, id)
 		return id, nil
 	default:
 		// Unknown error
@@ -178,17 +591,79 @@ func getAlbum(ctx context.Context, tx *sql.Tx, name string) (int64, error) {
 	}
 }
 
-func CreateDatabaseStructure(conn *sql.DB) error {
-	tx, err := conn.Begin()
+// The version of the tables, stored as the database's user_version.
+// Raise it when a change would make earlier versions misread the database.
+const SchemaVersion = 1
+
+func CreateDatabaseStructure(db *sql.DB) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
 	if err != nil {
 		return err
 	}
+	defer conn.Close()
+	// Tables are rebuilt to add foreign keys, which must not be enforced meanwhile.
+	// The pragma has no effect inside a transaction.
+	var foreignKeysOn bool
+	if err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&foreignKeysOn); err != nil {
+		return err
+	}
+	if foreignKeysOn {
+		if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Filled in from the album artists of tracks once it is created
+	var albumPersonExists, sessionExists bool
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'Album_Person'").Scan(&albumPersonExists); err != nil {
+		tx.Rollback()
+		return err
+	}
+	// Plays are grouped into listening sessions once the column is added
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) > 0 FROM pragma_table_info('TrackLog') WHERE name = 'session'").Scan(&sessionExists); err != nil {
+		tx.Rollback()
+		return err
+	}
 	for _, stmt := range []string{
 		"CREATE TABLE IF NOT EXISTS Album (id INTEGER PRIMARY KEY, title TEXT)",
-		"CREATE TABLE IF NOT EXISTS Track (id INTEGER PRIMARY KEY, title TEXT, trackId TEXT, url TEXT, album INTEGER)",
+		"CREATE TABLE IF NOT EXISTS Track (id INTEGER PRIMARY KEY, title TEXT, trackId TEXT, url TEXT, album INTEGER REFERENCES Album (id))",
 		"CREATE TABLE IF NOT EXISTS Person(id INTEGER PRIMARY KEY, name TEXT)",
-		"CREATE TABLE IF NOT EXISTS TrackLog (id INTEGER PRIMARY KEY, track INTEGER, timestamp DATETIME)",
-		"CREATE TABLE IF NOT EXISTS Track_Person(id INTEGER PRIMARY KEY, track INTEGER, person INTEGER)",
+		"CREATE TABLE IF NOT EXISTS TrackLog (id INTEGER PRIMARY KEY, track INTEGER REFERENCES Track (id), timestamp DATETIME)",
+		"CREATE TABLE IF NOT EXISTS Track_Person(id INTEGER PRIMARY KEY, track INTEGER REFERENCES Track (id), person INTEGER REFERENCES Person (id))",
+		// Positions and lengths are in microseconds
+		"CREATE TABLE IF NOT EXISTS Progress (track INTEGER PRIMARY KEY, position INTEGER, length INTEGER, listened INTEGER, completion REAL, updated DATETIME)",
+		// Players are stored by PlayerKey
+		"CREATE TABLE IF NOT EXISTS PlayerPolicy (player TEXT PRIMARY KEY, policy TEXT NOT NULL, updated DATETIME)",
+		// The last TrackLog id written by each incremental export
+		"CREATE TABLE IF NOT EXISTS ExportCursor (name TEXT PRIMARY KEY, lastId INTEGER NOT NULL, updated DATETIME)",
+		// Results of external lookups as JSON; value is NULL when nothing was found
+		"CREATE TABLE IF NOT EXISTS LookupCache (service TEXT NOT NULL, key TEXT NOT NULL, value BLOB, found INTEGER NOT NULL, fetched DATETIME, expires DATETIME, PRIMARY KEY (service, key))",
+		// Plays waiting to be sent by each sink, as JSON Events, so that they survive restarts
+		"CREATE TABLE IF NOT EXISTS SinkQueue (id INTEGER PRIMARY KEY, sink TEXT NOT NULL, event TEXT NOT NULL, queued DATETIME)",
+		// The ActivityPub actor's signing key as PKCS #8 PEM, its followers' inboxes, and the notes it has published
+		"CREATE TABLE IF NOT EXISTS ActivityPubKey (id INTEGER PRIMARY KEY, pem TEXT NOT NULL, created DATETIME)",
+		"CREATE TABLE IF NOT EXISTS ActivityPubFollower (actor TEXT PRIMARY KEY, inbox TEXT NOT NULL, followed DATETIME)",
+		"CREATE TABLE IF NOT EXISTS ActivityPubNote (id INTEGER PRIMARY KEY, content TEXT NOT NULL, published DATETIME)",
+		// Genres from xesam:genre and file tags
+		"CREATE TABLE IF NOT EXISTS Genre (id INTEGER PRIMARY KEY, name TEXT UNIQUE)",
+		"CREATE TABLE IF NOT EXISTS Track_Genre (track INTEGER REFERENCES Track (id), genre INTEGER REFERENCES Genre (id), PRIMARY KEY (track, genre))",
+		// Plays of podcasts, when they are kept apart from the music history
+		"CREATE TABLE IF NOT EXISTS PodcastLog (id INTEGER PRIMARY KEY, track INTEGER REFERENCES Track (id), timestamp DATETIME)",
+		// The album artists of albums, from xesam:albumArtist
+		"CREATE TABLE IF NOT EXISTS Album_Person (album INTEGER NOT NULL REFERENCES Album (id), person INTEGER NOT NULL REFERENCES Person (id), PRIMARY KEY (album, person))",
+		// Other names of persons, such as "Beatles" for "The Beatles", which are stored as the person, see MergePersons
+		"CREATE TABLE IF NOT EXISTS Person_Alias (alias TEXT PRIMARY KEY, person INTEGER NOT NULL REFERENCES Person (id))",
+		// Corrections, deletions and restorations of plays, as JSON of the play before and after, see EditListen
+		"CREATE TABLE IF NOT EXISTS TrackLogAudit (id INTEGER PRIMARY KEY, play INTEGER NOT NULL, action TEXT NOT NULL, changed DATETIME NOT NULL, before TEXT, after TEXT)",
+		// The number of times each track was played each day, for plays removed by PrunePlays; days are in UTC
+		"CREATE TABLE IF NOT EXISTS DailyPlays (day TEXT NOT NULL, track INTEGER NOT NULL REFERENCES Track (id), plays INTEGER NOT NULL, playedMs INTEGER, PRIMARY KEY (day, track))",
+		// The last play's ID sent to and received from each database synced with, see SyncPlays
+		"CREATE TABLE IF NOT EXISTS SyncCursor (peer TEXT PRIMARY KEY, sent INTEGER NOT NULL, received INTEGER NOT NULL, updated DATETIME)",
 	} {
 		_, err := tx.Exec(stmt)
 		if err != nil {
@@ -196,7 +671,535 @@ func CreateDatabaseStructure(conn *sql.DB) error {
 			return err
 		}
 	}
+	// Columns added after the tables were first created
+	for _, col := range []struct{ table, column, definition string }{
+		{"Album", "releaseGroup", "TEXT"},  // MusicBrainz release group ID
+		{"Album", "groupKey", "TEXT"},      // Normalized title and artists for grouping editions without an MBID
+		{"Track", "language", "TEXT"},      // ISO 639-1 code from DetectLanguage
+		{"TrackLog", "guest", "TEXT"},      // The guest session the play belongs to; NULL for the user's own plays
+		{"TrackLog", "skipped", "INTEGER"}, // 1 if the play finished early, see RecordPlayed
+		// MusicBrainz IDs filled in by MusicBrainz.Enrich
+		{"Track", "recordingMbid", "TEXT"},
+		{"Album", "releaseMbid", "TEXT"},
+		{"Person", "mbid", "TEXT"},
+		{"Album", "year", "INTEGER"}, // From xesam:contentCreated or the release on MusicBrainz
+		// The album's artists, which tell apart albums with the same title, see albumArtistKey
+		{"Album", "artistKey", "TEXT"},
+		// The track's position on its album, from xesam:trackNumber and xesam:discNumber
+		{"Track", "trackNumber", "INTEGER"},
+		{"Track", "discNumber", "INTEGER"},
+		// How long the play was listened to, and that as a fraction of the track's length, see RecordPlayed
+		{"TrackLog", "playedMs", "INTEGER"},
+		{"TrackLog", "completion", "REAL"},
+		// The player the play came from, by PlayerKey, and its desktop entry if it gave one
+		{"TrackLog", "player", "TEXT"},
+		{"TrackLog", "desktopEntry", "TEXT"},
+		{"TrackLog", "session", "INTEGER"}, // The ID of the first play of the listening session, see assignSession
+		{"Track", "raw", "TEXT"},           // The MPRIS metadata the track was first stored from, see encodeRawMetadata
+		// When the play was deleted by DeleteListens, until it is restored or purged; plays that are deleted are left out of TrackLogAll
+		{"TrackLog", "deleted", "DATETIME"},
+		// The album's cover as saved by CoverArt, and where it was downloaded from
+		{"Album", "coverPath", "TEXT"},
+		{"Album", "coverUrl", "TEXT"},
+		// The artwork given by the player, as saved by Artwork
+		{"Track", "artUrl", "TEXT"},
+		{"Track", "artPath", "TEXT"},
+		{"Album", "artPath", "TEXT"},
+		// albumArtist, artist, feature or composer; NULL for persons related to tracks before roles were recorded
+		{"Track_Person", "role", "TEXT"},
+		// The user whose play it is, see withUser; NULL for the default user
+		{"TrackLog", "user", "TEXT"},
+		// The host name of the machine the play was stored on and its device label, see withDevice
+		{"TrackLog", "host", "TEXT"},
+		{"TrackLog", "device", "TEXT"},
+		// The play's ULID, which identifies it across databases, so that synced plays are not stored twice, see newPlayUID
+		{"TrackLog", "uid", "TEXT"},
+	} {
+		tables := []string{col.table}
+		if col.table == "TrackLog" {
+			// Partitions have the same columns as TrackLog
+			if tables, err = trackLogTables(context.Background(), tx); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		for _, table := range tables {
+			if err := addColumn(tx, table, col.column, col.definition); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+	if err := addForeignKeys(ctx, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	// Replaced by Track_Person_role, since a person can have several roles on a track
+	if _, err := tx.ExecContext(ctx, "DROP INDEX IF EXISTS Track_Person_track"); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := addUniqueIndexes(ctx, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if !albumPersonExists {
+		// Only tracks stored since roles were recorded have their album artists
+		_, err := tx.ExecContext(
+			ctx,
+			`INSERT OR IGNORE INTO Album_Person (album, person)
+			SELECT t.album, tp.person FROM Track_Person tp JOIN Track t ON t.id = tp.track
+			WHERE tp.role = ? AND t.album IS NOT NULL`,
+			roleAlbumArtist,
+		)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	// Tracks stored before they were found by their MusicBrainz IDs are merged when the IDs are first indexed
+	var trackIdIndexed bool
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'index' AND name = 'Track_trackId'").Scan(&trackIdIndexed); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, stmt := range []string{
+		"CREATE INDEX IF NOT EXISTS TrackLog_timestamp ON TrackLog (timestamp)",
+		"CREATE INDEX IF NOT EXISTS TrackLog_track ON TrackLog (track, timestamp)",
+		"CREATE INDEX IF NOT EXISTS TrackLog_session ON TrackLog (session)",
+		"CREATE INDEX IF NOT EXISTS Track_trackId ON Track (trackId)",
+		"CREATE INDEX IF NOT EXISTS Track_recordingMbid ON Track (recordingMbid)",
+		"CREATE INDEX IF NOT EXISTS Album_groupKey ON Album (groupKey)",
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if !trackIdIndexed {
+		if err := mergeRecordingTracks(ctx, tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := createTrackLogView(ctx, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := backfillReleaseGroupKeys(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := convertLocalTimestamps(ctx, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := backfillPlayUIDs(ctx, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if !sessionExists {
+		if err := backfillSessions(ctx, tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	// Recorded for restores, which refuse backups taken by newer versions; a newer version's number is left as it is
+	var version int
+	if err := tx.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if version < SchemaVersion {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", SchemaVersion)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
 	return tx.Commit()
 }
 
+// Convert the timestamps of plays stored in local time, before they were stored in UTC.
+// They are taken to be in the current time zone, since the zone they were stored in was not recorded.
+func convertLocalTimestamps(ctx context.Context, tx *sql.Tx) error {
+	tables, err := trackLogTables(ctx, tx)
+	if err != nil {
+		return err
+	}
+	type timestampColumn struct{ table, column string }
+	var columns []timestampColumn
+	for _, table := range append(tables, "PodcastLog") {
+		columns = append(columns, timestampColumn{table, "timestamp"})
+	}
+	// Progress was also stored in local time, and is sorted by when it was updated
+	columns = append(columns, timestampColumn{"Progress", "updated"})
+	for _, c := range columns {
+		// Timestamps that SQLite cannot read are left as they are, rather than lost
+		res, err := tx.ExecContext(
+			ctx,
+			fmt.Sprintf(
+				`UPDATE %[1]s SET %[2]s = strftime('%%Y-%%m-%%dT%%H:%%M:%%SZ', %[2]s, 'utc')
+				WHERE %[2]s NOT LIKE '%%Z' AND strftime('%%Y-%%m-%%dT%%H:%%M:%%SZ', %[2]s, 'utc') IS NOT NULL`,
+				c.table,
+				c.column,
+			),
+		)
+		if err != nil {
+			return err
+		}
+		if count, _ := res.RowsAffected(); count > 0 {
+			slog.InfoContext(ctx, "Converted timestamps to UTC", "Table", c.table, "Rows", count)
+		}
+	}
+	return nil
+}
+
+// Foreign keys of the tables, which databases created before they were declared do not have
+var foreignKeys = []struct {
+	table string
+	keys  []string
+}{
+	{"Track", []string{"FOREIGN KEY (album) REFERENCES Album (id)"}},
+	{"TrackLog", []string{"FOREIGN KEY (track) REFERENCES Track (id)"}},
+	{"Track_Person", []string{"FOREIGN KEY (track) REFERENCES Track (id)", "FOREIGN KEY (person) REFERENCES Person (id)"}},
+	{"Track_Genre", []string{"FOREIGN KEY (track) REFERENCES Track (id)", "FOREIGN KEY (genre) REFERENCES Genre (id)"}},
+	{"PodcastLog", []string{"FOREIGN KEY (track) REFERENCES Track (id)"}},
+}
+
+// Rebuild the tables that have no foreign keys with them, keeping their columns, rows and indexes.
+// Rows that refer to missing rows are kept, and reported.
+func addForeignKeys(ctx context.Context, tx *sql.Tx) error {
+	rebuilt := false
+	for _, fk := range foreignKeys {
+		var count int
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_foreign_key_list(?)", fk.table).Scan(&count); err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		if !rebuilt {
+			// Renaming a table fails while a view refers to a table that is being rebuilt
+			if _, err := tx.ExecContext(ctx, "DROP VIEW IF EXISTS "+trackLogView); err != nil {
+				return err
+			}
+			rebuilt = true
+		}
+		if err := rebuildTable(ctx, tx, fk.table, fk.keys); err != nil {
+			return fmt.Errorf("unable to add foreign keys to %s: %w", fk.table, err)
+		}
+	}
+	if !rebuilt {
+		return nil
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT "table", COUNT(*) FROM pragma_foreign_key_check GROUP BY 1`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var table string
+		var count int
+		if err := rows.Scan(&table, &count); err != nil {
+			return err
+		}
+		slog.WarnContext(ctx, "Rows refer to rows that do not exist", "Table", table, "Rows", count)
+	}
+	return rows.Err()
+}
+
+// Replace the table with a copy that has the constraints
+func rebuildTable(ctx context.Context, tx *sql.Tx, table string, constraints []string) error {
+	rows, err := tx.QueryContext(ctx, `SELECT name, type, "notnull", dflt_value, pk FROM pragma_table_info(?) ORDER BY cid`, table)
+	if err != nil {
+		return err
+	}
+	var columns, definitions []string
+	// The columns of the primary key, by their position in it
+	primaryKey := make(map[int]string)
+	for rows.Next() {
+		var name, kind string
+		var notNull bool
+		var dflt sql.NullString
+		var pk int
+		if err := rows.Scan(&name, &kind, &notNull, &dflt, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		definition := strings.TrimSpace(name + " " + kind)
+		if notNull {
+			definition += " NOT NULL"
+		}
+		if dflt.Valid {
+			definition += " DEFAULT " + dflt.String
+		}
+		if pk > 0 {
+			primaryKey[pk] = name
+		}
+		columns = append(columns, name)
+		definitions = append(definitions, definition)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(primaryKey) > 0 {
+		key := make([]string, len(primaryKey))
+		for i := range key {
+			key[i] = primaryKey[i+1]
+		}
+		definitions = append(definitions, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(key, ", ")))
+	}
+	indexes, err := queryStrings(ctx, tx, "SELECT sql FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL", table)
+	if err != nil {
+		return err
+	}
+	list := strings.Join(columns, ", ")
+	for _, stmt := range append([]string{
+		fmt.Sprintf("CREATE TABLE %s_new (%s)", table, strings.Join(append(definitions, constraints...), ", ")),
+		fmt.Sprintf("INSERT INTO %s_new (%s) SELECT %s FROM %s", table, list, list, table),
+		"DROP TABLE " + table,
+		fmt.Sprintf("ALTER TABLE %s_new RENAME TO %s", table, table),
+	}, indexes...) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Indexes that make the rows identified by their columns unique, with how to merge the duplicates
+// that databases created before them may have. They replace the plain indexes of the same names.
+var uniqueIndexes = []struct {
+	name, table, columns string
+	merge                func(ctx context.Context, tx *sql.Tx) error
+}{
+	{"Person_name", "Person", "name", mergeDuplicatePersons},
+	{"Track_url_title", "Track", "url, title", mergeDuplicateTracks},
+	{"Album_title_artist", "Album", "title, artistKey", mergeDuplicateAlbums},
+	// After the others, since merging persons and tracks can relate a track to a person twice
+	{"Track_Person_role", "Track_Person", "track, person, role", mergeDuplicateTrackPersons},
+}
+
+func addUniqueIndexes(ctx context.Context, tx *sql.Tx) error {
+	for _, index := range uniqueIndexes {
+		var unique bool
+		err := tx.QueryRowContext(ctx, `SELECT "unique" FROM pragma_index_list(?) WHERE name = ?`, index.table, index.name).Scan(&unique)
+		if err == nil && unique {
+			continue
+		} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if err := index.merge(ctx, tx); err != nil {
+			return fmt.Errorf("unable to merge duplicate rows of %s: %w", index.table, err)
+		}
+		for _, stmt := range []string{
+			"DROP INDEX IF EXISTS " + index.name,
+			fmt.Sprintf("CREATE UNIQUE INDEX %s ON %s (%s)", index.name, index.table, index.columns),
+		} {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Relate tracks to the first person with each name, and delete the others
+func mergeDuplicatePersons(ctx context.Context, tx *sql.Tx) error {
+	for _, stmt := range []string{
+		"UPDATE Person SET mbid = (SELECT d.mbid FROM Person d WHERE d.name = Person.name AND d.mbid IS NOT NULL) WHERE mbid IS NULL",
+		"UPDATE Track_Person SET person = (SELECT MIN(k.id) FROM Person k JOIN Person p ON k.name = p.name WHERE p.id = Track_Person.person)",
+		"DELETE FROM Person WHERE id > (SELECT MIN(k.id) FROM Person k WHERE k.name = Person.name)",
+	} {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Merge each track into the first track with its URL and title
+func mergeDuplicateTracks(ctx context.Context, tx *sql.Tx) error {
+	return mergePairs(
+		ctx,
+		tx,
+		"Track",
+		`SELECT (SELECT MIN(k.id) FROM Track k WHERE k.url = t.url AND k.title = t.title), t.id FROM Track t
+		WHERE t.id > (SELECT MIN(k.id) FROM Track k WHERE k.url = t.url AND k.title = t.title)`,
+		mergeTrack,
+	)
+}
+
+// Merge each track into the first track with the MusicBrainz ID its player gave, as getTrack now finds them.
+// IDs found by MusicBrainz.Enrich are left out, since they come from a search.
+func mergeRecordingTracks(ctx context.Context, tx *sql.Tx) error {
+	return mergePairs(
+		ctx,
+		tx,
+		"Track",
+		`SELECT (SELECT MIN(k.id) FROM Track k WHERE k.trackId = t.trackId), t.id FROM Track t
+		WHERE t.trackId != '' AND t.id > (SELECT MIN(k.id) FROM Track k WHERE k.trackId = t.trackId)`,
+		mergeTrack,
+	)
+}
+
+// Merge each album into the first album with its title and artists, filling in their artists first
+func mergeDuplicateAlbums(ctx context.Context, tx *sql.Tx) error {
+	// Indexed meanwhile, since finding the duplicates of every album without it takes long in a large library
+	if _, err := tx.ExecContext(ctx, "CREATE INDEX IF NOT EXISTS Album_title_artist ON Album (title, artistKey)"); err != nil {
+		return err
+	}
+	if err := backfillAlbumArtistKeys(ctx, tx); err != nil {
+		return err
+	}
+	return mergePairs(
+		ctx,
+		tx,
+		"Album",
+		`SELECT (SELECT MIN(k.id) FROM Album k WHERE k.title = a.title AND k.artistKey = a.artistKey), a.id FROM Album a
+		WHERE a.id > (SELECT MIN(k.id) FROM Album k WHERE k.title = a.title AND k.artistKey = a.artistKey)`,
+		mergeAlbum,
+	)
+}
+
+// Fill in the artists of the albums stored before albums were told apart by them, or whose persons were merged,
+// from the persons of their tracks as albumArtistKey finds them. Persons related before roles were recorded are taken
+// to be artists. An album that becomes the same as another is merged into it.
+func backfillAlbumArtistKeys(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(
+		ctx,
+		`SELECT a.id, COALESCE(a.title, ''), tp.role, p.name FROM Album a
+		LEFT JOIN Track t ON t.album = a.id
+		LEFT JOIN Track_Person tp ON tp.track = t.id AND (tp.role IN (?, ?) OR tp.role IS NULL)
+		LEFT JOIN Person p ON p.id = tp.person
+		WHERE a.artistKey IS NULL ORDER BY a.id`,
+		roleAlbumArtist,
+		roleArtist,
+	)
+	if err != nil {
+		return err
+	}
+	type albumArtists struct {
+		id                    int64
+		title                 string
+		albumArtists, artists []string
+	}
+	var albums []albumArtists
+	for rows.Next() {
+		var id int64
+		var title string
+		var role, name sql.NullString
+		if err := rows.Scan(&id, &title, &role, &name); err != nil {
+			rows.Close()
+			return err
+		}
+		if n := len(albums); n == 0 || albums[n-1].id != id {
+			albums = append(albums, albumArtists{id: id, title: title})
+		}
+		album := &albums[len(albums)-1]
+		if !name.Valid {
+			continue
+		} else if role.String == roleAlbumArtist {
+			album.albumArtists = append(album.albumArtists, name.String)
+		} else {
+			album.artists = append(album.artists, name.String)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, album := range albums {
+		names := album.albumArtists
+		if len(names) == 0 {
+			names = album.artists
+		}
+		key := artistKey(names)
+		var existing int64
+		err := tx.QueryRowContext(ctx, "SELECT id FROM Album WHERE title = ? AND artistKey = ? AND id != ?", album.title, key, album.id).Scan(&existing)
+		switch err {
+		case sql.ErrNoRows:
+			_, err := tx.ExecContext(
+				ctx, "UPDATE Album SET artistKey = ?, groupKey = ? WHERE id = ?", key, ReleaseGroupKey(album.title, key), album.id,
+			)
+			if err != nil {
+				return err
+			}
+		case nil:
+			if err := mergeAlbum(ctx, tx, existing, album.id); err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Merge the rows of the table selected by the query, which selects the ID of the row to keep and of its duplicate
+func mergePairs(ctx context.Context, tx *sql.Tx, table, query string, merge func(ctx context.Context, tx *sql.Tx, keep, duplicate int64) error) error {
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	var pairs [][2]int64
+	for rows.Next() {
+		var pair [2]int64
+		if err := rows.Scan(&pair[0], &pair[1]); err != nil {
+			rows.Close()
+			return err
+		}
+		pairs = append(pairs, pair)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, pair := range pairs {
+		if err := merge(ctx, tx, pair[0], pair[1]); err != nil {
+			return err
+		}
+	}
+	if len(pairs) > 0 {
+		slog.InfoContext(ctx, "Merged duplicate rows", "Table", table, "Rows", len(pairs))
+	}
+	return nil
+}
+
+func mergeDuplicateTrackPersons(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`DELETE FROM Track_Person WHERE id > (
+			SELECT MIN(k.id) FROM Track_Person k
+			WHERE k.track = Track_Person.track AND k.person = Track_Person.person AND k.role IS Track_Person.role
+		)`,
+	)
+	return err
+}
+
+// Add a column to an existing table if it does not already have it
+func addColumn(tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.Query(fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
 type artistSet map[string]struct{}
@@ -2,25 +2,44 @@ package music_watch
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
-	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	dbus "github.com/godbus/dbus/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Metadata struct {
-	Album       string
-	AlbumArtist []string
-	Url         string
-	Artist      []string
-	Composer    []string
-	TrackId     string
-	Title       string
+	Album       string   `json:"album,omitempty"`
+	AlbumArtist []string `json:"albumArtist,omitempty"`
+	Url         string   `json:"url,omitempty"`
+	Artist      []string `json:"artist,omitempty"`
+	Featured    []string `json:"featured,omitempty"` // Artists split from "Artist feat. Other" by the rewrite settings
+	Composer    []string `json:"composer,omitempty"`
+	TrackId     string   `json:"trackId,omitempty"`
+	Title       string   `json:"title,omitempty"`
+	Player      string   `json:"player,omitempty"` // The MPRIS name of the player that reported the track
+	Length      int64    `json:"length,omitempty"` // Microseconds, as in mpris:length
+	Lyrics      string   `json:"lyrics,omitempty"` // From xesam:asText; only used to detect the language
+	ArtUrl      string   `json:"artUrl,omitempty"` // From mpris:artUrl, a file, http or https URL
+	Genre       []string `json:"genre,omitempty"`
+	// From xesam:trackNumber and xesam:discNumber, or filled in from the tags of local files by ReadFileTags
+	TrackNumber int    `json:"trackNumber,omitempty"`
+	DiscNumber  int    `json:"discNumber,omitempty"`
+	AlbumId     string `json:"albumId,omitempty"` // MusicBrainz release ID
+	Year        int    `json:"year,omitempty"`    // The album's release year, from xesam:contentCreated
+	// The player's desktop entry, from org.mpris.MediaPlayer2.DesktopEntry, if it gave one
+	DesktopEntry string `json:"desktopEntry,omitempty"`
+	// The metadata map the track was parsed from, kept with the track so that it can be parsed again
+	Raw map[string]dbus.Variant `json:"-"`
 }
 
 var ErrMetadataFailed = errors.New("failed to get metadata")
@@ -38,6 +57,7 @@ var filteredPlayers = []string{
 var busNameToName = make(map[string]string)
 var nameToBusName = make(map[string]string)
 var nameToCurrent = make(map[string]*Metadata)
+var nameToDesktopEntry = make(map[string]string)
 
 const playerPath = "/org/mpris/MediaPlayer2"
 const systemBusPath = "/org/freedesktop/DBus"
@@ -47,14 +67,25 @@ const ownerChanged = "org.freedesktop/DBus.NameOwnerChanged"
 const introspectName = "org.freedesktop.DBus.Introspectable.Introspect"
 const nameOwnerSignal = "org.freedesktop.DBus.NameOwnerChanged"
 const propertySignal = "org.freedesktop.DBus.Properties.PropertiesChanged"
+const prepareForSleepSignal = "org.freedesktop.login1.Manager.PrepareForSleep"
+const seekedSignal = "org.mpris.MediaPlayer2.Player.Seeked"
 
 type StoreCallback func(ctx context.Context, m *Metadata) error
 
+// Watch MPRIS players on the bus until a shutdown signal is received
 func StartWatching(conn *dbus.Conn, callback StoreCallback) error {
+	ctx, stop := signal.NotifyContext(conn.Context(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	return Watch(ctx, &MPRISSource{Conn: conn}, callback)
+}
 
-	// TODO: Actually make use of this context
-	ctx := conn.Context()
+// Reads tracks from MPRIS players on a session bus
+type MPRISSource struct {
+	Conn *dbus.Conn
+}
 
+func (s *MPRISSource) Run(ctx context.Context, events chan<- Event) error {
+	conn := s.Conn
 	slog.InfoContext(ctx, "Getting existing players")
 	if current, err := GetExistingPlayers(ctx, conn); err != nil {
 		return errors.Join(fmt.Errorf("unable to get list of existing players"), err)
@@ -62,7 +93,9 @@ func StartWatching(conn *dbus.Conn, callback StoreCallback) error {
 		for _, name := range current {
 			if strings.HasPrefix(name, "org.mpris.MediaPlayer2.") {
 				slog.Debug("Detected new player", "Name", name)
-				handleNewPlayer(ctx, conn, name, callback)
+				if err := handleNewPlayer(ctx, conn, name, events); err != nil {
+					slog.ErrorContext(ctx, "Error handling existing player", "Name", name, "Error", err)
+				}
 			}
 		}
 	}
@@ -89,77 +122,139 @@ func StartWatching(conn *dbus.Conn, callback StoreCallback) error {
 		return err
 	}
 
+	// Seeking, to keep track of the position in long tracks
+	if err := conn.AddMatchSignalContext(
+		ctx,
+		dbus.WithMatchObjectPath(playerPath),
+		dbus.WithMatchInterface("org.mpris.MediaPlayer2.Player"),
+		dbus.WithMatchMember("Seeked"),
+	); err != nil {
+		return err
+	}
+
 	// DBus changes
 	dbusChan := make(chan *dbus.Signal)
 	conn.Signal(dbusChan)
+	defer conn.RemoveSignal(dbusChan)
 
-	// Handle OS signals to stop
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-	// TODO: Scan to populate player maps with existing players
+	// System sleep, so that plays can be closed out before suspending
+	sleepChan := make(chan *dbus.Signal, 1)
+	if system, err := dbus.SystemBus(); err != nil {
+		slog.WarnContext(ctx, "Unable to connect to the system bus, suspend will not be detected", "Error", err)
+	} else if err := system.AddMatchSignalContext(
+		ctx,
+		dbus.WithMatchObjectPath(login1Path),
+		dbus.WithMatchInterface(login1Manager),
+		dbus.WithMatchMember("PrepareForSleep"),
+	); err != nil {
+		slog.WarnContext(ctx, "Unable to watch for suspend", "Error", err)
+	} else {
+		system.Signal(sleepChan)
+		defer system.RemoveSignal(sleepChan)
+	}
 
 	for {
 		select {
 		case sig := <-dbusChan:
 			switch sig.Name {
 			case nameOwnerSignal:
-				if err := handleNewPlayerSignal(ctx, conn, sig, callback); err != nil {
+				if err := handleNewPlayerSignal(ctx, conn, sig, events); err != nil {
 					slog.ErrorContext(ctx, "Error handling new player", "Error", err)
 				}
 			case propertySignal:
-				if err := handlePropertyChange(ctx, sig, callback); err != nil {
+				if err := handlePropertyChange(ctx, conn, sig, events); err != nil {
 					slog.ErrorContext(ctx, "Error handling property change", "Error", err)
 				}
+			case seekedSignal:
+				handleSeeked(sig)
 			}
-		case <-sigChan:
-			slog.InfoContext(ctx, "Received shutdown signal")
+		case sig := <-sleepChan:
+			handleSleepSignal(ctx, sig)
+		case <-ctx.Done():
+			finishAllPlays(ctx, events, "org.mpris.MediaPlayer2.")
 			return nil
 		}
 	}
 }
 
-func handleNewPlayerSignal(ctx context.Context, conn *dbus.Conn, sig *dbus.Signal, callback StoreCallback) error {
+func handleNewPlayerSignal(ctx context.Context, conn *dbus.Conn, sig *dbus.Signal, events chan<- Event) error {
 	if len(sig.Body) != 3 {
 		// Should be name, oldOwner, newOwner
 		return ErrInvalidSignalBody
 	}
 	// This is the player name
 	name, nameOk := sig.Body[0].(string)
-	newOwner, newOk := sig.Body[0].(string)
-	oldOwner, oldOk := sig.Body[0].(string)
+	oldOwner, oldOk := sig.Body[1].(string)
+	newOwner, newOk := sig.Body[2].(string)
 	if !nameOk || !newOk || !oldOk {
 		return ErrInvalidSignalBody
 	}
 	// A new player connecting will send two signals:
 	// One for the bus (:1.<bus-num>) and one for the name we want (org.mpris.MediaPlayer2.*)
 	if strings.HasPrefix(name, "org.mpris.MediaPlayer2.") {
-		if oldOwner == newOwner {
-			handleNewPlayer(ctx, conn, name, callback)
+		if len(newOwner) > 0 {
+			if len(oldOwner) > 0 {
+				// The name moved to a different connection
+				finishPlay(ctx, events, name)
+				removePlayer(name)
+			}
+			return handleNewPlayer(ctx, conn, name, events)
 		} else {
 			// Disconnected
+			finishPlay(ctx, events, name)
 			removePlayer(name)
 		}
 	}
 	return nil
 }
 
-func handleNewPlayer(ctx context.Context, conn *dbus.Conn, name string, callback StoreCallback) error {
+func handleSleepSignal(ctx context.Context, sig *dbus.Signal) {
+	if sig.Name != prepareForSleepSignal || len(sig.Body) != 1 {
+		return
+	}
+	// True before suspending, false after resuming
+	if sleeping, ok := sig.Body[0].(bool); !ok {
+		return
+	} else if sleeping {
+		slog.InfoContext(ctx, "System is suspending")
+		suspendPlays(ctx)
+	} else {
+		slog.InfoContext(ctx, "System resumed")
+		resumePlays(ctx)
+	}
+}
+
+func handleNewPlayer(ctx context.Context, conn *dbus.Conn, name string, events chan<- Event) (err error) {
 	// Connected
 	addPlayer(conn, name)
 	if isFilteredPlayer(name) {
 		slog.Debug("Ignoring filtered player", "Name", name)
 		return nil
 	}
+	ctx, span := tracer.Start(ctx, "MPRIS new player", trace.WithAttributes(attribute.String("player", name)))
+	defer func() { endSpan(span, err) }()
 	metadata, err := GetMetadata(conn.Object(name, dbus.ObjectPath(playerPath)))
 	if err != nil {
 		return err
 	}
+	metadata.Player = name
+	metadata.DesktopEntry = nameToDesktopEntry[name]
+	if metadata.Cleared() {
+		slog.DebugContext(ctx, "Player has no track loaded", "Name", name)
+		return nil
+	}
+	player := conn.Object(name, dbus.ObjectPath(playerPath))
+	status, err := GetPlaybackStatus(player)
+	if err != nil {
+		slog.DebugContext(ctx, "Unable to get playback status, assuming playing", "Name", name, "Error", err)
+		status = "Playing"
+	}
+	startPlay(ctx, events, name, metadata, status == "Playing", getPosition(player))
 	nameToCurrent[name] = metadata
-	return callback(ctx, metadata)
+	return sendTrack(ctx, events, metadata)
 }
 
-func handlePropertyChange(ctx context.Context, sig *dbus.Signal, callback StoreCallback) error {
+func handlePropertyChange(ctx context.Context, conn *dbus.Conn, sig *dbus.Signal, events chan<- Event) (err error) {
 	bus := sig.Sender // This is the bus name
 	name, ok := busNameToName[bus]
 	if ok {
@@ -169,20 +264,29 @@ func handlePropertyChange(ctx context.Context, sig *dbus.Signal, callback StoreC
 		}
 	} else {
 		slog.Warn("Received signal from unknown player, ignoring", "Bus", bus)
+		recordDropped("Unknown player", "Bus", bus)
 		return nil
 	}
 	slog.Debug("Detected change in player", "Name", name, "Bus", bus)
+	ctx, span := tracer.Start(ctx, "MPRIS properties changed", trace.WithAttributes(attribute.String("player", name)))
+	defer func() { endSpan(span, err) }()
 	if len(sig.Body) < 1 {
+		recordDropped("Signal has no body", "Name", name, "Bus", bus)
 		return errors.Join(errors.New("signal has no body"), ErrInvalidSignalBody)
 	}
 	changed, ok := sig.Body[1].(map[string]dbus.Variant)
 	if !ok {
+		recordDropped("Invalid signal body", "Name", name, "Bus", bus)
 		return ErrInvalidSignalBody
 	}
 	// MPV will not report complete metadata on startup, and then update its metadata field;
 	// Playback status also changes when this happens.
 	if status, ok := changed["PlaybackStatus"]; ok {
-		if s, ok := status.Value().(string); ok && s != "Playing" {
+		s, ok := status.Value().(string)
+		if ok {
+			setPlaying(ctx, events, name, s == "Playing")
+		}
+		if ok && s != "Playing" {
 			// Can be "Playing," "Paused," or "Stopped"
 			slog.Debug("Player is not playing, not logging", "Name", name, "Bus", bus)
 			return nil
@@ -191,17 +295,32 @@ func handlePropertyChange(ctx context.Context, sig *dbus.Signal, callback StoreC
 
 	_m, ok := changed["Metadata"]
 	if !ok {
-		return ErrMetadataFailed
+		// Only other properties, such as the volume, changed
+		return nil
 	}
 	metadata, ok := _m.Value().(map[string]dbus.Variant)
 	if !ok {
 		slog.Debug("Received invalid type for metadata", "Name", name, "Bus", bus)
+		recordDropped("Invalid type for metadata", "Name", name, "Bus", bus)
 		return ErrMetadataFailed
 	}
+	_, parseSpan := tracer.Start(ctx, "parseMetadata")
 	metaParsed := parseMetadata(metadata)
+	parseSpan.End()
+	metaParsed.Player = name
+	metaParsed.DesktopEntry = nameToDesktopEntry[name]
+	if metaParsed.Cleared() {
+		if _, ok := nameToCurrent[name]; ok {
+			slog.DebugContext(ctx, "Player cleared its metadata, finishing play", "Name", name, "Bus", bus)
+			delete(nameToCurrent, name)
+			finishPlay(ctx, events, name)
+		}
+		return nil
+	}
 	if current, ok := nameToCurrent[name]; !ok || !current.IsSameTrack(metaParsed) {
 		nameToCurrent[name] = metaParsed
-		return callback(ctx, metaParsed)
+		startPlay(ctx, events, name, metaParsed, true, getPosition(conn.Object(name, dbus.ObjectPath(playerPath))))
+		return sendTrack(ctx, events, metaParsed)
 	}
 	// Some players send 8 notifications every time they change
 	// This was observed while listening to Spotify with Firefox
@@ -227,8 +346,43 @@ func GetMetadata(player dbus.BusObject) (*Metadata, error) {
 	return parseMetadata(meta), nil
 }
 
+func handleSeeked(sig *dbus.Signal) {
+	name, ok := busNameToName[sig.Sender]
+	if !ok || len(sig.Body) != 1 {
+		return
+	}
+	if position, ok := sig.Body[0].(int64); ok {
+		seekPlay(name, time.Duration(position)*time.Microsecond)
+	}
+}
+
+// Get the player's position in the current track, or 0 if it is not known
+func getPosition(player dbus.BusObject) time.Duration {
+	const playerInterface = "org.mpris.MediaPlayer2.Player"
+	v, err := player.GetProperty(playerInterface + ".Position")
+	if err != nil {
+		return 0
+	}
+	position, _ := v.Value().(int64)
+	return time.Duration(position) * time.Microsecond
+}
+
+func GetPlaybackStatus(player dbus.BusObject) (string, error) {
+	const playerInterface = "org.mpris.MediaPlayer2.Player"
+	v, err := player.GetProperty(playerInterface + ".PlaybackStatus")
+	if err != nil {
+		return "", err
+	}
+	status, ok := v.Value().(string)
+	if !ok {
+		return "", ErrInvalidType
+	}
+	return status, nil
+}
+
 func parseMetadata(metaMap map[string]dbus.Variant) *Metadata {
 	var metadata Metadata
+	var nowPlaying string
 	for key, val := range metaMap {
 		// If anyting here fails, just use the default value
 		switch key {
@@ -242,8 +396,33 @@ func parseMetadata(metaMap map[string]dbus.Variant) *Metadata {
 			metadata.Artist, _ = getAny[[]string](val)
 		case "xesam:composer":
 			metadata.Composer, _ = getAny[[]string](val)
+		case "xesam:genre":
+			// A list, though some players give a single string
+			if genre, err := getAny[string](val); err == nil {
+				metadata.Genre = []string{genre}
+			} else {
+				metadata.Genre, _ = getAny[[]string](val)
+			}
+		case "mpris:length":
+			// Should be a 64-bit signed integer
+			metadata.Length = getInteger(val)
+		case "xesam:trackNumber":
+			// Should be a 32-bit signed integer
+			metadata.TrackNumber = int(getInteger(val))
+		case "xesam:discNumber":
+			metadata.DiscNumber = int(getInteger(val))
+		case "xesam:asText":
+			metadata.Lyrics, _ = getAny[string](val)
+		case "xesam:contentCreated":
+			created, _ := getAny[string](val)
+			metadata.Year = parseYear(created)
+		case "mpris:artUrl":
+			metadata.ArtUrl, _ = getAny[string](val)
 		case "mb:trackId":
 			metadata.TrackId, _ = getAny[string](val)
+		case "vlc:nowplaying":
+			// The stream title of internet radio, while xesam:title is the station's name
+			nowPlaying, _ = getAny[string](val)
 		case "xesam:title":
 			if temp, err := getAny[string](val); err != nil {
 				slog.Warn("Failed to extract title from track, assuming blank")
@@ -252,9 +431,78 @@ func parseMetadata(metaMap map[string]dbus.Variant) *Metadata {
 			}
 		}
 	}
+	if len(strings.TrimSpace(nowPlaying)) > 0 {
+		// Each song on the station is a track; the station is treated as the album
+		if len(metadata.Album) == 0 {
+			metadata.Album = metadata.Title
+		}
+		metadata.Title = nowPlaying
+	}
+	metadata.Raw = metaMap
+	metadata.Clean()
 	return &metadata
 }
 
+// Encode a metadata map as a JSON object of its keys' values in the GVariant text format, which keeps their types
+func encodeRawMetadata(raw map[string]dbus.Variant) (string, error) {
+	values := make(map[string]string, len(raw))
+	for key, val := range raw {
+		values[key] = val.String()
+	}
+	encoded, err := json.Marshal(values)
+	return string(encoded), err
+}
+
+// Decode a metadata map encoded by encodeRawMetadata
+func decodeRawMetadata(encoded string) (map[string]dbus.Variant, error) {
+	var values map[string]string
+	if err := json.Unmarshal([]byte(encoded), &values); err != nil {
+		return nil, err
+	}
+	raw := make(map[string]dbus.Variant, len(values))
+	for key, val := range values {
+		v, err := dbus.ParseVariant(val, dbus.Signature{})
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse %s: %w", key, err)
+		}
+		raw[key] = v
+	}
+	return raw, nil
+}
+
+// Get the year from the start of an ISO 8601 date, such as xesam:contentCreated; 0 if it has none
+func parseYear(date string) int {
+	if len(date) < 4 {
+		return 0
+	}
+	year, err := strconv.Atoi(date[:4])
+	if err != nil || year <= 0 {
+		return 0
+	}
+	return year
+}
+
+// Get an integer field, which some players give as a different integer type than the specification's; 0 if it is not an integer
+func getInteger(value dbus.Variant) int64 {
+	switch v := value.Value().(type) {
+	case int64:
+		return v
+	case uint64:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case byte:
+		return int64(v)
+	}
+	return 0
+}
+
 func getAny[T any](value dbus.Variant) (T, error) {
 	val := value.Value()
 	if v, ok := val.(T); ok {
@@ -287,10 +535,16 @@ func addPlayer(conn *dbus.Conn, name string) error {
 	}
 	busNameToName[busName] = name
 	nameToBusName[name] = busName
+	// Not every player has a desktop entry, so it is only recorded if there is one
+	player := conn.Object(name, dbus.ObjectPath(playerPath))
+	if entry, err := player.GetProperty("org.mpris.MediaPlayer2.DesktopEntry"); err == nil {
+		nameToDesktopEntry[name], _ = entry.Value().(string)
+	}
 	return nil
 }
 
 func removePlayer(name string) {
+	delete(nameToDesktopEntry, name)
 	busName, ok := nameToBusName[name]
 	if !ok {
 		slog.Warn("Attempted to remove player not in mapping", "Name", name)
@@ -321,6 +575,12 @@ func (m *Metadata) IsSameTrack(other *Metadata) bool {
 	return m.Url == other.Url && m.Title == other.Title
 }
 
+// Get whether the metadata identifies no track, as when a player such as mpv
+// clears its metadata on stopping; this ends the current play rather than starting one.
+func (m *Metadata) Cleared() bool {
+	return len(m.Title) == 0 && len(m.Url) == 0
+}
+
 func GetExistingPlayers(ctx context.Context, conn *dbus.Conn) ([]string, error) {
 	systemBus := conn.Object(systemBusName, systemBusPath)
 	call := systemBus.CallWithContext(ctx, systemBusName+".ListNames", 0)
@@ -0,0 +1,161 @@
+package music_watch
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Number of entries kept for each kind of diagnostic event
+const diagnosticsSize = 50
+
+// A single diagnostic event
+type DiagEvent struct {
+	Time     time.Time `json:"time"`
+	Message  string    `json:"message"`
+	Duration float64   `json:"durationMs,omitempty"`
+}
+
+// Fixed-size buffer keeping the most recent events
+type ringBuffer struct {
+	entries []DiagEvent
+	next    int
+	full    bool
+}
+
+func (r *ringBuffer) add(e DiagEvent) {
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Get the entries, oldest first
+func (r *ringBuffer) list() []DiagEvent {
+	if !r.full {
+		return append([]DiagEvent{}, r.entries[:r.next]...)
+	}
+	return append(append([]DiagEvent{}, r.entries[r.next:]...), r.entries[:r.next]...)
+}
+
+// In-memory record of recent problems, so that they can be inspected without debug logging
+type Diagnostics struct {
+	lock      sync.Mutex
+	errors    ringBuffer
+	dropped   ringBuffer
+	latencies ringBuffer
+	// Called with each error as it is recorded; must not block or log errors
+	errorListeners map[*func(DiagEvent)]struct{}
+}
+
+// A snapshot of the diagnostics
+type DiagReport struct {
+	Errors    []DiagEvent `json:"errors"`
+	Dropped   []DiagEvent `json:"droppedSignals"`
+	Latencies []DiagEvent `json:"sinkLatencies"`
+}
+
+var diagnostics = newDiagnostics(diagnosticsSize)
+
+func newDiagnostics(size int) *Diagnostics {
+	return &Diagnostics{
+		errors:    ringBuffer{entries: make([]DiagEvent, size)},
+		dropped:   ringBuffer{entries: make([]DiagEvent, size)},
+		latencies: ringBuffer{entries: make([]DiagEvent, size)},
+
+		errorListeners: make(map[*func(DiagEvent)]struct{}),
+	}
+}
+
+func recordError(message string) {
+	diagnostics.lock.Lock()
+	defer diagnostics.lock.Unlock()
+	e := DiagEvent{Time: time.Now(), Message: message}
+	diagnostics.errors.add(e)
+	for listener := range diagnostics.errorListeners {
+		(*listener)(e)
+	}
+}
+
+// Call fn with each error logged from now on, until the returned function is called
+func watchErrors(fn func(DiagEvent)) func() {
+	diagnostics.lock.Lock()
+	defer diagnostics.lock.Unlock()
+	diagnostics.errorListeners[&fn] = struct{}{}
+	return func() {
+		diagnostics.lock.Lock()
+		defer diagnostics.lock.Unlock()
+		delete(diagnostics.errorListeners, &fn)
+	}
+}
+
+// Record a D-Bus signal that was ignored
+func recordDropped(reason string, args ...any) {
+	diagnostics.lock.Lock()
+	defer diagnostics.lock.Unlock()
+	diagnostics.dropped.add(DiagEvent{Time: time.Now(), Message: formatAttrs(reason, args...)})
+}
+
+// Record how long a sink took to handle a track
+func recordLatency(sink string, d time.Duration) {
+	diagnostics.lock.Lock()
+	defer diagnostics.lock.Unlock()
+	diagnostics.latencies.add(DiagEvent{Time: time.Now(), Message: sink, Duration: float64(d.Microseconds()) / 1000})
+}
+
+func GetDiagnostics() *DiagReport {
+	diagnostics.lock.Lock()
+	defer diagnostics.lock.Unlock()
+	return &DiagReport{
+		Errors:    diagnostics.errors.list(),
+		Dropped:   diagnostics.dropped.list(),
+		Latencies: diagnostics.latencies.list(),
+	}
+}
+
+// Format a message and key-value pairs in the same way as the text log handler
+func formatAttrs(message string, args ...any) string {
+	var b strings.Builder
+	b.WriteString(message)
+	for i := 0; i+1 < len(args); i += 2 {
+		fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+	}
+	return b.String()
+}
+
+// A log handler that records errors in the diagnostics buffer before passing them on
+type diagHandler struct {
+	slog.Handler
+	attrs []slog.Attr
+}
+
+func NewDiagnosticsHandler(next slog.Handler) slog.Handler {
+	return &diagHandler{Handler: next}
+}
+
+func (h *diagHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelError {
+		args := make([]any, 0, 2*(len(h.attrs)+r.NumAttrs()))
+		for _, a := range h.attrs {
+			args = append(args, a.Key, a.Value)
+		}
+		r.Attrs(func(a slog.Attr) bool {
+			args = append(args, a.Key, a.Value)
+			return true
+		})
+		recordError(formatAttrs(r.Message, args...))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *diagHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &diagHandler{Handler: h.Handler.WithAttrs(attrs), attrs: append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...)}
+}
+
+func (h *diagHandler) WithGroup(name string) slog.Handler {
+	return &diagHandler{Handler: h.Handler.WithGroup(name), attrs: h.attrs}
+}
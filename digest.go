@@ -0,0 +1,242 @@
+package music_watch
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+
+	dbus "github.com/godbus/dbus/v5"
+)
+
+var ErrDigest = errors.New("invalid digest settings")
+
+// Gaps between plays longer than this are counted as a pause, not as listening
+const digestMaxGap = 10 * time.Minute
+
+// Settings for the listening digest
+type DigestConfig struct {
+	// The period covered by the digest: day, week, month or year; defaults to week
+	Period string `json:"period"`
+	// Number of entries in each list; defaults to 5
+	Limit int `json:"limit"`
+	// Send the digest as HTML instead of plain text
+	HTML bool `json:"html"`
+	// Where the digest task mails the digest
+	Mail MailConfig `json:"mail"`
+}
+
+// An SMTP server and the addresses to mail to
+type MailConfig struct {
+	// The server as host:port; STARTTLS is used when the server offers it
+	Server   string `json:"server"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// If Password is empty, the attributes of the password's entry in the keyring
+	PasswordKeyring map[string]string `json:"passwordKeyring"`
+	From            string            `json:"from"`
+	To              []string          `json:"to"`
+}
+
+// A summary of the plays in a period
+type Digest struct {
+	From       time.Time
+	To         time.Time
+	Plays      int
+	Listened   time.Duration // See estimateListened
+	TopArtists []TopItem
+	TopAlbums  []TopItem
+	TopTracks  []TopItem
+	// Artists that were first played in the period
+	NewArtists []TopItem
+}
+
+// Summarize the plays between from and to, with up to limit entries in each list
+func GetDigest(ctx context.Context, db *sql.DB, from, to time.Time, limit int) (*Digest, error) {
+	d := Digest{From: from, To: to}
+	where, args := playConditions(ctx, from, to)
+	plays, playsArgs := countedPlays(ctx, from, to)
+	if err := db.QueryRowContext(ctx, "SELECT COALESCE(SUM(l.plays), 0) FROM ("+plays+") l", playsArgs...).Scan(&d.Plays); err != nil {
+		return nil, err
+	}
+	var err error
+	if d.Listened, err = estimateListened(ctx, db, where, args); err != nil {
+		return nil, err
+	}
+	for kind, items := range map[string]*[]TopItem{"artists": &d.TopArtists, "albums": &d.TopAlbums, "tracks": &d.TopTracks} {
+		if *items, err = GetTopItems(ctx, db, kind, from, to, limit, 0); err != nil {
+			return nil, err
+		}
+	}
+	having := `
+		HAVING (
+			SELECT MIN(f.timestamp) FROM TrackLogAll f
+			JOIN Track_Person ftp ON ftp.track = f.track
+			JOIN Person fp ON fp.id = ftp.person
+			WHERE fp.name = tp.name AND f.guest IS NULL AND f.user IS ?
+		) >= ?`
+	newArgs := append(playsArgs, userArg(ctx), formatTimestamp(from))
+	if len(playUser(ctx)) == 0 {
+		// Nor in the daily counts of the plays that were pruned
+		having += ` AND NOT EXISTS (
+			SELECT 1 FROM DailyPlays fd
+			JOIN Track_Person ftp ON ftp.track = fd.track
+			JOIN Person fp ON fp.id = ftp.person
+			WHERE fp.name = tp.name AND fd.day || 'T00:00:00Z' < ?
+		)`
+		newArgs = append(newArgs, formatTimestamp(from))
+	}
+	rows, err := db.QueryContext(
+		ctx,
+		fmt.Sprintf(topItemQueries["artists"], plays)+having+" ORDER BY plays DESC, 1 LIMIT ?",
+		append(newArgs, limit)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item TopItem
+		if err := rows.Scan(&item.Name, &item.Plays); err != nil {
+			return nil, err
+		}
+		d.NewArtists = append(d.NewArtists, item)
+	}
+	return &d, rows.Err()
+}
+
+// Estimate the time spent listening to the plays matching the conditions on TrackLogAll l,
+// from how long each was played where that was recorded, and otherwise the time until the next play
+func estimateListened(ctx context.Context, db *sql.DB, where string, args []any) (time.Duration, error) {
+	var listened float64
+	err := db.QueryRowContext(
+		ctx,
+		`SELECT COALESCE(SUM(COALESCE(played, MIN(gap, ?))), 0) FROM (
+			SELECT l.playedMs / 1000.0 AS played,
+				(julianday(LEAD(l.timestamp) OVER (ORDER BY l.timestamp)) - julianday(l.timestamp)) * 86400 AS gap
+			FROM TrackLogAll l WHERE `+where+`
+		)`,
+		append([]any{digestMaxGap.Seconds()}, args...)...,
+	).Scan(&listened)
+	return time.Duration(listened) * time.Second, err
+}
+
+// Summarize the configured period ending now
+func (c DigestConfig) Build(ctx context.Context, db *sql.DB) (*Digest, error) {
+	period := c.Period
+	if len(period) == 0 {
+		period = "week"
+	}
+	limit := c.Limit
+	if limit <= 0 {
+		limit = 5
+	}
+	to := time.Now()
+	from, err := PeriodStart(to, period)
+	if err != nil {
+		return nil, err
+	}
+	return GetDigest(ctx, db, from, to, limit)
+}
+
+// Functions available to the digest templates
+var digestFuncs = map[string]any{
+	"date": func(t time.Time) string { return t.Format(time.DateOnly) },
+	"hours": func(d time.Duration) string {
+		return fmt.Sprintf("%.1f", d.Hours())
+	},
+}
+
+var digestText = template.Must(template.New("digest").Funcs(digestFuncs).Parse(`Listening from {{date .From}} to {{date .To}}
+
+{{.Plays}} plays, about {{hours .Listened}} hours
+{{define "list"}}{{range .}}
+  {{.Name}} ({{.Plays}}){{end}}
+{{end}}{{with .TopArtists}}
+Top artists:{{template "list" .}}{{end}}{{with .TopAlbums}}
+Top albums:{{template "list" .}}{{end}}{{with .TopTracks}}
+Top tracks:{{template "list" .}}{{end}}{{with .NewArtists}}
+New artists:{{template "list" .}}{{end}}`))
+
+var digestHTML = htmltemplate.Must(htmltemplate.New("digest").Funcs(digestFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Listening from {{date .From}} to {{date .To}}</title></head>
+<body>
+<h1>Listening from {{date .From}} to {{date .To}}</h1>
+<p>{{.Plays}} plays, about {{hours .Listened}} hours</p>
+{{define "list"}}<ol>{{range .}}<li>{{.Name}} ({{.Plays}})</li>{{end}}</ol>{{end}}
+{{with .TopArtists}}<h2>Top artists</h2>{{template "list" .}}{{end}}
+{{with .TopAlbums}}<h2>Top albums</h2>{{template "list" .}}{{end}}
+{{with .TopTracks}}<h2>Top tracks</h2>{{template "list" .}}{{end}}
+{{with .NewArtists}}<h2>New artists</h2>{{template "list" .}}{{end}}
+</body>
+</html>
+`))
+
+// Write the digest as plain text or HTML
+func (d *Digest) Render(w io.Writer, html bool) error {
+	if html {
+		return digestHTML.Execute(w, d)
+	}
+	return digestText.Execute(w, d)
+}
+
+// Mail the digest using the settings; conn is only used to read the password from the keyring and may be nil
+func MailDigest(conn *dbus.Conn, config MailConfig, d *Digest, html bool) error {
+	if len(config.Server) == 0 || len(config.From) == 0 || len(config.To) == 0 {
+		return fmt.Errorf("%w: mail needs a server, a sender and recipients", ErrDigest)
+	}
+	host, _, err := net.SplitHostPort(config.Server)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDigest, err)
+	}
+	var auth smtp.Auth
+	if len(config.Username) > 0 {
+		password, err := configSecret(conn, config.Password, config.PasswordKeyring)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", config.Username, password, host)
+	}
+	var msg bytes.Buffer
+	contentType := "text/plain"
+	if html {
+		contentType = "text/html"
+	}
+	fmt.Fprintf(&msg, "From: %s\r\n", config.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(config.To, ", "))
+	fmt.Fprintf(&msg, "Subject: Listening from %s to %s\r\n", d.From.Format(time.DateOnly), d.To.Format(time.DateOnly))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\nContent-Type: %s; charset=utf-8\r\n\r\n", contentType)
+	var body bytes.Buffer
+	if err := d.Render(&body, html); err != nil {
+		return err
+	}
+	// SMTP requires CRLF line endings
+	msg.WriteString(strings.ReplaceAll(body.String(), "\n", "\r\n"))
+	return smtp.SendMail(config.Server, auth, config.From, config.To, msg.Bytes())
+}
+
+// Register the digest task, which mails the digest for the period that just ended
+func RegisterDigestTasks(s *Scheduler, db *sql.DB, conn *dbus.Conn, config DigestConfig) {
+	s.Register("digest", func(ctx context.Context) error {
+		d, err := config.Build(ctx, db)
+		if err != nil {
+			return err
+		}
+		if err := MailDigest(conn, config.Mail, d, config.HTML); err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "Mailed digest", "To", config.Mail.To, "Plays", d.Plays)
+		return nil
+	})
+}
@@ -0,0 +1,326 @@
+package music_watch
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrDiscord = errors.New("Discord IPC request failed")
+var errDiscordNotRunning = fmt.Errorf("%w: Discord is not running", ErrDiscord)
+
+// Discord IPC frame opcodes
+const (
+	discordHandshake = 0
+	discordFrame     = 1
+	discordClose     = 2
+)
+
+// How often to try reaching Discord again while there is a presence to show
+const discordRetry = 30 * time.Second
+
+// Discord limits activity fields to 128 characters
+const discordMaxField = 128
+
+// Settings for showing the current track as Discord Rich Presence
+type DiscordConfig struct {
+	// The ID of an application created at https://discord.com/developers/applications,
+	// whose name is shown as what is being listened to; empty disables
+	ClientID string `json:"clientId"`
+}
+
+// Shows the current track as the user's Discord activity, clearing it when playback stops
+type Discord struct {
+	Config DiscordConfig
+
+	updates chan *discordActivity // nil clears the activity
+	done    chan struct{}
+	lock    sync.Mutex
+	current *Event // The event the activity was last set from
+	lastErr error
+}
+
+type discordActivity struct {
+	Type       int               `json:"type"`
+	Details    string            `json:"details,omitempty"`
+	State      string            `json:"state,omitempty"`
+	Timestamps *discordTimestamp `json:"timestamps,omitempty"`
+	Assets     *discordAssets    `json:"assets,omitempty"`
+}
+
+type discordTimestamp struct {
+	Start int64 `json:"start,omitempty"`
+	End   int64 `json:"end,omitempty"`
+}
+
+type discordAssets struct {
+	LargeText string `json:"large_text,omitempty"`
+}
+
+func (d *Discord) Name() string {
+	return "discord"
+}
+
+func (d *Discord) Init(ctx context.Context) error {
+	d.updates = make(chan *discordActivity, 1)
+	d.done = make(chan struct{})
+	go d.run()
+	return nil
+}
+
+func (d *Discord) Store(ctx context.Context, e Event) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	switch e.Type {
+	case EventNowPlaying, EventResumed:
+		if len(e.Track.Title) == 0 {
+			// There is nothing to show for players that have not loaded a track yet
+			return nil
+		}
+		d.current = &e
+		d.update(newDiscordActivity(e))
+	case EventPaused, EventPlayFinished:
+		// Only the player whose track is shown can clear it
+		if d.current != nil && d.current.Track.Player == e.Track.Player && d.current.Track.IsSameTrack(e.Track) {
+			d.current = nil
+			d.update(nil)
+		}
+	}
+	return nil
+}
+
+// Replace any update that has not been sent yet, since only the latest matters.
+// Must be called with the lock held.
+func (d *Discord) update(activity *discordActivity) {
+	select {
+	case <-d.updates:
+	default:
+	}
+	d.updates <- activity
+}
+
+func newDiscordActivity(e Event) *discordActivity {
+	activity := discordActivity{
+		Type:    2, // Listening
+		Details: truncate(e.Track.Title, discordMaxField),
+		State:   truncate(strings.Join(e.Track.Artist, ", "), discordMaxField),
+	}
+	if len(e.Track.Album) > 0 {
+		activity.Assets = &discordAssets{LargeText: truncate(e.Track.Album, discordMaxField)}
+	}
+	// Discord counts down to the end of the track from where playback is
+	start := e.Time.Add(-time.Duration(e.Position) * time.Microsecond)
+	activity.Timestamps = &discordTimestamp{Start: start.UnixMilli()}
+	if e.Track.Length > 0 {
+		activity.Timestamps.End = start.Add(time.Duration(e.Track.Length) * time.Microsecond).UnixMilli()
+	}
+	return &activity
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// Send activity updates, connecting to Discord when it is running
+func (d *Discord) run() {
+	defer close(d.done)
+	var conn net.Conn
+	var pending *discordActivity
+	var retrying bool // There is an update that could not be sent
+	retry := time.NewTicker(discordRetry)
+	defer retry.Stop()
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	for {
+		select {
+		case activity, ok := <-d.updates:
+			if !ok {
+				if conn != nil {
+					// Leave nothing behind once the watcher stops
+					d.setActivity(conn, nil)
+				}
+				return
+			}
+			pending, retrying = activity, true
+		case <-retry.C:
+			if !retrying {
+				continue
+			}
+		}
+		if conn == nil {
+			if pending == nil {
+				// Nothing is shown without a connection, so there is nothing to clear
+				retrying = false
+				continue
+			}
+			var err error
+			if conn, err = d.connect(); err != nil {
+				d.setError(err)
+				continue
+			}
+		}
+		if err := d.setActivity(conn, pending); err != nil {
+			slog.Debug("Unable to set Discord activity", "Error", err)
+			d.setError(err)
+			conn.Close()
+			conn = nil
+			continue
+		}
+		d.setError(nil)
+		retrying = false
+	}
+}
+
+// Find Discord's IPC socket, which may be inside a Flatpak or Snap sandbox directory
+func discordSocketPaths() []string {
+	var dirs []string
+	for _, env := range []string{"XDG_RUNTIME_DIR", "TMPDIR"} {
+		if dir := os.Getenv(env); len(dir) > 0 {
+			dirs = append(dirs, dir)
+		}
+	}
+	dirs = append(dirs, "/tmp")
+	var paths []string
+	for _, dir := range dirs {
+		for _, sub := range []string{"", "app/com.discordapp.Discord", "snap.discord", ".flatpak/dev.vencord.Vesktop/xdg-run"} {
+			for i := range 10 {
+				paths = append(paths, filepath.Join(dir, sub, "discord-ipc-"+strconv.Itoa(i)))
+			}
+		}
+	}
+	return paths
+}
+
+func (d *Discord) connect() (net.Conn, error) {
+	for _, path := range discordSocketPaths() {
+		conn, err := net.DialTimeout("unix", path, time.Second)
+		if err != nil {
+			continue
+		}
+		err = d.handshake(conn)
+		if err == nil {
+			slog.Debug("Connected to Discord", "Socket", path)
+			return conn, nil
+		}
+		conn.Close()
+		return nil, err
+	}
+	return nil, errDiscordNotRunning
+}
+
+func (d *Discord) handshake(conn net.Conn) error {
+	if err := writeDiscordFrame(conn, discordHandshake, map[string]any{"v": 1, "client_id": d.Config.ClientID}); err != nil {
+		return err
+	}
+	// Discord replies with a READY event, or closes the connection if the client ID is invalid
+	_, err := readDiscordFrame(conn)
+	return err
+}
+
+func (d *Discord) setActivity(conn net.Conn, activity *discordActivity) error {
+	args := map[string]any{"pid": os.Getpid()}
+	if activity != nil {
+		args["activity"] = activity
+	}
+	request := map[string]any{
+		"cmd":   "SET_ACTIVITY",
+		"args":  args,
+		"nonce": strconv.FormatInt(time.Now().UnixNano(), 10),
+	}
+	if err := writeDiscordFrame(conn, discordFrame, request); err != nil {
+		return err
+	}
+	response, err := readDiscordFrame(conn)
+	if err != nil {
+		return err
+	}
+	var result struct {
+		Evt  string `json:"evt"`
+		Data struct {
+			Message string `json:"message"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(response, &result); err != nil {
+		return err
+	}
+	if result.Evt == "ERROR" {
+		return fmt.Errorf("%w: %s", ErrDiscord, result.Data.Message)
+	}
+	return nil
+}
+
+// Frames are a little-endian opcode and length, followed by JSON
+func writeDiscordFrame(conn net.Conn, op uint32, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	frame := binary.LittleEndian.AppendUint32(nil, op)
+	frame = binary.LittleEndian.AppendUint32(frame, uint32(len(data)))
+	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write(append(frame, data...))
+	return err
+}
+
+func readDiscordFrame(conn net.Conn) ([]byte, error) {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	op := binary.LittleEndian.Uint32(header)
+	data := make([]byte, binary.LittleEndian.Uint32(header[4:]))
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return nil, err
+	}
+	if op == discordClose {
+		return nil, fmt.Errorf("%w: %s", ErrDiscord, data)
+	}
+	return data, nil
+}
+
+func (d *Discord) setError(err error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.lastErr = err
+}
+
+// Not being able to reach Discord is normal when it is not running, so it is not an error
+func (d *Discord) health() (int, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.lastErr == errDiscordNotRunning {
+		return 0, nil
+	}
+	return 0, d.lastErr
+}
+
+func (d *Discord) Flush(ctx context.Context) error {
+	return nil
+}
+
+func (d *Discord) Close() error {
+	d.lock.Lock()
+	close(d.updates)
+	d.lock.Unlock()
+	<-d.done
+	return nil
+}
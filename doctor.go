@@ -0,0 +1,249 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Rows found by CheckDatabase that are inconsistent or too empty to be useful
+type DatabaseProblem struct {
+	Check       string
+	Description string
+	Found       int64
+	Repaired    int64 // Rows that were left as they were, such as tracks that still have plays, are not counted
+}
+
+type integrityCheck struct {
+	name, description string
+	// Count the rows with the problem, repairing them if repair is set
+	run func(ctx context.Context, tx *sql.Tx, repair bool) (found, repaired int64, err error)
+}
+
+// Checks that are run in order, so that the rows removed by one are not found by the next
+var integrityChecks = []integrityCheck{
+	{"orphaned-plays", "Plays of tracks that do not exist", checkOrphanedPlays},
+	{"missing-albums", "Tracks on albums that do not exist", checkWhere(
+		"Track", "album IS NOT NULL AND album NOT IN (SELECT id FROM Album)",
+		"UPDATE Track SET album = NULL WHERE %s",
+	)},
+	{"orphaned-relations", "Artists, album artists and genres of tracks, albums or persons that do not exist", checkOrphanedRelations},
+	{"empty-plays", "Plays without a time, which cannot be placed in the history", checkEmptyPlays},
+	{"empty-persons", "Persons without a name", checkWhere(
+		"Person", "COALESCE(name, '') = ''",
+		"DELETE FROM Track_Person WHERE person IN (SELECT id FROM Person WHERE %s)",
+		"DELETE FROM Album_Person WHERE person IN (SELECT id FROM Person WHERE %s)",
+		"DELETE FROM Person_Alias WHERE person IN (SELECT id FROM Person WHERE %s)",
+		"DELETE FROM Person WHERE %s",
+	)},
+	{"empty-albums", "Albums without a title", checkWhere(
+		"Album", "COALESCE(title, '') = ''",
+		"UPDATE Track SET album = NULL WHERE album IN (SELECT id FROM Album WHERE %s)",
+		"DELETE FROM Album_Person WHERE album IN (SELECT id FROM Album WHERE %s)",
+		"DELETE FROM Album WHERE %s",
+	)},
+	{"empty-tracks", "Tracks with neither a title nor a URL; only those without plays are removed", checkEmptyTracks},
+	{"duplicate-persons", "Persons whose names differ only in case, punctuation or spacing", checkDuplicatePersons},
+}
+
+// Find plays, tracks, albums and persons that are inconsistent, such as plays of tracks that do not exist,
+// or too empty to be useful, and remove or fix them if repair is set.
+// Databases from before foreign keys were enforced, or changed by other programs, may have them.
+func CheckDatabase(ctx context.Context, db *sql.DB, repair bool) ([]DatabaseProblem, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	problems := make([]DatabaseProblem, len(integrityChecks))
+	for i, check := range integrityChecks {
+		problems[i] = DatabaseProblem{Check: check.name, Description: check.description}
+		if problems[i].Found, problems[i].Repaired, err = check.run(ctx, tx, repair); err != nil {
+			return nil, err
+		}
+	}
+	if !repair {
+		return problems, nil
+	}
+	return problems, tx.Commit()
+}
+
+// Count the rows of the table matching the condition, and run the statements to repair them.
+// Each statement has the condition in place of its %s.
+func checkWhere(table, where string, repairs ...string) func(ctx context.Context, tx *sql.Tx, repair bool) (int64, int64, error) {
+	return func(ctx context.Context, tx *sql.Tx, repair bool) (int64, int64, error) {
+		var found int64
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table+" WHERE "+where).Scan(&found); err != nil {
+			return 0, 0, err
+		}
+		if !repair || found == 0 {
+			return found, 0, nil
+		}
+		for _, stmt := range repairs {
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf(stmt, where)); err != nil {
+				return found, 0, err
+			}
+		}
+		return found, found, nil
+	}
+}
+
+// Get the tables that hold plays: TrackLog, its partitions and PodcastLog
+func playTables(ctx context.Context, tx *sql.Tx) ([]string, error) {
+	tables, err := trackLogTables(ctx, tx)
+	return append(tables, "PodcastLog"), err
+}
+
+func checkOrphanedPlays(ctx context.Context, tx *sql.Tx, repair bool) (int64, int64, error) {
+	return checkPlaysWhere(ctx, tx, repair, "track IS NULL OR track NOT IN (SELECT id FROM Track)")
+}
+
+func checkEmptyPlays(ctx context.Context, tx *sql.Tx, repair bool) (int64, int64, error) {
+	return checkPlaysWhere(ctx, tx, repair, "timestamp IS NULL")
+}
+
+func checkPlaysWhere(ctx context.Context, tx *sql.Tx, repair bool, where string) (int64, int64, error) {
+	tables, err := playTables(ctx, tx)
+	if err != nil {
+		return 0, 0, err
+	}
+	var found, repaired int64
+	for _, table := range tables {
+		f, r, err := checkWhere(table, where, "DELETE FROM "+table+" WHERE %s")(ctx, tx, repair)
+		if err != nil {
+			return found, repaired, err
+		}
+		found += f
+		repaired += r
+	}
+	return found, repaired, nil
+}
+
+func checkOrphanedRelations(ctx context.Context, tx *sql.Tx, repair bool) (int64, int64, error) {
+	var found, repaired int64
+	for _, relation := range []struct{ table, where string }{
+		{"Track_Person", "track IS NULL OR person IS NULL OR track NOT IN (SELECT id FROM Track) OR person NOT IN (SELECT id FROM Person)"},
+		{"Album_Person", "album NOT IN (SELECT id FROM Album) OR person NOT IN (SELECT id FROM Person)"},
+		{"Track_Genre", "track NOT IN (SELECT id FROM Track) OR genre NOT IN (SELECT id FROM Genre)"},
+		{"Person_Alias", "person NOT IN (SELECT id FROM Person)"},
+	} {
+		f, r, err := checkWhere(relation.table, relation.where, "DELETE FROM "+relation.table+" WHERE %s")(ctx, tx, repair)
+		if err != nil {
+			return found, repaired, err
+		}
+		found += f
+		repaired += r
+	}
+	return found, repaired, nil
+}
+
+func checkEmptyTracks(ctx context.Context, tx *sql.Tx, repair bool) (int64, int64, error) {
+	const empty = "COALESCE(title, '') = '' AND COALESCE(url, '') = ''"
+	var found int64
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM Track WHERE "+empty).Scan(&found); err != nil {
+		return 0, 0, err
+	}
+	if !repair || found == 0 {
+		return found, 0, nil
+	}
+	// Tracks are only removed if nothing refers to them, so that no plays are lost
+	tables, err := playTables(ctx, tx)
+	if err != nil {
+		return found, 0, err
+	}
+	unused := "id IN (SELECT id FROM Track WHERE " + empty + " AND id NOT IN (SELECT track FROM DailyPlays)"
+	for _, table := range tables {
+		unused += " AND id NOT IN (SELECT track FROM " + table + " WHERE track IS NOT NULL)"
+	}
+	unused += ")"
+	for _, stmt := range []string{
+		"DELETE FROM Track_Person WHERE track IN (SELECT id FROM Track WHERE %s)",
+		"DELETE FROM Track_Genre WHERE track IN (SELECT id FROM Track WHERE %s)",
+		"DELETE FROM Progress WHERE track IN (SELECT id FROM Track WHERE %s)",
+	} {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(stmt, unused)); err != nil {
+			return found, 0, err
+		}
+	}
+	res, err := tx.ExecContext(ctx, "DELETE FROM Track WHERE "+unused)
+	if err != nil {
+		return found, 0, err
+	}
+	repaired, err := res.RowsAffected()
+	return found, repaired, err
+}
+
+// Merge persons whose names are the same once normalized as FindDuplicateTracks does,
+// into the one on the most tracks, keeping the other names as its aliases
+func checkDuplicatePersons(ctx context.Context, tx *sql.Tx, repair bool) (int64, int64, error) {
+	rows, err := tx.QueryContext(
+		ctx,
+		`SELECT p.id, p.name FROM Person p WHERE COALESCE(p.name, '') != ''
+		ORDER BY (SELECT COUNT(*) FROM Track_Person tp WHERE tp.person = p.id) DESC, p.id`,
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+	type person struct {
+		id   int64
+		name string
+	}
+	var keys []string
+	groups := make(map[string][]person)
+	for rows.Next() {
+		var p person
+		if err := rows.Scan(&p.id, &p.name); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		key := duplicateName(p.name)
+		if len(key) == 0 {
+			// Names of punctuation alone are not compared
+			continue
+		}
+		if len(groups[key]) == 0 {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	var found, repaired int64
+	for _, key := range keys {
+		group := groups[key]
+		if len(group) < 2 {
+			continue
+		}
+		found += int64(len(group) - 1)
+		if !repair {
+			continue
+		}
+		for _, duplicate := range group[1:] {
+			if err := mergePerson(ctx, tx, group[0].id, duplicate.id); err != nil {
+				return found, repaired, err
+			}
+			_, err := tx.ExecContext(
+				ctx,
+				"INSERT INTO Person_Alias (alias, person) VALUES (?, ?) ON CONFLICT (alias) DO UPDATE SET person = excluded.person",
+				duplicate.name,
+				group[0].id,
+			)
+			if err != nil {
+				return found, repaired, err
+			}
+			repaired++
+		}
+	}
+	if repaired > 0 {
+		// Albums are told apart by their artists' names, some of which were merged
+		if _, err := tx.ExecContext(ctx, "UPDATE Album SET artistKey = NULL"); err != nil {
+			return found, repaired, err
+		}
+		if err := backfillAlbumArtistKeys(ctx, tx); err != nil {
+			return found, repaired, err
+		}
+	}
+	return found, repaired, nil
+}
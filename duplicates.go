@@ -0,0 +1,128 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+var ErrUnknownTrack = errors.New("unknown track")
+
+// A track in a set of likely duplicates
+type DuplicateTrack struct {
+	Id      int64
+	Title   string
+	Url     string
+	Artists []string
+	Plays   int
+}
+
+// Tracks that are likely the same recording, stored under different URLs or with different punctuation
+type DuplicateTracks struct {
+	Keep       DuplicateTrack // The track the others would be merged into: the most played
+	Duplicates []DuplicateTrack
+}
+
+// Get the key that likely duplicates share: the title and artists without case, punctuation or spacing
+func duplicateKey(title string, artists []string) string {
+	names := make([]string, len(artists))
+	for i, artist := range artists {
+		names[i] = duplicateName(artist)
+	}
+	slices.Sort(names)
+	return duplicateName(title) + "\x00" + strings.Join(slices.Compact(names), "\x00")
+}
+
+func duplicateName(value string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, normalizeName(value))
+}
+
+// Find tracks with the same title and artists, ignoring case, punctuation and spacing.
+// Tracks without artists are left out, since their titles alone say little.
+func FindDuplicateTracks(ctx context.Context, db *sql.DB) ([]DuplicateTracks, error) {
+	rows, err := db.QueryContext(
+		ctx,
+		`SELECT t.id, COALESCE(t.title, ''), COALESCE(t.url, ''), p.name,
+			(SELECT COUNT(*) FROM `+trackLogView+` l WHERE l.track = t.id)
+		FROM Track t JOIN Track_Person tp ON tp.track = t.id JOIN Person p ON p.id = tp.person
+		WHERE tp.role = ? OR tp.role IS NULL
+		ORDER BY t.id`,
+		roleArtist,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tracks []*DuplicateTrack
+	for rows.Next() {
+		var track DuplicateTrack
+		var artist string
+		if err := rows.Scan(&track.Id, &track.Title, &track.Url, &artist, &track.Plays); err != nil {
+			return nil, err
+		}
+		if n := len(tracks); n > 0 && tracks[n-1].Id == track.Id {
+			tracks[n-1].Artists = append(tracks[n-1].Artists, artist)
+			continue
+		}
+		track.Artists = []string{artist}
+		tracks = append(tracks, &track)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	var keys []string
+	groups := make(map[string][]DuplicateTrack)
+	for _, track := range tracks {
+		key := duplicateKey(track.Title, track.Artists)
+		if len(groups[key]) == 0 {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], *track)
+	}
+	var duplicates []DuplicateTracks
+	for _, key := range keys {
+		group := groups[key]
+		if len(group) < 2 {
+			continue
+		}
+		// The most played track is kept, or the oldest if several are played as much
+		slices.SortStableFunc(group, func(a, b DuplicateTrack) int { return b.Plays - a.Plays })
+		duplicates = append(duplicates, DuplicateTracks{Keep: group[0], Duplicates: group[1:]})
+	}
+	return duplicates, nil
+}
+
+// Merge the duplicates into the track that is kept, moving their plays, persons and genres to it
+func MergeTracks(ctx context.Context, db *sql.DB, keep int64, duplicates ...int64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, id := range append([]int64{keep}, duplicates...) {
+		var exists bool
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) > 0 FROM Track WHERE id = ?", id).Scan(&exists); err != nil {
+			return err
+		} else if !exists {
+			return fmt.Errorf("%w: %d", ErrUnknownTrack, id)
+		}
+	}
+	for _, duplicate := range duplicates {
+		if duplicate == keep {
+			continue
+		}
+		if err := mergeTrack(ctx, tx, keep, duplicate); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,356 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrUnknownListen = errors.New("unknown listen")
+
+// A correction of a listen. Fields that are empty are left as they are.
+type ListenEdit struct {
+	Title     string    // The listen is moved to the track with this title at the same URL, which is created if necessary
+	Artists   []string  // Replaces the artists of the listen's track, and so of its other listens
+	Album     string    // Replaces the album of the listen's track, and so of its other listens
+	Timestamp time.Time // When the track was played
+}
+
+// A listen as it was before or after a change
+type AuditedListen struct {
+	Track     int64     `json:"track"`
+	Title     string    `json:"title"`
+	Url       string    `json:"url"`
+	Album     string    `json:"album,omitempty"`
+	Artists   []string  `json:"artists,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// A change made to a listen by EditListen, DeleteListens, RestoreListens or PurgeListens
+type ListenChange struct {
+	ID      int64
+	Play    int64
+	Action  string // "edit", "delete", "restore" or "purge"
+	Changed time.Time
+	Before  *AuditedListen // nil for restored listens
+	After   *AuditedListen // nil for deleted and purged listens
+}
+
+const (
+	auditEdit    = "edit"
+	auditDelete  = "delete"
+	auditRestore = "restore"
+	auditPurge   = "purge"
+)
+
+// Find the table that holds the play, and the play as it is stored. Plays that are deleted are only found if deleted is set.
+func findListen(ctx context.Context, tx *sql.Tx, id int64, deleted bool) (string, *AuditedListen, error) {
+	tables, err := trackLogTables(ctx, tx)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, table := range tables {
+		var listen AuditedListen
+		var timestamp string
+		err := tx.QueryRowContext(ctx, "SELECT track, strftime('%Y-%m-%dT%H:%M:%SZ', timestamp) FROM "+table+" WHERE id = ? AND (deleted IS NOT NULL) = ?", id, deleted).Scan(&listen.Track, &timestamp)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return "", nil, err
+		}
+		if listen.Timestamp, err = time.Parse(timestampLayout, timestamp); err != nil {
+			return "", nil, err
+		}
+		if err := describeTrack(ctx, tx, &listen); err != nil {
+			return "", nil, err
+		}
+		return table, &listen, nil
+	}
+	return "", nil, fmt.Errorf("%w: %d", ErrUnknownListen, id)
+}
+
+// Fill in the title, URL, album and artists of the listen's track
+func describeTrack(ctx context.Context, tx *sql.Tx, listen *AuditedListen) error {
+	err := tx.QueryRowContext(
+		ctx,
+		"SELECT COALESCE(t.title, ''), COALESCE(t.url, ''), COALESCE(a.title, '') FROM Track t LEFT JOIN Album a ON a.id = t.album WHERE t.id = ?",
+		listen.Track,
+	).Scan(&listen.Title, &listen.Url, &listen.Album)
+	if err != nil {
+		return err
+	}
+	listen.Artists, err = queryStrings(
+		ctx,
+		tx,
+		"SELECT p.name FROM Track_Person tp JOIN Person p ON p.id = tp.person WHERE tp.track = ? AND (tp.role = ? OR tp.role IS NULL) ORDER BY tp.id",
+		listen.Track,
+		roleArtist,
+	)
+	return err
+}
+
+// Correct the track, artists, album or time of a listen, recording the change in the audit log
+func EditListen(ctx context.Context, db *sql.DB, id int64, edit ListenEdit) (*ListenChange, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	table, before, err := findListen(ctx, tx, id, false)
+	if err != nil {
+		return nil, err
+	}
+	track := before.Track
+	if len(edit.Title) > 0 && edit.Title != before.Title {
+		m := Metadata{Title: edit.Title, Url: before.Url, Album: before.Album, Artist: before.Artists}
+		if len(edit.Artists) > 0 {
+			m.Artist = edit.Artists
+		}
+		if len(edit.Album) > 0 {
+			m.Album = edit.Album
+		}
+		if track, err = getTrack(ctx, tx, &m, nil); err != nil {
+			return nil, err
+		}
+	}
+	if len(edit.Artists) > 0 {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM Track_Person WHERE track = ? AND (role = ? OR role IS NULL)", track, roleArtist); err != nil {
+			return nil, err
+		}
+		if err := insertPersons(ctx, tx, track, []rolePersons{{roleArtist, edit.Artists}}, nil); err != nil {
+			return nil, err
+		}
+	}
+	if len(edit.Album) > 0 {
+		m := Metadata{Artist: edit.Artists}
+		if len(m.Artist) == 0 {
+			m.Artist = before.Artists
+		}
+		artists, err := albumArtistKey(ctx, tx, &m, nil)
+		if err != nil {
+			return nil, err
+		}
+		album, err := getAlbum(ctx, tx, edit.Album, artists, nil)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE Track SET album = ? WHERE id = ?", album, track); err != nil {
+			return nil, err
+		}
+	}
+	played := before.Timestamp
+	if !edit.Timestamp.IsZero() {
+		played = edit.Timestamp.UTC()
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE "+table+" SET track = ?, timestamp = ? WHERE id = ?", track, formatTimestamp(played), id); err != nil {
+		return nil, err
+	}
+	if table == "TrackLog" && !played.Equal(before.Timestamp) {
+		// Plays in partitions are from past years, so their sessions are left as they are
+		if err := assignSession(ctx, tx, nil, id, played); err != nil {
+			return nil, err
+		}
+	}
+	after := &AuditedListen{Track: track, Timestamp: played}
+	if err := describeTrack(ctx, tx, after); err != nil {
+		return nil, err
+	}
+	change, err := auditListen(ctx, tx, id, auditEdit, before, after)
+	if err != nil {
+		return nil, err
+	}
+	return change, tx.Commit()
+}
+
+// Delete listens that should not have been recorded, recording them in the audit log.
+// The listens are kept with the time they were deleted until they are purged, and can be restored until then.
+func DeleteListens(ctx context.Context, db *sql.DB, ids ...int64) ([]ListenChange, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	// The listens deleted together share the time, so that they are restored together
+	deleted := formatTimestamp(time.Now())
+	var changes []ListenChange
+	for _, id := range ids {
+		table, before, err := findListen(ctx, tx, id, false)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE "+table+" SET deleted = ? WHERE id = ?", deleted, id); err != nil {
+			return nil, err
+		}
+		change, err := auditListen(ctx, tx, id, auditDelete, before, nil)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, *change)
+	}
+	return changes, tx.Commit()
+}
+
+// Restore deleted listens that have not been purged, or if no IDs are given, the listens deleted most recently
+func RestoreListens(ctx context.Context, db *sql.DB, ids ...int64) ([]ListenChange, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	tables, err := trackLogTables(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		selects := make([]string, len(tables))
+		for i, table := range tables {
+			selects[i] = "SELECT id, deleted FROM " + table
+		}
+		rows, err := tx.QueryContext(
+			ctx,
+			`WITH l AS (`+strings.Join(selects, " UNION ALL ")+`)
+			SELECT id FROM l WHERE deleted = (SELECT MAX(deleted) FROM l) ORDER BY id`,
+		)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	var changes []ListenChange
+	for _, id := range ids {
+		table, listen, err := findListen(ctx, tx, id, true)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE "+table+" SET deleted = NULL WHERE id = ?", id); err != nil {
+			return nil, err
+		}
+		if table == "TrackLog" {
+			if err := assignSession(ctx, tx, nil, id, listen.Timestamp); err != nil {
+				return nil, err
+			}
+		}
+		change, err := auditListen(ctx, tx, id, auditRestore, nil, listen)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, *change)
+	}
+	return changes, tx.Commit()
+}
+
+// Permanently remove the listens deleted by the time, recording them in the audit log
+func PurgeListens(ctx context.Context, db *sql.DB, before time.Time) ([]ListenChange, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	tables, err := trackLogTables(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	var changes []ListenChange
+	for _, table := range tables {
+		ids, err := queryStrings(ctx, tx, "SELECT id FROM "+table+" WHERE deleted <= ? ORDER BY id", formatTimestamp(before))
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range ids {
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			_, listen, err := findListen(ctx, tx, id, true)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE id = ?", id); err != nil {
+				return nil, err
+			}
+			change, err := auditListen(ctx, tx, id, auditPurge, listen, nil)
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, *change)
+		}
+	}
+	return changes, tx.Commit()
+}
+
+func auditListen(ctx context.Context, tx *sql.Tx, play int64, action string, before, after *AuditedListen) (*ListenChange, error) {
+	change := &ListenChange{Play: play, Action: action, Changed: time.Now().UTC().Truncate(time.Second), Before: before, After: after}
+	var encoded [2]sql.NullString
+	for i, listen := range []*AuditedListen{before, after} {
+		if listen == nil {
+			continue
+		}
+		value, err := json.Marshal(listen)
+		if err != nil {
+			return nil, err
+		}
+		encoded[i] = sql.NullString{String: string(value), Valid: true}
+	}
+	err := tx.QueryRowContext(
+		ctx,
+		"INSERT INTO TrackLogAudit (play, action, changed, before, after) VALUES (?, ?, ?, ?, ?) RETURNING id",
+		play,
+		action,
+		formatTimestamp(change.Changed),
+		encoded[0],
+		encoded[1],
+	).Scan(&change.ID)
+	return change, err
+}
+
+// Get the most recent changes made to listens, newest first
+func GetListenChanges(ctx context.Context, db *sql.DB, limit int) ([]ListenChange, error) {
+	rows, err := db.QueryContext(
+		ctx,
+		"SELECT id, play, action, strftime('%Y-%m-%dT%H:%M:%SZ', changed), before, after FROM TrackLogAudit ORDER BY id DESC LIMIT ?",
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var changes []ListenChange
+	for rows.Next() {
+		var change ListenChange
+		var changed string
+		var encoded [2]sql.NullString
+		if err := rows.Scan(&change.ID, &change.Play, &change.Action, &changed, &encoded[0], &encoded[1]); err != nil {
+			return nil, err
+		}
+		if change.Changed, err = time.Parse(timestampLayout, changed); err != nil {
+			return nil, err
+		}
+		for i, listen := range []**AuditedListen{&change.Before, &change.After} {
+			if !encoded[i].Valid {
+				continue
+			}
+			*listen = new(AuditedListen)
+			if err := json.Unmarshal([]byte(encoded[i].String), *listen); err != nil {
+				return nil, err
+			}
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
@@ -0,0 +1,73 @@
+package music_watch
+
+import (
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Event types
+const (
+	EventNowPlaying   = "nowPlaying"   // A new track started playing
+	EventScrobble     = "scrobble"     // A track was stored in the history
+	EventPlayFinished = "playFinished" // A track stopped being the current track of its player
+	EventPaused       = "paused"       // A player paused its current track
+	EventResumed      = "resumed"      // A player resumed its current track
+)
+
+// Number of events buffered for each subscriber before events are dropped
+const subscriberBuffer = 16
+
+type Event struct {
+	Type  string    `json:"type"`
+	Time  time.Time `json:"time"`
+	Track *Metadata `json:"track"`
+	// For finished, paused and resumed plays, the time spent playing and the position, in microseconds
+	Played   int64 `json:"played,omitempty"`
+	Position int64 `json:"position,omitempty"`
+	// For plays stored during a guest session, the guest's name
+	Guest string `json:"guest,omitempty"`
+	// The span the event was reported in, so that handling it continues the trace
+	trace trace.SpanContext
+}
+
+// Distributes events to any number of subscribers
+type EventHub struct {
+	lock        sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{subscribers: make(map[chan Event]struct{})}
+}
+
+// Send the event to every subscriber.
+// Subscribers that are not keeping up miss the event rather than blocking the watcher.
+func (h *EventHub) Publish(e Event) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- e:
+		default:
+			recordDropped("Event subscriber is not keeping up", "Type", e.Type)
+		}
+	}
+}
+
+// Receive published events until the returned function is called
+func (h *EventHub) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+	h.lock.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.lock.Unlock()
+	return ch, func() {
+		h.lock.Lock()
+		defer h.lock.Unlock()
+		if _, ok := h.subscribers[ch]; ok {
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+)
+
+// A play as written by ExportPlays
+type ExportedPlay struct {
+	ID        int64    `json:"id"`
+	UID       string   `json:"uid,omitempty"` // A ULID, which identifies the play across databases, unlike its ID
+	Timestamp string   `json:"timestamp"`     // RFC 3339, in UTC
+	Title     string   `json:"title"`
+	Album     string   `json:"album,omitempty"`
+	Artists   []string `json:"artists,omitempty"`
+	Url       string   `json:"url,omitempty"`
+	TrackId   string   `json:"trackId,omitempty"`
+	Guest     string   `json:"guest,omitempty"`
+	Player    string   `json:"player,omitempty"` // See PlayerKey
+	// The host name of the machine the track was played on, and its device label
+	Host   string `json:"host,omitempty"`
+	Device string `json:"device,omitempty"`
+	// The track's position on its album, if it is known
+	TrackNumber int `json:"trackNumber,omitempty"`
+	DiscNumber  int `json:"discNumber,omitempty"`
+}
+
+// Write the plays with an ID after afterID as newline-delimited JSON, oldest first.
+// Returns the ID of the last play written, or afterID if there were none.
+func ExportPlays(ctx context.Context, db *sql.DB, afterID int64, w io.Writer) (int64, int, error) {
+	rows, err := db.QueryContext(
+		ctx,
+		`SELECT l.id, COALESCE(l.uid, ''), l.timestamp, COALESCE(t.title, ''), COALESCE(a.title, ''),
+			(
+				SELECT json_group_array(DISTINCT p.name)
+				FROM Track_Person tp JOIN Person p ON p.id = tp.person
+				WHERE tp.track = t.id
+			),
+			COALESCE(t.url, ''), COALESCE(t.trackId, ''), COALESCE(l.guest, ''), COALESCE(l.player, ''),
+			COALESCE(l.host, ''), COALESCE(l.device, ''), COALESCE(t.trackNumber, 0), COALESCE(t.discNumber, 0)
+		FROM TrackLogAll l
+		JOIN Track t ON t.id = l.track
+		LEFT JOIN Album a ON a.id = t.album
+		WHERE l.id > ?
+		ORDER BY l.id`,
+		afterID,
+	)
+	if err != nil {
+		return afterID, 0, err
+	}
+	defer rows.Close()
+	enc := json.NewEncoder(w)
+	last, count := afterID, 0
+	for rows.Next() {
+		var p ExportedPlay
+		var artists string
+		if err := rows.Scan(&p.ID, &p.UID, &p.Timestamp, &p.Title, &p.Album, &artists, &p.Url, &p.TrackId, &p.Guest, &p.Player, &p.Host, &p.Device, &p.TrackNumber, &p.DiscNumber); err != nil {
+			return last, count, err
+		}
+		if err := json.Unmarshal([]byte(artists), &p.Artists); err != nil {
+			return last, count, err
+		}
+		if err := enc.Encode(p); err != nil {
+			return last, count, err
+		}
+		last = p.ID
+		count++
+	}
+	return last, count, rows.Err()
+}
+
+// The columns written by ExportCSV
+var csvExportHeader = []string{"timestamp", "artist", "album", "title", "duration", "player"}
+
+// Write the user's plays between from and to as CSV with a header, oldest first; zero times are not filtered on.
+// Timestamps are RFC 3339 in UTC, artists are separated by commas, and the duration is the number of seconds
+// the track was played for, which is empty for plays stored before it was recorded.
+// Rows are written as they are read, so a long history is not held in memory. Returns the number of plays written.
+func ExportCSV(ctx context.Context, db *sql.DB, from, to time.Time, w io.Writer) (int, error) {
+	where, args := playConditions(ctx, from, to)
+	rows, err := db.QueryContext(
+		ctx,
+		`SELECT strftime('%Y-%m-%dT%H:%M:%SZ', l.timestamp),
+			COALESCE((
+				SELECT group_concat(p.name, ', ')
+				FROM Person p
+				WHERE p.id IN (SELECT tp.person FROM Track_Person tp WHERE tp.track = t.id AND COALESCE(tp.role, ?) IN (?, ?))
+			), ''),
+			COALESCE(a.title, ''), COALESCE(t.title, ''), l.playedMs, COALESCE(l.player, '')
+		FROM TrackLogAll l
+		JOIN Track t ON t.id = l.track
+		LEFT JOIN Album a ON a.id = t.album
+		WHERE `+where+`
+		ORDER BY l.timestamp, l.id`,
+		append([]any{roleArtist, roleArtist, roleFeature}, args...)...,
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	out := csv.NewWriter(w)
+	if err := out.Write(csvExportHeader); err != nil {
+		return 0, err
+	}
+	count := 0
+	for rows.Next() {
+		var timestamp, artists, album, title, player string
+		var playedMs sql.NullInt64
+		if err := rows.Scan(&timestamp, &artists, &album, &title, &playedMs, &player); err != nil {
+			return count, err
+		}
+		var duration string
+		if playedMs.Valid {
+			duration = strconv.FormatInt(playedMs.Int64/1000, 10)
+		}
+		if err := out.Write([]string{timestamp, artists, album, title, duration, player}); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+	out.Flush()
+	return count, out.Error()
+}
+
+// Get the ID of the last play exported under the cursor's name, or 0 if it has not been used
+func GetExportCursor(ctx context.Context, db *sql.DB, name string) (int64, error) {
+	var id int64
+	err := db.QueryRowContext(ctx, "SELECT lastId FROM ExportCursor WHERE name = ?", name).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return id, err
+}
+
+// Record that the plays up to lastID have been exported under the cursor's name
+func SetExportCursor(ctx context.Context, db *sql.DB, name string, lastID int64) error {
+	_, err := db.ExecContext(
+		ctx,
+		"INSERT INTO ExportCursor (name, lastId, updated) VALUES (?, ?, ?) ON CONFLICT (name) DO UPDATE SET lastId = excluded.lastId, updated = excluded.updated",
+		name,
+		lastID,
+		time.Now().Format(time.DateTime),
+	)
+	return err
+}
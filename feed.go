@@ -0,0 +1,136 @@
+package music_watch
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Cover art for a MusicBrainz release group
+const coverArtURL = "https://coverartarchive.org/release-group/%s/front-250"
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomPerson  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID      string       `xml:"id"`
+	Title   string       `xml:"title"`
+	Updated string       `xml:"updated"`
+	Authors []atomPerson `xml:"author"`
+	Links   []atomLink   `xml:"link"`
+	Content atomContent  `xml:"content"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// The address the request was made to, used for the feed's links
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func feedEntry(base string, l Listen) atomEntry {
+	title := l.Title
+	if len(l.Artists) > 0 {
+		title = strings.Join(l.Artists, ", ") + " – " + l.Title
+	}
+	entry := atomEntry{
+		ID:      fmt.Sprintf("%s/feed.atom#listen-%d", base, l.ID),
+		Title:   title,
+		Updated: l.Timestamp,
+		Links:   []atomLink{{Rel: "alternate", Href: base + "/"}},
+		Content: atomContent{Type: "html"},
+	}
+	for _, artist := range l.Artists {
+		entry.Authors = append(entry.Authors, atomPerson{Name: artist})
+	}
+	if len(l.Url) > 0 && (strings.HasPrefix(l.Url, "http://") || strings.HasPrefix(l.Url, "https://")) {
+		entry.Links[0].Href = l.Url
+	}
+	var content strings.Builder
+	fmt.Fprintf(&content, "<p><strong>%s</strong>", html.EscapeString(l.Title))
+	if len(l.Artists) > 0 {
+		fmt.Fprintf(&content, " by %s", html.EscapeString(strings.Join(l.Artists, ", ")))
+	}
+	if len(l.Album) > 0 {
+		fmt.Fprintf(&content, " from <em>%s</em>", html.EscapeString(l.Album))
+	}
+	content.WriteString("</p>")
+	cover := l.Cover
+	if len(cover) == 0 && len(l.ReleaseGroup) > 0 {
+		cover = fmt.Sprintf(coverArtURL, l.ReleaseGroup)
+	}
+	if len(cover) > 0 {
+		fmt.Fprintf(&content, `<p><img src="%s" alt="%s"/></p>`, html.EscapeString(cover), html.EscapeString(l.Album))
+		entry.Links = append(entry.Links, atomLink{Rel: "enclosure", Type: "image/jpeg", Href: cover})
+	}
+	entry.Content.Body = content.String()
+	return entry
+}
+
+// Serve the latest plays as an Atom feed. Guest plays are left out, as in the history API.
+func serveFeed(w http.ResponseWriter, r *http.Request, c *Controller) {
+	limit, _, err := parseAPIPage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	listens, _, err := c.history.QueryListens(r.Context(), ListenQuery{Artist: r.URL.Query().Get("artist"), Limit: limit})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Unable to build feed", "Error", err)
+		http.Error(w, "unable to read the history", http.StatusInternalServerError)
+		return
+	}
+	base := requestBaseURL(r)
+	feed := atomFeed{
+		ID:    base + "/feed.atom",
+		Title: "Recently played",
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: base + r.URL.RequestURI()},
+			{Rel: "alternate", Type: "text/html", Href: base + "/"},
+		},
+		Author: atomPerson{Name: "music-watcher"},
+	}
+	// Feeds must have an update time even when there is nothing in them
+	feed.Updated = c.started.Format(time.RFC3339)
+	if len(listens) > 0 {
+		feed.Updated = listens[0].Timestamp
+	}
+	for _, l := range listens {
+		feed.Entries = append(feed.Entries, feedEntry(base, l))
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		slog.DebugContext(r.Context(), "Unable to write feed", "Error", err)
+	}
+}
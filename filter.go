@@ -0,0 +1,98 @@
+package music_watch
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Returned by the store callback for plays that the settings leave out of the history.
+// Like duplicate plays, they are not an error and are not sent to sinks.
+var ErrFiltered = errors.New("play was filtered out")
+
+var ErrInvalidFilter = errors.New("invalid filter")
+
+func filtered(reason string) error {
+	return fmt.Errorf("%w: %s", ErrFiltered, reason)
+}
+
+// Settings for leaving plays that are not music out of the history
+type FilterConfig struct {
+	// Patterns of URLs not to log, matched without case, where * matches anything, including slashes,
+	// and ? matches one character: "*.mp4", "*youtube.com/shorts/*", "*soundcloud.com/*/comments"
+	ExcludeUrls []string `json:"excludeUrls"`
+	// Tracks shorter than this many seconds, such as sounds and previews, are not logged; 0 disables
+	MinSeconds int `json:"minSeconds"`
+	// Tracks longer than this many minutes, such as mixes and audiobooks, are not logged; 0 disables
+	MaxMinutes int `json:"maxMinutes"`
+}
+
+// Decides which plays are left out of the history
+type Filter struct {
+	urls      []*regexp.Regexp
+	minLength time.Duration
+	maxLength time.Duration
+}
+
+// Convert a URL pattern to a regular expression matching the whole URL
+func urlPattern(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("(?i)^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+func NewFilter(config FilterConfig) (*Filter, error) {
+	if config.MinSeconds < 0 || config.MaxMinutes < 0 {
+		return nil, fmt.Errorf("%w: lengths must not be negative", ErrInvalidFilter)
+	}
+	f := Filter{
+		minLength: time.Duration(config.MinSeconds) * time.Second,
+		maxLength: time.Duration(config.MaxMinutes) * time.Minute,
+	}
+	if f.maxLength > 0 && f.minLength > f.maxLength {
+		return nil, fmt.Errorf("%w: the minimum length is longer than the maximum", ErrInvalidFilter)
+	}
+	for _, pattern := range config.ExcludeUrls {
+		if len(strings.TrimSpace(pattern)) == 0 {
+			return nil, fmt.Errorf("%w: empty URL pattern", ErrInvalidFilter)
+		}
+		re, err := urlPattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %w", ErrInvalidFilter, pattern, err)
+		}
+		f.urls = append(f.urls, re)
+	}
+	return &f, nil
+}
+
+// Get ErrFiltered if the track should not be logged, or nil if it should
+func (f *Filter) Check(m *Metadata) error {
+	for _, re := range f.urls {
+		if len(m.Url) > 0 && re.MatchString(m.Url) {
+			return filtered("URL is excluded")
+		}
+	}
+	// Tracks whose length the player does not give, such as streams, are always logged
+	if length := time.Duration(m.Length) * time.Microsecond; length > 0 {
+		if length < f.minLength {
+			return filtered("track is too short")
+		}
+		if f.maxLength > 0 && length > f.maxLength {
+			return filtered("track is too long")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package music_watch
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	dbus "github.com/godbus/dbus/v5"
+)
+
+// How long to wait before reconnecting to a followed instance
+const followRetryInterval = 10 * time.Second
+
+// Read the event stream of another instance, calling handler for each event,
+// until the context is cancelled. The connection is retried if it is lost.
+func Follow(ctx context.Context, url string, handler func(Event)) error {
+	for {
+		err := followStream(ctx, url, handler)
+		if ctx.Err() != nil {
+			return nil
+		}
+		slog.ErrorContext(ctx, "Lost connection to followed instance, retrying", "Url", url, "Error", err)
+		select {
+		case <-time.After(followRetryInterval):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func followStream(ctx context.Context, url string, handler func(Event)) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	slog.InfoContext(ctx, "Following instance", "Url", url)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			slog.WarnContext(ctx, "Received invalid event", "Error", err)
+			continue
+		}
+		if e.Track != nil {
+			handler(e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("event stream ended")
+}
+
+// Show a desktop notification for the track.
+// replaces is the ID of a previous notification to replace, and the new ID is returned.
+func NotifyTrack(conn *dbus.Conn, summary string, track *Metadata, replaces uint32) (uint32, error) {
+	body := track.Title
+	if len(track.Artist) > 0 {
+		body = fmt.Sprintf("%s — %s", strings.Join(track.Artist, ", "), track.Title)
+	}
+	if len(track.Album) > 0 {
+		body = fmt.Sprintf("%s (%s)", body, track.Album)
+	}
+	var id uint32
+	err := conn.Object(notificationsName, notificationsPath).Call(
+		notificationsName+".Notify",
+		0,
+		"music-watcher",
+		replaces,
+		"audio-x-generic",
+		summary,
+		body,
+		[]string{},
+		map[string]dbus.Variant{},
+		int32(-1),
+	).Store(&id)
+	return id, err
+}
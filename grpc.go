@@ -0,0 +1,198 @@
+package music_watch
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/inventor500/music-watcher/rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var grpcEventTypes = map[string]rpc.Event_Type{
+	EventNowPlaying:   rpc.Event_TYPE_NOW_PLAYING,
+	EventScrobble:     rpc.Event_TYPE_SCROBBLE,
+	EventPlayFinished: rpc.Event_TYPE_PLAY_FINISHED,
+	EventPaused:       rpc.Event_TYPE_PAUSED,
+	EventResumed:      rpc.Event_TYPE_RESUMED,
+}
+
+var grpcTopKinds = map[rpc.ListTopItemsRequest_Kind]string{
+	rpc.ListTopItemsRequest_KIND_ARTISTS: "artists",
+	rpc.ListTopItemsRequest_KIND_ALBUMS:  "albums",
+	rpc.ListTopItemsRequest_KIND_TRACKS:  "tracks",
+	rpc.ListTopItemsRequest_KIND_GENRES:  "genres",
+}
+
+// Answers the gRPC API described in rpc/watcher.proto
+type grpcServer struct {
+	rpc.UnimplementedMusicWatcherServer
+	c *Controller
+}
+
+// Serve the gRPC API on addr, a host:port or unix:<path>, until the context is cancelled
+func ServeGRPC(ctx context.Context, addr string, c *Controller) error {
+	network := "tcp"
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		network, addr = "unix", path
+		// Left behind if the watcher did not stop cleanly
+		os.Remove(path)
+	}
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	server := grpc.NewServer()
+	rpc.RegisterMusicWatcherServer(server, &grpcServer{c: c})
+	stop := context.AfterFunc(ctx, func() {
+		// Event streams only end when their clients leave, so they are not waited for
+		timer := time.AfterFunc(5*time.Second, server.Stop)
+		server.GracefulStop()
+		timer.Stop()
+	})
+	defer stop()
+	slog.InfoContext(ctx, "Starting gRPC server", "Address", addr)
+	if err := server.Serve(listener); !errors.Is(err, grpc.ErrServerStopped) {
+		return err
+	}
+	return nil
+}
+
+func grpcTrack(m *Metadata) *rpc.Track {
+	if m == nil {
+		return nil
+	}
+	return &rpc.Track{
+		Title:        m.Title,
+		Artists:      m.Artist,
+		Album:        m.Album,
+		AlbumArtists: m.AlbumArtist,
+		Composers:    m.Composer,
+		Url:          m.Url,
+		TrackId:      m.TrackId,
+		Player:       m.Player,
+		LengthUs:     m.Length,
+	}
+}
+
+func grpcTime(t *timestamppb.Timestamp) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return t.AsTime()
+}
+
+// Get the page size of a request, applying the same defaults and limits as the HTTP API
+func grpcPage(limit, offset int32) (int, int, error) {
+	if limit < 0 || offset < 0 {
+		return 0, 0, status.Error(codes.InvalidArgument, "limit and offset must not be negative")
+	}
+	if limit == 0 {
+		limit = defaultAPILimit
+	}
+	return min(int(limit), maxAPILimit), int(offset), nil
+}
+
+func (s *grpcServer) GetStatus(ctx context.Context, _ *rpc.GetStatusRequest) (*rpc.Status, error) {
+	s.c.lock.Lock()
+	defer s.c.lock.Unlock()
+	return &rpc.Status{
+		Logging:    !s.c.paused && len(s.c.inhibitors) == 0,
+		Private:    s.c.paused,
+		Inhibitors: s.c.inhibitorList(),
+		Guest:      s.c.guest,
+		Scrobbles:  s.c.scrobbles,
+		Started:    timestamppb.New(s.c.started),
+		NowPlaying: grpcTrack(s.c.nowPlaying),
+	}, nil
+}
+
+func (s *grpcServer) WatchEvents(req *rpc.WatchEventsRequest, stream grpc.ServerStreamingServer[rpc.Event]) error {
+	ctx := stream.Context()
+	events, unsubscribe := s.c.Events.Subscribe()
+	defer unsubscribe()
+	send := func(e Event) error {
+		if req.ShareableOnly && !s.c.Shareable(ctx, e) {
+			return nil
+		}
+		return stream.Send(&rpc.Event{
+			Type:       grpcEventTypes[e.Type],
+			Time:       timestamppb.New(e.Time),
+			Track:      grpcTrack(e.Track),
+			PlayedUs:   e.Played,
+			PositionUs: e.Position,
+			Guest:      e.Guest,
+		})
+	}
+	if current := s.c.NowPlaying(); current != nil {
+		if err := send(Event{Type: EventNowPlaying, Time: time.Now(), Track: current}); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := send(e); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (s *grpcServer) ListListens(ctx context.Context, req *rpc.ListListensRequest) (*rpc.ListListensResponse, error) {
+	limit, offset, err := grpcPage(req.Limit, req.Offset)
+	if err != nil {
+		return nil, err
+	}
+	listens, total, err := s.c.history.QueryListens(ctx, ListenQuery{
+		From:   grpcTime(req.From),
+		To:     grpcTime(req.To),
+		Artist: req.Artist,
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		return nil, err
+	}
+	response := rpc.ListListensResponse{Total: int64(total)}
+	for _, l := range listens {
+		listen := rpc.Listen{Id: l.ID, Title: l.Title, Album: l.Album, Artists: l.Artists, Url: l.Url}
+		if t, err := time.Parse(time.RFC3339, l.Timestamp); err == nil {
+			listen.Time = timestamppb.New(t)
+		}
+		response.Listens = append(response.Listens, &listen)
+	}
+	return &response, nil
+}
+
+func (s *grpcServer) ListTopItems(ctx context.Context, req *rpc.ListTopItemsRequest) (*rpc.ListTopItemsResponse, error) {
+	kind, ok := grpcTopKinds[req.Kind]
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "kind must be artists, albums or tracks")
+	}
+	limit, offset, err := grpcPage(req.Limit, req.Offset)
+	if err != nil {
+		return nil, err
+	}
+	items, err := s.c.history.GetTopItems(ctx, kind, grpcTime(req.From), grpcTime(req.To), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	var response rpc.ListTopItemsResponse
+	for _, item := range items {
+		response.Items = append(response.Items, &rpc.TopItem{Name: item.Name, Plays: int64(item.Plays)})
+	}
+	return &response, nil
+}
@@ -0,0 +1,130 @@
+package music_watch
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"text/template"
+	"time"
+)
+
+var ErrInvalidHook = errors.New("invalid hook")
+
+// The header carrying the signature of the request body, when a hook has a secret
+const hookSignatureHeader = "X-Music-Watcher-Signature"
+
+// Settings for posting plays to an arbitrary URL, such as an automation service
+type HookConfig struct {
+	URL string `json:"url"`
+	// A text/template for the request body, executed with the Event.
+	// The json function encodes a value as JSON. Empty posts the Event as JSON.
+	Template string `json:"template"`
+	// Extra request headers, such as Authorization; may override Content-Type
+	Headers map[string]string `json:"headers"`
+	// If set, the body is signed with HMAC-SHA256 and the hex digest is sent as
+	// "sha256=<digest>" in the X-Music-Watcher-Signature header
+	Secret string `json:"secret"`
+	// Also post an event whenever a new track starts playing
+	NowPlaying bool `json:"nowPlaying"`
+}
+
+// Posts each stored play to a configured URL
+type Hook struct {
+	Config HookConfig
+	Client *http.Client
+
+	template *template.Template
+}
+
+// Parse the hook's template, so that mistakes are reported at startup
+func NewHook(config HookConfig) (*Hook, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("%w: %q is not an HTTP URL", ErrInvalidHook, config.URL)
+	}
+	h := Hook{Config: config}
+	if len(config.Template) > 0 {
+		h.template, err = template.New(u.Host).Funcs(template.FuncMap{"json": hookJSON}).Parse(config.Template)
+		if err != nil {
+			return nil, errors.Join(ErrInvalidHook, err)
+		}
+	}
+	return &h, nil
+}
+
+func hookJSON(v any) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+// Get a sink that posts plays as they are stored
+func (h *Hook) Sink() Sink {
+	name := "hook"
+	if u, err := url.Parse(h.Config.URL); err == nil {
+		// Leave out the query, which may hold a token
+		name += ":" + u.Host + u.Path
+	}
+	return newScrobbleSink(name, h, 1)
+}
+
+func (h *Hook) nowPlaying(ctx context.Context, track *Metadata) error {
+	if !h.Config.NowPlaying {
+		return nil
+	}
+	return h.post(ctx, Event{Type: EventNowPlaying, Time: time.Now(), Track: track})
+}
+
+func (h *Hook) submit(ctx context.Context, plays []Event) error {
+	for _, play := range plays {
+		if err := h.post(ctx, play); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Hook) post(ctx context.Context, e Event) error {
+	var body bytes.Buffer
+	if h.template != nil {
+		if err := h.template.Execute(&body, e); err != nil {
+			// The same template would fail again
+			return &scrobbleError{err: errors.Join(ErrInvalidHook, err), permanent: true}
+		}
+	} else if err := json.NewEncoder(&body).Encode(e); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.Config.URL, bytes.NewReader(body.Bytes()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "music-watcher")
+	for name, value := range h.Config.Headers {
+		req.Header.Set(name, value)
+	}
+	if len(h.Config.Secret) > 0 {
+		mac := hmac.New(sha256.New, []byte(h.Config.Secret))
+		mac.Write(body.Bytes())
+		req.Header.Set(hookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	client := h.Client
+	if client == nil {
+		client = scrobbleClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return httpStatusError(req.URL.Host, resp)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package music_watch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Settings for the HTTP interface
+type HTTPConfig struct {
+	// Websites allowed to open the live feed from a browser, such as https://example.com;
+	// "*" allows any. Pages served from the same host are always allowed.
+	Origins []string `json:"origins"`
+	// The token other watchers send to sync plays with this one, see SyncPlays; the sync API is disabled without one
+	SyncToken string `json:"syncToken"`
+}
+
+// Build the HTTP interface of the daemon
+func NewHTTPHandler(c *Controller, config HTTPConfig) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /events", func(w http.ResponseWriter, r *http.Request) {
+		serveEvents(w, r, c)
+	})
+	mux.HandleFunc("GET /live", func(w http.ResponseWriter, r *http.Request) {
+		serveLive(w, r, c, config)
+	})
+	mux.HandleFunc("GET /feed.atom", func(w http.ResponseWriter, r *http.Request) {
+		serveFeed(w, r, c)
+	})
+	registerAPI(mux, c)
+	if len(config.SyncToken) > 0 {
+		registerSyncAPI(mux, c.db, config.SyncToken)
+	}
+	registerDashboard(mux)
+	return mux
+}
+
+// Serve the handler on addr until the context is cancelled
+func ServeHTTP(ctx context.Context, addr string, handler http.Handler) error {
+	server := http.Server{
+		Addr:        addr,
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	stop := context.AfterFunc(ctx, func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	})
+	defer stop()
+	slog.InfoContext(ctx, "Starting HTTP server", "Address", addr)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Stream events that can be shared as newline-delimited JSON, starting with the current track
+func serveEvents(w http.ResponseWriter, r *http.Request, c *Controller) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	events, unsubscribe := c.Events.Subscribe()
+	defer unsubscribe()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	if current := c.NowPlaying(); current != nil {
+		e := Event{Type: EventNowPlaying, Time: time.Now(), Track: current}
+		if c.Shareable(r.Context(), e) {
+			if err := enc.Encode(e); err != nil {
+				return
+			}
+		}
+	}
+	flusher.Flush()
+	slog.DebugContext(r.Context(), "Event stream opened", "Remote", r.RemoteAddr)
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if !c.Shareable(r.Context(), e) {
+				continue
+			}
+			if err := enc.Encode(e); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			slog.DebugContext(r.Context(), "Event stream closed", "Remote", r.RemoteAddr)
+			return
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package music_watch
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"strconv"
+	"sync"
+)
+
+// The most IDs kept by the cache, enough for the tracks, albums, persons and genres of a large library
+const idCacheSize = 8192
+
+// What a cached ID is found by: the table, and the names the row is looked up by
+type idKey struct {
+	table string
+	name  string
+}
+
+type idEntry struct {
+	key idKey
+	id  int64
+}
+
+// The IDs of rows by their names, so that storing a play of a known track does not look them up.
+// The least recently used IDs are dropped once the cache is full.
+type idCache struct {
+	lock    sync.Mutex
+	size    int
+	order   *list.List // Of idEntry, most recently used first
+	entries map[idKey]*list.Element
+}
+
+func newIDCache(size int) *idCache {
+	return &idCache{size: size, order: list.New(), entries: make(map[idKey]*list.Element)}
+}
+
+func (c *idCache) get(key idKey) (int64, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return 0, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(idEntry).id, true
+}
+
+func (c *idCache) add(key idKey, id int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if e, ok := c.entries[key]; ok {
+		e.Value = idEntry{key, id}
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.order.PushFront(idEntry{key, id})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(idEntry).key)
+	}
+}
+
+// Forget every ID, such as when rows may have been merged or deleted by another connection
+func (c *idCache) clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.order.Init()
+	clear(c.entries)
+}
+
+// The state of the write path for a transaction or a savepoint within it: the IDs it found or created,
+// which are only cached once it commits since the ID of a row that was rolled back may be given to another,
+// and the prepared statements. A nil batch caches and prepares nothing, for callers without them.
+type writeBatch struct {
+	cache  *idCache
+	stmts  *statements
+	parent *writeBatch
+	staged map[idKey]int64
+}
+
+// Start a batch for a transaction
+func newWriteBatch(cache *idCache, stmts *statements) *writeBatch {
+	return &writeBatch{cache: cache, stmts: stmts, staged: make(map[idKey]int64)}
+}
+
+// Start a batch for a savepoint within the batch's transaction
+func (b *writeBatch) begin() *writeBatch {
+	if b == nil {
+		return nil
+	}
+	return &writeBatch{cache: b.cache, stmts: b.stmts, parent: b, staged: make(map[idKey]int64)}
+}
+
+func (b *writeBatch) get(key idKey) (int64, bool) {
+	if b == nil {
+		return 0, false
+	}
+	for batch := b; batch != nil; batch = batch.parent {
+		if id, ok := batch.staged[key]; ok {
+			return id, true
+		}
+	}
+	return b.cache.get(key)
+}
+
+func (b *writeBatch) add(key idKey, id int64) {
+	if b != nil {
+		b.staged[key] = id
+	}
+}
+
+// Keep the batch's IDs once its transaction or savepoint has committed
+func (b *writeBatch) commit() {
+	if b == nil {
+		return
+	}
+	for key, id := range b.staged {
+		if b.parent != nil {
+			b.parent.staged[key] = id
+		} else {
+			b.cache.add(key, id)
+		}
+	}
+}
+
+// Run the statement in the transaction, using the prepared statement if the batch has them
+func (b *writeBatch) exec(ctx context.Context, tx *sql.Tx, query string, args ...any) (sql.Result, error) {
+	if b == nil {
+		return tx.ExecContext(ctx, query, args...)
+	}
+	stmt, err := b.stmts.prepare(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return tx.StmtContext(ctx, stmt).ExecContext(ctx, args...)
+}
+
+// Query a row in the transaction, using the prepared statement if the batch has them
+func (b *writeBatch) queryRow(ctx context.Context, tx *sql.Tx, query string, args ...any) *sql.Row {
+	if b == nil {
+		return tx.QueryRowContext(ctx, query, args...)
+	}
+	stmt, err := b.stmts.prepare(ctx, query)
+	if err != nil {
+		// Running it unprepared reports the error from the row
+		return tx.QueryRowContext(ctx, query, args...)
+	}
+	return tx.StmtContext(ctx, stmt).QueryRowContext(ctx, args...)
+}
+
+// The key of a track's relation to a genre, which is cached once it is known to exist
+func trackGenreKey(track int64, genre string) idKey {
+	return idKey{"Track_Genre", strconv.FormatInt(track, 10) + "\x00" + genre}
+}
@@ -0,0 +1,313 @@
+package music_watch
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Settings for appending each play to a file as a line of JSON, for reading with grep or other programs
+type JSONLConfig struct {
+	// The file plays are appended to; empty disables
+	Path string `json:"path"`
+	// Move the file aside once it is larger than this many megabytes; 0 never does.
+	// Full files are named after the file and the time they were moved, such as listens-20261017T054000.jsonl.
+	MaxMegabytes int `json:"maxMegabytes"`
+	// The number of full files to keep, deleting the oldest; 0 keeps them all
+	Keep int `json:"keep"`
+}
+
+// A line of the file: a play as written by ExportPlays, with the track's genres
+type jsonlPlay struct {
+	ExportedPlay
+	Genres []string `json:"genres,omitempty"`
+}
+
+// Appends plays to a file of newline-delimited JSON. Plays are numbered in order across the full files.
+type JSONLStore struct {
+	Config JSONLConfig
+
+	lock   sync.Mutex
+	file   *os.File
+	size   int64
+	lastID int64
+	// When each track was last played, by trackKey, for finding duplicate plays
+	lastPlayed map[string]time.Time
+}
+
+// Open the file for appending, creating it and its directory if they do not exist
+func NewJSONLStore(config JSONLConfig) (*JSONLStore, error) {
+	if err := os.MkdirAll(filepath.Dir(config.Path), 0750); err != nil {
+		return nil, err
+	}
+	s := JSONLStore{Config: config, lastPlayed: make(map[string]time.Time)}
+	err := s.each(func(p *jsonlPlay) {
+		s.lastID = max(s.lastID, p.ID)
+		if t, err := time.Parse(time.RFC3339, p.Timestamp); err == nil {
+			s.lastPlayed[trackKey(p.Url, p.Title)] = t
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Tracks are identified by their URL and title, as in the database
+func trackKey(url, title string) string {
+	return url + "\x00" + title
+}
+
+func (s *JSONLStore) open() error {
+	f, err := os.OpenFile(s.Config.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file, s.size = f, info.Size()
+	return nil
+}
+
+// Get the paths of the full files, oldest first
+func (s *JSONLStore) fullFiles() ([]string, error) {
+	ext := filepath.Ext(s.Config.Path)
+	paths, err := filepath.Glob(strings.TrimSuffix(s.Config.Path, ext) + "-*" + ext)
+	if err != nil {
+		return nil, err
+	}
+	// The times in the names sort in order
+	slices.Sort(paths)
+	return paths, nil
+}
+
+// Call f with each play in the files, oldest first
+func (s *JSONLStore) each(f func(p *jsonlPlay)) error {
+	paths, err := s.fullFiles()
+	if err != nil {
+		return err
+	}
+	for _, path := range append(paths, s.Config.Path) {
+		file, err := os.Open(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(nil, 1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			var p jsonlPlay
+			if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
+				file.Close()
+				return fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+			f(&p)
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Move the file aside and start a new one, deleting the oldest full files beyond the number to keep
+func (s *JSONLStore) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	ext := filepath.Ext(s.Config.Path)
+	var full string
+	// Files filled within a second of each other are named a second apart, keeping them in order
+	for t := time.Now(); ; t = t.Add(time.Second) {
+		full = fmt.Sprintf("%s-%s%s", strings.TrimSuffix(s.Config.Path, ext), t.Format("20060102T150405"), ext)
+		if _, err := os.Stat(full); errors.Is(err, fs.ErrNotExist) {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(s.Config.Path, full); err != nil {
+		return err
+	}
+	if err := s.open(); err != nil {
+		return err
+	}
+	if s.Config.Keep <= 0 {
+		return nil
+	}
+	paths, err := s.fullFiles()
+	if err != nil {
+		return err
+	}
+	for len(paths) > s.Config.Keep {
+		if err := os.Remove(paths[0]); err != nil {
+			return err
+		}
+		paths = paths[1:]
+	}
+	return nil
+}
+
+func (s *JSONLStore) StoreListen(ctx context.Context, m *Metadata) error {
+	if len(m.Title) == 0 && len(m.Url) == 0 {
+		return nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	played := playedAt(ctx)
+	key := trackKey(m.Url, m.Title)
+	window := max(time.Duration(m.Length)*time.Microsecond-duplicatePlaySlack, duplicatePlaySlack)
+	if last, ok := s.lastPlayed[key]; ok && last.After(played.Add(-window)) && !last.After(played) {
+		return ErrDuplicatePlay
+	}
+	var artists []string
+	seen := make(artistSet)
+	for _, set := range [][]string{m.AlbumArtist, m.Artist, m.Featured, m.Composer} {
+		for _, name := range set {
+			if _, ok := seen[name]; !ok && len(name) > 0 {
+				seen[name] = struct{}{}
+				artists = append(artists, name)
+			}
+		}
+	}
+	p := jsonlPlay{
+		ExportedPlay: ExportedPlay{
+			ID:        s.lastID + 1,
+			Timestamp: played.Format(time.RFC3339),
+			Title:     m.Title,
+			Album:     m.Album,
+			Artists:   artists,
+			Url:       m.Url,
+			TrackId:   m.TrackId,
+			Guest:     guestSession(ctx),
+			Player:    PlayerKey(m.Player),
+			Host:      deviceOf(ctx).host,
+			Device:    deviceOf(ctx).label,
+		},
+		Genres: m.Genre,
+	}
+	line, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	// One write per line, so that programs following the file never see part of one
+	n, err := s.file.Write(append(line, '\n'))
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	s.lastID = p.ID
+	s.lastPlayed[key] = played
+	if s.Config.MaxMegabytes > 0 && s.size > int64(s.Config.MaxMegabytes)*1024*1024 {
+		return s.rotate()
+	}
+	return nil
+}
+
+// The file has no IDs for tracks
+func (s *JSONLStore) GetOrCreateTrack(ctx context.Context, m *Metadata) (int64, error) {
+	return 0, fmt.Errorf("%w: the JSONL store has no track IDs", errors.ErrUnsupported)
+}
+
+// Call f with each of the user's own plays between from and to, oldest first
+func (s *JSONLStore) eachBetween(from, to time.Time, f func(p *jsonlPlay)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.each(func(p *jsonlPlay) {
+		if len(p.Guest) > 0 {
+			return
+		}
+		t, err := time.Parse(time.RFC3339, p.Timestamp)
+		if err != nil || (!from.IsZero() && t.Before(from)) || (!to.IsZero() && !t.Before(to)) {
+			return
+		}
+		f(p)
+	})
+}
+
+// Read every file for the plays matching the query
+func (s *JSONLStore) QueryListens(ctx context.Context, q ListenQuery) ([]Listen, int, error) {
+	var listens []Listen
+	err := s.eachBetween(q.From, q.To, func(p *jsonlPlay) {
+		if len(q.Artist) > 0 && !slices.Contains(p.Artists, q.Artist) {
+			return
+		}
+		listens = append(listens, Listen{
+			ID: p.ID, Timestamp: p.Timestamp, Title: p.Title, Album: p.Album, Artists: p.Artists, Url: p.Url,
+		})
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	slices.Reverse(listens)
+	total := len(listens)
+	page := listens[min(q.Offset, total):min(q.Offset+q.Limit, total)]
+	return append([]Listen{}, page...), total, nil
+}
+
+// Read every file to count the plays of each item
+func (s *JSONLStore) GetTopItems(ctx context.Context, kind string, from, to time.Time, limit, offset int) ([]TopItem, error) {
+	var names func(p *jsonlPlay) []string
+	switch kind {
+	case "artists":
+		names = func(p *jsonlPlay) []string { return p.Artists }
+	case "albums":
+		names = func(p *jsonlPlay) []string { return []string{p.Album} }
+	case "tracks":
+		names = func(p *jsonlPlay) []string { return []string{p.Title} }
+	case "genres":
+		names = func(p *jsonlPlay) []string { return p.Genres }
+	case "players":
+		names = func(p *jsonlPlay) []string { return []string{p.Player} }
+	case "devices":
+		names = func(p *jsonlPlay) []string { return []string{cmp.Or(p.Device, p.Host)} }
+	default:
+		return nil, fmt.Errorf("%w: unknown kind %q", ErrInvalidQuery, kind)
+	}
+	plays := make(map[string]int)
+	err := s.eachBetween(from, to, func(p *jsonlPlay) {
+		for _, name := range names(p) {
+			if len(name) > 0 {
+				plays[name]++
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	items := []TopItem{}
+	for name, count := range plays {
+		items = append(items, TopItem{Name: name, Plays: count})
+	}
+	slices.SortFunc(items, func(a, b TopItem) int {
+		return cmp.Or(cmp.Compare(b.Plays, a.Plays), cmp.Compare(a.Name, b.Name))
+	})
+	return append([]TopItem{}, items[min(offset, len(items)):min(offset+limit, len(items))]...), nil
+}
+
+func (s *JSONLStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.file.Close()
+}
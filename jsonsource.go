@@ -0,0 +1,89 @@
+package music_watch
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// The player name reported for tracks without one read from JSON input
+const jsonPlayerName = "json"
+
+// Reads newline-delimited JSON Metadata objects from stdin or a named pipe
+type JSONSource struct {
+	// The file to read, or "-" for stdin.
+	// A named pipe is reopened whenever its writer closes it.
+	Path string
+}
+
+func (s *JSONSource) Run(ctx context.Context, events chan<- Event) error {
+	lines := make(chan []byte)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.read(lines)
+	}()
+	slog.InfoContext(ctx, "Reading tracks from JSON input", "Path", s.Path)
+	for {
+		select {
+		case line := <-lines:
+			var m Metadata
+			if err := json.Unmarshal(line, &m); err != nil {
+				slog.WarnContext(ctx, "Received invalid JSON track", "Error", err)
+				recordDropped("Invalid JSON track", "Error", err)
+				continue
+			}
+			if len(m.Player) == 0 {
+				m.Player = jsonPlayerName
+			}
+			m.Clean()
+			if m.Cleared() {
+				slog.DebugContext(ctx, "Received JSON track with no title or URL")
+				continue
+			}
+			if err := sendTrack(ctx, events, &m); err != nil {
+				return err
+			}
+		case err := <-errChan:
+			return err
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Send each non-empty line of the input until it ends
+func (s *JSONSource) read(lines chan<- []byte) error {
+	if s.Path == "-" {
+		return readLines(os.Stdin, lines)
+	}
+	for {
+		// Opening a named pipe blocks until a writer opens it
+		f, err := os.Open(s.Path)
+		if err != nil {
+			return err
+		}
+		err = readLines(f, lines)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		if stat, err := os.Stat(s.Path); err != nil || stat.Mode()&os.ModeNamedPipe == 0 {
+			// A regular file has been read completely
+			return err
+		}
+	}
+}
+
+func readLines(r io.Reader, lines chan<- []byte) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		lines <- append([]byte{}, scanner.Bytes()...)
+	}
+	return scanner.Err()
+}
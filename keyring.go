@@ -0,0 +1,73 @@
+package music_watch
+
+import (
+	"errors"
+	"fmt"
+
+	dbus "github.com/godbus/dbus/v5"
+)
+
+var (
+	ErrSecretNotFound = errors.New("secret not found in keyring")
+	ErrNoSecret       = errors.New("no secret or keyring entry is set")
+)
+
+const (
+	secretsName = "org.freedesktop.secrets"
+	secretsPath = "/org/freedesktop/secrets"
+)
+
+// A secret as returned by the Secret Service API
+type secretServiceSecret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// Get a secret that is either given in the configuration file, or by the attributes of its entry in the keyring.
+// conn may be nil if the keyring is not used.
+func configSecret(conn *dbus.Conn, secret string, keyring map[string]string) (string, error) {
+	switch {
+	case len(secret) > 0:
+		return secret, nil
+	case len(keyring) == 0:
+		return "", ErrNoSecret
+	case conn == nil:
+		return "", fmt.Errorf("%w: the keyring needs the session bus", ErrSecretNotFound)
+	}
+	return LookupSecret(conn, keyring)
+}
+
+// Read a secret from the desktop keyring through the Secret Service API,
+// finding it by its attributes, as with `secret-tool lookup`.
+// The keyring must already be unlocked.
+func LookupSecret(conn *dbus.Conn, attributes map[string]string) (string, error) {
+	service := conn.Object(secretsName, secretsPath)
+	var unlocked, locked []dbus.ObjectPath
+	if err := service.Call("org.freedesktop.Secret.Service.SearchItems", 0, attributes).Store(&unlocked, &locked); err != nil {
+		return "", err
+	}
+	if len(unlocked) == 0 {
+		if len(locked) > 0 {
+			return "", fmt.Errorf("%w: the keyring is locked", ErrSecretNotFound)
+		}
+		return "", ErrSecretNotFound
+	}
+	// Secrets are sent unencrypted, as they are over the session bus anyway
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	if err := service.Call("org.freedesktop.Secret.Service.OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&output, &session); err != nil {
+		return "", err
+	}
+	defer conn.Object(secretsName, session).Call("org.freedesktop.Secret.Session.Close", 0)
+	var secrets map[dbus.ObjectPath]secretServiceSecret
+	if err := service.Call("org.freedesktop.Secret.Service.GetSecrets", 0, unlocked[:1], session).Store(&secrets); err != nil {
+		return "", err
+	}
+	secret, ok := secrets[unlocked[0]]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return string(secret.Value), nil
+}
@@ -0,0 +1,194 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"unicode"
+)
+
+// Settings for detecting the language of tracks
+type LanguageConfig struct {
+	// Detect the language of new tracks from their lyrics, or their title and album
+	Detect bool `json:"detect"`
+}
+
+// Scripts that are mostly used by a single language
+var scriptLanguages = []struct {
+	table    *unicode.RangeTable
+	language string
+}{
+	{unicode.Hangul, "ko"},
+	{unicode.Greek, "el"},
+	{unicode.Hebrew, "he"},
+	{unicode.Arabic, "ar"},
+	{unicode.Thai, "th"},
+	{unicode.Devanagari, "hi"},
+	{unicode.Bengali, "bn"},
+	{unicode.Tamil, "ta"},
+	{unicode.Georgian, "ka"},
+	{unicode.Armenian, "hy"},
+}
+
+// Common short words, which are enough to tell apart languages sharing a script.
+// Words used by several of the languages count towards each of them.
+var stopwords = map[string][]string{
+	"en": {"the", "and", "you", "is", "of", "to", "my", "me", "in", "it", "that", "your", "with", "be", "all", "on", "love", "what", "this", "don't", "i'm"},
+	"es": {"el", "los", "las", "y", "que", "de", "mi", "tu", "yo", "por", "con", "para", "una", "es", "como", "pero", "amor", "corazón", "más"},
+	"fr": {"le", "les", "et", "je", "tu", "de", "des", "un", "une", "est", "pas", "que", "qui", "dans", "pour", "avec", "moi", "toi", "mon", "c'est"},
+	"de": {"der", "die", "das", "und", "ich", "du", "nicht", "ist", "ein", "eine", "mit", "mich", "dich", "auf", "wir", "auch", "sie", "zu"},
+	"it": {"il", "lo", "gli", "e", "che", "di", "non", "sono", "mi", "ti", "per", "una", "è", "con", "del", "della", "amore", "io"},
+	"pt": {"o", "os", "e", "que", "de", "não", "eu", "você", "meu", "minha", "uma", "com", "para", "é", "do", "da", "mais", "coração"},
+	"nl": {"de", "het", "een", "en", "ik", "je", "niet", "van", "is", "dat", "met", "mijn", "wij", "zijn", "voor", "maar"},
+	"sv": {"och", "jag", "det", "att", "en", "är", "som", "på", "inte", "du", "med", "min", "vi", "har", "för"},
+	"pl": {"i", "nie", "się", "w", "na", "to", "jest", "że", "z", "ja", "ty", "mnie", "jak", "ale", "mój"},
+	"tr": {"ve", "bir", "bu", "ben", "sen", "için", "ne", "gibi", "da", "de", "çok", "beni", "seni", "aşk"},
+	"ru": {"и", "в", "не", "я", "ты", "на", "что", "с", "меня", "тебя", "как", "это", "мы", "все", "но"},
+	"uk": {"і", "в", "не", "я", "ти", "на", "що", "з", "мене", "тебе", "як", "це", "ми", "все", "але"},
+}
+
+var stopwordLanguages = func() map[string][]string {
+	index := make(map[string][]string)
+	for language, words := range stopwords {
+		for _, word := range words {
+			index[word] = append(index[word], language)
+		}
+	}
+	return index
+}()
+
+// Guess the ISO 639-1 code of the text's language, or return "" if it cannot be guessed
+func DetectLanguage(text string) string {
+	if language := detectScript(text); len(language) > 0 {
+		return language
+	}
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	scores := make(map[string]int)
+	for _, word := range words {
+		for _, language := range stopwordLanguages[word] {
+			scores[language]++
+		}
+	}
+	best, bestScore, second := "", 0, 0
+	for language, score := range scores {
+		switch {
+		case score > bestScore:
+			best, bestScore, second = language, score, bestScore
+		case score > second:
+			second = score
+		}
+	}
+	// Short titles rarely have enough words; require a clear winner
+	if bestScore < 2 || bestScore == second {
+		return ""
+	}
+	return best
+}
+
+// Detect languages by their script, returning "" for Latin and Cyrillic text
+func detectScript(text string) string {
+	counts := make(map[string]int)
+	var han, kana, letters int
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			kana++
+		case unicode.Is(unicode.Han, r):
+			han++
+		default:
+			for _, s := range scriptLanguages {
+				if unicode.Is(s.table, r) {
+					counts[s.language]++
+					break
+				}
+			}
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+	// Japanese mixes kanji with kana; Chinese has no kana
+	if kana > 0 && (kana+han)*2 >= letters {
+		return "ja"
+	}
+	if han*2 >= letters {
+		return "zh"
+	}
+	for language, count := range counts {
+		if count*2 >= letters {
+			return language
+		}
+	}
+	return ""
+}
+
+// The text that a track's language is detected from: its lyrics if it has them
+func languageText(m *Metadata) string {
+	if len(m.Lyrics) > 0 {
+		return m.Lyrics
+	}
+	return m.Title + "\n" + m.Album
+}
+
+// Detect and store the language of the track, if it does not already have one
+func StoreTrackLanguage(ctx context.Context, db *sql.DB, m *Metadata) error {
+	language := DetectLanguage(languageText(m))
+	if len(language) == 0 {
+		return nil
+	}
+	track, args := trackIDQuery(m)
+	_, err := db.ExecContext(ctx, "UPDATE Track SET language = ? WHERE id = "+track+" AND language IS NULL", append([]any{language}, args...)...)
+	return err
+}
+
+// Detect the language of stored tracks without one from their titles and albums,
+// returning the number of tracks updated
+func DetectTrackLanguages(ctx context.Context, db *sql.DB) (int, error) {
+	rows, err := db.QueryContext(ctx, "SELECT t.id, COALESCE(t.title, ''), COALESCE(a.title, '') FROM Track t LEFT JOIN Album a ON a.id = t.album WHERE t.language IS NULL")
+	if err != nil {
+		return 0, err
+	}
+	detected := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var m Metadata
+		if err := rows.Scan(&id, &m.Title, &m.Album); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if language := DetectLanguage(languageText(&m)); len(language) > 0 {
+			detected[id] = language
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	for id, language := range detected {
+		if _, err := tx.ExecContext(ctx, "UPDATE Track SET language = ? WHERE id = ?", language, id); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	return len(detected), tx.Commit()
+}
+
+// Get the number of plays in each language, with "" for tracks whose language is not known
+func GetLanguageCounts(ctx context.Context, db *sql.DB) ([]NameCount, error) {
+	return queryNameCounts(
+		ctx,
+		db,
+		"SELECT COALESCE(t.language, ''), COUNT(*) AS plays FROM TrackLogAll l JOIN Track t ON t.id = l.track WHERE l.guest IS NULL AND l.user IS ? GROUP BY 1 ORDER BY plays DESC",
+		userArg(ctx),
+	)
+}
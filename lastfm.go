@@ -0,0 +1,275 @@
+package music_watch
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrLastFM = errors.New("Audioscrobbler API request failed")
+
+const lastFMAPIURL = "https://ws.audioscrobbler.com/2.0/"
+const lastFMAuthURL = "https://www.last.fm/api/auth/"
+
+// GNU FM servers, such as Libre.fm, do not check API keys and secrets, so any will do
+const gnuFMPlaceholderKey = "music-watcher0000000000000000000"
+
+// Last.fm accepts up to 50 scrobbles per request
+const lastFMBatch = 50
+
+// Last.fm does not accept scrobbles of tracks shorter than this
+const lastFMMinLength = 30 * time.Second
+
+// Settings for scrobbling to Last.fm, or another server with the same API
+type LastFMConfig struct {
+	// From an API account created at https://www.last.fm/api/account/create.
+	// Optional for GNU FM servers.
+	APIKey string `json:"apiKey"`
+	Secret string `json:"secret"`
+	// From the lastfm-auth command; empty disables scrobbling
+	SessionKey string `json:"sessionKey"`
+	// The root of the 2.0 API, e.g. https://libre.fm/2.0/ for Libre.fm; empty uses Last.fm
+	URL string `json:"url"`
+	// The page where users authorize tokens; derived from URL if empty
+	AuthURL string `json:"authUrl"`
+}
+
+// Whether the configuration is for Last.fm itself, rather than a compatible server
+func (c LastFMConfig) isLastFM() bool {
+	return len(c.URL) == 0 || c.URL == lastFMAPIURL
+}
+
+func (c LastFMConfig) apiURL() string {
+	if len(c.URL) == 0 {
+		return lastFMAPIURL
+	}
+	return c.URL
+}
+
+func (c LastFMConfig) authURL() string {
+	switch {
+	case len(c.AuthURL) > 0:
+		return c.AuthURL
+	case c.isLastFM():
+		return lastFMAuthURL
+	}
+	// GNU FM serves the API at /2.0/ and the authorization page at /api/auth/
+	root := strings.TrimSuffix(strings.TrimSuffix(c.URL, "/"), "/2.0")
+	return root + "/api/auth/"
+}
+
+// Get the key and secret to sign requests with
+func (c LastFMConfig) credentials() (string, string) {
+	if len(c.APIKey) == 0 && !c.isLastFM() {
+		return gnuFMPlaceholderKey, gnuFMPlaceholderKey
+	}
+	return c.APIKey, c.Secret
+}
+
+// Check that requests can be signed, since only Last.fm requires a registered API account
+func (c LastFMConfig) Validate() error {
+	if c.isLastFM() && (len(c.APIKey) == 0 || len(c.Secret) == 0) {
+		return fmt.Errorf("%w: Last.fm requires an API key and secret", ErrLastFM)
+	}
+	return nil
+}
+
+// Scrobbles plays to Last.fm or a compatible server, such as Libre.fm
+type LastFM struct {
+	Config LastFMConfig
+	Client *http.Client
+}
+
+// Get a sink that scrobbles plays as they are stored.
+// It is named after the server's host, unless it is Last.fm itself.
+func (l *LastFM) Sink() Sink {
+	name := "lastfm"
+	if u, err := url.Parse(l.Config.URL); err == nil && !l.Config.isLastFM() {
+		name = u.Host
+	}
+	return newScrobbleSink(name, l, lastFMBatch)
+}
+
+func (l *LastFM) validate() error {
+	return l.Config.Validate()
+}
+
+func (l *LastFM) nowPlaying(ctx context.Context, track *Metadata) error {
+	params := url.Values{"method": {"track.updateNowPlaying"}, "sk": {l.Config.SessionKey}}
+	if !addLastFMTrack(params, "", track) {
+		return nil
+	}
+	_, err := l.call(ctx, params)
+	return err
+}
+
+func (l *LastFM) submit(ctx context.Context, plays []Event) error {
+	params := url.Values{"method": {"track.scrobble"}, "sk": {l.Config.SessionKey}}
+	i := 0
+	for _, play := range plays {
+		suffix := fmt.Sprintf("[%d]", i)
+		if addLastFMTrack(params, suffix, play.Track) {
+			params.Set("timestamp"+suffix, strconv.FormatInt(play.Time.Unix(), 10))
+			i++
+		}
+	}
+	if i == 0 {
+		return nil
+	}
+	_, err := l.call(ctx, params)
+	return err
+}
+
+// Add the track's fields to the parameters, returning false if Last.fm would not accept it
+func addLastFMTrack(params url.Values, suffix string, track *Metadata) bool {
+	if len(track.Artist) == 0 || len(track.Title) == 0 {
+		return false
+	}
+	length := time.Duration(track.Length) * time.Microsecond
+	if length > 0 && length < lastFMMinLength {
+		return false
+	}
+	params.Set("artist"+suffix, strings.Join(track.Artist, ", "))
+	params.Set("track"+suffix, track.Title)
+	if len(track.Album) > 0 {
+		params.Set("album"+suffix, track.Album)
+	}
+	if len(track.AlbumArtist) > 0 {
+		params.Set("albumArtist"+suffix, strings.Join(track.AlbumArtist, ", "))
+	}
+	if length > 0 {
+		params.Set("duration"+suffix, strconv.FormatInt(int64(length.Seconds()), 10))
+	}
+	if len(track.TrackId) > 0 {
+		params.Set("mbid"+suffix, track.TrackId)
+	}
+	return true
+}
+
+// Get a token for the user to authorize at LastFMAuthURL
+func GetLastFMToken(ctx context.Context, config LastFMConfig) (string, error) {
+	l := LastFM{Config: config}
+	data, err := l.call(ctx, url.Values{"method": {"auth.getToken"}})
+	if err != nil {
+		return "", err
+	}
+	var response struct {
+		Token string `json:"token"`
+	}
+	err = json.Unmarshal(data, &response)
+	return response.Token, err
+}
+
+// Get the page where the user allows the token to scrobble to their account
+func LastFMAuthURL(config LastFMConfig, token string) string {
+	key, _ := config.credentials()
+	return config.authURL() + "?" + url.Values{"api_key": {key}, "token": {token}}.Encode()
+}
+
+// Exchange an authorized token for the user name and a session key, which does not expire
+func GetLastFMSession(ctx context.Context, config LastFMConfig, token string) (string, string, error) {
+	l := LastFM{Config: config}
+	data, err := l.call(ctx, url.Values{"method": {"auth.getSession"}, "token": {token}})
+	if err != nil {
+		return "", "", err
+	}
+	return parseLastFMSession(data)
+}
+
+// Get a session key with the user's password instead of authorizing a token in a browser.
+// Useful for GNU FM servers, whose authorization page may not be reachable from the browser.
+func GetLastFMMobileSession(ctx context.Context, config LastFMConfig, username, password string) (string, string, error) {
+	l := LastFM{Config: config}
+	data, err := l.call(ctx, url.Values{"method": {"auth.getMobileSession"}, "username": {username}, "password": {password}})
+	if err != nil {
+		return "", "", err
+	}
+	return parseLastFMSession(data)
+}
+
+func parseLastFMSession(data []byte) (string, string, error) {
+	var response struct {
+		Session struct {
+			Name string `json:"name"`
+			Key  string `json:"key"`
+		} `json:"session"`
+	}
+	err := json.Unmarshal(data, &response)
+	return response.Session.Name, response.Session.Key, err
+}
+
+// Make a signed API call, returning the response body
+func (l *LastFM) call(ctx context.Context, params url.Values) ([]byte, error) {
+	key, secret := l.Config.credentials()
+	params.Set("api_key", key)
+	params.Set("api_sig", lastFMSignature(params, secret))
+	params.Set("format", "json")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.Config.apiURL(), strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	client := l.Client
+	if client == nil {
+		client = scrobbleClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var body json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, httpStatusError("Last.fm", resp)
+		}
+		return nil, errors.Join(ErrLastFM, err)
+	}
+	var apiErr struct {
+		Error   int    `json:"error"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &apiErr); err == nil && apiErr.Error != 0 {
+		err := fmt.Errorf("%w: %s (error %d)", ErrLastFM, apiErr.Message, apiErr.Error)
+		switch apiErr.Error {
+		case 11, 16: // Service offline, temporarily unavailable
+			return nil, err
+		case 29: // Rate limit exceeded
+			return nil, &scrobbleError{err: err, retryAfter: 5 * time.Minute}
+		default:
+			return nil, &scrobbleError{err: err, permanent: true}
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpStatusError("Last.fm", resp)
+	}
+	return body, nil
+}
+
+// Sign the parameters: the MD5 of every name and value in order of name, followed by the secret
+func lastFMSignature(params url.Values, secret string) string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		if name != "format" && name != "callback" {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteString(params.Get(name))
+	}
+	b.WriteString(secret)
+	sum := md5.Sum([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,208 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrInvalidPrefix = errors.New("library prefix must be an absolute path")
+
+// A track whose local file could not be found
+type MissingTrack struct {
+	Id    int64
+	Title string
+	Url   string
+	Path  string
+}
+
+// A track whose URL is changed by a relocation
+type RelocatedTrack struct {
+	Id     int64
+	Title  string
+	OldUrl string
+	NewUrl string
+	Merged bool // The new URL already belonged to a track, and the plays were moved to it
+}
+
+// Find file:// tracks whose files no longer exist
+func FindMissingFiles(ctx context.Context, db *sql.DB) ([]MissingTrack, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, COALESCE(title, ''), url FROM Track WHERE url LIKE 'file://%'")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var missing []MissingTrack
+	for rows.Next() {
+		var track MissingTrack
+		if err := rows.Scan(&track.Id, &track.Title, &track.Url); err != nil {
+			return nil, err
+		}
+		path, ok := filePath(track.Url)
+		if !ok {
+			continue
+		}
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			track.Path = path
+			missing = append(missing, track)
+		}
+	}
+	return missing, rows.Err()
+}
+
+// Rewrite file:// URLs under oldPrefix to point to newPrefix.
+// If dryRun is set, the changes are computed but not applied.
+func RelocateLibrary(ctx context.Context, db *sql.DB, oldPrefix, newPrefix string, dryRun bool) ([]RelocatedTrack, error) {
+	if !filepath.IsAbs(oldPrefix) || !filepath.IsAbs(newPrefix) {
+		return nil, ErrInvalidPrefix
+	}
+	oldPrefix, newPrefix = filepath.Clean(oldPrefix), filepath.Clean(newPrefix)
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, "SELECT id, COALESCE(title, ''), url FROM Track WHERE url LIKE 'file://%'")
+	if err != nil {
+		return nil, err
+	}
+	var changes []RelocatedTrack
+	for rows.Next() {
+		var change RelocatedTrack
+		if err := rows.Scan(&change.Id, &change.Title, &change.OldUrl); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		path, ok := filePath(change.OldUrl)
+		if !ok {
+			continue
+		}
+		rest, ok := strings.CutPrefix(path, oldPrefix)
+		if !ok || (len(rest) > 0 && rest[0] != filepath.Separator) {
+			continue
+		}
+		change.NewUrl = (&url.URL{Scheme: "file", Path: newPrefix + rest}).String()
+		changes = append(changes, change)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range changes {
+		if err := relocateTrack(ctx, tx, &changes[i], dryRun); err != nil {
+			return nil, err
+		}
+	}
+	if dryRun {
+		return changes, nil
+	}
+	return changes, tx.Commit()
+}
+
+func relocateTrack(ctx context.Context, tx *sql.Tx, change *RelocatedTrack, dryRun bool) error {
+	// (url, title) identifies a track, so the new location may already have been played
+	var existing int64
+	err := tx.QueryRowContext(
+		ctx,
+		"SELECT id FROM Track WHERE url = ? AND title = ? AND id != ?",
+		change.NewUrl,
+		change.Title,
+		change.Id,
+	).Scan(&existing)
+	switch err {
+	case sql.ErrNoRows:
+		if dryRun {
+			return nil
+		}
+		_, err := tx.ExecContext(ctx, "UPDATE Track SET url = ? WHERE id = ?", change.NewUrl, change.Id)
+		return err
+	case nil:
+		change.Merged = true
+		if dryRun {
+			return nil
+		}
+		return mergeTrack(ctx, tx, existing, change.Id)
+	default:
+		return err
+	}
+}
+
+// Move the plays, daily counts, progress, persons and genres of the duplicate to the track that is kept, filling in the IDs and
+// position the kept track does not have, and delete the duplicate
+func mergeTrack(ctx context.Context, tx *sql.Tx, keep, duplicate int64) error {
+	tables, err := trackLogTables(ctx, tx)
+	if err != nil {
+		return err
+	}
+	// The MusicBrainz IDs keep the duplicate's later plays on the kept track, see trackIDQuery
+	stmts := []string{
+		`UPDATE Track SET
+			trackId = COALESCE(NULLIF(trackId, ''), (SELECT NULLIF(d.trackId, '') FROM Track d WHERE d.id = ?2)),
+			recordingMbid = COALESCE(recordingMbid, (SELECT d.recordingMbid FROM Track d WHERE d.id = ?2)),
+			trackNumber = COALESCE(trackNumber, (SELECT d.trackNumber FROM Track d WHERE d.id = ?2)),
+			discNumber = COALESCE(discNumber, (SELECT d.discNumber FROM Track d WHERE d.id = ?2))
+		WHERE id = ?1`,
+	}
+	for _, table := range tables {
+		stmts = append(stmts, fmt.Sprintf("UPDATE %s SET track = ?1 WHERE track = ?2", table))
+	}
+	for _, stmt := range append(
+		stmts,
+		"UPDATE PodcastLog SET track = ?1 WHERE track = ?2",
+		// Where both tracks have progress, the kept track's is kept
+		"UPDATE OR IGNORE Progress SET track = ?1 WHERE track = ?2",
+		"DELETE FROM Progress WHERE track = ?2",
+		`INSERT INTO DailyPlays (day, track, plays, playedMs) SELECT day, ?1, plays, playedMs FROM DailyPlays WHERE track = ?2
+		ON CONFLICT (day, track) DO UPDATE SET plays = plays + excluded.plays, playedMs = COALESCE(playedMs + excluded.playedMs, playedMs, excluded.playedMs)`,
+		"DELETE FROM DailyPlays WHERE track = ?2",
+		// Relations without a role are not unique, so those the kept track has are left out
+		`INSERT OR IGNORE INTO Track_Person (track, person, role) SELECT ?1, person, role FROM Track_Person d
+		WHERE track = ?2 AND NOT EXISTS (SELECT 1 FROM Track_Person k WHERE k.track = ?1 AND k.person = d.person AND k.role IS d.role)`,
+		"DELETE FROM Track_Person WHERE track = ?2",
+		"INSERT OR IGNORE INTO Track_Genre (track, genre) SELECT ?1, genre FROM Track_Genre WHERE track = ?2",
+		"DELETE FROM Track_Genre WHERE track = ?2",
+		"DELETE FROM Track WHERE id = ?2",
+	) {
+		if _, err := tx.ExecContext(ctx, stmt, keep, duplicate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Move the tracks and album artists of the duplicate to the album that is kept, filling in the IDs, year and cover
+// the kept album does not have, and delete the duplicate
+func mergeAlbum(ctx context.Context, tx *sql.Tx, keep, duplicate int64) error {
+	for _, stmt := range []string{
+		`UPDATE Album SET
+			releaseMbid = COALESCE(releaseMbid, (SELECT d.releaseMbid FROM Album d WHERE d.id = ?2)),
+			releaseGroup = COALESCE(releaseGroup, (SELECT d.releaseGroup FROM Album d WHERE d.id = ?2)),
+			year = COALESCE(year, (SELECT d.year FROM Album d WHERE d.id = ?2)),
+			coverPath = COALESCE(coverPath, (SELECT d.coverPath FROM Album d WHERE d.id = ?2)),
+			coverUrl = COALESCE(coverUrl, (SELECT d.coverUrl FROM Album d WHERE d.id = ?2))
+		WHERE id = ?1`,
+		"UPDATE Track SET album = ?1 WHERE album = ?2",
+		"INSERT OR IGNORE INTO Album_Person (album, person) SELECT ?1, person FROM Album_Person WHERE album = ?2",
+		"DELETE FROM Album_Person WHERE album = ?2",
+		"DELETE FROM Album WHERE id = ?2",
+	} {
+		if _, err := tx.ExecContext(ctx, stmt, keep, duplicate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get the local path of a file:// URL
+func filePath(rawUrl string) (string, bool) {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.Scheme != "file" || len(u.Path) == 0 {
+		return "", false
+	}
+	return u.Path, true
+}
@@ -0,0 +1,153 @@
+package music_watch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const defaultListenBrainzURL = "https://api.listenbrainz.org"
+
+// ListenBrainz accepts up to 1000 listens per import, but smaller requests fail faster
+const listenBrainzBatch = 100
+
+// Settings for mirroring plays to ListenBrainz
+type ListenBrainzConfig struct {
+	// The user token from the ListenBrainz settings page; empty disables
+	Token string `json:"token"`
+	// The API root, for self-hosted instances
+	URL string `json:"url"`
+}
+
+// Submits plays to ListenBrainz
+type ListenBrainz struct {
+	Config ListenBrainzConfig
+	Client *http.Client
+}
+
+// Get a sink that submits plays as they are stored
+func (l *ListenBrainz) Sink() Sink {
+	return newScrobbleSink("listenbrainz", l, listenBrainzBatch)
+}
+
+type listenBrainzSubmission struct {
+	ListenType string               `json:"listen_type"`
+	Payload    []listenBrainzListen `json:"payload"`
+}
+
+type listenBrainzListen struct {
+	ListenedAt    int64                     `json:"listened_at,omitempty"`
+	TrackMetadata listenBrainzTrackMetadata `json:"track_metadata"`
+}
+
+type listenBrainzTrackMetadata struct {
+	ArtistName     string         `json:"artist_name"`
+	TrackName      string         `json:"track_name"`
+	ReleaseName    string         `json:"release_name,omitempty"`
+	AdditionalInfo map[string]any `json:"additional_info,omitempty"`
+}
+
+func (l *ListenBrainz) nowPlaying(ctx context.Context, track *Metadata) error {
+	listen, ok := newListenBrainzListen(track, time.Time{})
+	if !ok {
+		return nil
+	}
+	return l.post(ctx, listenBrainzSubmission{ListenType: "playing_now", Payload: []listenBrainzListen{listen}})
+}
+
+func (l *ListenBrainz) submit(ctx context.Context, plays []Event) error {
+	submission := listenBrainzSubmission{ListenType: "import"}
+	for _, play := range plays {
+		if listen, ok := newListenBrainzListen(play.Track, play.Time); ok {
+			submission.Payload = append(submission.Payload, listen)
+		}
+	}
+	switch len(submission.Payload) {
+	case 0:
+		return nil
+	case 1:
+		submission.ListenType = "single"
+	}
+	return l.post(ctx, submission)
+}
+
+// ListenBrainz requires an artist and a title, so tracks without them are skipped
+func newListenBrainzListen(track *Metadata, listenedAt time.Time) (listenBrainzListen, bool) {
+	if len(track.Artist) == 0 || len(track.Title) == 0 {
+		return listenBrainzListen{}, false
+	}
+	info := map[string]any{
+		"submission_client": "music-watcher",
+		"artist_names":      track.Artist,
+	}
+	if len(track.TrackId) > 0 {
+		info["recording_mbid"] = track.TrackId
+	}
+	if len(track.AlbumId) > 0 {
+		info["release_mbid"] = track.AlbumId
+	}
+	if track.TrackNumber > 0 {
+		info["tracknumber"] = track.TrackNumber
+	}
+	if len(track.Genre) > 0 {
+		info["tags"] = track.Genre
+	}
+	if track.Length > 0 {
+		info["duration_ms"] = track.Length / 1000
+	}
+	if len(track.Player) > 0 {
+		info["media_player"] = PlayerKey(track.Player)
+	}
+	if strings.HasPrefix(track.Url, "http://") || strings.HasPrefix(track.Url, "https://") {
+		info["origin_url"] = track.Url
+	}
+	listen := listenBrainzListen{
+		TrackMetadata: listenBrainzTrackMetadata{
+			ArtistName:     strings.Join(track.Artist, ", "),
+			TrackName:      track.Title,
+			ReleaseName:    track.Album,
+			AdditionalInfo: info,
+		},
+	}
+	if !listenedAt.IsZero() {
+		listen.ListenedAt = listenedAt.Unix()
+	}
+	return listen, true
+}
+
+func (l *ListenBrainz) post(ctx context.Context, submission listenBrainzSubmission) error {
+	body, err := json.Marshal(submission)
+	if err != nil {
+		return err
+	}
+	base := l.Config.URL
+	if len(base) == 0 {
+		base = defaultListenBrainzURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(base, "/")+"/1/submit-listens", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+l.Config.Token)
+	req.Header.Set("Content-Type", "application/json")
+	client := l.Client
+	if client == nil {
+		client = scrobbleClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusTooManyRequests && len(resp.Header.Get("Retry-After")) == 0 {
+			// ListenBrainz reports when the rate limit resets in its own header
+			resp.Header.Set("Retry-After", resp.Header.Get("X-RateLimit-Reset-In"))
+		}
+		return httpStatusError("ListenBrainz", resp)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Plays that start less than this long after the previous play ended are in its listening session, unless configured
+const defaultSessionGap = 30 * time.Minute
+
+type sessionGapKey struct{}
+
+// Group plays stored with the context into listening sessions split by gaps of at least gap
+func withSessionGap(ctx context.Context, gap time.Duration) context.Context {
+	if gap <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, sessionGapKey{}, gap)
+}
+
+func sessionGap(ctx context.Context) time.Duration {
+	if gap, ok := ctx.Value(sessionGapKey{}).(time.Duration); ok {
+		return gap
+	}
+	return defaultSessionGap
+}
+
+// Put the newly stored play in the listening session of the play before it, or start a session with it.
+// A session's ID is the ID of its first play. Each user's and each guest's plays are in sessions of their own.
+// The play before it is always in TrackLog, so partitions are not searched.
+func assignSession(ctx context.Context, tx *sql.Tx, batch *writeBatch, play int64, played time.Time) error {
+	guest := sql.NullString{String: guestSession(ctx), Valid: len(guestSession(ctx)) > 0}
+	var session int64
+	var gap float64
+	err := batch.queryRow(
+		ctx,
+		tx,
+		`SELECT COALESCE(session, id), (julianday(?1) - julianday(timestamp)) * 86400 - COALESCE(playedMs, 0) / 1000.0
+		FROM TrackLog WHERE id != ?2 AND timestamp <= ?1 AND guest IS ?3 AND user IS ?4 AND deleted IS NULL
+		ORDER BY timestamp DESC, id DESC LIMIT 1`,
+		formatTimestamp(played),
+		play,
+		guest,
+		userArg(ctx),
+	).Scan(&session, &gap)
+	if err == sql.ErrNoRows || (err == nil && gap >= sessionGap(ctx).Seconds()) {
+		session = play
+	} else if err != nil {
+		return err
+	}
+	_, err = batch.exec(ctx, tx, "UPDATE TrackLog SET session = ? WHERE id = ?", session, play)
+	return err
+}
+
+// Group the plays stored before listening sessions were recorded into sessions, using the default gap
+func backfillSessions(ctx context.Context, tx *sql.Tx) error {
+	tables, err := trackLogTables(ctx, tx)
+	if err != nil {
+		return err
+	}
+	// A play starts a session when the previous play ended long enough before it;
+	// each session's plays are then numbered by how many sessions started before them
+	_, err = tx.ExecContext(
+		ctx,
+		`CREATE TEMP TABLE ListeningSession AS
+		WITH gaps AS (
+			SELECT id, guest, user, timestamp,
+				(julianday(timestamp) - julianday(LAG(timestamp) OVER w)) * 86400 - COALESCE(LAG(playedMs) OVER w, 0) / 1000.0 AS gap
+			FROM `+trackLogView+`
+			WINDOW w AS (PARTITION BY guest, user ORDER BY timestamp, id)
+		), numbered AS (
+			SELECT id, guest, user, SUM(gap IS NULL OR gap >= ?) OVER (PARTITION BY guest, user ORDER BY timestamp, id) AS number
+			FROM gaps
+		)
+		SELECT id, MIN(id) OVER (PARTITION BY guest, user, number) AS session FROM numbered`,
+		defaultSessionGap.Seconds(),
+	)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		_, err := tx.ExecContext(
+			ctx,
+			"UPDATE "+table+" SET session = (SELECT s.session FROM temp.ListeningSession s WHERE s.id = "+table+".id) WHERE session IS NULL",
+		)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, "DROP TABLE temp.ListeningSession")
+	return err
+}
+
+// A run of plays with no long gaps between them
+type ListeningSession struct {
+	ID       int64
+	Start    time.Time
+	End      time.Time // When the last play ended, as far as is known
+	Plays    int
+	Listened time.Duration // Only counting the plays whose time played was recorded
+}
+
+// Get the user's listening sessions that started between from and to, oldest first; zero times are not filtered on
+func GetListeningSessions(ctx context.Context, db *sql.DB, from, to time.Time) ([]ListeningSession, error) {
+	where, args := playConditions(ctx, from, to)
+	rows, err := db.QueryContext(
+		ctx,
+		`SELECT l.session, MIN(l.timestamp) AS start,
+			strftime('%Y-%m-%dT%H:%M:%SZ', MAX(julianday(l.timestamp) + COALESCE(l.playedMs, 0) / 86400000.0)),
+			COUNT(l.id), COALESCE(SUM(l.playedMs), 0)
+		FROM TrackLogAll l
+		WHERE l.guest IS NULL AND l.session IN (SELECT l.id FROM TrackLogAll l WHERE `+where+`)
+		GROUP BY l.session ORDER BY start`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var sessions []ListeningSession
+	for rows.Next() {
+		var s ListeningSession
+		var start, end string
+		var listened int64
+		if err := rows.Scan(&s.ID, &start, &end, &s.Plays, &listened); err != nil {
+			return nil, err
+		}
+		if s.Start, err = time.Parse(timestampLayout, start); err != nil {
+			return nil, err
+		}
+		if s.End, err = time.Parse(timestampLayout, end); err != nil {
+			return nil, err
+		}
+		s.Listened = time.Duration(listened) * time.Millisecond
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
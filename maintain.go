@@ -0,0 +1,64 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// What MaintainDatabase did
+type MaintenanceResult struct {
+	// The size of the database file and its write-ahead log, in bytes
+	SizeBefore int64
+	SizeAfter  int64
+	// Whether another connection kept the write-ahead log from being emptied, as it was reading or writing
+	CheckpointBusy bool
+}
+
+// Compact the database, update the statistics the query planner uses and empty the write-ahead log.
+// Other connections wait while the database is vacuumed, which can take a while for a large database.
+func MaintainDatabase(ctx context.Context, db *sql.DB) (MaintenanceResult, error) {
+	var result MaintenanceResult
+	var path string
+	if err := db.QueryRowContext(ctx, "SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&path); err != nil {
+		return result, err
+	}
+	var err error
+	if result.SizeBefore, err = databaseSize(path); err != nil {
+		return result, err
+	}
+	for _, stmt := range []string{"VACUUM", "ANALYZE", "PRAGMA optimize"} {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return result, err
+		}
+	}
+	// Vacuuming a database in WAL mode writes all of it to the log, which is only truncated by a checkpoint.
+	// In other journal modes there is no log, and the checkpoint does nothing.
+	var busy, logged, checkpointed int
+	if err := db.QueryRowContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &logged, &checkpointed); err != nil {
+		return result, err
+	}
+	result.CheckpointBusy = busy != 0
+	result.SizeAfter, err = databaseSize(path)
+	return result, err
+}
+
+// Get the size of the database file and its write-ahead log; 0 for an in-memory database
+func databaseSize(path string) (int64, error) {
+	var size int64
+	if len(path) == 0 {
+		return size, nil
+	}
+	for _, file := range []string{path, path + "-wal"} {
+		info, err := os.Stat(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return size, err
+		}
+		size += info.Size()
+	}
+	return size, nil
+}
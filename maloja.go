@@ -0,0 +1,92 @@
+package music_watch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Settings for scrobbling to a self-hosted Maloja server
+type MalojaConfig struct {
+	// The root of the server, e.g. https://maloja.example.com; empty disables
+	URL string `json:"url"`
+	// An API key created in the server's settings
+	APIKey string `json:"apiKey"`
+}
+
+// Scrobbles plays to Maloja
+type Maloja struct {
+	Config MalojaConfig
+	Client *http.Client
+}
+
+// Get a sink that scrobbles plays as they are stored.
+// The API takes one scrobble per request.
+func (m *Maloja) Sink() Sink {
+	return newScrobbleSink("maloja", m, 1)
+}
+
+type malojaScrobble struct {
+	Key          string   `json:"key"`
+	Artists      []string `json:"artists"`
+	Title        string   `json:"title"`
+	Album        string   `json:"album,omitempty"`
+	AlbumArtists []string `json:"albumartists,omitempty"`
+	Length       int64    `json:"length,omitempty"` // Seconds
+	Time         int64    `json:"time"`
+}
+
+// Maloja has no now playing status
+func (m *Maloja) nowPlaying(ctx context.Context, track *Metadata) error {
+	return nil
+}
+
+func (m *Maloja) submit(ctx context.Context, plays []Event) error {
+	for _, play := range plays {
+		// Maloja requires an artist and a title
+		if len(play.Track.Artist) == 0 || len(play.Track.Title) == 0 {
+			continue
+		}
+		scrobble := malojaScrobble{
+			Key:          m.Config.APIKey,
+			Artists:      play.Track.Artist,
+			Title:        play.Track.Title,
+			Album:        play.Track.Album,
+			AlbumArtists: play.Track.AlbumArtist,
+			Length:       play.Track.Length / 1000000,
+			Time:         play.Time.Unix(),
+		}
+		if err := m.post(ctx, scrobble); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Maloja) post(ctx context.Context, scrobble malojaScrobble) error {
+	body, err := json.Marshal(scrobble)
+	if err != nil {
+		return err
+	}
+	url := strings.TrimSuffix(m.Config.URL, "/") + "/apis/mlj_1/newscrobble"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := m.Client
+	if client == nil {
+		client = scrobbleClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return httpStatusError("Maloja", resp)
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package music_watch
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrMPDProtocol = errors.New("unexpected response from MPD")
+
+// The player name reported for tracks played by MPD
+const mpdPlayerName = "mpd"
+
+// How long to wait before reconnecting to MPD after losing the connection
+const mpdRetryInterval = 10 * time.Second
+
+// A connection to an MPD server using its native protocol
+type mpdConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// Reads tracks from an MPD server
+type MPDSource struct {
+	Address  string // host:port, or the path to a Unix socket
+	Password string
+}
+
+func (s *MPDSource) Run(ctx context.Context, events chan<- Event) error {
+	slog.InfoContext(ctx, "Starting monitor of MPD", "Address", s.Address)
+	for {
+		err := watchMPDConnection(ctx, s.Address, s.Password, events)
+		finishPlay(ctx, events, mpdPlayerName)
+		if ctx.Err() != nil {
+			return nil
+		}
+		slog.ErrorContext(ctx, "Lost connection to MPD, retrying", "Address", s.Address, "Error", err)
+		select {
+		case <-time.After(mpdRetryInterval):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func watchMPDConnection(ctx context.Context, addr, password string, events chan<- Event) error {
+	conn, err := dialMPD(ctx, addr, password)
+	if err != nil {
+		return err
+	}
+	defer conn.conn.Close()
+	// Unblock the idle command when shutting down
+	stop := context.AfterFunc(ctx, func() { conn.conn.Close() })
+	defer stop()
+	var current *Metadata
+	for {
+		status, err := conn.command("status")
+		if err != nil {
+			return err
+		}
+		playing := status["state"] == "play"
+		if status["state"] == "stop" {
+			finishPlay(ctx, events, mpdPlayerName)
+			current = nil
+		} else {
+			song, err := conn.command("currentsong")
+			if err != nil {
+				return err
+			}
+			metadata := parseMPDSong(song)
+			if metadata.Cleared() {
+				// The queue can end without MPD reporting that it stopped
+				finishPlay(ctx, events, mpdPlayerName)
+				current = nil
+			} else if current == nil || !current.IsSameTrack(metadata) {
+				startPlay(ctx, events, mpdPlayerName, metadata, playing, parseMPDSeconds(status["elapsed"]))
+				if playing {
+					// Only log the track once it is playing, as with MPRIS
+					current = metadata
+					if err := sendTrack(ctx, events, metadata); err != nil {
+						return err
+					}
+				}
+			} else {
+				setPlaying(ctx, events, mpdPlayerName, playing)
+				// Also sent after seeking
+				seekPlay(mpdPlayerName, parseMPDSeconds(status["elapsed"]))
+			}
+		}
+		if _, err := conn.command("idle player"); err != nil {
+			return err
+		}
+	}
+}
+
+func dialMPD(ctx context.Context, addr, password string) (*mpdConn, error) {
+	network := "tcp"
+	if strings.HasPrefix(addr, "/") {
+		network = "unix"
+	}
+	var dialer net.Dialer
+	c, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := &mpdConn{conn: c, reader: bufio.NewReader(c)}
+	greeting, err := conn.reader.ReadString('\n')
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(greeting, "OK MPD ") {
+		c.Close()
+		return nil, fmt.Errorf("%w: %q", ErrMPDProtocol, strings.TrimSpace(greeting))
+	}
+	slog.DebugContext(ctx, "Connected to MPD", "Address", addr, "Version", strings.TrimSpace(greeting[len("OK MPD "):]))
+	if len(password) > 0 {
+		if _, err := conn.command("password " + quoteMPD(password)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// Send a command and read the response as a map of keys to values.
+// Repeated keys are joined with a newline.
+func (c *mpdConn) command(cmd string) (map[string]string, error) {
+	if _, err := fmt.Fprintf(c.conn, "%s\n", cmd); err != nil {
+		return nil, err
+	}
+	response := make(map[string]string)
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "OK" {
+			return response, nil
+		}
+		if strings.HasPrefix(line, "ACK ") {
+			return nil, fmt.Errorf("%w: %s", ErrMPDProtocol, line)
+		}
+		key, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrMPDProtocol, line)
+		}
+		if existing, ok := response[key]; ok {
+			response[key] = existing + "\n" + value
+		} else {
+			response[key] = value
+		}
+	}
+}
+
+func parseMPDSong(song map[string]string) *Metadata {
+	split := func(value string) []string {
+		if len(value) == 0 {
+			return nil
+		}
+		return strings.Split(value, "\n")
+	}
+	metadata := Metadata{
+		Album:       song["Album"],
+		AlbumArtist: split(song["AlbumArtist"]),
+		Url:         song["file"],
+		Artist:      split(song["Artist"]),
+		Composer:    split(song["Composer"]),
+		Genre:       split(song["Genre"]),
+		TrackId:     song["MUSICBRAINZ_TRACKID"],
+		Title:       song["Title"],
+		Player:      mpdPlayerName,
+		Length:      parseMPDSeconds(song["duration"]).Microseconds(),
+	}
+	if len(metadata.Album) == 0 {
+		// Internet radio streams have the station's name, which is treated as the album
+		metadata.Album = song["Name"]
+	}
+	metadata.Clean()
+	return &metadata
+}
+
+// Parse a number of seconds with a fractional part, returning 0 if it is invalid
+func parseMPDSeconds(value string) time.Duration {
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+func quoteMPD(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// Get the MPD address from MPD_HOST and MPD_PORT, as other clients do
+func DefaultMPDAddress() (string, string) {
+	host, port := os.Getenv("MPD_HOST"), os.Getenv("MPD_PORT")
+	var password string
+	// MPD_HOST may be password@host
+	if i := strings.LastIndex(host, "@"); i > 0 {
+		password, host = host[:i], host[i+1:]
+	}
+	if len(host) == 0 {
+		host = "localhost"
+	}
+	if strings.HasPrefix(host, "/") {
+		return host, password
+	}
+	if len(port) == 0 {
+		port = "6600"
+	}
+	return net.JoinHostPort(host, port), password
+}
@@ -0,0 +1,154 @@
+package music_watch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+var ErrInvalidMQTT = errors.New("invalid MQTT settings")
+
+const defaultMQTTTopic = "music-watcher"
+
+// Settings for publishing events to an MQTT broker
+type MQTTConfig struct {
+	// The broker's URL, e.g. tcp://localhost:1883, ssl://... or ws://...; empty disables
+	Broker   string `json:"broker"`
+	ClientID string `json:"clientId"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Events are published as JSON to <topic>/now_playing and <topic>/scrobble; default music-watcher
+	Topic string `json:"topic"`
+	// 0, 1 or 2
+	QoS byte `json:"qos"`
+	// Keep the last now playing message on the broker, for clients that connect later.
+	// It is cleared when the watcher stops or loses its connection.
+	RetainNowPlaying bool `json:"retainNowPlaying"`
+}
+
+// Publishes now playing and scrobble events to an MQTT broker
+type MQTT struct {
+	Config MQTTConfig
+
+	client  mqtt.Client
+	pending sync.WaitGroup
+	lock    sync.Mutex
+	queued  int
+	lastErr error
+}
+
+func (m *MQTT) Name() string {
+	return "mqtt"
+}
+
+func (m *MQTT) topic(name string) string {
+	topic := m.Config.Topic
+	if len(topic) == 0 {
+		topic = defaultMQTTTopic
+	}
+	return topic + "/" + name
+}
+
+func (m *MQTT) Init(ctx context.Context) error {
+	if m.Config.QoS > 2 {
+		return fmt.Errorf("%w: QoS must be 0, 1 or 2", ErrInvalidMQTT)
+	}
+	clientID := m.Config.ClientID
+	if len(clientID) == 0 {
+		clientID = fmt.Sprintf("music-watcher-%d", time.Now().UnixNano())
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(m.Config.Broker).
+		SetClientID(clientID).
+		SetUsername(m.Config.Username).
+		SetPassword(m.Config.Password).
+		SetAutoReconnect(true).
+		// Messages published before the broker is reachable are sent once it is
+		SetConnectRetry(true).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			m.setError(err)
+		}).
+		SetOnConnectHandler(func(mqtt.Client) {
+			m.setError(nil)
+		})
+	if m.Config.RetainNowPlaying {
+		opts.SetBinaryWill(m.topic("now_playing"), nil, m.Config.QoS, true)
+	}
+	m.client = mqtt.NewClient(opts)
+	m.client.Connect()
+	return nil
+}
+
+func (m *MQTT) Store(ctx context.Context, e Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	switch e.Type {
+	case EventNowPlaying:
+		m.publish(m.topic("now_playing"), payload, m.Config.RetainNowPlaying)
+	case EventScrobble:
+		m.publish(m.topic("scrobble"), payload, false)
+	}
+	return nil
+}
+
+// Publish without waiting for the broker, recording the result for the sink's status
+func (m *MQTT) publish(topic string, payload []byte, retain bool) {
+	token := m.client.Publish(topic, m.Config.QoS, retain, payload)
+	m.lock.Lock()
+	m.queued++
+	m.lock.Unlock()
+	m.pending.Add(1)
+	go func() {
+		defer m.pending.Done()
+		<-token.Done()
+		m.lock.Lock()
+		m.queued--
+		m.lock.Unlock()
+		if err := token.Error(); err != nil {
+			m.setError(err)
+		}
+	}()
+}
+
+func (m *MQTT) setError(err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.lastErr = err
+}
+
+func (m *MQTT) health() (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.queued, m.lastErr
+}
+
+// Wait for the broker to accept the published messages
+func (m *MQTT) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		m.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *MQTT) Close() error {
+	if m.Config.RetainNowPlaying && m.client.IsConnected() {
+		// Nothing is playing once the watcher stops
+		m.client.Publish(m.topic("now_playing"), m.Config.QoS, true, []byte{}).WaitTimeout(time.Second)
+	}
+	m.client.Disconnect(250)
+	return nil
+}
@@ -0,0 +1,292 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMusicBrainzURL = "https://musicbrainz.org"
+	// MusicBrainz allows an average of one request per second
+	musicBrainzInterval = time.Second
+	// Search results scoring lower than this are not trusted to be the same recording
+	minMusicBrainzScore = 90
+)
+
+// Settings for filling in MusicBrainz IDs of tracks that are played
+type MusicBrainzConfig struct {
+	// Look up tracks as they are stored, also filling in their albums' release IDs and years from their tags
+	Enrich bool `json:"enrich"`
+	// The server to use, such as a mirror; defaults to https://musicbrainz.org
+	URL string `json:"url"`
+	// An email address or URL, sent so that MusicBrainz can reach the user about their requests
+	Contact string `json:"contact"`
+}
+
+// The IDs found for a track, as cached
+type musicBrainzMatch struct {
+	Recording    string              `json:"recording"`
+	Release      string              `json:"release,omitempty"`
+	ReleaseGroup string              `json:"releaseGroup,omitempty"`
+	Year         int                 `json:"year,omitempty"` // When the release came out
+	Artists      []musicBrainzArtist `json:"artists,omitempty"`
+}
+
+type musicBrainzArtist struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// The fields of a recording in search and lookup responses that are used
+type musicBrainzRecording struct {
+	ID           string `json:"id"`
+	Score        int    `json:"score"`
+	ArtistCredit []struct {
+		Name   string            `json:"name"`
+		Artist musicBrainzArtist `json:"artist"`
+	} `json:"artist-credit"`
+	Releases []struct {
+		ID           string `json:"id"`
+		Title        string `json:"title"`
+		Date         string `json:"date"`
+		ReleaseGroup struct {
+			ID string `json:"id"`
+		} `json:"release-group"`
+	} `json:"releases"`
+}
+
+// Looks up tracks with the MusicBrainz API, caching the results
+type MusicBrainz struct {
+	Config MusicBrainzConfig
+	Cache  *LookupCache
+	Client *http.Client
+	// If set, the album's cover is downloaded once the track has been looked up
+	Covers *CoverArt
+
+	lock sync.Mutex
+	next time.Time // When the next request may be sent
+}
+
+// Wait until a request can be sent without going over the rate limit
+func (mb *MusicBrainz) wait(ctx context.Context) error {
+	mb.lock.Lock()
+	delay := time.Until(mb.next)
+	mb.next = time.Now().Add(max(delay, 0) + musicBrainzInterval)
+	mb.lock.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (mb *MusicBrainz) get(ctx context.Context, path string, query url.Values, v any) error {
+	base := mb.Config.URL
+	if len(base) == 0 {
+		base = defaultMusicBrainzURL
+	}
+	query.Set("fmt", "json")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(base, "/")+"/ws/2/"+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	// MusicBrainz blocks clients that do not identify themselves
+	agent := "music-watcher"
+	if len(mb.Config.Contact) > 0 {
+		agent += " ( " + mb.Config.Contact + " )"
+	}
+	req.Header.Set("User-Agent", agent)
+	req.Header.Set("Accept", "application/json")
+	if err := mb.wait(ctx); err != nil {
+		return err
+	}
+	client := mb.Client
+	if client == nil {
+		client = scrobbleClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("MusicBrainz returned %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Escape the characters that are special in Lucene queries
+func luceneEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`+-&|!(){}[]^"~*?:\/`, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Find the IDs of the track: by its recording MBID if it has one, otherwise by searching for its artist, title and album
+func (mb *MusicBrainz) match(ctx context.Context, m *Metadata) (*musicBrainzMatch, bool, error) {
+	if len(m.TrackId) > 0 {
+		return Lookup(ctx, mb.Cache, "musicbrainz", "recording:"+m.TrackId, func(ctx context.Context) (*musicBrainzMatch, bool, error) {
+			var recording musicBrainzRecording
+			err := mb.get(ctx, "recording/"+url.PathEscape(m.TrackId), url.Values{"inc": {"artists releases release-groups"}}, &recording)
+			if err != nil {
+				return nil, false, err
+			}
+			return newMusicBrainzMatch(&recording, m.Album), true, nil
+		})
+	}
+	if len(m.Title) == 0 || len(m.Artist) == 0 {
+		return nil, false, nil
+	}
+	key := strings.ToLower(strings.Join([]string{strings.Join(m.Artist, ", "), m.Title, m.Album}, "\x1f"))
+	return Lookup(ctx, mb.Cache, "musicbrainz", "search:"+key, func(ctx context.Context) (*musicBrainzMatch, bool, error) {
+		terms := []string{
+			`recording:"` + luceneEscape(m.Title) + `"`,
+			`artist:"` + luceneEscape(m.Artist[0]) + `"`,
+		}
+		if len(m.Album) > 0 {
+			terms = append(terms, `release:"`+luceneEscape(m.Album)+`"`)
+		}
+		var response struct {
+			Recordings []musicBrainzRecording `json:"recordings"`
+		}
+		err := mb.get(ctx, "recording", url.Values{"query": {strings.Join(terms, " AND ")}, "limit": {"5"}}, &response)
+		if err != nil {
+			return nil, false, err
+		}
+		if len(response.Recordings) == 0 || response.Recordings[0].Score < minMusicBrainzScore {
+			return nil, false, nil
+		}
+		return newMusicBrainzMatch(&response.Recordings[0], m.Album), true, nil
+	})
+}
+
+// Collect the IDs of the recording, using the release with the album's title
+func newMusicBrainzMatch(recording *musicBrainzRecording, album string) *musicBrainzMatch {
+	match := musicBrainzMatch{Recording: recording.ID}
+	for _, credit := range recording.ArtistCredit {
+		match.Artists = append(match.Artists, musicBrainzArtist{ID: credit.Artist.ID, Name: credit.Name})
+	}
+	for _, release := range recording.Releases {
+		// Compilations and other releases with the recording are not the album that was played
+		if strings.EqualFold(release.Title, album) || (len(album) > 0 && strings.EqualFold(releaseGroupTitle(release.Title), releaseGroupTitle(album))) {
+			match.Release = release.ID
+			match.ReleaseGroup = release.ReleaseGroup.ID
+			match.Year = parseYear(release.Date)
+			break
+		}
+	}
+	return &match
+}
+
+// Store the album's release ID and year given by the player or the file's tags, if they are not already known
+func storeAlbumTags(ctx context.Context, db *sql.DB, m *Metadata) error {
+	if len(m.AlbumId) == 0 && m.Year == 0 {
+		return nil
+	}
+	track, args := trackIDQuery(m)
+	_, err := db.ExecContext(
+		ctx,
+		`UPDATE Album SET releaseMbid = COALESCE(releaseMbid, NULLIF(?, '')), year = COALESCE(year, NULLIF(?, 0))
+		WHERE id = (SELECT album FROM Track WHERE id = `+track+`)`,
+		append([]any{m.AlbumId, m.Year}, args...)...,
+	)
+	return err
+}
+
+// Look up the track and store the IDs and the album's year that are not already known
+func (mb *MusicBrainz) Enrich(ctx context.Context, db *sql.DB, m *Metadata) error {
+	// The track's own tags are trusted over a search
+	if err := storeAlbumTags(ctx, db, m); err != nil {
+		return err
+	}
+	match, found, err := mb.match(ctx, m)
+	if err != nil || !found {
+		return err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var trackID int64
+	var albumID sql.NullInt64
+	track, args := trackIDQuery(m)
+	err = tx.QueryRowContext(ctx, "SELECT id, album FROM Track WHERE id = "+track, args...).Scan(&trackID, &albumID)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE Track SET recordingMbid = ? WHERE id = ? AND recordingMbid IS NULL", match.Recording, trackID); err != nil {
+		return err
+	}
+	if albumID.Valid && len(match.Release) > 0 {
+		_, err := tx.ExecContext(
+			ctx,
+			`UPDATE Album SET releaseMbid = COALESCE(releaseMbid, ?), releaseGroup = COALESCE(releaseGroup, ?), year = COALESCE(year, NULLIF(?, 0))
+			WHERE id = ?`,
+			match.Release, match.ReleaseGroup, match.Year, albumID.Int64,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	for _, artist := range match.Artists {
+		_, err := tx.ExecContext(
+			ctx,
+			`UPDATE Person SET mbid = ? WHERE mbid IS NULL AND name = ? AND id IN (SELECT person FROM Track_Person WHERE track = ?)`,
+			artist.ID, artist.Name, trackID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Look up each track as it is stored, until the context is cancelled.
+// Lookups are rate limited, so they are done in the background rather than while storing.
+func (mb *MusicBrainz) Run(ctx context.Context, db *sql.DB, events *EventHub) {
+	subscription, unsubscribe := events.Subscribe()
+	defer unsubscribe()
+	for {
+		select {
+		case e, ok := <-subscription:
+			if !ok {
+				return
+			}
+			if e.Type != EventScrobble {
+				continue
+			}
+			if err := mb.Enrich(ctx, db, e.Track); err != nil && ctx.Err() == nil {
+				slog.WarnContext(ctx, "Unable to look up track on MusicBrainz", "Title", e.Track.Title, "Error", err)
+				continue
+			}
+			if mb.Covers == nil {
+				continue
+			}
+			if err := mb.Covers.FetchTrack(ctx, db, e.Track); err != nil && ctx.Err() == nil {
+				slog.WarnContext(ctx, "Unable to download album cover", "Album", e.Track.Album, "Error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Names are compared exactly, as in SQLite, rather than ignoring case and accents as the default collation does
+const mysqlTableOptions = "ENGINE = InnoDB DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin"
+
+var mysqlDialect = sqlDialect{
+	name: "MySQL",
+	schema: []string{
+		// Titles and artists together are too long for a unique index, so the index is on their hash, as with tracks
+		`CREATE TABLE IF NOT EXISTS Album (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY, title VARCHAR(768) NOT NULL, artistKey TEXT NOT NULL,
+			titleArtists BINARY(32) AS (UNHEX(SHA2(CONCAT(title, CHAR(0), artistKey), 256))) STORED,
+			UNIQUE (titleArtists)
+		) ` + mysqlTableOptions,
+		// URLs and titles together are too long for a unique index, so the index is on their hash
+		`CREATE TABLE IF NOT EXISTS Track (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY, title TEXT NOT NULL, trackId TEXT, url TEXT NOT NULL, album BIGINT,
+			urlTitle BINARY(32) AS (UNHEX(SHA2(CONCAT(url, CHAR(0), title), 256))) STORED,
+			UNIQUE (urlTitle), FOREIGN KEY (album) REFERENCES Album (id)
+		) ` + mysqlTableOptions,
+		"CREATE TABLE IF NOT EXISTS Person (id BIGINT AUTO_INCREMENT PRIMARY KEY, name VARCHAR(768) NOT NULL, UNIQUE (name)) " + mysqlTableOptions,
+		`CREATE TABLE IF NOT EXISTS Track_Person (
+			track BIGINT NOT NULL, person BIGINT NOT NULL, PRIMARY KEY (track, person),
+			FOREIGN KEY (track) REFERENCES Track (id), FOREIGN KEY (person) REFERENCES Person (id)
+		) ` + mysqlTableOptions,
+		"CREATE TABLE IF NOT EXISTS Genre (id BIGINT AUTO_INCREMENT PRIMARY KEY, name VARCHAR(768) NOT NULL, UNIQUE (name)) " + mysqlTableOptions,
+		`CREATE TABLE IF NOT EXISTS Track_Genre (
+			track BIGINT NOT NULL, genre BIGINT NOT NULL, PRIMARY KEY (track, genre),
+			FOREIGN KEY (track) REFERENCES Track (id), FOREIGN KEY (genre) REFERENCES Genre (id)
+		) ` + mysqlTableOptions,
+		// Times are in UTC, since DATETIME has no zone; the driver must be opened with parseTime and loc=UTC
+		`CREATE TABLE IF NOT EXISTS TrackLog (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY, track BIGINT NOT NULL, timestamp DATETIME(6) NOT NULL, guest VARCHAR(255),
+			INDEX (timestamp), INDEX (track, timestamp), FOREIGN KEY (track) REFERENCES Track (id)
+		) ` + mysqlTableOptions,
+	},
+	columns: []sqlColumn{
+		// Named as in the PostgreSQL database, where user is reserved
+		{"TrackLog", "userName", "VARCHAR(255)", nil},
+		// The host name of the machine the play was stored on and its device label, as in the SQLite database
+		{"TrackLog", "host", "VARCHAR(255)", nil},
+		{"TrackLog", "device", "VARCHAR(255)", nil},
+		// Albums stored before they were told apart by their artists keep an empty key
+		{"Album", "artistKey", "TEXT NOT NULL", []string{
+			"ALTER TABLE Album ADD COLUMN titleArtists BINARY(32) AS (UNHEX(SHA2(CONCAT(title, CHAR(0), artistKey), 256))) STORED",
+			"ALTER TABLE Album ADD UNIQUE (titleArtists)",
+			"ALTER TABLE Album DROP INDEX title",
+		}},
+	},
+	currentSchema: "DATABASE()",
+	bind: func(query string) string {
+		return query
+	},
+	ignoreConflict: func(key ...string) string {
+		// Unlike INSERT IGNORE, this does not hide other errors
+		return fmt.Sprintf("ON DUPLICATE KEY UPDATE %s = %[1]s", key[0])
+	},
+	insertID: func(ctx context.Context, tx *sql.Tx, query string, key []string, args ...any) (int64, error) {
+		// Setting LAST_INSERT_ID makes the existing row's ID the insert ID
+		res, err := tx.ExecContext(ctx, query+" ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id)", args...)
+		if err != nil {
+			return 0, err
+		}
+		return res.LastInsertId()
+	},
+	aggregate: func(expr, sep string) string {
+		return fmt.Sprintf("GROUP_CONCAT(%s SEPARATOR %s)", expr, sep)
+	},
+}
+
+// Open a store in the MySQL or MariaDB database, creating its tables if they do not exist.
+// The store closes the database when it is closed.
+func NewMySQLStore(ctx context.Context, db *sql.DB) (*SQLStore, error) {
+	return newSQLStore(ctx, db, &mysqlDialect)
+}
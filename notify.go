@@ -0,0 +1,317 @@
+package music_watch
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	dbus "github.com/godbus/dbus/v5"
+)
+
+var ErrNotify = errors.New("invalid notification settings")
+
+// Things that messages can be sent about
+const (
+	NotifyNewAlbum     = "newAlbum"     // The first play of an album
+	NotifyDailySummary = "dailySummary" // The plays of the last day, sent on SummarySchedule
+	NotifyErrors       = "errors"       // Errors logged by the watcher
+)
+
+// Errors are collected for this long before being sent, so that a failing player does not flood the chat
+const notifyErrorInterval = 10 * time.Minute
+
+// Settings for sending messages to a chat
+type NotifyConfig struct {
+	Matrix   MatrixConfig   `json:"matrix"`
+	Telegram TelegramConfig `json:"telegram"`
+	// What to send messages about: newAlbum, dailySummary and errors
+	Events []string `json:"events"`
+	// When to send the daily summary, as a cron expression; defaults to 21:00
+	SummarySchedule string `json:"summarySchedule"`
+}
+
+// A Matrix room to send messages to
+type MatrixConfig struct {
+	// The homeserver's URL, e.g. https://matrix.org
+	Homeserver string `json:"homeserver"`
+	// The room's ID, e.g. !abcdef:matrix.org; the account must already have joined it
+	Room string `json:"room"`
+	// The account's access token
+	Token string `json:"token"`
+	// If Token is empty, the attributes of the token's entry in the keyring
+	TokenKeyring map[string]string `json:"tokenKeyring"`
+}
+
+// A Telegram chat to send messages to
+type TelegramConfig struct {
+	// The bot's token from BotFather
+	Token string `json:"token"`
+	// If Token is empty, the attributes of the token's entry in the keyring
+	TokenKeyring map[string]string `json:"tokenKeyring"`
+	// The chat's ID, or @name for channels
+	ChatID string `json:"chatId"`
+	// The Bot API server; defaults to https://api.telegram.org
+	API string `json:"api"`
+}
+
+// A chat that messages can be sent to
+type chat interface {
+	send(ctx context.Context, text string) error
+}
+
+// Sends messages about plays and problems to Matrix and Telegram
+type Notifier struct {
+	config NotifyConfig
+	db     *sql.DB
+	chats  []chat
+}
+
+// Set up the chats in the configuration, reading their tokens from the keyring if needed.
+// conn is only used for the keyring and may be nil.
+// Returns nil if no chat is configured.
+func NewNotifier(config NotifyConfig, db *sql.DB, conn *dbus.Conn) (*Notifier, error) {
+	n := Notifier{config: config, db: db}
+	for _, event := range config.Events {
+		if event != NotifyNewAlbum && event != NotifyDailySummary && event != NotifyErrors {
+			return nil, fmt.Errorf("%w: unknown event %q", ErrNotify, event)
+		}
+	}
+	if len(config.Matrix.Room) > 0 {
+		token, err := configSecret(conn, config.Matrix.Token, config.Matrix.TokenKeyring)
+		if err != nil {
+			return nil, fmt.Errorf("matrix: %w", err)
+		}
+		if _, err := url.Parse(config.Matrix.Homeserver); err != nil || len(config.Matrix.Homeserver) == 0 {
+			return nil, fmt.Errorf("%w: matrix needs the homeserver's URL", ErrNotify)
+		}
+		n.chats = append(n.chats, &matrixChat{config: config.Matrix, token: token})
+	}
+	if len(config.Telegram.ChatID) > 0 {
+		token, err := configSecret(conn, config.Telegram.Token, config.Telegram.TokenKeyring)
+		if err != nil {
+			return nil, fmt.Errorf("telegram: %w", err)
+		}
+		api := strings.TrimSuffix(config.Telegram.API, "/")
+		if len(api) == 0 {
+			api = "https://api.telegram.org"
+		}
+		n.chats = append(n.chats, &telegramChat{api: api, chatID: config.Telegram.ChatID, token: token})
+	}
+	if len(n.chats) == 0 {
+		return nil, nil
+	}
+	return &n, nil
+}
+
+func (n *Notifier) enabled(event string) bool {
+	return slices.Contains(n.config.Events, event)
+}
+
+// Get a sink that sends a message when an album is played for the first time
+func (n *Notifier) Sink() Sink {
+	return newScrobbleSink("notify", n, 1)
+}
+
+func (n *Notifier) nowPlaying(context.Context, *Metadata) error {
+	return nil
+}
+
+func (n *Notifier) submit(ctx context.Context, plays []Event) error {
+	if !n.enabled(NotifyNewAlbum) {
+		return nil
+	}
+	for _, play := range plays {
+		if len(play.Track.Album) == 0 {
+			continue
+		}
+		// Plays stored after this one are left out, so that a retried message is still sent
+		var count int
+		err := n.db.QueryRowContext(
+			ctx,
+			`SELECT COUNT(*) FROM TrackLogAll l
+			JOIN Track t ON t.id = l.track
+			JOIN Album a ON a.id = t.album
+			WHERE a.title = ? AND l.guest IS NULL AND l.user IS ? AND l.timestamp <= ?`,
+			play.Track.Album, userArg(ctx), formatTimestamp(play.Time),
+		).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count != 1 {
+			continue
+		}
+		text := fmt.Sprintf("New album: %s", play.Track.Album)
+		if artists := play.Track.AlbumArtist; len(artists) > 0 {
+			text += " by " + strings.Join(artists, ", ")
+		} else if len(play.Track.Artist) > 0 {
+			text += " by " + strings.Join(play.Track.Artist, ", ")
+		}
+		if err := n.Send(ctx, text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Send a message to every chat
+func (n *Notifier) Send(ctx context.Context, text string) error {
+	var errs []error
+	for _, c := range n.chats {
+		errs = append(errs, c.send(ctx, text))
+	}
+	return errors.Join(errs...)
+}
+
+// Send a summary of the plays of the last day
+func (n *Notifier) SendSummary(ctx context.Context) error {
+	to := time.Now()
+	from := to.AddDate(0, 0, -1)
+	where, args := playConditions(ctx, from, to)
+	var plays int
+	if err := n.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM TrackLogAll l WHERE "+where, args...).Scan(&plays); err != nil {
+		return err
+	}
+	if plays == 0 {
+		return n.Send(ctx, "Nothing was played today.")
+	}
+	artists, err := GetTopItems(ctx, n.db, "artists", from, to, 3, 0)
+	if err != nil {
+		return err
+	}
+	text := fmt.Sprintf("%d plays today.", plays)
+	if len(artists) > 0 {
+		top := make([]string, len(artists))
+		for i, artist := range artists {
+			top[i] = fmt.Sprintf("%s (%d)", artist.Name, artist.Plays)
+		}
+		text += " Top artists: " + strings.Join(top, ", ")
+	}
+	return n.Send(ctx, text)
+}
+
+// Register the notify-summary task, and schedule it if daily summaries are enabled
+func RegisterNotifyTasks(s *Scheduler, n *Notifier) error {
+	s.Register("notify-summary", n.SendSummary)
+	if !n.enabled(NotifyDailySummary) {
+		return nil
+	}
+	schedule := n.config.SummarySchedule
+	if len(schedule) == 0 {
+		schedule = "0 21 * * *"
+	}
+	return s.Schedule("notify-summary", schedule)
+}
+
+// Send the errors logged by the watcher until the context is cancelled, if enabled.
+// Errors are sent in batches at most every notifyErrorInterval.
+func (n *Notifier) WatchErrors(ctx context.Context) {
+	if !n.enabled(NotifyErrors) {
+		return
+	}
+	errs := make(chan DiagEvent, diagnosticsSize)
+	stop := watchErrors(func(e DiagEvent) {
+		select {
+		case errs <- e:
+		default:
+		}
+	})
+	defer stop()
+	var pending []string
+	var lastSent time.Time
+	timer := time.NewTimer(0)
+	<-timer.C
+	for {
+		select {
+		case e := <-errs:
+			pending = append(pending, e.Message)
+			if len(pending) == 1 {
+				timer.Reset(max(time.Until(lastSent.Add(notifyErrorInterval)), 0))
+			}
+		case <-timer.C:
+			text := "The watcher logged an error: " + pending[0]
+			if len(pending) > 1 {
+				text = fmt.Sprintf("The watcher logged %d errors:\n%s", len(pending), strings.Join(pending, "\n"))
+			}
+			// Logged as a warning, as an error would be sent again
+			if err := n.Send(ctx, text); err != nil {
+				slog.WarnContext(ctx, "Unable to send errors", "Error", err)
+			}
+			pending = nil
+			lastSent = time.Now()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+type matrixChat struct {
+	config MatrixConfig
+	token  string
+}
+
+// Makes the IDs of messages sent in the same instant unique
+var matrixTransaction atomic.Uint64
+
+func (m *matrixChat) send(ctx context.Context, text string) error {
+	body, err := json.Marshal(map[string]string{"msgtype": "m.notice", "body": text})
+	if err != nil {
+		return err
+	}
+	txn := fmt.Sprintf("music-watcher-%d-%d", time.Now().UnixNano(), matrixTransaction.Add(1))
+	endpoint := strings.TrimSuffix(m.config.Homeserver, "/") + "/_matrix/client/v3/rooms/" +
+		url.PathEscape(m.config.Room) + "/send/m.room.message/" + txn
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+m.token)
+	return sendChatRequest("Matrix", req)
+}
+
+type telegramChat struct {
+	api    string
+	chatID string
+	token  string
+}
+
+func (t *telegramChat) send(ctx context.Context, text string) error {
+	body, err := json.Marshal(map[string]string{"chat_id": t.chatID, "text": text})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.api+"/bot"+t.token+"/sendMessage", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return sendChatRequest("Telegram", req)
+}
+
+func sendChatRequest(service string, req *http.Request) error {
+	req.Header.Set("User-Agent", "music-watcher")
+	resp, err := scrobbleClient.Do(req)
+	if err != nil {
+		// The URL of Telegram's API holds the token
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return httpStatusError(service, resp)
+	}
+	return nil
+}
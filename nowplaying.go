@@ -0,0 +1,121 @@
+package music_watch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+var ErrInvalidNowPlayingFile = errors.New("invalid now playing file")
+
+// Used when no template is configured
+const (
+	defaultNowPlayingText = `{{with .}}{{with .Artist}}{{join . ", "}} - {{end}}{{.Title}}{{end}}`
+	defaultNowPlayingJSON = `{{json .}}`
+)
+
+// Settings for writing the current track to a file, such as for a streaming overlay
+type NowPlayingFileConfig struct {
+	// Where to write the track; empty disables
+	Path string `json:"path"`
+	// A text/template executed with the Metadata of the current track, or nil when nothing is playing.
+	// The json function encodes a value as JSON, and join joins a list.
+	// Empty writes "Artist - Title", or the track as JSON if the path ends in .json.
+	Template string `json:"template"`
+}
+
+// Keeps a file up to date with the current track. The file is replaced rather than
+// rewritten, so programs reading it never see it partially written.
+type NowPlayingFile struct {
+	Config NowPlayingFileConfig
+
+	template *template.Template
+	lock     sync.Mutex
+	current  *Metadata
+}
+
+// Parse the file's template, so that mistakes are reported at startup
+func NewNowPlayingFile(config NowPlayingFileConfig) (*NowPlayingFile, error) {
+	text := config.Template
+	if len(text) == 0 {
+		text = defaultNowPlayingText
+		if strings.EqualFold(filepath.Ext(config.Path), ".json") {
+			text = defaultNowPlayingJSON
+		}
+	}
+	t, err := template.New(filepath.Base(config.Path)).
+		Funcs(template.FuncMap{"json": hookJSON, "join": strings.Join}).
+		Parse(text)
+	if err != nil {
+		return nil, errors.Join(ErrInvalidNowPlayingFile, err)
+	}
+	return &NowPlayingFile{Config: config, template: t}, nil
+}
+
+func (f *NowPlayingFile) Name() string {
+	return "nowplaying-file"
+}
+
+// Start with nothing playing, rather than whatever was playing when the watcher last ran
+func (f *NowPlayingFile) Init(ctx context.Context) error {
+	return f.write(nil)
+}
+
+func (f *NowPlayingFile) Store(ctx context.Context, e Event) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	switch e.Type {
+	case EventNowPlaying, EventResumed:
+		f.current = e.Track
+		return f.write(e.Track)
+	case EventPaused, EventPlayFinished:
+		// Another player stopping leaves the current track in place
+		if f.current != nil && f.current.Player == e.Track.Player && f.current.IsSameTrack(e.Track) {
+			f.current = nil
+			return f.write(nil)
+		}
+	}
+	return nil
+}
+
+// Replace the file with the template executed for the track
+func (f *NowPlayingFile) write(track *Metadata) error {
+	var buf bytes.Buffer
+	if err := f.template.Execute(&buf, track); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.Config.Path), "."+filepath.Base(f.Config.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.Config.Path)
+}
+
+func (f *NowPlayingFile) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Nothing is playing once the watcher stops
+func (f *NowPlayingFile) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.current = nil
+	return f.write(nil)
+}
@@ -0,0 +1,170 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// Plays from past years can be moved out of TrackLog into a table for each year, TrackLog_<year>,
+// so that the table that new plays are written to and its indexes stay small.
+// Queries that read plays use the TrackLogAll view, which includes every partition but not deleted plays.
+const trackLogView = "TrackLogAll"
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// Get the yearly partitions of TrackLog, oldest first
+func trackLogPartitions(ctx context.Context, q querier) ([]string, error) {
+	return queryStrings(ctx, q, "SELECT name FROM sqlite_master WHERE type = 'table' AND name GLOB 'TrackLog_[0-9][0-9][0-9][0-9]' ORDER BY name")
+}
+
+// Get TrackLog and its partitions, for statements that change plays
+func trackLogTables(ctx context.Context, q querier) ([]string, error) {
+	partitions, err := trackLogPartitions(ctx, q)
+	return append([]string{"TrackLog"}, partitions...), err
+}
+
+func queryStrings(ctx context.Context, q querier, query string, args ...any) ([]string, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// Recreate the view of every play, so that it includes new partitions and columns
+func createTrackLogView(ctx context.Context, tx *sql.Tx) error {
+	tables, err := trackLogTables(ctx, tx)
+	if err != nil {
+		return err
+	}
+	// Columns are listed so that partitions created before a column was added still line up
+	columns, err := queryStrings(ctx, tx, "SELECT name FROM pragma_table_info('TrackLog') ORDER BY cid")
+	if err != nil {
+		return err
+	}
+	selects := make([]string, len(tables))
+	for i, table := range tables {
+		selects[i] = fmt.Sprintf("SELECT %s FROM %s WHERE deleted IS NULL", strings.Join(columns, ", "), table)
+	}
+	for _, stmt := range []string{
+		"DROP VIEW IF EXISTS " + trackLogView,
+		fmt.Sprintf("CREATE VIEW %s AS %s", trackLogView, strings.Join(selects, " UNION ALL ")),
+	} {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Create the partition for the year with the same columns and indexes as TrackLog
+func createTrackLogPartition(ctx context.Context, tx *sql.Tx, year string) (string, error) {
+	partition := "TrackLog_" + year
+	var exists bool
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = ?", partition).Scan(&exists); err != nil {
+		return "", err
+	}
+	if exists {
+		return partition, nil
+	}
+	rows, err := tx.QueryContext(ctx, "SELECT type, name, sql FROM sqlite_master WHERE tbl_name = 'TrackLog' AND sql IS NOT NULL ORDER BY type = 'index'")
+	if err != nil {
+		return "", err
+	}
+	var stmts []string
+	for rows.Next() {
+		var kind, name, stmt string
+		if err := rows.Scan(&kind, &name, &stmt); err != nil {
+			rows.Close()
+			return "", err
+		}
+		if kind == "index" {
+			stmt = strings.Replace(stmt, " "+name+" ON TrackLog", fmt.Sprintf(" %s_%s ON %s", name, year, partition), 1)
+		} else {
+			stmt = strings.Replace(stmt, "TABLE TrackLog", "TABLE "+partition, 1)
+		}
+		stmts = append(stmts, stmt)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return "", err
+		}
+	}
+	return partition, nil
+}
+
+// Move plays from before the year of the latest play into yearly partitions,
+// returning the number of plays moved.
+// The play with the highest ID always stays in TrackLog, even when it is from an earlier year,
+// since SQLite gives new plays the ID after the highest one in the table and would otherwise reuse IDs.
+func PartitionTrackLog(ctx context.Context, db *sql.DB) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	years, err := queryStrings(
+		ctx,
+		tx,
+		`SELECT DISTINCT strftime('%Y', timestamp) FROM TrackLog
+		WHERE timestamp < (SELECT strftime('%Y', MAX(timestamp)) || '-01-01' FROM TrackLog)
+			AND id != (SELECT MAX(id) FROM TrackLog)
+		ORDER BY 1`,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var moved int64
+	for _, year := range years {
+		partition, err := createTrackLogPartition(ctx, tx, year)
+		if err != nil {
+			return moved, err
+		}
+		columns, err := queryStrings(ctx, tx, "SELECT name FROM pragma_table_info('TrackLog') ORDER BY cid")
+		if err != nil {
+			return moved, err
+		}
+		list := strings.Join(columns, ", ")
+		if _, err := tx.ExecContext(
+			ctx,
+			fmt.Sprintf(
+				"INSERT INTO %s (%s) SELECT %s FROM TrackLog WHERE strftime('%%Y', timestamp) = ? AND id != (SELECT MAX(id) FROM TrackLog)",
+				partition,
+				list,
+				list,
+			),
+			year,
+		); err != nil {
+			return moved, err
+		}
+		res, err := tx.ExecContext(ctx, "DELETE FROM TrackLog WHERE strftime('%Y', timestamp) = ? AND id != (SELECT MAX(id) FROM TrackLog)", year)
+		if err != nil {
+			return moved, err
+		}
+		count, _ := res.RowsAffected()
+		moved += count
+		slog.InfoContext(ctx, "Moved plays into partition", "Partition", partition, "Plays", count)
+	}
+	if err := createTrackLogView(ctx, tx); err != nil {
+		return moved, err
+	}
+	return moved, tx.Commit()
+}
@@ -0,0 +1,179 @@
+package music_watch
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Listening time of the track currently loaded in a player.
+// Times are taken from time.Now, so differences use the monotonic clock;
+// on Linux it does not advance while the system is suspended,
+// and wall-clock changes do not affect durations.
+type playTimer struct {
+	track     *Metadata
+	started   time.Time // When playback last started; zero while paused
+	played    time.Duration
+	suspended bool // Was playing when the system went to sleep
+	// The position in the track when it was last reported by the player,
+	// and the value of played at that time
+	position       time.Duration
+	positionPlayed time.Duration
+}
+
+// Sources may run concurrently, so the timers are shared under a lock
+var timerLock sync.Mutex
+var nameToTimer = make(map[string]*playTimer)
+
+// Begin timing a new track on the player, finishing the previous one.
+// position is where playback of the new track starts.
+func startPlay(ctx context.Context, events chan<- Event, name string, track *Metadata, playing bool, position time.Duration) {
+	timerLock.Lock()
+	finished := takeFinished(ctx, name)
+	timer := playTimer{track: track, position: position}
+	if playing {
+		timer.started = time.Now()
+	}
+	nameToTimer[name] = &timer
+	timerLock.Unlock()
+	sendPlayEvent(events, finished)
+}
+
+// Record that the player started or stopped playing, reporting the change if there was one
+func setPlaying(ctx context.Context, events chan<- Event, name string, playing bool) {
+	timerLock.Lock()
+	timer, ok := nameToTimer[name]
+	if !ok {
+		timerLock.Unlock()
+		return
+	}
+	timer.suspended = false
+	var changed *Event
+	if playing && timer.started.IsZero() {
+		timer.started = time.Now()
+		changed = &Event{Type: EventResumed}
+	} else if !playing && !timer.started.IsZero() {
+		timer.pause()
+		changed = &Event{Type: EventPaused}
+	}
+	if changed != nil {
+		changed.Time = time.Now()
+		changed.Track = timer.track
+		changed.Played = timer.elapsed().Microseconds()
+		changed.Position = timer.currentPosition().Microseconds()
+	}
+	timerLock.Unlock()
+	sendPlayEvent(events, changed)
+}
+
+// Record that the player jumped to a new position in the track
+func seekPlay(name string, position time.Duration) {
+	timerLock.Lock()
+	defer timerLock.Unlock()
+	timer, ok := nameToTimer[name]
+	if !ok {
+		return
+	}
+	timer.position = position
+	timer.positionPlayed = timer.elapsed()
+}
+
+// Stop timing the player's current track, and report how long it was played
+func finishPlay(ctx context.Context, events chan<- Event, name string) {
+	timerLock.Lock()
+	finished := takeFinished(ctx, name)
+	timerLock.Unlock()
+	sendPlayEvent(events, finished)
+}
+
+// Finish the plays of every player whose name starts with prefix, such as when a source is stopping
+func finishAllPlays(ctx context.Context, events chan<- Event, prefix string) {
+	timerLock.Lock()
+	var finished []*Event
+	for name := range nameToTimer {
+		if strings.HasPrefix(name, prefix) {
+			finished = append(finished, takeFinished(ctx, name))
+		}
+	}
+	timerLock.Unlock()
+	for _, e := range finished {
+		sendPlayEvent(events, e)
+	}
+}
+
+// Remove the player's timer, returning the event for the finished play if there is one.
+// Must be called with the lock held, and the event sent with sendPlayEvent once it is released.
+func takeFinished(ctx context.Context, name string) *Event {
+	timer, ok := nameToTimer[name]
+	if !ok {
+		return nil
+	}
+	delete(nameToTimer, name)
+	timer.pause()
+	slog.DebugContext(ctx, "Finished play", "Name", name, "Title", timer.track.Title, "Played", timer.played)
+	if len(timer.track.Title) == 0 && len(timer.track.Url) == 0 {
+		return nil
+	}
+	return &Event{
+		Type:     EventPlayFinished,
+		Time:     time.Now(),
+		Track:    timer.track,
+		Played:   timer.played.Microseconds(),
+		Position: timer.currentPosition().Microseconds(),
+	}
+}
+
+func sendPlayEvent(events chan<- Event, e *Event) {
+	if e != nil {
+		// The watcher keeps receiving until the source returns, so this also works while shutting down
+		events <- *e
+	}
+}
+
+// Close out every playing track before the system sleeps
+func suspendPlays(ctx context.Context) {
+	timerLock.Lock()
+	defer timerLock.Unlock()
+	for name, timer := range nameToTimer {
+		if !timer.started.IsZero() {
+			timer.pause()
+			timer.suspended = true
+			slog.DebugContext(ctx, "Paused play for suspend", "Name", name, "Title", timer.track.Title, "Played", timer.played)
+		}
+	}
+}
+
+// Resume timing the tracks that were playing when the system went to sleep
+func resumePlays(ctx context.Context) {
+	timerLock.Lock()
+	defer timerLock.Unlock()
+	for name, timer := range nameToTimer {
+		if timer.suspended {
+			timer.suspended = false
+			timer.started = time.Now()
+			slog.DebugContext(ctx, "Resumed play after suspend", "Name", name, "Title", timer.track.Title)
+		}
+	}
+}
+
+func (t *playTimer) pause() {
+	if !t.started.IsZero() {
+		t.played += time.Since(t.started)
+		t.started = time.Time{}
+	}
+}
+
+// Get the time played so far, including the current stretch of playback
+func (t *playTimer) elapsed() time.Duration {
+	if t.started.IsZero() {
+		return t.played
+	}
+	return t.played + time.Since(t.started)
+}
+
+// Estimate the current position in the track from the last reported position
+func (t *playTimer) currentPosition() time.Duration {
+	return t.position + t.elapsed() - t.positionPlayed
+}
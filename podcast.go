@@ -0,0 +1,106 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// What is done with plays of podcasts
+const (
+	PodcastKeep     = ""         // Logged as music
+	PodcastExclude  = "exclude"  // Not logged
+	PodcastSeparate = "separate" // Logged in PodcastLog instead of TrackLog
+)
+
+// Settings for telling podcast episodes apart from music
+type PodcastConfig struct {
+	// keep, exclude or separate; defaults to keeping podcasts in the history
+	Mode string `json:"mode"`
+	// Players that only play podcasts; defaults to gPodder, Kasts and GNOME Podcasts
+	Players []string `json:"players"`
+	// Domains of podcast hosts, added to a list of common hosts
+	Domains []string `json:"domains"`
+	// Tracks at least this many minutes long are podcasts; 0 disables
+	MinMinutes int `json:"minMinutes"`
+}
+
+var defaultPodcastPlayers = []string{"gpodder", "kasts", "gnome.podcasts"}
+
+// Hosts that serve podcast episodes
+var podcastDomains = []string{
+	"acast.com", "anchor.fm", "art19.com", "buzzsprout.com", "captivate.fm", "libsyn.com", "megaphone.fm",
+	"omny.fm", "podbean.com", "podtrac.com", "simplecast.com", "spreaker.com", "transistor.fm",
+}
+
+func (c PodcastConfig) Validate() error {
+	switch c.Mode {
+	case PodcastKeep, PodcastExclude, PodcastSeparate:
+		return nil
+	}
+	return fmt.Errorf("unknown podcast mode %q", c.Mode)
+}
+
+// Get whether the track looks like a podcast episode
+func (c PodcastConfig) IsPodcast(m *Metadata) bool {
+	players := c.Players
+	if players == nil {
+		players = defaultPodcastPlayers
+	}
+	if matchesPlayer(players, m.Player) {
+		return true
+	}
+	if slices.ContainsFunc(m.Genre, func(genre string) bool { return strings.Contains(strings.ToLower(genre), "podcast") }) {
+		return true
+	}
+	// Spotify's MPRIS track IDs and URLs say whether the track is an episode
+	if strings.Contains(m.TrackId, "/episode/") || strings.Contains(m.Url, "open.spotify.com/episode/") {
+		return true
+	}
+	if u, err := url.Parse(m.Url); err == nil && len(u.Hostname()) > 0 {
+		host := strings.ToLower(u.Hostname())
+		for _, domain := range append(podcastDomains, c.Domains...) {
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		}
+	}
+	return c.MinMinutes > 0 && time.Duration(m.Length)*time.Microsecond >= time.Duration(c.MinMinutes)*time.Minute
+}
+
+// Store a play of a podcast in PodcastLog instead of the music history
+func StorePodcast(ctx context.Context, m *Metadata, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	track, err := storeTrack(ctx, tx, m, nil)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO PodcastLog (track, timestamp) VALUES (?, ?)", track, formatTimestamp(playedAt(ctx)))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Apply the podcast mode to the track before it is stored.
+// Returns ErrFiltered if the track is a podcast that is left out of the history or was stored separately.
+func (c PodcastConfig) Apply(ctx context.Context, m *Metadata, db *sql.DB) error {
+	if c.Mode == PodcastKeep || !c.IsPodcast(m) {
+		return nil
+	}
+	if c.Mode == PodcastSeparate {
+		if err := StorePodcast(ctx, m, db); err != nil {
+			return err
+		}
+		return filtered("podcast stored separately")
+	}
+	return filtered("podcast")
+}
@@ -0,0 +1,284 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	dbus "github.com/godbus/dbus/v5"
+)
+
+var ErrInvalidPolicy = errors.New("invalid player policy")
+
+// Whether plays from a player are logged
+type PlayerPolicy string
+
+const (
+	PolicyAlways  PlayerPolicy = "always"
+	PolicyNever   PlayerPolicy = "never"
+	PolicySession PlayerPolicy = "session" // Log plays until the watcher restarts, then ask again
+)
+
+// Settings for asking before logging plays from players that have not been seen before
+type ConsentConfig struct {
+	// Hold plays from new players until a policy is chosen for them
+	Enabled bool `json:"enabled"`
+	// Ask with a desktop notification when a new player appears
+	Notify bool `json:"notify"`
+}
+
+// The most plays held for a player while waiting for a decision
+const maxPendingPlays = 500
+
+// MPRIS players with several instances, e.g. Firefox, add a suffix to their name
+var instanceSuffix = regexp.MustCompile(`\.instance_?\d+(_\d+)?$`)
+
+// Get the name policies are stored under for a player,
+// which is the same for every instance of the player
+func PlayerKey(player string) string {
+	player = strings.TrimPrefix(player, "org.mpris.MediaPlayer2.")
+	return instanceSuffix.ReplaceAllString(player, "")
+}
+
+type pendingPlay struct {
+	track *Metadata
+	time  time.Time
+}
+
+// Decides whether plays from each player may be logged, holding them until the user decides
+type Consent struct {
+	db      *sql.DB
+	lock    sync.Mutex
+	session map[string]PlayerPolicy // Decisions that only last until the watcher restarts
+	pending map[string][]pendingPlay
+	// Called when a player's first play is held; must not block
+	OnPending func(player string)
+}
+
+func NewConsent(db *sql.DB) *Consent {
+	return &Consent{db: db, session: make(map[string]PlayerPolicy), pending: make(map[string][]pendingPlay)}
+}
+
+// Check whether the track may be stored now. If the player has no policy, the play is held.
+func (c *Consent) Allowed(ctx context.Context, m *Metadata) (bool, error) {
+	key := PlayerKey(m.Player)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	policy, ok := c.session[key]
+	if !ok {
+		var err error
+		policy, err = getPlayerPolicy(ctx, c.db, key)
+		if err != nil {
+			return false, err
+		}
+	}
+	switch policy {
+	case PolicyAlways, PolicySession:
+		return true, nil
+	case PolicyNever:
+		slog.DebugContext(ctx, "Player is never logged, not storing track", "Title", m.Title, "Player", key)
+		return false, nil
+	}
+	plays := c.pending[key]
+	if len(plays) >= maxPendingPlays {
+		recordDropped("Too many pending plays", "Player", key)
+		plays = plays[1:]
+	}
+	c.pending[key] = append(plays, pendingPlay{track: m, time: time.Now()})
+	slog.InfoContext(ctx, "Holding play from new player until a policy is chosen", "Title", m.Title, "Player", key)
+	if len(plays) == 0 && c.OnPending != nil {
+		c.OnPending(key)
+	}
+	return false, nil
+}
+
+// Get whether the player's plays are logged without being held
+func (c *Consent) Known(ctx context.Context, player string) bool {
+	key := PlayerKey(player)
+	c.lock.Lock()
+	policy, ok := c.session[key]
+	c.lock.Unlock()
+	if !ok {
+		var err error
+		if policy, err = getPlayerPolicy(ctx, c.db, key); err != nil {
+			return false
+		}
+	}
+	return policy == PolicyAlways || policy == PolicySession
+}
+
+// Set the player's policy, returning the held plays that should now be stored with their times
+func (c *Consent) Decide(ctx context.Context, player string, policy PlayerPolicy) ([]pendingPlay, error) {
+	key := PlayerKey(player)
+	switch policy {
+	case PolicyAlways, PolicyNever:
+		if err := setPlayerPolicy(ctx, c.db, key, policy); err != nil {
+			return nil, err
+		}
+	case PolicySession:
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidPolicy, policy)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.session[key] = policy
+	plays := c.pending[key]
+	delete(c.pending, key)
+	slog.InfoContext(ctx, "Set player policy", "Player", key, "Policy", policy, "Pending", len(plays))
+	if policy == PolicyNever {
+		return nil, nil
+	}
+	return plays, nil
+}
+
+// Get the number of plays held for each player waiting for a decision
+func (c *Consent) Pending() map[string]uint32 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	counts := make(map[string]uint32, len(c.pending))
+	for key, plays := range c.pending {
+		counts[key] = uint32(len(plays))
+	}
+	return counts
+}
+
+func getPlayerPolicy(ctx context.Context, db *sql.DB, key string) (PlayerPolicy, error) {
+	var policy PlayerPolicy
+	err := db.QueryRowContext(ctx, "SELECT policy FROM PlayerPolicy WHERE player = ?", key).Scan(&policy)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return policy, err
+}
+
+func setPlayerPolicy(ctx context.Context, db *sql.DB, key string, policy PlayerPolicy) error {
+	_, err := db.ExecContext(
+		ctx,
+		"INSERT INTO PlayerPolicy (player, policy, updated) VALUES (?, ?, ?) ON CONFLICT (player) DO UPDATE SET policy = excluded.policy, updated = excluded.updated",
+		key,
+		policy,
+		time.Now().Format(time.DateTime),
+	)
+	return err
+}
+
+// Store a player's policy without a running watcher; session policies need the watcher
+func SetPlayerPolicy(ctx context.Context, db *sql.DB, player string, policy PlayerPolicy) error {
+	if policy != PolicyAlways && policy != PolicyNever {
+		return fmt.Errorf("%w: %q", ErrInvalidPolicy, policy)
+	}
+	return setPlayerPolicy(ctx, db, PlayerKey(player), policy)
+}
+
+// A player's remembered policy
+type PlayerPolicyEntry struct {
+	Player  string       `json:"player"`
+	Policy  PlayerPolicy `json:"policy"`
+	Updated string       `json:"updated"`
+}
+
+func GetPlayerPolicies(ctx context.Context, db *sql.DB) ([]PlayerPolicyEntry, error) {
+	rows, err := db.QueryContext(ctx, "SELECT player, policy, updated FROM PlayerPolicy ORDER BY player")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []PlayerPolicyEntry
+	for rows.Next() {
+		var e PlayerPolicyEntry
+		if err := rows.Scan(&e.Player, &e.Policy, &e.Updated); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Asks whether to log new players with desktop notifications
+type ConsentPrompter struct {
+	Conn    *dbus.Conn
+	lock    sync.Mutex
+	players map[uint32]string // Notification IDs to the player they ask about
+}
+
+const notificationsName = "org.freedesktop.Notifications"
+const notificationsPath = "/org/freedesktop/Notifications"
+
+// Show a notification asking about the player
+func (p *ConsentPrompter) Prompt(player string) error {
+	var id uint32
+	err := p.Conn.Object(notificationsName, notificationsPath).Call(
+		notificationsName+".Notify",
+		0,
+		"music-watcher",
+		uint32(0),
+		"audio-x-generic",
+		"New player: "+player,
+		"Should plays from this player be logged?",
+		[]string{
+			string(PolicyAlways), "Always",
+			string(PolicySession), "This session",
+			string(PolicyNever), "Never",
+		},
+		map[string]dbus.Variant{"resident": dbus.MakeVariant(true)},
+		int32(0), // Wait for an answer
+	).Store(&id)
+	if err != nil {
+		return err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.players == nil {
+		p.players = make(map[uint32]string)
+	}
+	p.players[id] = player
+	return nil
+}
+
+// Pass the answers to prompts to decide until the context is cancelled
+func (p *ConsentPrompter) Run(ctx context.Context, decide func(ctx context.Context, player string, policy PlayerPolicy) error) error {
+	if err := p.Conn.AddMatchSignalContext(
+		ctx,
+		dbus.WithMatchObjectPath(notificationsPath),
+		dbus.WithMatchInterface(notificationsName),
+	); err != nil {
+		return err
+	}
+	signals := make(chan *dbus.Signal, 10)
+	p.Conn.Signal(signals)
+	defer p.Conn.RemoveSignal(signals)
+	for {
+		select {
+		case sig := <-signals:
+			if sig.Name != notificationsName+".ActionInvoked" && sig.Name != notificationsName+".NotificationClosed" {
+				continue
+			}
+			if len(sig.Body) != 2 {
+				continue
+			}
+			id, _ := sig.Body[0].(uint32)
+			p.lock.Lock()
+			player, ok := p.players[id]
+			delete(p.players, id)
+			p.lock.Unlock()
+			if !ok {
+				continue
+			}
+			// Closing the notification leaves the plays pending
+			if action, ok := sig.Body[1].(string); ok && slices.Contains([]PlayerPolicy{PolicyAlways, PolicySession, PolicyNever}, PlayerPolicy(action)) {
+				if err := decide(ctx, player, PlayerPolicy(action)); err != nil {
+					slog.ErrorContext(ctx, "Unable to set player policy", "Player", player, "Error", err)
+				}
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var postgresDialect = sqlDialect{
+	name: "PostgreSQL",
+	schema: []string{
+		"CREATE TABLE IF NOT EXISTS Album (id BIGSERIAL PRIMARY KEY, title TEXT NOT NULL, artistKey TEXT NOT NULL DEFAULT '', UNIQUE (title, artistKey))",
+		"CREATE TABLE IF NOT EXISTS Track (id BIGSERIAL PRIMARY KEY, title TEXT NOT NULL, trackId TEXT, url TEXT NOT NULL, album BIGINT REFERENCES Album (id), UNIQUE (url, title))",
+		"CREATE TABLE IF NOT EXISTS Person (id BIGSERIAL PRIMARY KEY, name TEXT NOT NULL UNIQUE)",
+		"CREATE TABLE IF NOT EXISTS Track_Person (track BIGINT NOT NULL REFERENCES Track (id), person BIGINT NOT NULL REFERENCES Person (id), PRIMARY KEY (track, person))",
+		"CREATE TABLE IF NOT EXISTS Genre (id BIGSERIAL PRIMARY KEY, name TEXT NOT NULL UNIQUE)",
+		"CREATE TABLE IF NOT EXISTS Track_Genre (track BIGINT NOT NULL REFERENCES Track (id), genre BIGINT NOT NULL REFERENCES Genre (id), PRIMARY KEY (track, genre))",
+		// Unlike the SQLite database, times are stored with their zone
+		"CREATE TABLE IF NOT EXISTS TrackLog (id BIGSERIAL PRIMARY KEY, track BIGINT NOT NULL REFERENCES Track (id), timestamp TIMESTAMPTZ NOT NULL, guest TEXT)",
+		"CREATE INDEX IF NOT EXISTS TrackLog_timestamp ON TrackLog (timestamp)",
+		"CREATE INDEX IF NOT EXISTS TrackLog_track ON TrackLog (track, timestamp)",
+	},
+	columns: []sqlColumn{
+		// user is reserved in PostgreSQL, so the column the SQLite database calls user is userName
+		{"TrackLog", "userName", "TEXT", nil},
+		// The host name of the machine the play was stored on and its device label, as in the SQLite database
+		{"TrackLog", "host", "TEXT", nil},
+		{"TrackLog", "device", "TEXT", nil},
+		// Albums stored before they were told apart by their artists keep an empty key
+		{"Album", "artistKey", "TEXT NOT NULL DEFAULT ''", []string{
+			"ALTER TABLE Album DROP CONSTRAINT IF EXISTS album_title_key",
+			"ALTER TABLE Album ADD UNIQUE (title, artistKey)",
+		}},
+	},
+	currentSchema: "current_schema()",
+	bind:          postgresBind,
+	ignoreConflict: func(key ...string) string {
+		return fmt.Sprintf("ON CONFLICT (%s) DO NOTHING", strings.Join(key, ", "))
+	},
+	insertID: func(ctx context.Context, tx *sql.Tx, query string, key []string, args ...any) (int64, error) {
+		// Updating the row with itself returns it, where DO NOTHING would return nothing
+		var id int64
+		err := tx.QueryRowContext(
+			ctx,
+			postgresBind(fmt.Sprintf("%s ON CONFLICT (%s) DO UPDATE SET %[3]s = EXCLUDED.%[3]s RETURNING id", query, strings.Join(key, ", "), key[0])),
+			args...,
+		).Scan(&id)
+		return id, err
+	},
+	aggregate: func(expr, sep string) string {
+		return fmt.Sprintf("string_agg(%s, %s)", expr, sep)
+	},
+}
+
+// Number the ? placeholders of the query as $1, $2, ...; the queries have no ? in their strings
+func postgresBind(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Open a store in the PostgreSQL database, creating its tables if they do not exist.
+// The store closes the database when it is closed.
+func NewPostgresStore(ctx context.Context, db *sql.DB) (*SQLStore, error) {
+	return newSQLStore(ctx, db, &postgresDialect)
+}
@@ -0,0 +1,128 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// Tracks at least this long are treated as podcasts or audiobooks by default
+const defaultLongFormMinutes = 20
+
+// Fraction of a track that must be reached for it to count as finished
+const completedFraction = 0.95
+
+// Settings for tracking progress through podcasts and audiobooks
+type ProgressConfig struct {
+	// Tracks at least this many minutes long are tracked; 0 uses the default
+	MinMinutes int `json:"minMinutes"`
+	// Players whose tracks are always tracked, such as "gPodder"
+	Players []string `json:"players"`
+}
+
+// The resume position of a long-form track
+type Progress struct {
+	Title      string
+	Album      string
+	Artists    string
+	Position   time.Duration
+	Length     time.Duration
+	Listened   time.Duration // Total time spent listening across sessions
+	Completion float64
+	Updated    string // In local time
+}
+
+func (p *Progress) Completed() bool {
+	return p.Completion >= completedFraction
+}
+
+// Update the resume position of a long-form track when a play of it finishes
+func RecordProgress(ctx context.Context, db *sql.DB, config ProgressConfig, e Event) error {
+	if e.Type != EventPlayFinished || !isLongForm(config, e.Track) {
+		return nil
+	}
+	var trackId int64
+	track, args := trackIDQuery(e.Track)
+	err := db.QueryRowContext(ctx, "SELECT id FROM Track WHERE id = "+track, args...).Scan(&trackId)
+	if err == sql.ErrNoRows {
+		// The track was never logged, e.g. because of private mode
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var completion float64
+	if e.Track.Length > 0 {
+		completion = min(max(float64(e.Position)/float64(e.Track.Length), 0), 1)
+	}
+	_, err = db.ExecContext(
+		ctx,
+		`INSERT INTO Progress (track, position, length, listened, completion, updated) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (track) DO UPDATE SET
+			position = excluded.position,
+			length = excluded.length,
+			listened = listened + excluded.listened,
+			completion = excluded.completion,
+			updated = excluded.updated`,
+		trackId,
+		e.Position,
+		e.Track.Length,
+		e.Played,
+		completion,
+		formatTimestamp(e.Time),
+	)
+	return err
+}
+
+func isLongForm(config ProgressConfig, m *Metadata) bool {
+	for _, player := range config.Players {
+		if strings.Contains(strings.ToLower(m.Player), strings.ToLower(player)) {
+			return true
+		}
+	}
+	minutes := config.MinMinutes
+	if minutes <= 0 {
+		minutes = defaultLongFormMinutes
+	}
+	return time.Duration(m.Length)*time.Microsecond >= time.Duration(minutes)*time.Minute
+}
+
+// Get the progress through long-form tracks, most recently played first
+func GetProgress(ctx context.Context, db *sql.DB, includeCompleted bool) ([]Progress, error) {
+	query := `SELECT COALESCE(t.title, ''), COALESCE(a.title, ''),
+			COALESCE((
+				SELECT group_concat(p.name, ', ')
+				FROM Person p
+				WHERE p.id IN (SELECT tp.person FROM Track_Person tp WHERE tp.track = t.id)
+			), ''),
+			pr.position, pr.length, pr.listened, pr.completion, datetime(pr.updated, 'localtime')
+		FROM Progress pr
+		JOIN Track t ON t.id = pr.track
+		LEFT JOIN Album a ON a.id = t.album`
+	if !includeCompleted {
+		query += " WHERE pr.completion < ?"
+	}
+	query += " ORDER BY pr.updated DESC"
+	var args []any
+	if !includeCompleted {
+		args = append(args, completedFraction)
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var progress []Progress
+	for rows.Next() {
+		var p Progress
+		var position, length, listened int64
+		if err := rows.Scan(&p.Title, &p.Album, &p.Artists, &position, &length, &listened, &p.Completion, &p.Updated); err != nil {
+			return nil, err
+		}
+		p.Position = time.Duration(position) * time.Microsecond
+		p.Length = time.Duration(length) * time.Microsecond
+		p.Listened = time.Duration(listened) * time.Microsecond
+		progress = append(progress, p)
+	}
+	return progress, rows.Err()
+}
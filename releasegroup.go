@@ -0,0 +1,112 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Words that mark a bracketed or dashed album title suffix as describing an edition
+const editionWords = `remaster(?:ed)?|deluxe|edition|expanded|anniversary|bonus|reissue|version|mono|stereo|special|collector'?s|super|legacy`
+
+// "Abbey Road (Remastered 2009)", "Abbey Road [Super Deluxe Edition]"
+var bracketedEdition = regexp.MustCompile(`(?i)\s*[(\[][^)\]]*\b(?:` + editionWords + `)\b[^)\]]*[)\]]\s*$`)
+
+// "Abbey Road - 2019 Remaster"
+var dashedEdition = regexp.MustCompile(`(?i)\s+[-–—]\s+[^-–—]*\b(?:` + editionWords + `)\b[^-–—]*$`)
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+// Get the key used to group editions of an album when no release group MBID is known,
+// from its title and the album's artists as artistKey joins them, so that albums of
+// different artists with the same title are not taken for editions of each other
+func ReleaseGroupKey(title, artists string) string {
+	return strings.ToLower(releaseGroupTitle(title)) + "\x1f" + strings.ToLower(artists)
+}
+
+// Remove edition descriptions from an album title
+func releaseGroupTitle(title string) string {
+	for {
+		stripped := dashedEdition.ReplaceAllString(bracketedEdition.ReplaceAllString(title, ""), "")
+		if stripped == title || len(stripped) == 0 {
+			break
+		}
+		title = stripped
+	}
+	return strings.TrimSpace(whitespace.ReplaceAllString(title, " "))
+}
+
+// Plays of every edition of an album
+type ReleaseGroupCount struct {
+	Title    string `json:"title"` // The title without edition descriptions
+	Plays    int    `json:"plays"`
+	Editions int    `json:"editions"`
+}
+
+// Get the most played release groups in the time range; a zero time leaves it open at that end.
+// Albums are grouped by release group MBID, falling back to the title and artist heuristic
+// for albums without one.
+func GetTopReleaseGroups(ctx context.Context, db *sql.DB, from, to time.Time, limit, offset int) ([]ReleaseGroupCount, error) {
+	plays, args := countedPlays(ctx, from, to)
+	rows, err := db.QueryContext(
+		ctx,
+		`SELECT a.title, MIN(length(a.title)), SUM(l.plays) AS plays, COUNT(DISTINCT a.title)
+		FROM (`+plays+`) l
+		JOIN Track t ON t.id = l.track
+		JOIN Album a ON a.id = t.album
+		GROUP BY COALESCE(
+			a.releaseGroup,
+			(SELECT b.releaseGroup FROM Album b WHERE b.groupKey = a.groupKey AND b.releaseGroup IS NOT NULL LIMIT 1),
+			a.groupKey
+		)
+		ORDER BY plays DESC LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var groups []ReleaseGroupCount
+	for rows.Next() {
+		var g ReleaseGroupCount
+		var length int
+		if err := rows.Scan(&g.Title, &length, &g.Plays, &g.Editions); err != nil {
+			return nil, err
+		}
+		g.Title = releaseGroupTitle(g.Title)
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+// Fill in the grouping key for albums stored before it existed, or before it included the album's artists
+func backfillReleaseGroupKeys(tx *sql.Tx) error {
+	rows, err := tx.Query(
+		"SELECT id, COALESCE(title, ''), COALESCE(artistKey, '') FROM Album WHERE groupKey IS NULL OR instr(groupKey, char(31)) = 0",
+	)
+	if err != nil {
+		return err
+	}
+	keys := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var title, artists string
+		if err := rows.Scan(&id, &title, &artists); err != nil {
+			rows.Close()
+			return err
+		}
+		keys[id] = ReleaseGroupKey(title, artists)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for id, key := range keys {
+		if _, err := tx.Exec("UPDATE Album SET groupKey = ? WHERE id = ?", key, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
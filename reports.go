@@ -0,0 +1,91 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+var ErrUnknownReport = errors.New("unknown report")
+
+// A user-defined SQL query from the configuration file
+type ReportConfig struct {
+	Description string `json:"description"`
+	// The query to run; parameters are referenced as :name
+	Query string `json:"query"`
+	// Names of the parameters that must be supplied
+	Params []string `json:"params"`
+}
+
+type ReportResult struct {
+	Columns []string `json:"columns"`
+	Rows    [][]any  `json:"rows"`
+}
+
+// Run the report with the given parameters.
+// db should be opened read-only, so that reports cannot modify the history.
+func RunReport(ctx context.Context, db *sql.DB, report ReportConfig, params map[string]string) (*ReportResult, error) {
+	args := make([]any, 0, len(report.Params))
+	for _, name := range report.Params {
+		value, ok := params[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: missing report parameter %q", ErrInvalidQuery, name)
+		}
+		args = append(args, sql.Named(name, value))
+	}
+	for name := range params {
+		if !slices.Contains(report.Params, name) {
+			return nil, fmt.Errorf("%w: unknown report parameter %q", ErrInvalidQuery, name)
+		}
+	}
+	rows, err := db.QueryContext(ctx, report.Query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	result := ReportResult{Columns: columns, Rows: [][]any{}}
+	for rows.Next() {
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		for i, v := range values {
+			// Text columns may be returned as bytes
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	return &result, rows.Err()
+}
+
+// Serve the reports at /api/reports/{name}, with their parameters from the query string.
+// db should be opened read-only, as for RunReport.
+func RegisterReportAPI(mux *http.ServeMux, db *sql.DB, reports map[string]ReportConfig) {
+	mux.HandleFunc("GET /api/reports/{name}", func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		report, ok := reports[name]
+		if !ok {
+			writeAPIResponse(w, r, nil, fmt.Errorf("%w: %s", ErrUnknownReport, name))
+			return
+		}
+		params := make(map[string]string)
+		for param, values := range r.URL.Query() {
+			params[param] = values[0]
+		}
+		result, err := RunReport(r.Context(), db, report, params)
+		writeAPIResponse(w, r, result, err)
+	})
+}
@@ -0,0 +1,221 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+)
+
+// A track whose fields change when its raw metadata is parsed again
+type ReprocessedTrack struct {
+	Id       int64
+	OldTitle string
+	Title    string
+	Album    string
+	Merged   bool // The new URL and title already belonged to a track, and the plays were moved to it
+}
+
+// A track with raw metadata, as it is stored
+type storedTrack struct {
+	id                      int64
+	title, url, trackId     string
+	album                   string
+	trackNumber, discNumber int
+	player                  string // Of the track's first play, for the rewrite rules that apply to some players
+	raw                     string
+	persons                 []string // From personKeys
+}
+
+// Parse the raw metadata of the tracks that have it again, and update the tracks, their albums and their persons
+// to match. prepare, which may be nil, is applied to each parsed track as the watcher applies its settings before
+// storing it, such as rewrite rules. Tracks that become the same as another track are merged into it.
+// If dryRun is set, the changes are computed but not applied.
+func ReprocessTracks(ctx context.Context, db *sql.DB, prepare func(m *Metadata), dryRun bool) ([]ReprocessedTrack, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	tracks, err := queryStoredTracks(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	// Names are stored as the persons they are aliases of
+	aliases := make(map[string]string)
+	rows, err := tx.QueryContext(ctx, "SELECT a.alias, p.name FROM Person_Alias a JOIN Person p ON p.id = a.person")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var alias, name string
+		if err := rows.Scan(&alias, &name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		aliases[alias] = name
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	var changes []ReprocessedTrack
+	for _, track := range tracks {
+		raw, err := decodeRawMetadata(track.raw)
+		if err != nil {
+			return nil, err
+		}
+		m := parseMetadata(raw)
+		m.Player = track.player
+		if prepare != nil {
+			prepare(m)
+		}
+		if m.Cleared() || !track.changedBy(m, aliases) {
+			continue
+		}
+		change := ReprocessedTrack{Id: track.id, OldTitle: track.title, Title: m.Title, Album: m.Album}
+		if err := reprocessTrack(ctx, tx, &change, m, dryRun); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	if dryRun {
+		return changes, nil
+	}
+	return changes, tx.Commit()
+}
+
+func queryStoredTracks(ctx context.Context, tx *sql.Tx) ([]storedTrack, error) {
+	rows, err := tx.QueryContext(
+		ctx,
+		`SELECT t.id, t.title, t.url, COALESCE(t.trackId, ''), COALESCE(a.title, ''),
+			COALESCE(t.trackNumber, 0), COALESCE(t.discNumber, 0), t.raw,
+			COALESCE((SELECT l.player FROM `+trackLogView+` l WHERE l.track = t.id ORDER BY l.timestamp LIMIT 1), '')
+		FROM Track t LEFT JOIN Album a ON a.id = t.album
+		WHERE t.raw IS NOT NULL ORDER BY t.id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var tracks []storedTrack
+	for rows.Next() {
+		var t storedTrack
+		if err := rows.Scan(&t.id, &t.title, &t.url, &t.trackId, &t.album, &t.trackNumber, &t.discNumber, &t.raw, &t.player); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tracks = append(tracks, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range tracks {
+		rows, err := tx.QueryContext(
+			ctx,
+			"SELECT COALESCE(tp.role, ''), p.name FROM Track_Person tp JOIN Person p ON p.id = tp.person WHERE tp.track = ?",
+			tracks[i].id,
+		)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var role, name string
+			if err := rows.Scan(&role, &name); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			tracks[i].persons = append(tracks[i].persons, role+"\x00"+name)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		slices.Sort(tracks[i].persons)
+		tracks[i].persons = slices.Compact(tracks[i].persons)
+	}
+	return tracks, nil
+}
+
+// Get the track's persons as sorted role and name pairs, by the names they are stored as,
+// without the empty names insertPersons leaves out
+func personKeys(persons []rolePersons, aliases map[string]string) []string {
+	var keys []string
+	for _, set := range persons {
+		for _, name := range set.names {
+			if canonical, ok := aliases[name]; ok {
+				name = canonical
+			}
+			if len(name) > 0 {
+				keys = append(keys, set.role+"\x00"+name)
+			}
+		}
+	}
+	slices.Sort(keys)
+	return slices.Compact(keys)
+}
+
+// Whether storing the track would change it. Track and disc numbers are only replaced, not removed.
+func (t *storedTrack) changedBy(m *Metadata, aliases map[string]string) bool {
+	return t.title != m.Title || t.url != m.Url || t.trackId != m.TrackId || t.album != m.Album ||
+		(m.TrackNumber > 0 && t.trackNumber != m.TrackNumber) || (m.DiscNumber > 0 && t.discNumber != m.DiscNumber) ||
+		!slices.Equal(t.persons, personKeys(trackPersons(m), aliases))
+}
+
+func reprocessTrack(ctx context.Context, tx *sql.Tx, change *ReprocessedTrack, m *Metadata, dryRun bool) error {
+	// (url, title) identifies a track, so another track may already have the new ones
+	var existing int64
+	err := tx.QueryRowContext(ctx, "SELECT id FROM Track WHERE url = ? AND title = ? AND id != ?", m.Url, m.Title, change.Id).Scan(&existing)
+	switch err {
+	case sql.ErrNoRows:
+	case nil:
+		change.Merged = true
+		if dryRun {
+			return nil
+		}
+		return mergeTrack(ctx, tx, existing, change.Id)
+	default:
+		return err
+	}
+	if dryRun {
+		return nil
+	}
+	persons := trackPersons(m)
+	var album sql.NullInt64
+	if len(m.Album) > 0 {
+		artists, err := albumArtistKey(ctx, tx, m, nil)
+		if err != nil {
+			return err
+		}
+		if album.Int64, err = getAlbum(ctx, tx, m.Album, artists, nil); err != nil {
+			return err
+		}
+		album.Valid = true
+		if err := insertAlbumPersons(ctx, tx, album.Int64, persons, nil); err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE Track SET title = ?, url = ?, trackId = ?, album = ?,
+			trackNumber = COALESCE(NULLIF(?, 0), trackNumber), discNumber = COALESCE(NULLIF(?, 0), discNumber)
+		WHERE id = ?`,
+		m.Title,
+		m.Url,
+		m.TrackId,
+		album,
+		m.TrackNumber,
+		m.DiscNumber,
+		change.Id,
+	)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM Track_Person WHERE track = ?", change.Id); err != nil {
+		return err
+	}
+	if err := insertPersons(ctx, tx, change.Id, persons, nil); err != nil {
+		return err
+	}
+	// Genres are only added, since they may also have come from other players or the file's tags
+	return insertGenres(ctx, tx, change.Id, m.Genre, nil)
+}
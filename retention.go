@@ -0,0 +1,149 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// How long plays are kept individually. Plays that are pruned are rolled into the number of times
+// each track was played each day, in DailyPlays, so that old listening still counts toward the top items
+// and the numbers of plays in digests and yearly recaps.
+// Only the default user's plays are pruned, as the daily counts are not kept by user.
+type RetentionConfig struct {
+	// Plays older than this many days are pruned; 0 keeps every play
+	KeepDays int `json:"keepDays"`
+	// While the database uses more than this many megabytes, its oldest month of plays is pruned; 0 for no limit.
+	// The file only shrinks once it is vacuumed.
+	MaxSizeMB int `json:"maxSizeMb"`
+}
+
+// The number of plays rolled into daily counts and removed by PrunePlays
+type PruneResult struct {
+	Rolled  int64 // Plays added to the daily counts
+	Removed int64 // Plays removed, including guest plays and deleted plays, which are not counted
+}
+
+// Roll the plays older than the retention period into daily counts and remove them,
+// then the oldest plays while the database is larger than its limit
+func PrunePlays(ctx context.Context, db *sql.DB, config RetentionConfig) (PruneResult, error) {
+	var result PruneResult
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+	if config.KeepDays > 0 {
+		if err := prunePlaysBefore(ctx, tx, time.Now().AddDate(0, 0, -config.KeepDays), &result); err != nil {
+			return result, err
+		}
+	}
+	for config.MaxSizeMB > 0 {
+		var used int64
+		err := tx.QueryRowContext(
+			ctx,
+			"SELECT (SELECT page_count FROM pragma_page_count()) - (SELECT freelist_count FROM pragma_freelist_count())",
+		).Scan(&used)
+		if err != nil {
+			return result, err
+		}
+		var page int64
+		if err := tx.QueryRowContext(ctx, "SELECT page_size FROM pragma_page_size()").Scan(&page); err != nil {
+			return result, err
+		}
+		if used*page <= int64(config.MaxSizeMB)<<20 {
+			break
+		}
+		var oldest sql.NullString
+		if err := tx.QueryRowContext(ctx, "SELECT MIN(timestamp) FROM "+trackLogView+" WHERE user IS NULL").Scan(&oldest); err != nil {
+			return result, err
+		}
+		before, err := time.Parse(timestampLayout, oldest.String)
+		if !oldest.Valid || err != nil {
+			// There are no plays left to prune, or none with timestamps that can be read
+			break
+		}
+		removed := result.Removed
+		if err := prunePlaysBefore(ctx, tx, before.AddDate(0, 1, 0), &result); err != nil {
+			return result, err
+		}
+		if result.Removed == removed {
+			// Only the latest play is left
+			break
+		}
+	}
+	return result, tx.Commit()
+}
+
+func prunePlaysBefore(ctx context.Context, tx *sql.Tx, before time.Time, result *PruneResult) error {
+	tables, err := trackLogTables(ctx, tx)
+	if err != nil {
+		return err
+	}
+	cutoff := formatTimestamp(before)
+	dropped := false
+	for _, table := range tables {
+		// Plays of guests and plays that were deleted are not counted, as they are left out of statistics
+		var rolled int64
+		err := tx.QueryRowContext(
+			ctx,
+			"SELECT COUNT(*) FROM "+table+" WHERE timestamp < ? AND user IS NULL AND guest IS NULL AND deleted IS NULL AND id != (SELECT MAX(id) FROM TrackLog)",
+			cutoff,
+		).Scan(&rolled)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(
+			ctx,
+			`INSERT INTO DailyPlays (day, track, plays, playedMs)
+			SELECT date(timestamp), track, COUNT(*), SUM(playedMs) FROM `+table+`
+			WHERE timestamp < ? AND user IS NULL AND guest IS NULL AND deleted IS NULL AND id != (SELECT MAX(id) FROM TrackLog)
+			GROUP BY 1, 2
+			ON CONFLICT (day, track) DO UPDATE SET plays = plays + excluded.plays, playedMs = COALESCE(playedMs + excluded.playedMs, playedMs, excluded.playedMs)`,
+			cutoff,
+		)
+		if err != nil {
+			return err
+		}
+		result.Rolled += rolled
+		// The latest play always stays in TrackLog, so new plays keep getting new IDs
+		res, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE timestamp < ? AND user IS NULL AND id != (SELECT MAX(id) FROM TrackLog)", cutoff)
+		if err != nil {
+			return err
+		}
+		removed, _ := res.RowsAffected()
+		if removed == 0 {
+			continue
+		}
+		result.Removed += removed
+		slog.InfoContext(ctx, "Pruned plays", "Table", table, "Before", cutoff, "Plays", removed)
+		if table != "TrackLog" {
+			var empty bool
+			if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) = 0 FROM "+table).Scan(&empty); err != nil {
+				return err
+			}
+			if empty {
+				if _, err := tx.ExecContext(ctx, "DROP TABLE "+table); err != nil {
+					return err
+				}
+				dropped = true
+			}
+		}
+	}
+	if dropped {
+		return createTrackLogView(ctx, tx)
+	}
+	return nil
+}
+
+// Register the prune task, which applies the retention policy
+func RegisterRetentionTasks(s *Scheduler, db *sql.DB, config RetentionConfig) {
+	s.Register("prune", func(ctx context.Context) error {
+		result, err := PrunePlays(ctx, db, config)
+		if err == nil {
+			slog.InfoContext(ctx, "Pruned plays", "Rolled", result.Rolled, "Removed", result.Removed)
+		}
+		return err
+	})
+}
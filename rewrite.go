@@ -0,0 +1,184 @@
+package music_watch
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var ErrInvalidRewrite = errors.New("invalid rewrite rule")
+
+// A find and replace applied to fields of tracks before they are stored
+type RewriteRule struct {
+	// Used to disable a default rule, and in errors
+	Name string `json:"name"`
+	// The fields the rule applies to: title, album, artist, albumArtist or composer; defaults to title
+	Fields []string `json:"fields"`
+	// A regular expression in Go's syntax; all matches are replaced
+	Match string `json:"match"`
+	// The replacement, which may refer to groups as $1 or ${name}; defaults to removing the match
+	Replace string `json:"replace"`
+	// Only rewrite tracks from players whose names contain one of these; defaults to every player
+	Players []string `json:"players"`
+}
+
+// Settings for cleaning up track fields before they are stored
+type RewriteConfig struct {
+	// Do not apply the default rules
+	NoDefaults bool `json:"noDefaults"`
+	// Names of default rules not to apply
+	Disable []string `json:"disable"`
+	// Rules applied after the default rules, in order
+	Rules []RewriteRule `json:"rules"`
+	// Players, such as browsers, whose YouTube videos with no artist have titles like "Artist - Title".
+	// The title is split before the rules are applied.
+	SplitTitlePlayers []string `json:"splitTitlePlayers"`
+	// Split the "Artist - Title" stream titles of internet radio, which has a network URL but no length or artist.
+	// Each title is a separate track, under the station's URL.
+	SplitStreamTitles bool `json:"splitStreamTitles"`
+	// Split artist names holding several artists, such as "Artist feat. Other", after the rules are applied
+	SplitArtists bool `json:"splitArtists"`
+	// Words and symbols between the artists in a name, matched without case. Artists after "feat.", "ft." or "featuring"
+	// are featured artists. Defaults to those words; "&" and "," may be added for names such as "Artist & Other".
+	ArtistSeparators []string `json:"artistSeparators"`
+	// Names that are one artist despite holding a separator, such as "Simon & Garfunkel"
+	KeepArtists []string `json:"keepArtists"`
+}
+
+// Words in brackets that describe a video or upload rather than the track itself
+const uploadWords = `official|video|audio|lyrics?|visuali[sz]er|hd|hq|4k|1080p|720p|mv`
+
+// Rules applied unless disabled
+var defaultRewriteRules = []RewriteRule{
+	{
+		// "Song (Official Video) [HD]"
+		Name:   "upload-suffix",
+		Fields: []string{"title"},
+		Match:  `(?i)\s*[(\[][^)\]]*\b(?:` + uploadWords + `)\b[^)\]]*[)\]]`,
+	},
+	{
+		// "Album (Remastered 2011)", "Song [2009 Remaster]"
+		Name:   "remaster-suffix",
+		Fields: []string{"title", "album"},
+		Match:  `(?i)\s*[(\[][^)\]]*\bremaster(?:ed)?\b[^)\]]*[)\]]`,
+	},
+	{
+		// "Song - Remastered 2011", "Album - 2019 Remaster"
+		Name:   "remaster-dash",
+		Fields: []string{"title", "album"},
+		Match:  `(?i)\s+[-–—]\s+(?:\d{4}\s+)?(?:digital(?:ly)?\s+)?remaster(?:ed)?(?:\s+\d{4})?(?:\s+version)?$`,
+	},
+}
+
+type compiledRewriteRule struct {
+	RewriteRule
+	pattern *regexp.Regexp
+}
+
+// Applies rewrite rules to tracks
+type Rewriter struct {
+	rules             []compiledRewriteRule
+	splitTitlePlayers []string
+	splitStreams      bool
+	artists           *artistSplitter // nil if artists are not split
+}
+
+// Compile the default rules and the configured rules, returning an error for invalid rules
+func NewRewriter(config RewriteConfig) (*Rewriter, error) {
+	var rules []RewriteRule
+	if !config.NoDefaults {
+		for _, rule := range defaultRewriteRules {
+			if !slices.Contains(config.Disable, rule.Name) {
+				rules = append(rules, rule)
+			}
+		}
+	}
+	r := Rewriter{splitTitlePlayers: config.SplitTitlePlayers, splitStreams: config.SplitStreamTitles}
+	for i, rule := range append(rules, config.Rules...) {
+		name := rule.Name
+		if len(name) == 0 {
+			name = fmt.Sprintf("rule %d", i+1)
+		}
+		if len(rule.Fields) == 0 {
+			rule.Fields = []string{"title"}
+		}
+		for _, field := range rule.Fields {
+			if !slices.Contains([]string{"title", "album", "artist", "albumArtist", "composer"}, field) {
+				return nil, fmt.Errorf("%w: %s: unknown field %q", ErrInvalidRewrite, name, field)
+			}
+		}
+		pattern, err := regexp.Compile(rule.Match)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ErrInvalidRewrite, name, err)
+		}
+		r.rules = append(r.rules, compiledRewriteRule{RewriteRule: rule, pattern: pattern})
+	}
+	if config.SplitArtists {
+		var err error
+		if r.artists, err = newArtistSplitter(config.ArtistSeparators, config.KeepArtists); err != nil {
+			return nil, err
+		}
+	}
+	return &r, nil
+}
+
+// Replace the matches of the rule in the value, keeping the value if nothing would be left
+func (rule *compiledRewriteRule) apply(value string) string {
+	rewritten := strings.TrimSpace(rule.pattern.ReplaceAllString(value, rule.Replace))
+	if len(rewritten) == 0 {
+		return value
+	}
+	return rewritten
+}
+
+// Whether the player's name contains one of the names
+func matchesPlayer(names []string, player string) bool {
+	for _, name := range names {
+		if strings.Contains(strings.ToLower(player), strings.ToLower(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply the rules to the track's fields in order
+func (r *Rewriter) Apply(m *Metadata) {
+	splitTitle(r.splitTitlePlayers, m)
+	if r.splitStreams {
+		splitStreamTitle(m)
+	}
+	for i := range r.rules {
+		rule := &r.rules[i]
+		if len(rule.Players) > 0 && !matchesPlayer(rule.Players, m.Player) {
+			continue
+		}
+		for _, field := range rule.Fields {
+			switch field {
+			case "title":
+				m.Title = rule.apply(m.Title)
+			case "album":
+				m.Album = rule.apply(m.Album)
+			case "artist":
+				m.Artist = rule.applyList(m.Artist)
+			case "albumArtist":
+				m.AlbumArtist = rule.applyList(m.AlbumArtist)
+			case "composer":
+				m.Composer = rule.applyList(m.Composer)
+			}
+		}
+	}
+	if r.artists != nil {
+		r.artists.split(m)
+	}
+	// Removing part of a field can leave doubled spaces
+	m.Clean()
+}
+
+func (rule *compiledRewriteRule) applyList(values []string) []string {
+	for i, value := range values {
+		values[i] = rule.apply(value)
+	}
+	return values
+}
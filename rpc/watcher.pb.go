@@ -0,0 +1,1070 @@
+// The gRPC API of the music watcher daemon, served with the -grpc flag.
+// Generate the Go code with: buf generate (see buf.gen.yaml)
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        (unknown)
+// source: watcher.proto
+
+package rpc
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Event_Type int32
+
+const (
+	Event_TYPE_UNSPECIFIED   Event_Type = 0
+	Event_TYPE_NOW_PLAYING   Event_Type = 1
+	Event_TYPE_SCROBBLE      Event_Type = 2
+	Event_TYPE_PLAY_FINISHED Event_Type = 3
+	Event_TYPE_PAUSED        Event_Type = 4
+	Event_TYPE_RESUMED       Event_Type = 5
+)
+
+// Enum value maps for Event_Type.
+var (
+	Event_Type_name = map[int32]string{
+		0: "TYPE_UNSPECIFIED",
+		1: "TYPE_NOW_PLAYING",
+		2: "TYPE_SCROBBLE",
+		3: "TYPE_PLAY_FINISHED",
+		4: "TYPE_PAUSED",
+		5: "TYPE_RESUMED",
+	}
+	Event_Type_value = map[string]int32{
+		"TYPE_UNSPECIFIED":   0,
+		"TYPE_NOW_PLAYING":   1,
+		"TYPE_SCROBBLE":      2,
+		"TYPE_PLAY_FINISHED": 3,
+		"TYPE_PAUSED":        4,
+		"TYPE_RESUMED":       5,
+	}
+)
+
+func (x Event_Type) Enum() *Event_Type {
+	p := new(Event_Type)
+	*p = x
+	return p
+}
+
+func (x Event_Type) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Event_Type) Descriptor() protoreflect.EnumDescriptor {
+	return file_watcher_proto_enumTypes[0].Descriptor()
+}
+
+func (Event_Type) Type() protoreflect.EnumType {
+	return &file_watcher_proto_enumTypes[0]
+}
+
+func (x Event_Type) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Event_Type.Descriptor instead.
+func (Event_Type) EnumDescriptor() ([]byte, []int) {
+	return file_watcher_proto_rawDescGZIP(), []int{4, 0}
+}
+
+type ListTopItemsRequest_Kind int32
+
+const (
+	ListTopItemsRequest_KIND_UNSPECIFIED ListTopItemsRequest_Kind = 0
+	ListTopItemsRequest_KIND_ARTISTS     ListTopItemsRequest_Kind = 1
+	ListTopItemsRequest_KIND_ALBUMS      ListTopItemsRequest_Kind = 2
+	ListTopItemsRequest_KIND_TRACKS      ListTopItemsRequest_Kind = 3
+	ListTopItemsRequest_KIND_GENRES      ListTopItemsRequest_Kind = 4
+)
+
+// Enum value maps for ListTopItemsRequest_Kind.
+var (
+	ListTopItemsRequest_Kind_name = map[int32]string{
+		0: "KIND_UNSPECIFIED",
+		1: "KIND_ARTISTS",
+		2: "KIND_ALBUMS",
+		3: "KIND_TRACKS",
+		4: "KIND_GENRES",
+	}
+	ListTopItemsRequest_Kind_value = map[string]int32{
+		"KIND_UNSPECIFIED": 0,
+		"KIND_ARTISTS":     1,
+		"KIND_ALBUMS":      2,
+		"KIND_TRACKS":      3,
+		"KIND_GENRES":      4,
+	}
+)
+
+func (x ListTopItemsRequest_Kind) Enum() *ListTopItemsRequest_Kind {
+	p := new(ListTopItemsRequest_Kind)
+	*p = x
+	return p
+}
+
+func (x ListTopItemsRequest_Kind) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ListTopItemsRequest_Kind) Descriptor() protoreflect.EnumDescriptor {
+	return file_watcher_proto_enumTypes[1].Descriptor()
+}
+
+func (ListTopItemsRequest_Kind) Type() protoreflect.EnumType {
+	return &file_watcher_proto_enumTypes[1]
+}
+
+func (x ListTopItemsRequest_Kind) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ListTopItemsRequest_Kind.Descriptor instead.
+func (ListTopItemsRequest_Kind) EnumDescriptor() ([]byte, []int) {
+	return file_watcher_proto_rawDescGZIP(), []int{8, 0}
+}
+
+type Track struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	Title        string                 `protobuf:"bytes,1,opt,name=title,proto3" json:"title,omitempty"`
+	Artists      []string               `protobuf:"bytes,2,rep,name=artists,proto3" json:"artists,omitempty"`
+	Album        string                 `protobuf:"bytes,3,opt,name=album,proto3" json:"album,omitempty"`
+	AlbumArtists []string               `protobuf:"bytes,4,rep,name=album_artists,json=albumArtists,proto3" json:"album_artists,omitempty"`
+	Composers    []string               `protobuf:"bytes,5,rep,name=composers,proto3" json:"composers,omitempty"`
+	Url          string                 `protobuf:"bytes,6,opt,name=url,proto3" json:"url,omitempty"`
+	// The MusicBrainz recording ID, if the player reports one
+	TrackId string `protobuf:"bytes,7,opt,name=track_id,json=trackId,proto3" json:"track_id,omitempty"`
+	// The MPRIS name of the player, or the name of another source
+	Player        string `protobuf:"bytes,8,opt,name=player,proto3" json:"player,omitempty"`
+	LengthUs      int64  `protobuf:"varint,9,opt,name=length_us,json=lengthUs,proto3" json:"length_us,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Track) Reset() {
+	*x = Track{}
+	mi := &file_watcher_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Track) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Track) ProtoMessage() {}
+
+func (x *Track) ProtoReflect() protoreflect.Message {
+	mi := &file_watcher_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Track.ProtoReflect.Descriptor instead.
+func (*Track) Descriptor() ([]byte, []int) {
+	return file_watcher_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Track) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *Track) GetArtists() []string {
+	if x != nil {
+		return x.Artists
+	}
+	return nil
+}
+
+func (x *Track) GetAlbum() string {
+	if x != nil {
+		return x.Album
+	}
+	return ""
+}
+
+func (x *Track) GetAlbumArtists() []string {
+	if x != nil {
+		return x.AlbumArtists
+	}
+	return nil
+}
+
+func (x *Track) GetComposers() []string {
+	if x != nil {
+		return x.Composers
+	}
+	return nil
+}
+
+func (x *Track) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *Track) GetTrackId() string {
+	if x != nil {
+		return x.TrackId
+	}
+	return ""
+}
+
+func (x *Track) GetPlayer() string {
+	if x != nil {
+		return x.Player
+	}
+	return ""
+}
+
+func (x *Track) GetLengthUs() int64 {
+	if x != nil {
+		return x.LengthUs
+	}
+	return 0
+}
+
+type GetStatusRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetStatusRequest) Reset() {
+	*x = GetStatusRequest{}
+	mi := &file_watcher_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetStatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetStatusRequest) ProtoMessage() {}
+
+func (x *GetStatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_watcher_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetStatusRequest.ProtoReflect.Descriptor instead.
+func (*GetStatusRequest) Descriptor() ([]byte, []int) {
+	return file_watcher_proto_rawDescGZIP(), []int{1}
+}
+
+type Status struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Whether plays are being stored
+	Logging bool `protobuf:"varint,1,opt,name=logging,proto3" json:"logging,omitempty"`
+	// Whether logging was paused by the user
+	Private bool `protobuf:"varint,2,opt,name=private,proto3" json:"private,omitempty"`
+	// Why logging is inhibited, such as a locked screen
+	Inhibitors []string `protobuf:"bytes,3,rep,name=inhibitors,proto3" json:"inhibitors,omitempty"`
+	// The guest whose session is active, if any
+	Guest string `protobuf:"bytes,4,opt,name=guest,proto3" json:"guest,omitempty"`
+	// Plays stored since the watcher started
+	Scrobbles uint64                 `protobuf:"varint,5,opt,name=scrobbles,proto3" json:"scrobbles,omitempty"`
+	Started   *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=started,proto3" json:"started,omitempty"`
+	// Unset if nothing has played yet
+	NowPlaying    *Track `protobuf:"bytes,7,opt,name=now_playing,json=nowPlaying,proto3" json:"now_playing,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Status) Reset() {
+	*x = Status{}
+	mi := &file_watcher_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Status) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Status) ProtoMessage() {}
+
+func (x *Status) ProtoReflect() protoreflect.Message {
+	mi := &file_watcher_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Status.ProtoReflect.Descriptor instead.
+func (*Status) Descriptor() ([]byte, []int) {
+	return file_watcher_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Status) GetLogging() bool {
+	if x != nil {
+		return x.Logging
+	}
+	return false
+}
+
+func (x *Status) GetPrivate() bool {
+	if x != nil {
+		return x.Private
+	}
+	return false
+}
+
+func (x *Status) GetInhibitors() []string {
+	if x != nil {
+		return x.Inhibitors
+	}
+	return nil
+}
+
+func (x *Status) GetGuest() string {
+	if x != nil {
+		return x.Guest
+	}
+	return ""
+}
+
+func (x *Status) GetScrobbles() uint64 {
+	if x != nil {
+		return x.Scrobbles
+	}
+	return 0
+}
+
+func (x *Status) GetStarted() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Started
+	}
+	return nil
+}
+
+func (x *Status) GetNowPlaying() *Track {
+	if x != nil {
+		return x.NowPlaying
+	}
+	return nil
+}
+
+type WatchEventsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Only send what would be sent to scrobbling services
+	ShareableOnly bool `protobuf:"varint,1,opt,name=shareable_only,json=shareableOnly,proto3" json:"shareable_only,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchEventsRequest) Reset() {
+	*x = WatchEventsRequest{}
+	mi := &file_watcher_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchEventsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchEventsRequest) ProtoMessage() {}
+
+func (x *WatchEventsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_watcher_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchEventsRequest.ProtoReflect.Descriptor instead.
+func (*WatchEventsRequest) Descriptor() ([]byte, []int) {
+	return file_watcher_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *WatchEventsRequest) GetShareableOnly() bool {
+	if x != nil {
+		return x.ShareableOnly
+	}
+	return false
+}
+
+type Event struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Type  Event_Type             `protobuf:"varint,1,opt,name=type,proto3,enum=musicwatcher.v1.Event_Type" json:"type,omitempty"`
+	Time  *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=time,proto3" json:"time,omitempty"`
+	Track *Track                 `protobuf:"bytes,3,opt,name=track,proto3" json:"track,omitempty"`
+	// For finished, paused and resumed plays, the time spent playing and the position
+	PlayedUs   int64 `protobuf:"varint,4,opt,name=played_us,json=playedUs,proto3" json:"played_us,omitempty"`
+	PositionUs int64 `protobuf:"varint,5,opt,name=position_us,json=positionUs,proto3" json:"position_us,omitempty"`
+	// For plays stored during a guest session, the guest's name
+	Guest         string `protobuf:"bytes,6,opt,name=guest,proto3" json:"guest,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Event) Reset() {
+	*x = Event{}
+	mi := &file_watcher_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Event) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event) ProtoMessage() {}
+
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_watcher_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
+func (*Event) Descriptor() ([]byte, []int) {
+	return file_watcher_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Event) GetType() Event_Type {
+	if x != nil {
+		return x.Type
+	}
+	return Event_TYPE_UNSPECIFIED
+}
+
+func (x *Event) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *Event) GetTrack() *Track {
+	if x != nil {
+		return x.Track
+	}
+	return nil
+}
+
+func (x *Event) GetPlayedUs() int64 {
+	if x != nil {
+		return x.PlayedUs
+	}
+	return 0
+}
+
+func (x *Event) GetPositionUs() int64 {
+	if x != nil {
+		return x.PositionUs
+	}
+	return 0
+}
+
+func (x *Event) GetGuest() string {
+	if x != nil {
+		return x.Guest
+	}
+	return ""
+}
+
+type Listen struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=time,proto3" json:"time,omitempty"`
+	Title         string                 `protobuf:"bytes,3,opt,name=title,proto3" json:"title,omitempty"`
+	Album         string                 `protobuf:"bytes,4,opt,name=album,proto3" json:"album,omitempty"`
+	Artists       []string               `protobuf:"bytes,5,rep,name=artists,proto3" json:"artists,omitempty"`
+	Url           string                 `protobuf:"bytes,6,opt,name=url,proto3" json:"url,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Listen) Reset() {
+	*x = Listen{}
+	mi := &file_watcher_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Listen) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Listen) ProtoMessage() {}
+
+func (x *Listen) ProtoReflect() protoreflect.Message {
+	mi := &file_watcher_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Listen.ProtoReflect.Descriptor instead.
+func (*Listen) Descriptor() ([]byte, []int) {
+	return file_watcher_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Listen) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Listen) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *Listen) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *Listen) GetAlbum() string {
+	if x != nil {
+		return x.Album
+	}
+	return ""
+}
+
+func (x *Listen) GetArtists() []string {
+	if x != nil {
+		return x.Artists
+	}
+	return nil
+}
+
+func (x *Listen) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+type ListListensRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Unset times are not filtered on
+	From *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=from,proto3" json:"from,omitempty"`
+	To   *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=to,proto3" json:"to,omitempty"`
+	// Only plays of tracks by this artist
+	Artist string `protobuf:"bytes,3,opt,name=artist,proto3" json:"artist,omitempty"`
+	// Default 50, at most 500
+	Limit         int32 `protobuf:"varint,4,opt,name=limit,proto3" json:"limit,omitempty"`
+	Offset        int32 `protobuf:"varint,5,opt,name=offset,proto3" json:"offset,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListListensRequest) Reset() {
+	*x = ListListensRequest{}
+	mi := &file_watcher_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListListensRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListListensRequest) ProtoMessage() {}
+
+func (x *ListListensRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_watcher_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListListensRequest.ProtoReflect.Descriptor instead.
+func (*ListListensRequest) Descriptor() ([]byte, []int) {
+	return file_watcher_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ListListensRequest) GetFrom() *timestamppb.Timestamp {
+	if x != nil {
+		return x.From
+	}
+	return nil
+}
+
+func (x *ListListensRequest) GetTo() *timestamppb.Timestamp {
+	if x != nil {
+		return x.To
+	}
+	return nil
+}
+
+func (x *ListListensRequest) GetArtist() string {
+	if x != nil {
+		return x.Artist
+	}
+	return ""
+}
+
+func (x *ListListensRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *ListListensRequest) GetOffset() int32 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+type ListListensResponse struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Listens []*Listen              `protobuf:"bytes,1,rep,name=listens,proto3" json:"listens,omitempty"`
+	// The number of plays that match, across all pages
+	Total         int64 `protobuf:"varint,2,opt,name=total,proto3" json:"total,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListListensResponse) Reset() {
+	*x = ListListensResponse{}
+	mi := &file_watcher_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListListensResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListListensResponse) ProtoMessage() {}
+
+func (x *ListListensResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_watcher_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListListensResponse.ProtoReflect.Descriptor instead.
+func (*ListListensResponse) Descriptor() ([]byte, []int) {
+	return file_watcher_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ListListensResponse) GetListens() []*Listen {
+	if x != nil {
+		return x.Listens
+	}
+	return nil
+}
+
+func (x *ListListensResponse) GetTotal() int64 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+type ListTopItemsRequest struct {
+	state protoimpl.MessageState   `protogen:"open.v1"`
+	Kind  ListTopItemsRequest_Kind `protobuf:"varint,1,opt,name=kind,proto3,enum=musicwatcher.v1.ListTopItemsRequest_Kind" json:"kind,omitempty"`
+	From  *timestamppb.Timestamp   `protobuf:"bytes,2,opt,name=from,proto3" json:"from,omitempty"`
+	To    *timestamppb.Timestamp   `protobuf:"bytes,3,opt,name=to,proto3" json:"to,omitempty"`
+	// Default 50, at most 500
+	Limit         int32 `protobuf:"varint,4,opt,name=limit,proto3" json:"limit,omitempty"`
+	Offset        int32 `protobuf:"varint,5,opt,name=offset,proto3" json:"offset,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListTopItemsRequest) Reset() {
+	*x = ListTopItemsRequest{}
+	mi := &file_watcher_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListTopItemsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListTopItemsRequest) ProtoMessage() {}
+
+func (x *ListTopItemsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_watcher_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListTopItemsRequest.ProtoReflect.Descriptor instead.
+func (*ListTopItemsRequest) Descriptor() ([]byte, []int) {
+	return file_watcher_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ListTopItemsRequest) GetKind() ListTopItemsRequest_Kind {
+	if x != nil {
+		return x.Kind
+	}
+	return ListTopItemsRequest_KIND_UNSPECIFIED
+}
+
+func (x *ListTopItemsRequest) GetFrom() *timestamppb.Timestamp {
+	if x != nil {
+		return x.From
+	}
+	return nil
+}
+
+func (x *ListTopItemsRequest) GetTo() *timestamppb.Timestamp {
+	if x != nil {
+		return x.To
+	}
+	return nil
+}
+
+func (x *ListTopItemsRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *ListTopItemsRequest) GetOffset() int32 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+type TopItem struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Plays         int64                  `protobuf:"varint,2,opt,name=plays,proto3" json:"plays,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TopItem) Reset() {
+	*x = TopItem{}
+	mi := &file_watcher_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TopItem) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TopItem) ProtoMessage() {}
+
+func (x *TopItem) ProtoReflect() protoreflect.Message {
+	mi := &file_watcher_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TopItem.ProtoReflect.Descriptor instead.
+func (*TopItem) Descriptor() ([]byte, []int) {
+	return file_watcher_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *TopItem) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *TopItem) GetPlays() int64 {
+	if x != nil {
+		return x.Plays
+	}
+	return 0
+}
+
+type ListTopItemsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Items         []*TopItem             `protobuf:"bytes,1,rep,name=items,proto3" json:"items,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListTopItemsResponse) Reset() {
+	*x = ListTopItemsResponse{}
+	mi := &file_watcher_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListTopItemsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListTopItemsResponse) ProtoMessage() {}
+
+func (x *ListTopItemsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_watcher_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListTopItemsResponse.ProtoReflect.Descriptor instead.
+func (*ListTopItemsResponse) Descriptor() ([]byte, []int) {
+	return file_watcher_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ListTopItemsResponse) GetItems() []*TopItem {
+	if x != nil {
+		return x.Items
+	}
+	return nil
+}
+
+var File_watcher_proto protoreflect.FileDescriptor
+
+const file_watcher_proto_rawDesc = "" +
+	"\n" +
+	"\rwatcher.proto\x12\x0fmusicwatcher.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xf2\x01\n" +
+	"\x05Track\x12\x14\n" +
+	"\x05title\x18\x01 \x01(\tR\x05title\x12\x18\n" +
+	"\aartists\x18\x02 \x03(\tR\aartists\x12\x14\n" +
+	"\x05album\x18\x03 \x01(\tR\x05album\x12#\n" +
+	"\ralbum_artists\x18\x04 \x03(\tR\falbumArtists\x12\x1c\n" +
+	"\tcomposers\x18\x05 \x03(\tR\tcomposers\x12\x10\n" +
+	"\x03url\x18\x06 \x01(\tR\x03url\x12\x19\n" +
+	"\btrack_id\x18\a \x01(\tR\atrackId\x12\x16\n" +
+	"\x06player\x18\b \x01(\tR\x06player\x12\x1b\n" +
+	"\tlength_us\x18\t \x01(\x03R\blengthUs\"\x12\n" +
+	"\x10GetStatusRequest\"\xff\x01\n" +
+	"\x06Status\x12\x18\n" +
+	"\alogging\x18\x01 \x01(\bR\alogging\x12\x18\n" +
+	"\aprivate\x18\x02 \x01(\bR\aprivate\x12\x1e\n" +
+	"\n" +
+	"inhibitors\x18\x03 \x03(\tR\n" +
+	"inhibitors\x12\x14\n" +
+	"\x05guest\x18\x04 \x01(\tR\x05guest\x12\x1c\n" +
+	"\tscrobbles\x18\x05 \x01(\x04R\tscrobbles\x124\n" +
+	"\astarted\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\astarted\x127\n" +
+	"\vnow_playing\x18\a \x01(\v2\x16.musicwatcher.v1.TrackR\n" +
+	"nowPlaying\";\n" +
+	"\x12WatchEventsRequest\x12%\n" +
+	"\x0eshareable_only\x18\x01 \x01(\bR\rshareableOnly\"\xed\x02\n" +
+	"\x05Event\x12/\n" +
+	"\x04type\x18\x01 \x01(\x0e2\x1b.musicwatcher.v1.Event.TypeR\x04type\x12.\n" +
+	"\x04time\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12,\n" +
+	"\x05track\x18\x03 \x01(\v2\x16.musicwatcher.v1.TrackR\x05track\x12\x1b\n" +
+	"\tplayed_us\x18\x04 \x01(\x03R\bplayedUs\x12\x1f\n" +
+	"\vposition_us\x18\x05 \x01(\x03R\n" +
+	"positionUs\x12\x14\n" +
+	"\x05guest\x18\x06 \x01(\tR\x05guest\"\x80\x01\n" +
+	"\x04Type\x12\x14\n" +
+	"\x10TYPE_UNSPECIFIED\x10\x00\x12\x14\n" +
+	"\x10TYPE_NOW_PLAYING\x10\x01\x12\x11\n" +
+	"\rTYPE_SCROBBLE\x10\x02\x12\x16\n" +
+	"\x12TYPE_PLAY_FINISHED\x10\x03\x12\x0f\n" +
+	"\vTYPE_PAUSED\x10\x04\x12\x10\n" +
+	"\fTYPE_RESUMED\x10\x05\"\xa0\x01\n" +
+	"\x06Listen\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12.\n" +
+	"\x04time\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x14\n" +
+	"\x05title\x18\x03 \x01(\tR\x05title\x12\x14\n" +
+	"\x05album\x18\x04 \x01(\tR\x05album\x12\x18\n" +
+	"\aartists\x18\x05 \x03(\tR\aartists\x12\x10\n" +
+	"\x03url\x18\x06 \x01(\tR\x03url\"\xb6\x01\n" +
+	"\x12ListListensRequest\x12.\n" +
+	"\x04from\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\x04from\x12*\n" +
+	"\x02to\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\x02to\x12\x16\n" +
+	"\x06artist\x18\x03 \x01(\tR\x06artist\x12\x14\n" +
+	"\x05limit\x18\x04 \x01(\x05R\x05limit\x12\x16\n" +
+	"\x06offset\x18\x05 \x01(\x05R\x06offset\"^\n" +
+	"\x13ListListensResponse\x121\n" +
+	"\alistens\x18\x01 \x03(\v2\x17.musicwatcher.v1.ListenR\alistens\x12\x14\n" +
+	"\x05total\x18\x02 \x01(\x03R\x05total\"\xc1\x02\n" +
+	"\x13ListTopItemsRequest\x12=\n" +
+	"\x04kind\x18\x01 \x01(\x0e2).musicwatcher.v1.ListTopItemsRequest.KindR\x04kind\x12.\n" +
+	"\x04from\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\x04from\x12*\n" +
+	"\x02to\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\x02to\x12\x14\n" +
+	"\x05limit\x18\x04 \x01(\x05R\x05limit\x12\x16\n" +
+	"\x06offset\x18\x05 \x01(\x05R\x06offset\"a\n" +
+	"\x04Kind\x12\x14\n" +
+	"\x10KIND_UNSPECIFIED\x10\x00\x12\x10\n" +
+	"\fKIND_ARTISTS\x10\x01\x12\x0f\n" +
+	"\vKIND_ALBUMS\x10\x02\x12\x0f\n" +
+	"\vKIND_TRACKS\x10\x03\x12\x0f\n" +
+	"\vKIND_GENRES\x10\x04\"3\n" +
+	"\aTopItem\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x14\n" +
+	"\x05plays\x18\x02 \x01(\x03R\x05plays\"F\n" +
+	"\x14ListTopItemsResponse\x12.\n" +
+	"\x05items\x18\x01 \x03(\v2\x18.musicwatcher.v1.TopItemR\x05items2\xdc\x02\n" +
+	"\fMusicWatcher\x12G\n" +
+	"\tGetStatus\x12!.musicwatcher.v1.GetStatusRequest\x1a\x17.musicwatcher.v1.Status\x12L\n" +
+	"\vWatchEvents\x12#.musicwatcher.v1.WatchEventsRequest\x1a\x16.musicwatcher.v1.Event0\x01\x12X\n" +
+	"\vListListens\x12#.musicwatcher.v1.ListListensRequest\x1a$.musicwatcher.v1.ListListensResponse\x12[\n" +
+	"\fListTopItems\x12$.musicwatcher.v1.ListTopItemsRequest\x1a%.musicwatcher.v1.ListTopItemsResponseB.Z,github.com/inventor500/music-watcher/rpc;rpcb\x06proto3"
+
+var (
+	file_watcher_proto_rawDescOnce sync.Once
+	file_watcher_proto_rawDescData []byte
+)
+
+func file_watcher_proto_rawDescGZIP() []byte {
+	file_watcher_proto_rawDescOnce.Do(func() {
+		file_watcher_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_watcher_proto_rawDesc), len(file_watcher_proto_rawDesc)))
+	})
+	return file_watcher_proto_rawDescData
+}
+
+var file_watcher_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_watcher_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
+var file_watcher_proto_goTypes = []any{
+	(Event_Type)(0),               // 0: musicwatcher.v1.Event.Type
+	(ListTopItemsRequest_Kind)(0), // 1: musicwatcher.v1.ListTopItemsRequest.Kind
+	(*Track)(nil),                 // 2: musicwatcher.v1.Track
+	(*GetStatusRequest)(nil),      // 3: musicwatcher.v1.GetStatusRequest
+	(*Status)(nil),                // 4: musicwatcher.v1.Status
+	(*WatchEventsRequest)(nil),    // 5: musicwatcher.v1.WatchEventsRequest
+	(*Event)(nil),                 // 6: musicwatcher.v1.Event
+	(*Listen)(nil),                // 7: musicwatcher.v1.Listen
+	(*ListListensRequest)(nil),    // 8: musicwatcher.v1.ListListensRequest
+	(*ListListensResponse)(nil),   // 9: musicwatcher.v1.ListListensResponse
+	(*ListTopItemsRequest)(nil),   // 10: musicwatcher.v1.ListTopItemsRequest
+	(*TopItem)(nil),               // 11: musicwatcher.v1.TopItem
+	(*ListTopItemsResponse)(nil),  // 12: musicwatcher.v1.ListTopItemsResponse
+	(*timestamppb.Timestamp)(nil), // 13: google.protobuf.Timestamp
+}
+var file_watcher_proto_depIdxs = []int32{
+	13, // 0: musicwatcher.v1.Status.started:type_name -> google.protobuf.Timestamp
+	2,  // 1: musicwatcher.v1.Status.now_playing:type_name -> musicwatcher.v1.Track
+	0,  // 2: musicwatcher.v1.Event.type:type_name -> musicwatcher.v1.Event.Type
+	13, // 3: musicwatcher.v1.Event.time:type_name -> google.protobuf.Timestamp
+	2,  // 4: musicwatcher.v1.Event.track:type_name -> musicwatcher.v1.Track
+	13, // 5: musicwatcher.v1.Listen.time:type_name -> google.protobuf.Timestamp
+	13, // 6: musicwatcher.v1.ListListensRequest.from:type_name -> google.protobuf.Timestamp
+	13, // 7: musicwatcher.v1.ListListensRequest.to:type_name -> google.protobuf.Timestamp
+	7,  // 8: musicwatcher.v1.ListListensResponse.listens:type_name -> musicwatcher.v1.Listen
+	1,  // 9: musicwatcher.v1.ListTopItemsRequest.kind:type_name -> musicwatcher.v1.ListTopItemsRequest.Kind
+	13, // 10: musicwatcher.v1.ListTopItemsRequest.from:type_name -> google.protobuf.Timestamp
+	13, // 11: musicwatcher.v1.ListTopItemsRequest.to:type_name -> google.protobuf.Timestamp
+	11, // 12: musicwatcher.v1.ListTopItemsResponse.items:type_name -> musicwatcher.v1.TopItem
+	3,  // 13: musicwatcher.v1.MusicWatcher.GetStatus:input_type -> musicwatcher.v1.GetStatusRequest
+	5,  // 14: musicwatcher.v1.MusicWatcher.WatchEvents:input_type -> musicwatcher.v1.WatchEventsRequest
+	8,  // 15: musicwatcher.v1.MusicWatcher.ListListens:input_type -> musicwatcher.v1.ListListensRequest
+	10, // 16: musicwatcher.v1.MusicWatcher.ListTopItems:input_type -> musicwatcher.v1.ListTopItemsRequest
+	4,  // 17: musicwatcher.v1.MusicWatcher.GetStatus:output_type -> musicwatcher.v1.Status
+	6,  // 18: musicwatcher.v1.MusicWatcher.WatchEvents:output_type -> musicwatcher.v1.Event
+	9,  // 19: musicwatcher.v1.MusicWatcher.ListListens:output_type -> musicwatcher.v1.ListListensResponse
+	12, // 20: musicwatcher.v1.MusicWatcher.ListTopItems:output_type -> musicwatcher.v1.ListTopItemsResponse
+	17, // [17:21] is the sub-list for method output_type
+	13, // [13:17] is the sub-list for method input_type
+	13, // [13:13] is the sub-list for extension type_name
+	13, // [13:13] is the sub-list for extension extendee
+	0,  // [0:13] is the sub-list for field type_name
+}
+
+func init() { file_watcher_proto_init() }
+func file_watcher_proto_init() {
+	if File_watcher_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_watcher_proto_rawDesc), len(file_watcher_proto_rawDesc)),
+			NumEnums:      2,
+			NumMessages:   11,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_watcher_proto_goTypes,
+		DependencyIndexes: file_watcher_proto_depIdxs,
+		EnumInfos:         file_watcher_proto_enumTypes,
+		MessageInfos:      file_watcher_proto_msgTypes,
+	}.Build()
+	File_watcher_proto = out.File
+	file_watcher_proto_goTypes = nil
+	file_watcher_proto_depIdxs = nil
+}
@@ -0,0 +1,250 @@
+// The gRPC API of the music watcher daemon, served with the -grpc flag.
+// Generate the Go code with: buf generate (see buf.gen.yaml)
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: watcher.proto
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	MusicWatcher_GetStatus_FullMethodName    = "/musicwatcher.v1.MusicWatcher/GetStatus"
+	MusicWatcher_WatchEvents_FullMethodName  = "/musicwatcher.v1.MusicWatcher/WatchEvents"
+	MusicWatcher_ListListens_FullMethodName  = "/musicwatcher.v1.MusicWatcher/ListListens"
+	MusicWatcher_ListTopItems_FullMethodName = "/musicwatcher.v1.MusicWatcher/ListTopItems"
+)
+
+// MusicWatcherClient is the client API for MusicWatcher service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MusicWatcherClient interface {
+	// Get the state of the watcher
+	GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*Status, error)
+	// Stream events as they happen, starting with the current track
+	WatchEvents(ctx context.Context, in *WatchEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error)
+	// Get a page of plays, newest first
+	ListListens(ctx context.Context, in *ListListensRequest, opts ...grpc.CallOption) (*ListListensResponse, error)
+	// Get the most played artists, albums or tracks
+	ListTopItems(ctx context.Context, in *ListTopItemsRequest, opts ...grpc.CallOption) (*ListTopItemsResponse, error)
+}
+
+type musicWatcherClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMusicWatcherClient(cc grpc.ClientConnInterface) MusicWatcherClient {
+	return &musicWatcherClient{cc}
+}
+
+func (c *musicWatcherClient) GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*Status, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Status)
+	err := c.cc.Invoke(ctx, MusicWatcher_GetStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *musicWatcherClient) WatchEvents(ctx context.Context, in *WatchEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &MusicWatcher_ServiceDesc.Streams[0], MusicWatcher_WatchEvents_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchEventsRequest, Event]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MusicWatcher_WatchEventsClient = grpc.ServerStreamingClient[Event]
+
+func (c *musicWatcherClient) ListListens(ctx context.Context, in *ListListensRequest, opts ...grpc.CallOption) (*ListListensResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListListensResponse)
+	err := c.cc.Invoke(ctx, MusicWatcher_ListListens_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *musicWatcherClient) ListTopItems(ctx context.Context, in *ListTopItemsRequest, opts ...grpc.CallOption) (*ListTopItemsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListTopItemsResponse)
+	err := c.cc.Invoke(ctx, MusicWatcher_ListTopItems_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MusicWatcherServer is the server API for MusicWatcher service.
+// All implementations must embed UnimplementedMusicWatcherServer
+// for forward compatibility.
+type MusicWatcherServer interface {
+	// Get the state of the watcher
+	GetStatus(context.Context, *GetStatusRequest) (*Status, error)
+	// Stream events as they happen, starting with the current track
+	WatchEvents(*WatchEventsRequest, grpc.ServerStreamingServer[Event]) error
+	// Get a page of plays, newest first
+	ListListens(context.Context, *ListListensRequest) (*ListListensResponse, error)
+	// Get the most played artists, albums or tracks
+	ListTopItems(context.Context, *ListTopItemsRequest) (*ListTopItemsResponse, error)
+	mustEmbedUnimplementedMusicWatcherServer()
+}
+
+// UnimplementedMusicWatcherServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedMusicWatcherServer struct{}
+
+func (UnimplementedMusicWatcherServer) GetStatus(context.Context, *GetStatusRequest) (*Status, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStatus not implemented")
+}
+func (UnimplementedMusicWatcherServer) WatchEvents(*WatchEventsRequest, grpc.ServerStreamingServer[Event]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchEvents not implemented")
+}
+func (UnimplementedMusicWatcherServer) ListListens(context.Context, *ListListensRequest) (*ListListensResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListListens not implemented")
+}
+func (UnimplementedMusicWatcherServer) ListTopItems(context.Context, *ListTopItemsRequest) (*ListTopItemsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTopItems not implemented")
+}
+func (UnimplementedMusicWatcherServer) mustEmbedUnimplementedMusicWatcherServer() {}
+func (UnimplementedMusicWatcherServer) testEmbeddedByValue()                      {}
+
+// UnsafeMusicWatcherServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MusicWatcherServer will
+// result in compilation errors.
+type UnsafeMusicWatcherServer interface {
+	mustEmbedUnimplementedMusicWatcherServer()
+}
+
+func RegisterMusicWatcherServer(s grpc.ServiceRegistrar, srv MusicWatcherServer) {
+	// If the following call pancis, it indicates UnimplementedMusicWatcherServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&MusicWatcher_ServiceDesc, srv)
+}
+
+func _MusicWatcher_GetStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MusicWatcherServer).GetStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MusicWatcher_GetStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MusicWatcherServer).GetStatus(ctx, req.(*GetStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MusicWatcher_WatchEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MusicWatcherServer).WatchEvents(m, &grpc.GenericServerStream[WatchEventsRequest, Event]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MusicWatcher_WatchEventsServer = grpc.ServerStreamingServer[Event]
+
+func _MusicWatcher_ListListens_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListListensRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MusicWatcherServer).ListListens(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MusicWatcher_ListListens_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MusicWatcherServer).ListListens(ctx, req.(*ListListensRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MusicWatcher_ListTopItems_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTopItemsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MusicWatcherServer).ListTopItems(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MusicWatcher_ListTopItems_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MusicWatcherServer).ListTopItems(ctx, req.(*ListTopItemsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// MusicWatcher_ServiceDesc is the grpc.ServiceDesc for MusicWatcher service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var MusicWatcher_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "musicwatcher.v1.MusicWatcher",
+	HandlerType: (*MusicWatcherServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetStatus",
+			Handler:    _MusicWatcher_GetStatus_Handler,
+		},
+		{
+			MethodName: "ListListens",
+			Handler:    _MusicWatcher_ListListens_Handler,
+		},
+		{
+			MethodName: "ListTopItems",
+			Handler:    _MusicWatcher_ListTopItems_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchEvents",
+			Handler:       _MusicWatcher_WatchEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "watcher.proto",
+}
@@ -0,0 +1,142 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// A maintenance task that can be run by the scheduler
+type Task func(ctx context.Context) error
+
+type scheduleEntry struct {
+	name     string
+	schedule *CronSchedule
+	next     time.Time
+}
+
+// Run registered tasks according to cron expressions
+type Scheduler struct {
+	lock    sync.Mutex
+	tasks   map[string]Task
+	entries []*scheduleEntry
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{tasks: make(map[string]Task)}
+}
+
+// Make a task available to be scheduled by name
+func (s *Scheduler) Register(name string, task Task) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.tasks[name] = task
+}
+
+// Schedule a registered task using a cron expression
+func (s *Scheduler) Schedule(name, expr string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.tasks[name]; !ok {
+		return fmt.Errorf("unknown task %q", name)
+	}
+	sched, err := ParseCron(expr)
+	if err != nil {
+		return err
+	}
+	next := sched.Next(time.Now())
+	if next.IsZero() {
+		return fmt.Errorf("%w: %q never matches", ErrInvalidCron, expr)
+	}
+	s.entries = append(s.entries, &scheduleEntry{name: name, schedule: sched, next: next})
+	return nil
+}
+
+// Run a registered task immediately
+func (s *Scheduler) RunTask(ctx context.Context, name string) error {
+	s.lock.Lock()
+	task, ok := s.tasks[name]
+	s.lock.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown task %q", name)
+	}
+	return task(ctx)
+}
+
+// Run scheduled tasks until the context is cancelled.
+// Tasks run one at a time, so a slow task delays the ones after it instead of overlapping.
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		entry := s.nextEntry()
+		if entry == nil {
+			slog.DebugContext(ctx, "No scheduled tasks")
+			return
+		}
+		slog.DebugContext(ctx, "Waiting for next scheduled task", "Task", entry.name, "At", entry.next)
+		timer := time.NewTimer(time.Until(entry.next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		slog.InfoContext(ctx, "Running scheduled task", "Task", entry.name)
+		start := time.Now()
+		if err := s.RunTask(ctx, entry.name); err != nil {
+			slog.ErrorContext(ctx, "Scheduled task failed", "Task", entry.name, "Error", err)
+		} else {
+			slog.InfoContext(ctx, "Finished scheduled task", "Task", entry.name, "Duration", time.Since(start))
+		}
+		s.lock.Lock()
+		entry.next = entry.schedule.Next(time.Now())
+		s.lock.Unlock()
+		if entry.next.IsZero() {
+			slog.WarnContext(ctx, "Scheduled task will not run again", "Task", entry.name)
+		}
+	}
+}
+
+func (s *Scheduler) nextEntry() *scheduleEntry {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var next *scheduleEntry
+	for _, entry := range s.entries {
+		if entry.next.IsZero() {
+			continue
+		}
+		if next == nil || entry.next.Before(next.next) {
+			next = entry
+		}
+	}
+	return next
+}
+
+// Register the database maintenance tasks that are always available
+func RegisterDatabaseTasks(s *Scheduler, db *sql.DB) {
+	s.Register("optimize", func(ctx context.Context) error {
+		_, err := db.ExecContext(ctx, "PRAGMA optimize")
+		return err
+	})
+	s.Register("vacuum", func(ctx context.Context) error {
+		_, err := db.ExecContext(ctx, "VACUUM")
+		return err
+	})
+	s.Register("maintain", func(ctx context.Context) error {
+		result, err := MaintainDatabase(ctx, db)
+		if err == nil {
+			slog.InfoContext(ctx, "Maintained database", "Before", result.SizeBefore, "After", result.SizeAfter, "CheckpointBusy", result.CheckpointBusy)
+		}
+		return err
+	})
+	// Not run unless scheduled, e.g. "0 4 1 1 *" to start a new partition each year
+	s.Register("partition-plays", func(ctx context.Context) error {
+		moved, err := PartitionTrackLog(ctx, db)
+		if err == nil {
+			slog.InfoContext(ctx, "Partitioned plays", "Moved", moved)
+		}
+		return err
+	})
+}
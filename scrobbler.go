@@ -0,0 +1,289 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// An external service that the history is mirrored to as it is logged
+type scrobbler interface {
+	// Report the track that just started playing. Not retried.
+	nowPlaying(ctx context.Context, track *Metadata) error
+	// Submit stored plays, oldest first
+	submit(ctx context.Context, plays []Event) error
+}
+
+// Returned by scrobblers when a request should not be retried as it is,
+// or should only be retried after a delay
+type scrobbleError struct {
+	err        error
+	permanent  bool
+	retryAfter time.Duration
+}
+
+func (e *scrobbleError) Error() string {
+	return e.err.Error()
+}
+
+func (e *scrobbleError) Unwrap() error {
+	return e.err
+}
+
+// Classify a failed HTTP response: client errors are permanent, except rate limiting
+func httpStatusError(service string, resp *http.Response) error {
+	err := fmt.Errorf("%s returned %s", service, resp.Status)
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		var delay time.Duration
+		if seconds, parseErr := strconv.Atoi(resp.Header.Get("Retry-After")); parseErr == nil {
+			delay = time.Duration(seconds) * time.Second
+		}
+		return &scrobbleError{err: err, retryAfter: delay}
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return &scrobbleError{err: err, permanent: true}
+	default:
+		return err
+	}
+}
+
+// Used when a scrobbler has no client of its own, so that a stalled request cannot hold up the queue
+var scrobbleClient = &http.Client{Timeout: 30 * time.Second}
+
+const (
+	minScrobbleBackoff = 30 * time.Second
+	maxScrobbleBackoff = 30 * time.Minute
+)
+
+type scrobbleResult struct {
+	plays []queuedPlay // Empty for now playing updates
+	err   error
+}
+
+// Sends events to a scrobbler in the background.
+// Plays are queued while the service is unreachable, and sent in batches of up to maxBatch.
+// With a journal, the queue is kept in the database so that plays are not lost when the watcher stops.
+type scrobbleSink struct {
+	name     string
+	s        scrobbler
+	maxBatch int
+	journal  *sinkJournal
+
+	events  chan Event
+	flushes chan chan error
+	cancel  context.CancelFunc
+	done    chan struct{}
+
+	lock    sync.Mutex
+	queued  int
+	lastErr error
+}
+
+func newScrobbleSink(name string, s scrobbler, maxBatch int) *scrobbleSink {
+	return &scrobbleSink{name: name, s: s, maxBatch: maxBatch}
+}
+
+func (s *scrobbleSink) Name() string {
+	return s.name
+}
+
+func (s *scrobbleSink) setJournal(db *sql.DB) {
+	s.journal = &sinkJournal{db: db, sink: s.name}
+}
+
+func (s *scrobbleSink) Init(ctx context.Context) error {
+	if v, ok := s.s.(interface{ validate() error }); ok {
+		if err := v.validate(); err != nil {
+			return err
+		}
+	}
+	// Queued plays are still sent by Flush after the caller's context is cancelled
+	ctx, s.cancel = context.WithCancel(context.WithoutCancel(ctx))
+	s.events = make(chan Event, subscriberBuffer)
+	s.flushes = make(chan chan error)
+	s.done = make(chan struct{})
+	go s.run(ctx)
+	return nil
+}
+
+func (s *scrobbleSink) Store(ctx context.Context, e Event) error {
+	select {
+	case s.events <- e:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Send the queued plays now, even if waiting to retry
+func (s *scrobbleSink) Flush(ctx context.Context) error {
+	result := make(chan error, 1)
+	select {
+	case s.flushes <- result:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *scrobbleSink) Close() error {
+	s.cancel()
+	<-s.done
+	return nil
+}
+
+func (s *scrobbleSink) health() (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.queued, s.lastErr
+}
+
+func (s *scrobbleSink) run(ctx context.Context) {
+	defer close(s.done)
+	var queue []queuedPlay
+	if s.journal != nil {
+		var err error
+		if queue, err = s.journal.load(ctx); err != nil {
+			slog.ErrorContext(ctx, "Unable to load queued plays", "Sink", s.name, "Error", err)
+		} else if len(queue) > 0 {
+			slog.InfoContext(ctx, "Submitting plays queued before the watcher stopped", "Sink", s.name, "Count", len(queue))
+		}
+	}
+	var playing *Metadata // Now playing update that has not been sent yet
+	var sending bool
+	var retryAt time.Time
+	var flushes []chan error // Waiting for the queue to be empty
+	backoff := minScrobbleBackoff
+	results := make(chan scrobbleResult, 1)
+	// Plays loaded from the journal are sent right away
+	retry := time.NewTimer(0)
+	if len(queue) == 0 {
+		<-retry.C
+	}
+	send := func(plays []queuedPlay, track *Metadata) {
+		sending = true
+		go func() {
+			start := time.Now()
+			var err error
+			if track != nil {
+				err = s.s.nowPlaying(ctx, track)
+			} else {
+				events := make([]Event, len(plays))
+				for i, play := range plays {
+					events[i] = play.Event
+				}
+				err = s.s.submit(ctx, events)
+			}
+			recordLatency(s.name, time.Since(start))
+			results <- scrobbleResult{plays: plays, err: err}
+		}()
+	}
+	finishFlushes := func(err error) {
+		for _, f := range flushes {
+			f <- err
+		}
+		flushes = nil
+	}
+	for {
+		select {
+		case e := <-s.events:
+			switch e.Type {
+			case EventNowPlaying:
+				playing = e.Track
+			case EventScrobble:
+				play := queuedPlay{Event: e}
+				if s.journal != nil {
+					var err error
+					if play.id, err = s.journal.add(ctx, e); err != nil {
+						slog.WarnContext(ctx, "Unable to save queued play, it will be lost if the watcher stops", "Sink", s.name, "Error", err)
+					}
+				}
+				queue = append(queue, play)
+			}
+		case f := <-s.flushes:
+			flushes = append(flushes, f)
+			retryAt = time.Time{}
+		case res := <-results:
+			sending = false
+			var scrobbleErr *scrobbleError
+			switch {
+			case res.err == nil:
+				backoff = minScrobbleBackoff
+				if len(res.plays) > 0 {
+					slog.DebugContext(ctx, "Submitted plays", "Sink", s.name, "Count", len(res.plays))
+					s.dequeue(ctx, res.plays)
+				}
+			case ctx.Err() != nil:
+				return
+			case len(res.plays) == 0:
+				// Now playing updates are stale by the time they could be retried
+				slog.WarnContext(ctx, "Unable to send now playing", "Sink", s.name, "Error", res.err)
+			case errors.As(res.err, &scrobbleErr) && scrobbleErr.permanent:
+				slog.ErrorContext(ctx, "Plays were rejected, dropping them", "Sink", s.name, "Count", len(res.plays), "Error", res.err)
+				s.dequeue(ctx, res.plays)
+			default:
+				queue = append(res.plays, queue...)
+				delay := backoff
+				if scrobbleErr != nil && scrobbleErr.retryAfter > 0 {
+					delay = scrobbleErr.retryAfter
+				} else {
+					backoff = min(backoff*2, maxScrobbleBackoff)
+				}
+				slog.WarnContext(ctx, "Unable to submit plays, retrying", "Sink", s.name, "Count", len(res.plays), "Delay", delay, "Error", res.err)
+				retryAt = time.Now().Add(delay)
+				retry.Reset(delay)
+				finishFlushes(res.err)
+			}
+			s.lock.Lock()
+			s.lastErr = res.err
+			s.lock.Unlock()
+		case <-retry.C:
+		case <-ctx.Done():
+			if len(queue) > 0 && s.journal != nil {
+				slog.InfoContext(ctx, "Stopping with plays that were not submitted, they will be sent on the next start", "Sink", s.name, "Count", len(queue))
+			} else if len(queue) > 0 {
+				slog.WarnContext(ctx, "Stopping with plays that were not submitted", "Sink", s.name, "Count", len(queue))
+			}
+			finishFlushes(ctx.Err())
+			return
+		}
+		s.lock.Lock()
+		s.queued = len(queue)
+		s.lock.Unlock()
+		if sending || time.Now().Before(retryAt) {
+			continue
+		}
+		if len(queue) > 0 {
+			batch := slices.Clone(queue[:min(len(queue), s.maxBatch)])
+			queue = queue[len(batch):]
+			send(batch, nil)
+		} else if playing != nil {
+			send(nil, playing)
+			playing = nil
+		} else {
+			finishFlushes(nil)
+		}
+	}
+}
+
+// Remove plays from the journal once they no longer need to be sent
+func (s *scrobbleSink) dequeue(ctx context.Context, plays []queuedPlay) {
+	if s.journal == nil {
+		return
+	}
+	if err := s.journal.remove(ctx, plays); err != nil {
+		slog.WarnContext(ctx, "Unable to remove sent plays from the queue, they may be sent again", "Sink", s.name, "Error", err)
+	}
+}
@@ -0,0 +1,130 @@
+package music_watch
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	dbus "github.com/godbus/dbus/v5"
+)
+
+const screenSaverName = "org.freedesktop.ScreenSaver"
+const screenSaverPath = "/org/freedesktop/ScreenSaver"
+const screenSaverSignal = "org.freedesktop.ScreenSaver.ActiveChanged"
+const login1Name = "org.freedesktop.login1"
+const login1Path = "/org/freedesktop/login1"
+const login1Manager = "org.freedesktop.login1.Manager"
+const login1Session = "org.freedesktop.login1.Session"
+
+// Inhibitor reasons
+const inhibitLocked = "locked"
+const inhibitIdle = "idle"
+
+// How often to check logind for the lock and idle state
+const sessionPollInterval = 30 * time.Second
+
+// Suspend logging while the screen is locked or the session is idle, until the context is cancelled.
+// conn is the session bus; logind is looked up on the system bus.
+func WatchSession(ctx context.Context, conn *dbus.Conn, config SessionConfig, c *Controller) {
+	if !config.PauseWhenLocked && config.IdleMinutes <= 0 {
+		return
+	}
+	var screenSaverLocked, sessionLocked bool
+	var signals chan *dbus.Signal
+	if config.PauseWhenLocked {
+		if err := conn.AddMatchSignalContext(
+			ctx,
+			dbus.WithMatchInterface(screenSaverName),
+			dbus.WithMatchMember("ActiveChanged"),
+		); err != nil {
+			slog.WarnContext(ctx, "Unable to watch the screen saver", "Error", err)
+		} else {
+			signals = make(chan *dbus.Signal, 10)
+			conn.Signal(signals)
+			defer conn.RemoveSignal(signals)
+		}
+		call := conn.Object(screenSaverName, screenSaverPath).CallWithContext(ctx, screenSaverName+".GetActive", 0)
+		if call.Err == nil {
+			call.Store(&screenSaverLocked)
+		}
+	}
+	session := getLogindSession(ctx)
+	update := func() {
+		if session != nil {
+			locked, idle := pollLogindSession(ctx, session, config.IdleMinutes)
+			sessionLocked = locked
+			c.SetInhibited(inhibitIdle, idle)
+		}
+		if config.PauseWhenLocked {
+			c.SetInhibited(inhibitLocked, screenSaverLocked || sessionLocked)
+		}
+	}
+	update()
+	ticker := time.NewTicker(sessionPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case sig := <-signals:
+			if sig.Name != screenSaverSignal || len(sig.Body) != 1 {
+				continue
+			}
+			if active, ok := sig.Body[0].(bool); ok {
+				slog.DebugContext(ctx, "Screen saver changed state", "Active", active)
+				screenSaverLocked = active
+				c.SetInhibited(inhibitLocked, screenSaverLocked || sessionLocked)
+			}
+		case <-ticker.C:
+			update()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Get the logind session of the current user, or nil if it is not available
+func getLogindSession(ctx context.Context) dbus.BusObject {
+	system, err := dbus.SystemBus()
+	if err != nil {
+		slog.WarnContext(ctx, "Unable to connect to the system bus, session state will not be tracked", "Error", err)
+		return nil
+	}
+	manager := system.Object(login1Name, login1Path)
+	var path dbus.ObjectPath
+	// "auto" resolves to the caller's session, or the user's display session for services outside a session
+	if err := manager.CallWithContext(ctx, login1Manager+".GetSession", 0, "auto").Store(&path); err != nil {
+		slog.WarnContext(ctx, "Unable to find the logind session, session state will not be tracked", "Error", err)
+		return nil
+	}
+	slog.DebugContext(ctx, "Found logind session", "Path", path)
+	return system.Object(login1Name, path)
+}
+
+// Get whether the session is locked, and whether it has been idle for at least idleMinutes
+func pollLogindSession(ctx context.Context, session dbus.BusObject, idleMinutes int) (bool, bool) {
+	var locked, idle bool
+	if v, err := session.GetProperty(login1Session + ".LockedHint"); err == nil {
+		locked, _ = v.Value().(bool)
+	} else {
+		slog.DebugContext(ctx, "Unable to get session lock state", "Error", err)
+	}
+	if idleMinutes <= 0 {
+		return locked, false
+	}
+	hint, err := session.GetProperty(login1Session + ".IdleHint")
+	if err != nil {
+		slog.DebugContext(ctx, "Unable to get session idle state", "Error", err)
+		return locked, false
+	}
+	if isIdle, _ := hint.Value().(bool); isIdle {
+		// IdleSinceHint is in microseconds since the epoch
+		since, err := session.GetProperty(login1Session + ".IdleSinceHint")
+		if err != nil {
+			return locked, false
+		}
+		if usec, ok := since.Value().(uint64); ok {
+			idleSince := time.UnixMicro(int64(usec))
+			idle = time.Since(idleSince) >= time.Duration(idleMinutes)*time.Minute
+		}
+	}
+	return locked, idle
+}
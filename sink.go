@@ -0,0 +1,204 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// How long each sink has to send what it has queued when the watcher stops
+const sinkFlushTimeout = 10 * time.Second
+
+// An output that plays are sent to as they are stored, such as a scrobbling service.
+// The database is not a sink: plays are only passed to sinks once they are stored.
+type Sink interface {
+	// Identifies the sink in logs, status and the sinks configuration
+	Name() string
+	// Prepare the sink. Sinks that fail to initialize are disabled.
+	Init(ctx context.Context) error
+	// Handle a now playing, scrobble or playback event without waiting on the network
+	Store(ctx context.Context, e Event) error
+	// Send anything that is queued
+	Flush(ctx context.Context) error
+	Close() error
+}
+
+// Implemented by sinks that can keep what they have queued in the database across restarts
+type sinkQueue interface {
+	setJournal(db *sql.DB)
+}
+
+// Implemented by sinks that send in the background, to report their state
+type sinkHealth interface {
+	health() (queued int, err error)
+}
+
+// The state of a registered sink
+type SinkStatus struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	Healthy bool   `json:"healthy"`
+	Queued  int    `json:"queued,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type registeredSink struct {
+	sink    Sink
+	enabled bool
+	err     error // The last error from Init or Store
+}
+
+// Passes stored plays to every enabled sink
+type SinkRegistry struct {
+	lock    sync.Mutex
+	enabled map[string]bool
+	sinks   []*registeredSink
+	journal *sql.DB // Optional; where sinks keep their queues
+}
+
+// Create a registry; sinks set to false in enabled are registered but not run
+func NewSinkRegistry(enabled map[string]bool) *SinkRegistry {
+	return &SinkRegistry{enabled: enabled}
+}
+
+// Keep the queues of the sinks that support it in the database.
+// Must be called before Run.
+func (r *SinkRegistry) SetJournal(db *sql.DB) {
+	r.journal = db
+}
+
+func (r *SinkRegistry) Register(s Sink) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	enabled, ok := r.enabled[s.Name()]
+	r.sinks = append(r.sinks, &registeredSink{sink: s, enabled: enabled || !ok})
+}
+
+// Send the controller's events to the sinks until the context is cancelled,
+// then flush and close them
+func (r *SinkRegistry) Run(ctx context.Context, c *Controller) {
+	events, unsubscribe := c.Events.Subscribe()
+	defer unsubscribe()
+	// Starting a sink may connect to a server, so the events published meanwhile are kept
+	// rather than left to fill the subscription, which drops them when it is full
+	started := make(chan struct{})
+	go func() {
+		defer close(started)
+		r.init(ctx)
+	}()
+	var pending []Event
+	subscription := events
+	for starting := true; starting; {
+		select {
+		case e, ok := <-subscription:
+			if ok {
+				pending = append(pending, e)
+			} else {
+				subscription = nil
+			}
+		case <-started:
+			starting = false
+		}
+	}
+	running := r.running()
+	defer func() {
+		for _, s := range running {
+			flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sinkFlushTimeout)
+			if err := s.sink.Flush(flushCtx); err != nil {
+				slog.WarnContext(ctx, "Unable to flush sink", "Sink", s.sink.Name(), "Error", err)
+			}
+			cancel()
+			if err := s.sink.Close(); err != nil {
+				slog.WarnContext(ctx, "Unable to close sink", "Sink", s.sink.Name(), "Error", err)
+			}
+		}
+	}()
+	for _, e := range pending {
+		r.store(ctx, c, running, e)
+	}
+	if subscription == nil {
+		return
+	}
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			r.store(ctx, c, running, e)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Start the enabled sinks, disabling those that fail to start
+func (r *SinkRegistry) init(ctx context.Context) {
+	for _, s := range r.running() {
+		if q, ok := s.sink.(sinkQueue); ok && r.journal != nil {
+			q.setJournal(r.journal)
+		}
+		if err := s.sink.Init(ctx); err != nil {
+			slog.WarnContext(ctx, "Unable to start sink, disabling it", "Sink", s.sink.Name(), "Error", err)
+			r.setError(s, err)
+			r.lock.Lock()
+			s.enabled = false
+			r.lock.Unlock()
+		}
+	}
+}
+
+// Send the event to the running sinks, if it may be shared
+func (r *SinkRegistry) store(ctx context.Context, c *Controller, running []*registeredSink, e Event) {
+	if !c.Shareable(ctx, e) {
+		return
+	}
+	for _, s := range running {
+		err := s.sink.Store(ctx, e)
+		if err != nil {
+			slog.WarnContext(ctx, "Sink failed to store event", "Sink", s.sink.Name(), "Type", e.Type, "Error", err)
+		}
+		r.setError(s, err)
+	}
+}
+
+// Get the sinks that are enabled
+func (r *SinkRegistry) running() []*registeredSink {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var running []*registeredSink
+	for _, s := range r.sinks {
+		if s.enabled {
+			running = append(running, s)
+		}
+	}
+	return running
+}
+
+func (r *SinkRegistry) setError(s *registeredSink, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	s.err = err
+}
+
+// Get the state of every registered sink
+func (r *SinkRegistry) Status() []SinkStatus {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	statuses := make([]SinkStatus, 0, len(r.sinks))
+	for _, s := range r.sinks {
+		status := SinkStatus{Name: s.sink.Name(), Enabled: s.enabled}
+		err := s.err
+		if h, ok := s.sink.(sinkHealth); ok && s.enabled && err == nil {
+			status.Queued, err = h.health()
+		}
+		status.Healthy = s.enabled && err == nil
+		if err != nil {
+			status.Error = err.Error()
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
@@ -0,0 +1,78 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// A play waiting to be sent by a sink
+type queuedPlay struct {
+	id int64 // The play's row in SinkQueue, or 0 if it is only queued in memory
+	Event
+}
+
+// Keeps a sink's queued plays in the database until they are sent
+type sinkJournal struct {
+	db   *sql.DB
+	sink string
+}
+
+// Get the plays the sink had not sent when the watcher last stopped, oldest first
+func (j *sinkJournal) load(ctx context.Context) ([]queuedPlay, error) {
+	rows, err := j.db.QueryContext(ctx, "SELECT id, event FROM SinkQueue WHERE sink = ? ORDER BY id", j.sink)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var plays []queuedPlay
+	for rows.Next() {
+		var play queuedPlay
+		var data []byte
+		if err := rows.Scan(&play.id, &data); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &play.Event); err != nil {
+			return nil, err
+		}
+		plays = append(plays, play)
+	}
+	return plays, rows.Err()
+}
+
+func (j *sinkJournal) add(ctx context.Context, e Event) (int64, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return 0, err
+	}
+	res, err := j.db.ExecContext(
+		ctx,
+		"INSERT INTO SinkQueue (sink, event, queued) VALUES (?, ?, ?)",
+		j.sink,
+		string(data),
+		time.Now().Format(time.DateTime),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// Remove plays that were sent or rejected
+func (j *sinkJournal) remove(ctx context.Context, plays []queuedPlay) error {
+	tx, err := j.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, play := range plays {
+		if play.id == 0 {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM SinkQueue WHERE id = ?", play.id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,46 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Plays that finish before this much of the track was heard are counted as skipped
+const (
+	skipMaxPlayed   = 30 * time.Second
+	skipMaxFraction = 0.5
+)
+
+// Record how long the play was listened to when the track finishes, and mark it as skipped if it finished early
+func RecordPlayed(ctx context.Context, db *sql.DB, e Event) error {
+	if e.Type != EventPlayFinished {
+		return nil
+	}
+	played := time.Duration(e.Played) * time.Microsecond
+	var completion sql.NullFloat64
+	if e.Track.Length > 0 {
+		// Parts of the track that were played again can make this more than the length
+		completion.Float64, completion.Valid = min(float64(e.Played)/float64(e.Track.Length), 1), true
+	}
+	var skipped sql.NullInt64
+	if played < skipMaxPlayed && (!completion.Valid || completion.Float64 < skipMaxFraction) {
+		skipped.Int64, skipped.Valid = 1, true
+	}
+	// Only the play that just finished is updated, not an earlier one if this play was not logged.
+	// New plays are always in TrackLog rather than a partition.
+	track, args := trackIDQuery(e.Track)
+	_, err := db.ExecContext(
+		ctx,
+		`UPDATE TrackLog SET playedMs = ?, completion = ?, skipped = ? WHERE id = (
+			SELECT l.id FROM TrackLog l
+			WHERE l.track = `+track+` AND l.timestamp >= ?
+			ORDER BY l.timestamp DESC, l.id DESC LIMIT 1
+		)`,
+		append(
+			append([]any{played.Milliseconds(), completion, skipped}, args...),
+			formatTimestamp(e.Time.Add(-played-time.Minute)),
+		)...,
+	)
+	return err
+}
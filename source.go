@@ -0,0 +1,88 @@
+package music_watch
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Something that reports tracks as they are played, such as MPRIS players or MPD
+type Source interface {
+	// Send events until the context is cancelled or the source fails
+	Run(ctx context.Context, events chan<- Event) error
+}
+
+// Run the source, passing each new track to the callback, until the context is cancelled.
+// Every event, including finished plays, is also passed to the handlers.
+func Watch(ctx context.Context, source Source, callback StoreCallback, handlers ...func(context.Context, Event)) error {
+	events := make(chan Event)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- source.Run(ctx, events)
+	}()
+	for {
+		select {
+		case e := <-events:
+			if e.Track == nil || (e.Type == EventNowPlaying && e.Track.Cleared()) {
+				// Sources end plays when metadata is cleared; it is never a play itself
+				continue
+			}
+			// Sources may report final events while shutting down; let them be handled
+			handlerCtx := trace.ContextWithSpanContext(context.WithoutCancel(ctx), e.trace)
+			handlerCtx, span := tracer.Start(handlerCtx, "Watch "+e.Type, trackAttributes(e.Track))
+			var err error
+			if e.Type == EventNowPlaying {
+				if err = callback(handlerCtx, e.Track); err != nil {
+					slog.ErrorContext(ctx, "Error handling track", "Title", e.Track.Title, "Player", e.Track.Player, "Error", err)
+				}
+			}
+			for _, handler := range handlers {
+				handler(handlerCtx, e)
+			}
+			endSpan(span, err)
+		case err := <-errChan:
+			if ctx.Err() != nil {
+				slog.InfoContext(ctx, "Received shutdown signal")
+			}
+			return err
+		}
+	}
+}
+
+// Report a new track from a source
+func sendTrack(ctx context.Context, events chan<- Event, m *Metadata) error {
+	select {
+	case events <- Event{Type: EventNowPlaying, Time: time.Now(), Track: m, trace: trace.SpanContextFromContext(ctx)}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Several sources that run at the same time, such as desktop players and a media server
+type MultiSource []Source
+
+// Run every source until the context is cancelled or one of them fails, which stops the rest
+func (m MultiSource) Run(ctx context.Context, events chan<- Event) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errChan := make(chan error, len(m))
+	for _, source := range m {
+		go func() {
+			err := source.Run(ctx, events)
+			if err != nil {
+				cancel()
+			}
+			errChan <- err
+		}()
+	}
+	var firstErr error
+	for range m {
+		if err := <-errChan; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
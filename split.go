@@ -0,0 +1,177 @@
+package music_watch
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// The separator between the artist and title in video titles such as "Artist - Title"
+var titleSeparator = regexp.MustCompile(`\s+[-–—]\s+`)
+
+// A featured artist at the end of a title: "Title (feat. X)", "Title ft. X"
+var titleFeature = regexp.MustCompile(`(?i)\s*(?:[(\[]\s*(?:feat\.?|ft\.?|featuring)\s+([^)\]]+)[)\]]|\s(?:feat\.?|ft\.?|featuring)\s+(.+)$)`)
+
+// Whether the URL is a page on YouTube or YouTube Music
+func isYouTubeURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	return host == "youtube.com" || host == "youtu.be" || strings.HasSuffix(host, ".youtube.com")
+}
+
+// Split a title such as "Artist - Title (feat. X)" into the artist and title.
+// Featured artists are moved to the artist, as "Artist feat. X".
+func splitVideoTitle(title string) (string, string, bool) {
+	separator := titleSeparator.FindStringIndex(title)
+	if separator == nil {
+		return "", title, false
+	}
+	artist := strings.TrimSpace(title[:separator[0]])
+	title = strings.TrimSpace(title[separator[1]:])
+	if len(artist) == 0 || len(title) == 0 {
+		return "", title, false
+	}
+	if feature := titleFeature.FindStringSubmatchIndex(title); feature != nil {
+		for group := 2; group < len(feature); group += 2 {
+			if feature[group] >= 0 {
+				artist += " feat. " + strings.TrimSpace(title[feature[group]:feature[group+1]])
+			}
+		}
+		if stripped := strings.TrimSpace(title[:feature[0]] + title[feature[1]:]); len(stripped) > 0 {
+			title = stripped
+		}
+	}
+	return artist, title, true
+}
+
+// Split the title of a video with no artist into the artist and title, if it is from one of the players.
+// Tracks with a URL must be on YouTube; tracks without one are split since some browsers, such as Firefox, do not give the page.
+func splitTitle(players []string, m *Metadata) {
+	if len(m.Artist) > 0 || !matchesPlayer(players, m.Player) {
+		return
+	}
+	if len(m.Url) > 0 && !isYouTubeURL(m.Url) {
+		return
+	}
+	if artist, title, ok := splitVideoTitle(m.Title); ok {
+		m.Artist = []string{artist}
+		m.Title = title
+		featureSplitter.split(m)
+	}
+}
+
+// URL schemes of network streams
+var streamSchemes = []string{"http", "https", "icy", "icyx", "mms", "mmsh", "rtsp", "rtmp"}
+
+// Whether the track is from an internet radio stream, which has a network URL, no length and no artist
+func isStream(m *Metadata) bool {
+	if m.Length > 0 || len(m.Artist) > 0 {
+		return false
+	}
+	u, err := url.Parse(m.Url)
+	return err == nil && slices.Contains(streamSchemes, strings.ToLower(u.Scheme)) && !isYouTubeURL(m.Url)
+}
+
+// Split the "Artist - Title" stream titles of internet radio into the artist and title
+func splitStreamTitle(m *Metadata) {
+	if !isStream(m) {
+		return
+	}
+	if artist, title, ok := splitVideoTitle(m.Title); ok {
+		m.Artist = []string{artist}
+		m.Title = title
+		featureSplitter.split(m)
+	}
+}
+
+// Separators that introduce featured artists rather than co-artists
+var featureSeparators = []string{"feat.", "feat", "ft.", "ft", "featuring"}
+
+// Splits featured artists from artist names
+var featureSplitter = func() *artistSplitter {
+	s, err := newArtistSplitter(featureSeparators, nil)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}()
+
+// Splits artist names that hold several artists, such as "Artist feat. Other"
+type artistSplitter struct {
+	separator *regexp.Regexp
+	keep      []string
+}
+
+func newArtistSplitter(separators, keep []string) (*artistSplitter, error) {
+	// "&" and "," are not split by default, since they are part of the names of many groups
+	if len(separators) == 0 {
+		separators = featureSeparators
+	}
+	var alternatives []string
+	for _, separator := range separators {
+		separator = strings.TrimSpace(separator)
+		if len(separator) == 0 {
+			continue
+		}
+		quoted := regexp.QuoteMeta(separator)
+		last := rune(separator[len(separator)-1])
+		if unicode.IsLetter(last) || unicode.IsDigit(last) {
+			// Words must not be part of a longer word, as "ft" in "Daft Punk"
+			alternatives = append(alternatives, `\s+`+quoted+`\s+`)
+		} else if unicode.IsLetter(rune(separator[0])) {
+			alternatives = append(alternatives, `\s+`+quoted+`\s*`)
+		} else {
+			alternatives = append(alternatives, `\s*`+quoted+`\s*`)
+		}
+	}
+	if len(alternatives) == 0 {
+		return nil, fmt.Errorf("%w: no artist separators", ErrInvalidRewrite)
+	}
+	// Longer separators first, so that "feat." is not matched as "feat" followed by a dot
+	slices.SortFunc(alternatives, func(a, b string) int { return len(b) - len(a) })
+	separator, err := regexp.Compile(`(?i)(?:` + strings.Join(alternatives, "|") + `)`)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRewrite, err)
+	}
+	return &artistSplitter{separator: separator, keep: keep}, nil
+}
+
+// Split the track's artist names, moving artists after a feature separator to the featured artists
+func (s *artistSplitter) split(m *Metadata) {
+	var artists, featured []string
+	for _, name := range m.Featured {
+		s.splitName(name, &featured, &featured)
+	}
+	for _, name := range m.Artist {
+		s.splitName(name, &artists, &featured)
+	}
+	m.Artist = artists
+	m.Featured = featured
+}
+
+// Append the artists in the name to artists, and those after a feature separator to featured
+func (s *artistSplitter) splitName(name string, artists, featured *[]string) {
+	if slices.ContainsFunc(s.keep, func(keep string) bool { return strings.EqualFold(keep, name) }) {
+		*artists = append(*artists, name)
+		return
+	}
+	// "Artist (feat. Other)" is split like "Artist feat. Other"
+	name = strings.NewReplacer("(", " ", ")", " ", "[", " ", "]", " ").Replace(name)
+	into := artists
+	start := 0
+	for _, match := range append(s.separator.FindAllStringIndex(name, -1), []int{len(name), len(name)}) {
+		if part := strings.TrimSpace(name[start:match[0]]); len(part) > 0 {
+			*into = append(*into, part)
+		}
+		if match[0] < len(name) && slices.Contains(featureSeparators, strings.ToLower(strings.TrimSpace(name[match[0]:match[1]]))) {
+			into = featured
+		}
+		start = match[1]
+	}
+}
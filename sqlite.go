@@ -0,0 +1,60 @@
+package music_watch
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Settings for the connections to the SQLite database
+type SQLiteConfig struct {
+	// The journal mode, kept in the database file; defaults to "wal",
+	// which lets the commands and the web interface read while plays are written
+	JournalMode string `json:"journalMode"`
+	// How long to wait for another connection's lock before failing, in milliseconds; defaults to 5000
+	BusyTimeoutMs int `json:"busyTimeoutMs"`
+	// How often SQLite waits for writes to reach the disk: "off", "normal", "full" or "extra".
+	// Defaults to "normal", which can lose the last plays in a power failure but does not corrupt a WAL database.
+	Synchronous string `json:"synchronous"`
+	// The most plays waiting to be written by the watcher; defaults to 256.
+	// Plays received while it is full are written at once, holding up the watcher until they are.
+	QueueSize int `json:"queueSize"`
+}
+
+// A pragma set on each connection when it is opened
+type SQLitePragma struct {
+	Name  string
+	Value string
+}
+
+var journalModes = []string{"delete", "truncate", "persist", "memory", "wal", "off"}
+var synchronousLevels = []string{"off", "normal", "full", "extra"}
+
+// Get the pragmas for the settings, filling in the defaults
+func (c SQLiteConfig) Pragmas() ([]SQLitePragma, error) {
+	journalMode := strings.ToLower(c.JournalMode)
+	if len(journalMode) == 0 {
+		journalMode = "wal"
+	} else if !slices.Contains(journalModes, journalMode) {
+		return nil, fmt.Errorf("unknown journal mode %q, expected one of %s", c.JournalMode, strings.Join(journalModes, ", "))
+	}
+	synchronous := strings.ToLower(c.Synchronous)
+	if len(synchronous) == 0 {
+		synchronous = "normal"
+	} else if !slices.Contains(synchronousLevels, synchronous) {
+		return nil, fmt.Errorf("unknown synchronous level %q, expected one of %s", c.Synchronous, strings.Join(synchronousLevels, ", "))
+	}
+	busyTimeout := c.BusyTimeoutMs
+	if busyTimeout < 0 {
+		return nil, fmt.Errorf("busy timeout must not be negative, got %d", busyTimeout)
+	} else if busyTimeout == 0 {
+		busyTimeout = 5000
+	}
+	// The busy timeout comes first, so that setting the journal mode waits for other connections
+	return []SQLitePragma{
+		{"busy_timeout", strconv.Itoa(busyTimeout)},
+		{"journal_mode", journalMode},
+		{"synchronous", synchronous},
+	}, nil
+}
@@ -0,0 +1,295 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The differences between the database servers that SQLStore can use
+type sqlDialect struct {
+	name string
+	// Statements creating the tables, run when the store is opened
+	schema []string
+	// Rewrite a query written with ? placeholders for the server
+	bind func(query string) string
+	// Appended to an INSERT so that rows whose key columns are already taken are left as they are
+	ignoreConflict func(key ...string) string
+	// Insert a row whose key columns may already be taken, returning the ID of the new or existing row
+	insertID func(ctx context.Context, tx *sql.Tx, query string, key []string, args ...any) (int64, error)
+	// Join the values of an expression over a group, separated by sep
+	aggregate func(expr, sep string) string
+	// The expression for the schema or database the tables are in, for information_schema
+	currentSchema string
+	// Columns added since the tables were first created, which are added to existing tables when the store is opened
+	columns []sqlColumn
+}
+
+type sqlColumn struct {
+	table, name, definition string
+	// Run once the column is added, such as to change the keys that use it
+	migrate []string
+}
+
+// A store in a database server, such as a central database shared by the watchers on several machines.
+// It holds the tracks, their persons and genres, and the plays; the other features use the SQLite database.
+type SQLStore struct {
+	DB      *sql.DB
+	dialect *sqlDialect
+}
+
+func newSQLStore(ctx context.Context, db *sql.DB, dialect *sqlDialect) (*SQLStore, error) {
+	if err := db.PingContext(ctx); err != nil {
+		return nil, fmt.Errorf("unable to connect to %s: %w", dialect.name, err)
+	}
+	for _, stmt := range dialect.schema {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return nil, fmt.Errorf("unable to create %s tables: %w", dialect.name, err)
+		}
+	}
+	for _, column := range dialect.columns {
+		// Unquoted names are folded to lower case by PostgreSQL
+		var exists bool
+		err := db.QueryRowContext(
+			ctx,
+			dialect.bind(`SELECT COUNT(*) > 0 FROM information_schema.columns
+			WHERE table_schema = `+dialect.currentSchema+` AND LOWER(table_name) = LOWER(?) AND LOWER(column_name) = LOWER(?)`),
+			column.table,
+			column.name,
+		).Scan(&exists)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s columns: %w", dialect.name, err)
+		}
+		if exists {
+			continue
+		}
+		for _, stmt := range append([]string{fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", column.table, column.name, column.definition)}, column.migrate...) {
+			if _, err := db.ExecContext(ctx, stmt); err != nil {
+				return nil, fmt.Errorf("unable to add %s column %s.%s: %w", dialect.name, column.table, column.name, err)
+			}
+		}
+	}
+	return &SQLStore{DB: db, dialect: dialect}, nil
+}
+
+func (s *SQLStore) exec(ctx context.Context, tx *sql.Tx, query string, args ...any) error {
+	_, err := tx.ExecContext(ctx, s.dialect.bind(query), args...)
+	return err
+}
+
+// Get the ID of the row with the name, inserting it if it is new
+func (s *SQLStore) nameID(ctx context.Context, tx *sql.Tx, table, column, name string) (int64, error) {
+	return s.dialect.insertID(ctx, tx, fmt.Sprintf("INSERT INTO %s (%s) VALUES (?)", table, column), []string{column}, name)
+}
+
+func (s *SQLStore) storeTrack(ctx context.Context, tx *sql.Tx, m *Metadata) (int64, error) {
+	var album sql.NullInt64
+	if len(m.Album) > 0 {
+		// As in the SQLite database, the title and album artists identify the album, though without the persons' aliases
+		artists := m.AlbumArtist
+		if len(artists) == 0 {
+			artists = m.Artist
+		}
+		id, err := s.dialect.insertID(
+			ctx, tx, "INSERT INTO Album (title, artistKey) VALUES (?, ?)", []string{"title", "artistKey"},
+			m.Album, artistKey(artists),
+		)
+		if err != nil {
+			return 0, err
+		}
+		album = sql.NullInt64{Int64: id, Valid: true}
+	}
+	// As in the SQLite database, (url, title) identifies the track
+	track, err := s.dialect.insertID(
+		ctx, tx, "INSERT INTO Track (title, trackId, url, album) VALUES (?, ?, ?, ?)", []string{"url", "title"},
+		m.Title, sql.NullString{String: m.TrackId, Valid: len(m.TrackId) > 0}, m.Url, album,
+	)
+	if err != nil {
+		return 0, err
+	}
+	// Persons and genres are added to existing tracks too, since other machines' players may give different ones
+	seen := make(artistSet)
+	for _, set := range [][]string{m.AlbumArtist, m.Artist, m.Featured, m.Composer} {
+		for _, name := range set {
+			if _, ok := seen[name]; ok || len(name) == 0 {
+				continue
+			}
+			seen[name] = struct{}{}
+			person, err := s.nameID(ctx, tx, "Person", "name", name)
+			if err != nil {
+				return 0, err
+			}
+			if err := s.exec(ctx, tx, "INSERT INTO Track_Person (track, person) VALUES (?, ?) "+s.dialect.ignoreConflict("track", "person"), track, person); err != nil {
+				return 0, err
+			}
+		}
+	}
+	for _, name := range m.Genre {
+		genre, err := s.nameID(ctx, tx, "Genre", "name", name)
+		if err != nil {
+			return 0, err
+		}
+		if err := s.exec(ctx, tx, "INSERT INTO Track_Genre (track, genre) VALUES (?, ?) "+s.dialect.ignoreConflict("track", "genre"), track, genre); err != nil {
+			return 0, err
+		}
+	}
+	return track, nil
+}
+
+func (s *SQLStore) StoreListen(ctx context.Context, m *Metadata) (err error) {
+	ctx, span := tracer.Start(ctx, "SQLStore.StoreListen", trackAttributes(m))
+	defer func() {
+		if errors.Is(err, ErrDuplicatePlay) {
+			span.End()
+			return
+		}
+		endSpan(span, err)
+	}()
+	if len(m.Title) == 0 && len(m.Url) == 0 {
+		return nil
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	track, err := s.storeTrack(ctx, tx, m)
+	if err != nil {
+		return err
+	}
+	// Lock the track until the play is stored, so that watchers storing the same play at once see each other's
+	if err := tx.QueryRowContext(ctx, s.dialect.bind("SELECT id FROM Track WHERE id = ? FOR UPDATE"), track).Scan(&track); err != nil {
+		return err
+	}
+	played := playedAt(ctx)
+	window := max(time.Duration(m.Length)*time.Microsecond-duplicatePlaySlack, duplicatePlaySlack)
+	var duplicate bool
+	err = tx.QueryRowContext(
+		ctx,
+		s.dialect.bind("SELECT COUNT(*) > 0 FROM TrackLog WHERE track = ? AND timestamp > ? AND timestamp <= ? AND COALESCE(userName, '') = ?"),
+		track, played.Add(-window), played, playUser(ctx),
+	).Scan(&duplicate)
+	if err != nil {
+		return err
+	} else if duplicate {
+		return ErrDuplicatePlay
+	}
+	guest := sql.NullString{String: guestSession(ctx), Valid: len(guestSession(ctx)) > 0}
+	device := deviceOf(ctx)
+	err = s.exec(
+		ctx, tx, "INSERT INTO TrackLog (track, timestamp, guest, userName, host, device) VALUES (?, ?, ?, ?, ?, ?)",
+		track, played, guest, userArg(ctx),
+		sql.NullString{String: device.host, Valid: len(device.host) > 0},
+		sql.NullString{String: device.label, Valid: len(device.label) > 0},
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) GetOrCreateTrack(ctx context.Context, m *Metadata) (int64, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	id, err := s.storeTrack(ctx, tx, m)
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// Build the conditions on TrackLog l, as playConditions does for the SQLite database
+func sqlStoreConditions(ctx context.Context, from, to time.Time) (string, []any) {
+	conditions := []string{"l.guest IS NULL", "COALESCE(l.userName, '') = ?"}
+	args := []any{playUser(ctx)}
+	if !from.IsZero() {
+		conditions = append(conditions, "l.timestamp >= ?")
+		args = append(args, from)
+	}
+	if !to.IsZero() {
+		conditions = append(conditions, "l.timestamp < ?")
+		args = append(args, to)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+func (s *SQLStore) QueryListens(ctx context.Context, q ListenQuery) ([]Listen, int, error) {
+	where, args := sqlStoreConditions(ctx, q.From, q.To)
+	if len(q.Artist) > 0 {
+		where += " AND l.track IN (SELECT tp.track FROM Track_Person tp JOIN Person p ON p.id = tp.person WHERE p.name = ?)"
+		args = append(args, q.Artist)
+	}
+	var total int
+	if err := s.DB.QueryRowContext(ctx, s.dialect.bind("SELECT COUNT(*) FROM TrackLog l WHERE "+where), args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := s.DB.QueryContext(
+		ctx,
+		s.dialect.bind(`SELECT l.id, l.timestamp, t.title, COALESCE(a.title, ''), t.url,
+			COALESCE((
+				SELECT `+s.dialect.aggregate("p.name", "'\x1f'")+`
+				FROM Track_Person tp JOIN Person p ON p.id = tp.person
+				WHERE tp.track = t.id
+			), '')
+		FROM TrackLog l
+		JOIN Track t ON t.id = l.track
+		LEFT JOIN Album a ON a.id = t.album
+		WHERE `+where+`
+		ORDER BY l.timestamp DESC, l.id DESC LIMIT ? OFFSET ?`),
+		append(args, q.Limit, q.Offset)...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	listens := []Listen{}
+	for rows.Next() {
+		var l Listen
+		var timestamp time.Time
+		var artists string
+		if err := rows.Scan(&l.ID, &timestamp, &l.Title, &l.Album, &l.Url, &artists); err != nil {
+			return nil, 0, err
+		}
+		l.Timestamp = timestamp.Local().Format(time.RFC3339)
+		if len(artists) > 0 {
+			l.Artists = strings.Split(artists, "\x1f")
+		}
+		listens = append(listens, l)
+	}
+	return listens, total, rows.Err()
+}
+
+func (s *SQLStore) GetTopItems(ctx context.Context, kind string, from, to time.Time, limit, offset int) ([]TopItem, error) {
+	query, ok := topItemQueries[kind]
+	if !ok || kind == "players" {
+		// The player is not stored in these databases
+		return nil, fmt.Errorf("%w: unknown kind %q", ErrInvalidQuery, kind)
+	}
+	// The queries are shared with the SQLite database, whose plays are read from its partitions and daily counts too
+	where, args := sqlStoreConditions(ctx, from, to)
+	plays := "SELECT l.track, COALESCE(l.device, l.host) AS device, 1 AS plays FROM TrackLog l WHERE " + where
+	rows, err := s.DB.QueryContext(ctx, s.dialect.bind(fmt.Sprintf(query, plays)+" ORDER BY plays DESC, 1 LIMIT ? OFFSET ?"), append(args, limit, offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []TopItem{}
+	for rows.Next() {
+		var item TopItem
+		if err := rows.Scan(&item.Name, &item.Plays); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func (s *SQLStore) Close() error {
+	return s.DB.Close()
+}
@@ -0,0 +1,46 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+)
+
+// The statements used to store plays, each prepared on a connection the first time it is used there
+// and reused after, rather than being parsed again for every play
+type statements struct {
+	db       *sql.DB
+	lock     sync.Mutex
+	prepared map[string]*sql.Stmt
+}
+
+func newStatements(db *sql.DB) *statements {
+	return &statements{db: db, prepared: make(map[string]*sql.Stmt)}
+}
+
+// Get the statement for the query, preparing it if it has not been used before
+func (s *statements) prepare(ctx context.Context, query string) (*sql.Stmt, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if stmt, ok := s.prepared[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := s.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	s.prepared[query] = stmt
+	return stmt, nil
+}
+
+func (s *statements) close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var errs []error
+	for query, stmt := range s.prepared {
+		errs = append(errs, stmt.Close())
+		delete(s.prepared, query)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,71 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Store plays of a known track, each in its own transaction as the write queue does when plays arrive one at a time,
+// running the statements unprepared, prepared once, or prepared once with the track's IDs cached
+func BenchmarkStorePlay(b *testing.B) {
+	for _, bench := range []struct {
+		name      string
+		prepared  bool
+		cacheSize int // 0 caches nothing
+	}{
+		{"unprepared", false, 0},
+		{"prepared", true, 0},
+		{"prepared and cached", true, idCacheSize},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			// With the daemon's default journal mode and synchronous level, see SQLiteConfig.Pragmas
+			db, err := sql.Open(
+				"sqlite",
+				"file:"+filepath.Join(b.TempDir(), "bench.db")+"?_pragma=foreign_keys(1)&_pragma=journal_mode(wal)&_pragma=synchronous(normal)",
+			)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer db.Close()
+			if err := CreateDatabaseStructure(db); err != nil {
+				b.Fatal(err)
+			}
+			cache, stmts := newIDCache(bench.cacheSize), newStatements(db)
+			defer stmts.close()
+			m := &Metadata{
+				Title:  "Benchmark",
+				Artist: []string{"Artist"},
+				Album:  "Album",
+				Genre:  []string{"Genre"},
+				Url:    "file:///benchmark.mp3",
+				Length: int64(3 * time.Minute / time.Microsecond),
+			}
+			ctx := context.Background()
+			// Plays an hour apart, so that none is taken for a duplicate of the one before
+			start := time.Now().Add(-time.Duration(b.N+1) * time.Hour)
+			b.ResetTimer()
+			for i := range b.N {
+				tx, err := db.BeginTx(ctx, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				var batch *writeBatch
+				if bench.prepared {
+					batch = newWriteBatch(cache, stmts)
+				}
+				if err := writeQueued(withPlayedAt(ctx, start.Add(time.Duration(i)*time.Hour)), tx, m, batch); err != nil {
+					b.Fatal(err)
+				}
+				if err := tx.Commit(); err != nil {
+					b.Fatal(err)
+				}
+				batch.commit()
+			}
+		})
+	}
+}
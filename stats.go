@@ -0,0 +1,342 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Number of plays on a single day
+type DayCount struct {
+	Day   string `json:"day"`
+	Plays int    `json:"plays"`
+}
+
+// Number of plays for a named item (track, artist, album)
+type NameCount struct {
+	Name  string `json:"name"`
+	Plays int    `json:"plays"`
+}
+
+// Aggregate listening information for a single artist
+type ArtistStats struct {
+	Name        string        `json:"name"`
+	Plays       int           `json:"plays"`
+	Listened    time.Duration `json:"-"` // Only counting the plays whose time played was recorded
+	FirstPlayed string        `json:"firstPlayed"`
+	LastPlayed  string        `json:"lastPlayed"`
+	Timeline    []DayCount    `json:"timeline"`
+	TopTracks   []NameCount   `json:"topTracks"`
+	// The albums the artist is the album artist of
+	TopAlbums  []NameCount `json:"topAlbums"`
+	CoListened []NameCount `json:"coListened"`
+}
+
+// Aggregate listening information for a single album
+type AlbumStats struct {
+	Title       string        `json:"title"`
+	Plays       int           `json:"plays"`
+	Listened    time.Duration `json:"-"` // Only counting the plays whose time played was recorded
+	FirstPlayed string        `json:"firstPlayed"`
+	LastPlayed  string        `json:"lastPlayed"`
+	Timeline    []DayCount    `json:"timeline"`
+	TopTracks   []NameCount   `json:"topTracks"`
+	Artists     []NameCount   `json:"artists"`
+	// The album's tracks in the album's order, as far as their positions are known
+	Tracks []AlbumTrack `json:"tracks"`
+	// The album's artists, as tagged on its tracks
+	AlbumArtists []string `json:"albumArtists"`
+	// Whether the album is a compilation: its album artist is Various Artists,
+	// or it has none and its tracks are by several artists
+	VariousArtists bool `json:"variousArtists"`
+}
+
+// A track of an album, with its position on the album; 0 if it is not known
+type AlbumTrack struct {
+	Disc   int    `json:"disc,omitempty"`
+	Number int    `json:"number,omitempty"`
+	Title  string `json:"title"`
+	Plays  int    `json:"plays"`
+}
+
+// Album artists that taggers give to compilations
+var variousArtistsNames = []string{"various artists", "various", "va", "v.a."}
+
+// Get the play statistics for the artist, limiting lists to limit entries
+func GetArtistStats(ctx context.Context, db *sql.DB, name string, limit int) (*ArtistStats, error) {
+	stats := ArtistStats{Name: name}
+	var first, last sql.NullString
+	var listened int64
+	err := db.QueryRowContext(
+		ctx,
+		`SELECT COUNT(l.id), COALESCE(SUM(l.playedMs), 0), datetime(MIN(l.timestamp), 'localtime'), datetime(MAX(l.timestamp), 'localtime')
+		FROM TrackLogAll l
+		WHERE l.guest IS NULL AND l.user IS ? AND l.track IN (
+			SELECT tp.track FROM Track_Person tp JOIN Person p ON p.id = tp.person WHERE p.name = ?
+		)`,
+		userArg(ctx),
+		name,
+	).Scan(&stats.Plays, &listened, &first, &last)
+	if err != nil {
+		return nil, err
+	}
+	if stats.Plays == 0 {
+		return nil, sql.ErrNoRows
+	}
+	stats.Listened = time.Duration(listened) * time.Millisecond
+	stats.FirstPlayed, stats.LastPlayed = first.String, last.String
+	stats.Timeline, err = queryDayCounts(
+		ctx,
+		db,
+		`SELECT date(l.timestamp, 'localtime') AS day, COUNT(l.id)
+		FROM TrackLogAll l
+		WHERE l.guest IS NULL AND l.user IS ? AND l.track IN (
+			SELECT tp.track FROM Track_Person tp JOIN Person p ON p.id = tp.person WHERE p.name = ?
+		)
+		GROUP BY day ORDER BY day`,
+		userArg(ctx),
+		name,
+	)
+	if err != nil {
+		return nil, err
+	}
+	stats.TopTracks, err = queryNameCounts(
+		ctx,
+		db,
+		`SELECT t.title, COUNT(l.id) AS plays
+		FROM TrackLogAll l JOIN Track t ON t.id = l.track
+		WHERE l.guest IS NULL AND l.user IS ? AND t.id IN (
+			SELECT tp.track FROM Track_Person tp JOIN Person p ON p.id = tp.person WHERE p.name = ?
+		)
+		GROUP BY t.title ORDER BY plays DESC, t.title LIMIT ?`,
+		userArg(ctx),
+		name,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	stats.TopAlbums, err = queryNameCounts(
+		ctx,
+		db,
+		`SELECT a.title, COUNT(l.id) AS plays
+		FROM TrackLogAll l JOIN Track t ON t.id = l.track JOIN Album a ON a.id = t.album
+		WHERE l.guest IS NULL AND l.user IS ? AND a.id IN (
+			SELECT ap.album FROM Album_Person ap JOIN Person p ON p.id = ap.person WHERE p.name = ?
+		)
+		GROUP BY a.title ORDER BY plays DESC, a.title LIMIT ?`,
+		userArg(ctx),
+		name,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	// Co-listened artists are the other artists played on the same days as this one
+	stats.CoListened, err = queryNameCounts(
+		ctx,
+		db,
+		`SELECT p.name, COUNT(DISTINCT l.id) AS plays
+		FROM TrackLogAll l
+		JOIN Track_Person tp ON tp.track = l.track
+		JOIN Person p ON p.id = tp.person
+		WHERE l.guest IS NULL AND l.user IS ?3 AND p.name != ?1 AND date(l.timestamp, 'localtime') IN (
+			SELECT date(l2.timestamp, 'localtime') FROM TrackLogAll l2
+			JOIN Track_Person tp2 ON tp2.track = l2.track
+			JOIN Person p2 ON p2.id = tp2.person
+			WHERE l2.guest IS NULL AND l2.user IS ?3 AND p2.name = ?1
+		)
+		GROUP BY p.name ORDER BY plays DESC, p.name LIMIT ?2`,
+		name,
+		limit,
+		userArg(ctx),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// Get the play statistics for the album, limiting lists to limit entries
+func GetAlbumStats(ctx context.Context, db *sql.DB, title string, limit int) (*AlbumStats, error) {
+	stats := AlbumStats{Title: title}
+	var first, last sql.NullString
+	var listened int64
+	err := db.QueryRowContext(
+		ctx,
+		`SELECT COUNT(l.id), COALESCE(SUM(l.playedMs), 0), datetime(MIN(l.timestamp), 'localtime'), datetime(MAX(l.timestamp), 'localtime')
+		FROM TrackLogAll l JOIN Track t ON t.id = l.track JOIN Album a ON a.id = t.album
+		WHERE l.guest IS NULL AND l.user IS ? AND a.title = ?`,
+		userArg(ctx),
+		title,
+	).Scan(&stats.Plays, &listened, &first, &last)
+	if err != nil {
+		return nil, err
+	}
+	if stats.Plays == 0 {
+		return nil, sql.ErrNoRows
+	}
+	stats.Listened = time.Duration(listened) * time.Millisecond
+	stats.FirstPlayed, stats.LastPlayed = first.String, last.String
+	stats.Timeline, err = queryDayCounts(
+		ctx,
+		db,
+		`SELECT date(l.timestamp, 'localtime') AS day, COUNT(l.id)
+		FROM TrackLogAll l JOIN Track t ON t.id = l.track JOIN Album a ON a.id = t.album
+		WHERE l.guest IS NULL AND l.user IS ? AND a.title = ?
+		GROUP BY day ORDER BY day`,
+		userArg(ctx),
+		title,
+	)
+	if err != nil {
+		return nil, err
+	}
+	stats.TopTracks, err = queryNameCounts(
+		ctx,
+		db,
+		`SELECT t.title, COUNT(l.id) AS plays
+		FROM TrackLogAll l JOIN Track t ON t.id = l.track JOIN Album a ON a.id = t.album
+		WHERE l.guest IS NULL AND l.user IS ? AND a.title = ?
+		GROUP BY t.title ORDER BY plays DESC, t.title LIMIT ?`,
+		userArg(ctx),
+		title,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	stats.Artists, err = queryNameCounts(
+		ctx,
+		db,
+		`SELECT p.name, COUNT(DISTINCT l.id) AS plays
+		FROM TrackLogAll l
+		JOIN Track t ON t.id = l.track
+		JOIN Album a ON a.id = t.album
+		JOIN Track_Person tp ON tp.track = t.id
+		JOIN Person p ON p.id = tp.person
+		WHERE l.guest IS NULL AND l.user IS ? AND a.title = ?
+		GROUP BY p.name ORDER BY plays DESC, p.name LIMIT ?`,
+		userArg(ctx),
+		title,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	stats.Tracks, err = queryAlbumTracks(ctx, db, title)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(
+		ctx,
+		`SELECT DISTINCT p.name
+		FROM Album_Person ap JOIN Album a ON a.id = ap.album JOIN Person p ON p.id = ap.person
+		WHERE a.title = ? ORDER BY p.name`,
+		title,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		stats.AlbumArtists = append(stats.AlbumArtists, name)
+		if slices.Contains(variousArtistsNames, strings.ToLower(name)) {
+			stats.VariousArtists = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(stats.AlbumArtists) == 0 {
+		// Tracks stored before roles were recorded have none, so these only count as compilations by their artists
+		var artists int
+		err := db.QueryRowContext(
+			ctx,
+			`SELECT COUNT(DISTINCT tp.person)
+			FROM Track t JOIN Album a ON a.id = t.album JOIN Track_Person tp ON tp.track = t.id
+			WHERE a.title = ? AND tp.role = ?`,
+			title,
+			roleArtist,
+		).Scan(&artists)
+		if err != nil {
+			return nil, err
+		}
+		stats.VariousArtists = artists > 1
+	}
+	return &stats, nil
+}
+
+// Get the tracks of the album in order. Tracks without a position come last, by title.
+func queryAlbumTracks(ctx context.Context, db *sql.DB, title string) ([]AlbumTrack, error) {
+	rows, err := db.QueryContext(
+		ctx,
+		`SELECT COALESCE(t.discNumber, 0), COALESCE(t.trackNumber, 0), t.title,
+			(SELECT COUNT(l.id) FROM TrackLogAll l WHERE l.track = t.id AND l.guest IS NULL AND l.user IS ?)
+		FROM Track t JOIN Album a ON a.id = t.album
+		WHERE a.title = ?
+		ORDER BY t.trackNumber IS NULL, COALESCE(t.discNumber, 1), t.trackNumber, t.title`,
+		userArg(ctx),
+		title,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tracks []AlbumTrack
+	for rows.Next() {
+		var t AlbumTrack
+		if err := rows.Scan(&t.Disc, &t.Number, &t.Title, &t.Plays); err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, t)
+	}
+	return tracks, rows.Err()
+}
+
+func queryDayCounts(ctx context.Context, db *sql.DB, query string, args ...any) ([]DayCount, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var counts []DayCount
+	for rows.Next() {
+		var c DayCount
+		if err := rows.Scan(&c.Day, &c.Plays); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+func queryNameCounts(ctx context.Context, db *sql.DB, query string, args ...any) ([]NameCount, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var counts []NameCount
+	for rows.Next() {
+		var c NameCount
+		if err := rows.Scan(&c.Name, &c.Plays); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// A single entry in the track log, as the control interface lists it
+type Play struct {
+	Timestamp string // In local time
+	Title     string
+	Album     string
+	Artists   string
+}
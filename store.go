@@ -0,0 +1,102 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Where the history of plays is kept.
+// The SQLite database is always used, since the other features query it directly;
+// other stores are given every play in addition to it.
+type Store interface {
+	// Store a play of the track at the time in the context, returning ErrDuplicatePlay if it was already stored
+	StoreListen(ctx context.Context, m *Metadata) error
+	// Get the store's ID for the track, creating it with its album and persons if it is new
+	GetOrCreateTrack(ctx context.Context, m *Metadata) (int64, error)
+	// Get the plays matching the query, newest first, and the number of plays matching it across all pages
+	QueryListens(ctx context.Context, q ListenQuery) ([]Listen, int, error)
+	// Get the most played artists, albums, tracks, genres, players or devices between from and to
+	GetTopItems(ctx context.Context, kind string, from, to time.Time, limit, offset int) ([]TopItem, error)
+	Close() error
+}
+
+// The default store, in the SQLite database
+type SQLiteStore struct {
+	DB *sql.DB
+	// How long between plays starts a new listening session; 0 uses the default
+	SessionGap time.Duration
+	// The user whose plays are stored and queried, unless the context has one; "" for the default user
+	User string
+	// The host name of the machine plays are stored on, and an optional label for it, recorded with each play
+	Host   string
+	Device string
+}
+
+// Apply the store's settings to the context of a play or a query
+func (s *SQLiteStore) context(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(userKey{}).(string); !ok && len(s.User) > 0 {
+		ctx = WithUser(ctx, s.User)
+	}
+	if len(s.Host) > 0 || len(s.Device) > 0 {
+		ctx = withDevice(ctx, s.Host, s.Device)
+	}
+	return withSessionGap(ctx, s.SessionGap)
+}
+
+func (s *SQLiteStore) StoreListen(ctx context.Context, m *Metadata) error {
+	return StoreData(s.context(ctx), m, s.DB)
+}
+
+func (s *SQLiteStore) GetOrCreateTrack(ctx context.Context, m *Metadata) (int64, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	id, err := storeTrack(ctx, tx, m, nil)
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func (s *SQLiteStore) QueryListens(ctx context.Context, q ListenQuery) ([]Listen, int, error) {
+	return QueryListens(s.context(ctx), s.DB, q)
+}
+
+func (s *SQLiteStore) GetTopItems(ctx context.Context, kind string, from, to time.Time, limit, offset int) ([]TopItem, error) {
+	return GetTopItems(s.context(ctx), s.DB, kind, from, to, limit, offset)
+}
+
+// Does nothing, since the database is shared with the rest of the watcher and closed by its owner
+func (s *SQLiteStore) Close() error {
+	return nil
+}
+
+// A store in an in-memory SQLite database, whose plays are lost when it is closed, such as for tests
+// or for keeping statistics of only the current session. Every connection of the pool must open the
+// same database, such as with SQLite's memdb VFS.
+type MemoryStore struct {
+	SQLiteStore
+	// Held open, since the database is freed once its last connection is closed
+	conn *sql.Conn
+}
+
+// Create the tables in the in-memory database, which the store closes when it is closed
+func NewMemoryStore(ctx context.Context, db *sql.DB) (*MemoryStore, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := CreateDatabaseStructure(db); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &MemoryStore{SQLiteStore: SQLiteStore{DB: db}, conn: conn}, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return errors.Join(s.conn.Close(), s.DB.Close())
+}
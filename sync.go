@@ -0,0 +1,437 @@
+package music_watch
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrUnauthorized = errors.New("unauthorized")
+
+// The most plays sent or received at once
+const syncBatchSize = 500
+
+// A play as it is exchanged between databases by SyncPlays
+type SyncedPlay struct {
+	ExportedPlay
+	User     string `json:"user,omitempty"`
+	PlayedMs int64  `json:"playedMs,omitempty"`
+}
+
+// A database that plays are synced with
+type SyncPeer interface {
+	// Get up to limit plays with an ID after afterID, oldest first
+	Plays(ctx context.Context, afterID int64, limit int) ([]SyncedPlay, error)
+	// Store the plays that the database does not have yet, returning the number stored
+	AddPlays(ctx context.Context, plays []SyncedPlay) (int, error)
+}
+
+// The number of plays sent and received by SyncPlays
+type SyncResult struct {
+	Sent     int
+	Received int
+}
+
+// Crockford's base 32, which ULIDs are written in
+const ulidAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// Get a new identifier for a play at the time: a ULID, which is unique across databases
+// and sorts in the order the plays were played
+func newPlayUID(played time.Time) string {
+	var entropy [10]byte
+	rand.Read(entropy[:])
+	return formatULID(played, entropy)
+}
+
+// Get the identifier of a play that was given a UUID before plays had ULIDs, from its time and the UUID's last 80 bits,
+// so that every database converts the same play's UUID to the same ULID. Other identifiers are returned as they are.
+func playUID(uid string, played time.Time) string {
+	if len(uid) != 36 {
+		return uid
+	}
+	id, err := uuid.Parse(uid)
+	if err != nil {
+		return uid
+	}
+	var entropy [10]byte
+	copy(entropy[:], id[6:])
+	return formatULID(played, entropy)
+}
+
+// Write the time in milliseconds and the random bits as the 26 characters of a ULID
+func formatULID(t time.Time, entropy [10]byte) string {
+	var id [16]byte
+	binary.BigEndian.PutUint64(id[:8], uint64(t.UnixMilli())<<16)
+	copy(id[6:], entropy[:])
+	hi, lo := binary.BigEndian.Uint64(id[:8]), binary.BigEndian.Uint64(id[8:])
+	// 5 bits a character from the end, so that the first character only has the top 3
+	var text [26]byte
+	for i := len(text) - 1; i >= 0; i-- {
+		text[i] = ulidAlphabet[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(text[:])
+}
+
+// Give the plays stored before they had identifiers their own, and the plays given UUIDs ULIDs
+func backfillPlayUIDs(ctx context.Context, tx *sql.Tx) error {
+	tables, err := trackLogTables(ctx, tx)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		// Named as createTrackLogPartition names the partitions' copies of TrackLog's indexes
+		index := "TrackLog_uid" + strings.TrimPrefix(table, "TrackLog")
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s (uid)", index, table)); err != nil {
+			return err
+		}
+		rows, err := tx.QueryContext(
+			ctx,
+			"SELECT id, COALESCE(uid, ''), COALESCE(strftime('%Y-%m-%dT%H:%M:%SZ', timestamp), '') FROM "+table+" WHERE uid IS NULL OR length(uid) = 36",
+		)
+		if err != nil {
+			return err
+		}
+		uids := make(map[int64]string)
+		for rows.Next() {
+			var id int64
+			var uid, timestamp string
+			if err := rows.Scan(&id, &uid, &timestamp); err != nil {
+				rows.Close()
+				return err
+			}
+			// Plays without a time, which CheckDatabase finds, are given the current time
+			played, err := time.Parse(timestampLayout, timestamp)
+			if err != nil {
+				played = time.Now()
+			}
+			if len(uid) == 0 {
+				uids[id] = newPlayUID(played)
+			} else {
+				uids[id] = playUID(uid, played)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for id, uid := range uids {
+			if _, err := tx.ExecContext(ctx, "UPDATE "+table+" SET uid = ? WHERE id = ?", uid, id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Send the plays stored since the last sync with the peer to it, and store the plays it stored since, in both directions.
+// The peer is remembered by name, such as its path or URL. Plays are matched by their identifiers,
+// or by their track and time for plays that were copied between the databases before they had identifiers,
+// so each play is only stored once however often the databases are synced.
+// Changes to plays that both databases have, such as edits and deletions, are not synced.
+func SyncPlays(ctx context.Context, db *sql.DB, name string, peer SyncPeer) (SyncResult, error) {
+	var result SyncResult
+	var sent, received int64
+	err := db.QueryRowContext(ctx, "SELECT sent, received FROM SyncCursor WHERE peer = ?", name).Scan(&sent, &received)
+	if err != nil && err != sql.ErrNoRows {
+		return result, err
+	}
+	local := DatabasePeer{DB: db}
+	for {
+		plays, err := local.Plays(ctx, sent, syncBatchSize)
+		if err != nil {
+			return result, err
+		} else if len(plays) == 0 {
+			break
+		}
+		added, err := peer.AddPlays(ctx, plays)
+		if err != nil {
+			return result, err
+		}
+		result.Sent += added
+		sent = plays[len(plays)-1].ID
+		if err := setSyncCursor(ctx, db, name, sent, received); err != nil {
+			return result, err
+		}
+		if len(plays) < syncBatchSize {
+			break
+		}
+	}
+	// Plays received from the peer are sent back to it next time, and not stored again
+	for {
+		plays, err := peer.Plays(ctx, received, syncBatchSize)
+		if err != nil || len(plays) == 0 {
+			return result, err
+		}
+		added, err := local.AddPlays(ctx, plays)
+		if err != nil {
+			return result, err
+		}
+		result.Received += added
+		received = plays[len(plays)-1].ID
+		if err := setSyncCursor(ctx, db, name, sent, received); err != nil {
+			return result, err
+		}
+		if len(plays) < syncBatchSize {
+			return result, nil
+		}
+	}
+}
+
+func setSyncCursor(ctx context.Context, db *sql.DB, name string, sent, received int64) error {
+	_, err := db.ExecContext(
+		ctx,
+		`INSERT INTO SyncCursor (peer, sent, received, updated) VALUES (?, ?, ?, ?)
+		ON CONFLICT (peer) DO UPDATE SET sent = excluded.sent, received = excluded.received, updated = excluded.updated`,
+		name,
+		sent,
+		received,
+		formatTimestamp(time.Now()),
+	)
+	return err
+}
+
+// Another SQLite database, or the watcher's own
+type DatabasePeer struct {
+	DB *sql.DB
+}
+
+func (p DatabasePeer) Plays(ctx context.Context, afterID int64, limit int) ([]SyncedPlay, error) {
+	// Album artists and composers are left out, as they would be stored as the track's artists
+	rows, err := p.DB.QueryContext(
+		ctx,
+		`SELECT l.id, l.uid, strftime('%Y-%m-%dT%H:%M:%SZ', l.timestamp), COALESCE(t.title, ''), COALESCE(a.title, ''),
+			(
+				SELECT json_group_array(DISTINCT p.name)
+				FROM Track_Person tp JOIN Person p ON p.id = tp.person
+				WHERE tp.track = t.id AND COALESCE(tp.role, ?3) IN (?3, ?4)
+			),
+			COALESCE(t.url, ''), COALESCE(t.trackId, ''), COALESCE(l.guest, ''), COALESCE(l.user, ''), COALESCE(l.player, ''),
+			COALESCE(l.host, ''), COALESCE(l.device, ''), COALESCE(l.playedMs, 0), COALESCE(t.trackNumber, 0), COALESCE(t.discNumber, 0)
+		FROM TrackLogAll l
+		JOIN Track t ON t.id = l.track
+		LEFT JOIN Album a ON a.id = t.album
+		WHERE l.id > ?1
+		ORDER BY l.id LIMIT ?2`,
+		afterID,
+		limit,
+		roleArtist,
+		roleFeature,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	plays := []SyncedPlay{}
+	for rows.Next() {
+		var p SyncedPlay
+		var artists string
+		err := rows.Scan(
+			&p.ID, &p.UID, &p.Timestamp, &p.Title, &p.Album, &artists, &p.Url, &p.TrackId, &p.Guest, &p.User, &p.Player,
+			&p.Host, &p.Device, &p.PlayedMs, &p.TrackNumber, &p.DiscNumber,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(artists), &p.Artists); err != nil {
+			return nil, err
+		}
+		plays = append(plays, p)
+	}
+	return plays, rows.Err()
+}
+
+func (p DatabasePeer) AddPlays(ctx context.Context, plays []SyncedPlay) (int, error) {
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	tables, err := trackLogTables(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+	// Deleted plays are searched too, so that they are not brought back
+	exists := make([]string, len(tables))
+	for i, table := range tables {
+		exists[i] = "SELECT 1 FROM " + table + " WHERE uid = ?1 OR (track = ?2 AND timestamp = ?3)"
+	}
+	existsQuery := "SELECT COUNT(*) > 0 FROM (" + strings.Join(exists, " UNION ALL ") + ")"
+	added := 0
+	for _, play := range plays {
+		if len(play.UID) == 0 {
+			return 0, fmt.Errorf("%w: play %d has no uid", ErrInvalidQuery, play.ID)
+		}
+		played, err := time.Parse(time.RFC3339, play.Timestamp)
+		if err != nil {
+			return 0, fmt.Errorf("%w: play %d has an invalid timestamp: %w", ErrInvalidQuery, play.ID, err)
+		}
+		// Plays from databases that gave them UUIDs are matched by the ULIDs they are converted to
+		uid := playUID(play.UID, played)
+		playCtx := WithUser(withPlayedAt(ctx, played), play.User)
+		if len(play.Guest) > 0 {
+			playCtx = withGuest(playCtx, play.Guest)
+		}
+		m := Metadata{
+			Title:       play.Title,
+			Album:       play.Album,
+			Artist:      play.Artists,
+			Url:         play.Url,
+			TrackId:     play.TrackId,
+			TrackNumber: play.TrackNumber,
+			DiscNumber:  play.DiscNumber,
+		}
+		track, err := storeTrack(playCtx, tx, &m, nil)
+		if err != nil {
+			return 0, err
+		}
+		var found bool
+		if err := tx.QueryRowContext(ctx, existsQuery, uid, track, formatTimestamp(played)).Scan(&found); err != nil {
+			return 0, err
+		}
+		if found {
+			continue
+		}
+		res, err := tx.ExecContext(
+			ctx,
+			"INSERT INTO TrackLog (uid, track, timestamp, guest, user, host, device, player, playedMs) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			uid,
+			track,
+			formatTimestamp(played),
+			sql.NullString{String: play.Guest, Valid: len(play.Guest) > 0},
+			userArg(playCtx),
+			sql.NullString{String: play.Host, Valid: len(play.Host) > 0},
+			sql.NullString{String: play.Device, Valid: len(play.Device) > 0},
+			sql.NullString{String: play.Player, Valid: len(play.Player) > 0},
+			sql.NullInt64{Int64: play.PlayedMs, Valid: play.PlayedMs > 0},
+		)
+		if err != nil {
+			return 0, err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return 0, err
+		}
+		if err := assignSession(playCtx, tx, nil, id, played); err != nil {
+			return 0, err
+		}
+		added++
+	}
+	return added, tx.Commit()
+}
+
+// Another watcher, through the sync API of its HTTP interface
+type HTTPPeer struct {
+	// The root of its HTTP interface, such as http://desktop:8265
+	URL string
+	// Its http.syncToken
+	Token  string
+	Client *http.Client
+}
+
+func (p HTTPPeer) Plays(ctx context.Context, afterID int64, limit int) ([]SyncedPlay, error) {
+	query := url.Values{"after": {strconv.FormatInt(afterID, 10)}, "limit": {strconv.Itoa(limit)}}
+	var response struct {
+		Plays []SyncedPlay `json:"plays"`
+	}
+	err := p.do(ctx, http.MethodGet, "/api/sync/plays?"+query.Encode(), nil, &response)
+	return response.Plays, err
+}
+
+func (p HTTPPeer) AddPlays(ctx context.Context, plays []SyncedPlay) (int, error) {
+	body, err := json.Marshal(map[string]any{"plays": plays})
+	if err != nil {
+		return 0, err
+	}
+	var response struct {
+		Added int `json:"added"`
+	}
+	err = p.do(ctx, http.MethodPost, "/api/sync/plays", body, &response)
+	return response.Added, err
+}
+
+func (p HTTPPeer) do(ctx context.Context, method, path string, body []byte, response any) error {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(p.URL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	client := p.Client
+	if client == nil {
+		client = scrobbleClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && len(apiErr.Error) > 0 {
+			return fmt.Errorf("%s returned %s: %s", p.URL, resp.Status, apiErr.Error)
+		}
+		return fmt.Errorf("%s returned %s", p.URL, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+// Add the sync API, which other watchers use to send and receive plays with the token
+func registerSyncAPI(mux *http.ServeMux, db *sql.DB, token string) {
+	authorized := func(r *http.Request) bool {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		return ok && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+	}
+	peer := DatabasePeer{DB: db}
+	mux.HandleFunc("GET /api/sync/plays", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r) {
+			writeAPIResponse(w, r, nil, ErrUnauthorized)
+			return
+		}
+		after, err := strconv.ParseInt(r.URL.Query().Get("after"), 10, 64)
+		if err != nil {
+			writeAPIResponse(w, r, nil, fmt.Errorf("%w: after must be a play ID", ErrInvalidQuery))
+			return
+		}
+		limit, _, err := parseAPIPage(r)
+		if err != nil {
+			writeAPIResponse(w, r, nil, err)
+			return
+		}
+		plays, err := peer.Plays(r.Context(), after, limit)
+		writeAPIResponse(w, r, map[string]any{"plays": plays}, err)
+	})
+	mux.HandleFunc("POST /api/sync/plays", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r) {
+			writeAPIResponse(w, r, nil, ErrUnauthorized)
+			return
+		}
+		var request struct {
+			Plays []SyncedPlay `json:"plays"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<20)).Decode(&request); err != nil {
+			writeAPIResponse(w, r, nil, fmt.Errorf("%w: %w", ErrInvalidQuery, err))
+			return
+		}
+		added, err := peer.AddPlays(r.Context(), request.Plays)
+		writeAPIResponse(w, r, map[string]any{"added": added}, err)
+	})
+}
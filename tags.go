@@ -0,0 +1,89 @@
+package music_watch
+
+import (
+	"errors"
+	"io/fs"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/dhowden/tag"
+	"github.com/dhowden/tag/mbz"
+)
+
+// Settings for reading the tags of local files
+type TagsConfig struct {
+	// Fill in the fields that the player left out from the tags of the file at xesam:url
+	Read bool `json:"read"`
+}
+
+// Split a tag holding several values, as written by taggers that do not use repeated fields
+func splitTagValues(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == 0 })
+}
+
+// Fill in the fields that the player left out from the ID3, Vorbis, FLAC or MP4 tags of the track's file.
+// Tracks that are not local files, and files that cannot be read or have no tags, are left as they are.
+func ReadFileTags(m *Metadata) error {
+	if !strings.HasPrefix(m.Url, "file://") {
+		return nil
+	}
+	u, err := url.Parse(m.Url)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(u.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// Possibly a file on another host that is being played over the network
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	tags, err := tag.ReadFrom(f)
+	if errors.Is(err, tag.ErrNoTagsFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if len(m.Title) == 0 {
+		m.Title = tags.Title()
+	}
+	if len(m.Album) == 0 {
+		m.Album = tags.Album()
+	}
+	if len(m.Artist) == 0 && len(tags.Artist()) > 0 {
+		m.Artist = splitTagValues(tags.Artist())
+	}
+	if len(m.AlbumArtist) == 0 && len(tags.AlbumArtist()) > 0 {
+		m.AlbumArtist = splitTagValues(tags.AlbumArtist())
+	}
+	if len(m.Composer) == 0 && len(tags.Composer()) > 0 {
+		m.Composer = splitTagValues(tags.Composer())
+	}
+	if len(m.Genre) == 0 && len(tags.Genre()) > 0 {
+		m.Genre = splitTagValues(tags.Genre())
+	}
+	if m.TrackNumber == 0 {
+		m.TrackNumber, _ = tags.Track()
+	}
+	if m.DiscNumber == 0 {
+		m.DiscNumber, _ = tags.Disc()
+	}
+	if m.Year == 0 {
+		m.Year = tags.Year()
+	}
+	ids := mbz.Extract(tags)
+	if len(m.TrackId) == 0 {
+		m.TrackId = ids.Get(mbz.Recording)
+		if len(m.TrackId) == 0 && (tags.Format() == tag.VORBIS || tags.FileType() == tag.FLAC) {
+			// Vorbis comments hold the recording ID in MUSICBRAINZ_TRACKID
+			m.TrackId = ids.Get(mbz.Track)
+		}
+	}
+	if len(m.AlbumId) == 0 {
+		m.AlbumId = ids.Get(mbz.Album)
+	}
+	m.Clean()
+	return nil
+}
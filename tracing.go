@@ -0,0 +1,70 @@
+package music_watch
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Settings for exporting traces of how tracks move from players to the database
+type TracingConfig struct {
+	// The OTLP/HTTP endpoint to send traces to, e.g. http://localhost:4318.
+	// Empty disables tracing, unless the standard OTEL_EXPORTER_OTLP_ENDPOINT
+	// or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT variable is set.
+	Endpoint string `json:"endpoint"`
+}
+
+// Spans are recorded through the global provider, so they cost next to nothing until tracing is started
+var tracer = otel.Tracer("github.com/inventor500/music-watcher")
+
+// Start exporting traces, returning a function that sends the remaining spans and stops.
+// Does nothing if tracing is not configured.
+func StartTracing(ctx context.Context, config TracingConfig) (func(context.Context) error, error) {
+	var opts []otlptracehttp.Option
+	if len(config.Endpoint) > 0 {
+		opts = append(opts, otlptracehttp.WithEndpointURL(config.Endpoint))
+	} else if len(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")) == 0 && len(os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")) == 0 {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName("music-watcher")))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	slog.InfoContext(ctx, "Exporting traces", "Endpoint", config.Endpoint)
+	return provider.Shutdown, nil
+}
+
+// Attributes describing a track on spans
+func trackAttributes(m *Metadata) trace.SpanStartEventOption {
+	return trace.WithAttributes(
+		attribute.String("track.title", m.Title),
+		attribute.String("track.url", m.Url),
+		attribute.String("track.player", m.Player),
+	)
+}
+
+// End the span, marking it as failed if err is set
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,312 @@
+package music_watch
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var ErrInvalidWebhook = errors.New("invalid webhook payload")
+
+// The player names reported for tracks played by media servers
+const (
+	jellyfinPlayerName = "jellyfin"
+	plexPlayerName     = "plex"
+)
+
+// Settings for receiving plays from Jellyfin and Plex
+type WebhookConfig struct {
+	// Listen for webhooks on this address, e.g. :8266; empty disables
+	Address string `json:"address"`
+	// If set, webhooks must pass this as the token query parameter
+	Token string `json:"token"`
+	// Only log plays by these media server users; empty logs every user
+	Users []string `json:"users"`
+}
+
+// Receives plays from Jellyfin and Plex webhooks.
+//
+// Plex sends its webhooks to /plex.
+// Jellyfin's webhook plugin sends to /jellyfin using a generic destination,
+// with a template that includes any of NotificationType, NotificationUsername,
+// ItemType, ItemId, Name, Album, Artists, AlbumArtist, Provider_musicbrainztrack,
+// RunTimeTicks, PlaybackPositionTicks, IsPaused, DeviceName and ClientName.
+type WebhookSource struct {
+	Config WebhookConfig
+
+	lock    sync.Mutex
+	current map[string]*Metadata // The last track on each device
+}
+
+func (s *WebhookSource) Run(ctx context.Context, events chan<- Event) error {
+	s.current = make(map[string]*Metadata)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jellyfin", func(w http.ResponseWriter, r *http.Request) {
+		s.serve(w, r, events, parseJellyfinWebhook)
+	})
+	mux.HandleFunc("POST /plex", func(w http.ResponseWriter, r *http.Request) {
+		s.serve(w, r, events, parsePlexWebhook)
+	})
+	err := ServeHTTP(ctx, s.Config.Address, mux)
+	finishAllPlays(ctx, events, jellyfinPlayerName+":")
+	finishAllPlays(ctx, events, plexPlayerName+":")
+	return err
+}
+
+// What a webhook reports happened on a media server
+type webhookPlay struct {
+	Action   webhookAction
+	User     string
+	Device   string // Identifies the timer for the play
+	Track    *Metadata
+	Position time.Duration
+}
+
+type webhookAction int
+
+const (
+	webhookIgnore webhookAction = iota
+	webhookStart
+	webhookPause
+	webhookResume
+	webhookProgress
+	webhookStop
+)
+
+func (s *WebhookSource) serve(w http.ResponseWriter, r *http.Request, events chan<- Event, parse func(*http.Request) (*webhookPlay, error)) {
+	if len(s.Config.Token) > 0 && subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(s.Config.Token)) != 1 {
+		http.Error(w, "invalid token", http.StatusForbidden)
+		return
+	}
+	play, err := parse(r)
+	if err != nil {
+		recordDropped("invalid webhook", "Path", r.URL.Path, "Error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if play.Action == webhookIgnore {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if len(s.Config.Users) > 0 && !slices.Contains(s.Config.Users, play.User) {
+		slog.DebugContext(r.Context(), "Ignoring webhook for another user", "User", play.User)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	// Continue the media server's trace, if it sends one
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	if err := s.handle(ctx, events, play); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *WebhookSource) handle(ctx context.Context, events chan<- Event, play *webhookPlay) (err error) {
+	ctx, span := tracer.Start(ctx, "Webhook", trace.WithAttributes(attribute.String("device", play.Device)))
+	defer func() { endSpan(span, err) }()
+	play.Track.Clean()
+	slog.DebugContext(ctx, "Received webhook", "Device", play.Device, "Action", play.Action, "Title", play.Track.Title)
+	if play.Track.Cleared() && play.Action != webhookPause {
+		// Servers may report an empty item when a session ends
+		play.Action = webhookStop
+	}
+	switch play.Action {
+	case webhookStart, webhookResume, webhookProgress:
+		s.lock.Lock()
+		current, ok := s.current[play.Device]
+		isNew := !ok || !current.IsSameTrack(play.Track)
+		if isNew {
+			s.current[play.Device] = play.Track
+		}
+		s.lock.Unlock()
+		if isNew {
+			// Servers may not report the end of the previous track, so starting one finishes it
+			startPlay(ctx, events, play.Device, play.Track, true, play.Position)
+			return sendTrack(ctx, events, play.Track)
+		}
+		setPlaying(ctx, events, play.Device, true)
+		seekPlay(play.Device, play.Position)
+	case webhookPause:
+		setPlaying(ctx, events, play.Device, false)
+		seekPlay(play.Device, play.Position)
+	case webhookStop:
+		s.lock.Lock()
+		delete(s.current, play.Device)
+		s.lock.Unlock()
+		seekPlay(play.Device, play.Position)
+		finishPlay(ctx, events, play.Device)
+	}
+	return nil
+}
+
+// Ticks are 100 nanoseconds
+const jellyfinTick = 100 * time.Nanosecond
+
+type jellyfinPayload struct {
+	NotificationType      string
+	NotificationUsername  string
+	ItemType              string
+	ItemId                string
+	Name                  string
+	Album                 string
+	Artists               stringList
+	AlbumArtist           stringList
+	MusicBrainzTrack      string `json:"Provider_musicbrainztrack"`
+	RunTimeTicks          int64
+	PlaybackPositionTicks int64
+	IsPaused              bool
+	DeviceName            string
+	ClientName            string
+}
+
+func parseJellyfinWebhook(r *http.Request) (*webhookPlay, error) {
+	var payload jellyfinPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return nil, errors.Join(ErrInvalidWebhook, err)
+	}
+	play := webhookPlay{User: payload.NotificationUsername}
+	if payload.ItemType != "Audio" {
+		return &play, nil
+	}
+	switch payload.NotificationType {
+	case "PlaybackStart":
+		play.Action = webhookStart
+	case "PlaybackProgress":
+		play.Action = webhookProgress
+		if payload.IsPaused {
+			play.Action = webhookPause
+		}
+	case "PlaybackStop":
+		play.Action = webhookStop
+	default:
+		return &play, nil
+	}
+	play.Device = jellyfinPlayerName + ":" + payload.DeviceName + "/" + payload.ClientName
+	play.Position = time.Duration(payload.PlaybackPositionTicks) * jellyfinTick
+	play.Track = &Metadata{
+		Album:       payload.Album,
+		AlbumArtist: payload.AlbumArtist,
+		Url:         "jellyfin:" + payload.ItemId,
+		Artist:      payload.Artists,
+		TrackId:     payload.MusicBrainzTrack,
+		Title:       payload.Name,
+		Player:      jellyfinPlayerName,
+		Length:      (time.Duration(payload.RunTimeTicks) * jellyfinTick).Microseconds(),
+	}
+	return &play, nil
+}
+
+type plexPayload struct {
+	Event   string `json:"event"`
+	Account struct {
+		Title string `json:"title"`
+	} `json:"Account"`
+	Player struct {
+		Title string `json:"title"`
+		UUID  string `json:"uuid"`
+	} `json:"Player"`
+	Metadata struct {
+		Type             string `json:"type"`
+		Guid             string `json:"guid"`
+		Title            string `json:"title"`
+		ParentTitle      string `json:"parentTitle"`      // The album
+		GrandparentTitle string `json:"grandparentTitle"` // The album artist
+		OriginalTitle    string `json:"originalTitle"`    // The track artist, if different
+		Duration         int64  `json:"duration"`         // Milliseconds
+		ViewOffset       int64  `json:"viewOffset"`       // Milliseconds
+	} `json:"Metadata"`
+}
+
+// Plex sends the payload as JSON in a multipart form, alongside a thumbnail
+func parsePlexWebhook(r *http.Request) (*webhookPlay, error) {
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		return nil, errors.Join(ErrInvalidWebhook, err)
+	}
+	var payload plexPayload
+	if err := json.Unmarshal([]byte(r.FormValue("payload")), &payload); err != nil {
+		return nil, errors.Join(ErrInvalidWebhook, err)
+	}
+	play := webhookPlay{User: payload.Account.Title}
+	if payload.Metadata.Type != "track" {
+		return &play, nil
+	}
+	switch payload.Event {
+	case "media.play":
+		play.Action = webhookStart
+	case "media.resume":
+		play.Action = webhookResume
+	case "media.pause":
+		play.Action = webhookPause
+	case "media.stop":
+		play.Action = webhookStop
+	default:
+		// media.scrobble is Plex's own idea of a play, which is tracked here instead
+		return &play, nil
+	}
+	device := payload.Player.UUID
+	if len(device) == 0 {
+		device = payload.Player.Title
+	}
+	play.Device = plexPlayerName + ":" + device
+	play.Position = time.Duration(payload.Metadata.ViewOffset) * time.Millisecond
+	artist := payload.Metadata.OriginalTitle
+	if len(artist) == 0 {
+		artist = payload.Metadata.GrandparentTitle
+	}
+	play.Track = &Metadata{
+		Album:       payload.Metadata.ParentTitle,
+		AlbumArtist: nonEmpty(payload.Metadata.GrandparentTitle),
+		Url:         payload.Metadata.Guid,
+		Artist:      nonEmpty(artist),
+		Title:       payload.Metadata.Title,
+		Player:      plexPlayerName,
+		Length:      (time.Duration(payload.Metadata.Duration) * time.Millisecond).Microseconds(),
+	}
+	return &play, nil
+}
+
+// A list of names that may be sent as an array or as one comma-separated string
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		*l = list
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*l = nil
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); len(name) > 0 {
+			*l = append(*l, name)
+		}
+	}
+	return nil
+}
+
+func nonEmpty(s string) []string {
+	if len(s) == 0 {
+		return nil
+	}
+	return []string{s}
+}
+
+func (a webhookAction) String() string {
+	return [...]string{"ignore", "play", "pause", "resume", "progress", "stop"}[a]
+}
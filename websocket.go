@@ -0,0 +1,96 @@
+package music_watch
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Timing of the live feed's keepalive; clients that do not answer a ping in time are disconnected
+const (
+	liveWriteTimeout = 10 * time.Second
+	livePingInterval = 30 * time.Second
+	livePongTimeout  = livePingInterval + liveWriteTimeout
+)
+
+// Stream events that can be shared to a WebSocket client as JSON messages, starting with the current track.
+// This is meant for public pages, so it leaves out what the sinks leave out.
+func serveLive(w http.ResponseWriter, r *http.Request, c *Controller, config HTTPConfig) {
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if len(origin) == 0 || slices.Contains(config.Origins, "*") {
+			return true
+		}
+		for _, allowed := range config.Origins {
+			if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+				return true
+			}
+		}
+		return strings.HasSuffix(origin, "://"+r.Host)
+	}}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already replied
+		slog.DebugContext(r.Context(), "Unable to open live feed", "Remote", r.RemoteAddr, "Error", err)
+		return
+	}
+	defer conn.Close()
+	events, unsubscribe := c.Events.Subscribe()
+	defer unsubscribe()
+	// Messages from the client are not used, but must be read to handle pongs and closing
+	closed := make(chan struct{})
+	conn.SetReadDeadline(time.Now().Add(livePongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(livePongTimeout))
+	})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	send := func(e Event) bool {
+		conn.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+		return conn.WriteJSON(e) == nil
+	}
+	if current := c.NowPlaying(); current != nil {
+		e := Event{Type: EventNowPlaying, Time: time.Now(), Track: current}
+		if c.Shareable(r.Context(), e) && !send(e) {
+			return
+		}
+	}
+	slog.DebugContext(r.Context(), "Live feed opened", "Remote", r.RemoteAddr)
+	ping := time.NewTicker(livePingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if c.Shareable(r.Context(), e) && !send(e) {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(liveWriteTimeout)); err != nil {
+				return
+			}
+		case <-closed:
+			slog.DebugContext(r.Context(), "Live feed closed", "Remote", r.RemoteAddr)
+			return
+		case <-r.Context().Done():
+			conn.WriteControl(
+				websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, ""),
+				time.Now().Add(liveWriteTimeout),
+			)
+			return
+		}
+	}
+}
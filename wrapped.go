@@ -0,0 +1,192 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html/template"
+	"io"
+	"time"
+)
+
+// The listening of a month in a yearly recap
+type WrappedMonth struct {
+	Month      time.Month
+	Plays      int
+	TopArtists []TopItem
+}
+
+// The longest run of consecutive days with at least one play
+type Streak struct {
+	Days  int
+	Start time.Time
+	End   time.Time
+}
+
+// A recap of a year of listening
+type Wrapped struct {
+	Year         int
+	Generated    time.Time
+	Plays        int
+	Listened     time.Duration // See estimateListened
+	TotalArtists int
+	TopTracks    []TopItem
+	TopArtists   []TopItem
+	Months       []WrappedMonth
+	Streak       Streak
+	MostSkipped  []TopItem // Tracks by the number of plays that were skipped
+}
+
+// Build the recap of the year, with up to limit entries in each list
+func GetWrapped(ctx context.Context, db *sql.DB, year, limit int) (*Wrapped, error) {
+	from := time.Date(year, time.January, 1, 0, 0, 0, 0, time.Local)
+	to := from.AddDate(1, 0, 0)
+	w := Wrapped{Year: year, Generated: time.Now()}
+	where, args := playConditions(ctx, from, to)
+	plays, playsArgs := countedPlays(ctx, from, to)
+	if err := db.QueryRowContext(ctx, "SELECT COALESCE(SUM(l.plays), 0) FROM ("+plays+") l", playsArgs...).Scan(&w.Plays); err != nil {
+		return nil, err
+	}
+	err := db.QueryRowContext(
+		ctx,
+		`SELECT COUNT(DISTINCT tp.person) FROM (`+plays+`) l JOIN Track_Person tp ON tp.track = l.track`,
+		playsArgs...,
+	).Scan(&w.TotalArtists)
+	if err != nil {
+		return nil, err
+	}
+	if w.Listened, err = estimateListened(ctx, db, where, args); err != nil {
+		return nil, err
+	}
+	if w.TopTracks, err = GetTopItems(ctx, db, "tracks", from, to, limit, 0); err != nil {
+		return nil, err
+	}
+	if w.TopArtists, err = GetTopItems(ctx, db, "artists", from, to, limit, 0); err != nil {
+		return nil, err
+	}
+	for month := from; month.Before(to); month = month.AddDate(0, 1, 0) {
+		m := WrappedMonth{Month: month.Month()}
+		monthPlays, monthArgs := countedPlays(ctx, month, month.AddDate(0, 1, 0))
+		if err := db.QueryRowContext(ctx, "SELECT COALESCE(SUM(l.plays), 0) FROM ("+monthPlays+") l", monthArgs...).Scan(&m.Plays); err != nil {
+			return nil, err
+		}
+		if m.TopArtists, err = GetTopItems(ctx, db, "artists", month, month.AddDate(0, 1, 0), 3, 0); err != nil {
+			return nil, err
+		}
+		w.Months = append(w.Months, m)
+	}
+	if w.Streak, err = longestStreak(ctx, db, where, args); err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(
+		ctx,
+		`SELECT t.title, COUNT(l.id) AS skips
+		FROM TrackLogAll l
+		JOIN Track t ON t.id = l.track
+		WHERE `+where+` AND l.skipped = 1
+		GROUP BY t.title ORDER BY skips DESC, 1 LIMIT ?`,
+		append(args, limit)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item TopItem
+		if err := rows.Scan(&item.Name, &item.Plays); err != nil {
+			return nil, err
+		}
+		w.MostSkipped = append(w.MostSkipped, item)
+	}
+	return &w, rows.Err()
+}
+
+// Find the longest run of consecutive days with plays matching the conditions on TrackLogAll l
+func longestStreak(ctx context.Context, db *sql.DB, where string, args []any) (Streak, error) {
+	days, err := queryStrings(ctx, db, "SELECT DISTINCT date(l.timestamp, 'localtime') AS day FROM TrackLogAll l WHERE "+where+" ORDER BY day", args...)
+	if err != nil {
+		return Streak{}, err
+	}
+	var longest, current Streak
+	var previous time.Time
+	for _, value := range days {
+		day, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+		if err != nil {
+			return Streak{}, err
+		}
+		if current.Days > 0 && previous.AddDate(0, 0, 1).Equal(day) {
+			current.Days++
+		} else {
+			current = Streak{Days: 1, Start: day}
+		}
+		current.End = day
+		previous = day
+		if current.Days > longest.Days {
+			longest = current
+		}
+	}
+	return longest, nil
+}
+
+var wrappedPage = template.Must(template.New("wrapped").Funcs(template.FuncMap{
+	"date":    func(t time.Time) string { return t.Format("January 2") },
+	"hours":   func(d time.Duration) string { return fmt.Sprintf("%.0f", d.Hours()) },
+	"percent": func(n, of int) int { return 100 * n / of },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Year}} in music</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 0; background: #14161a; color: #e8e8ec; }
+main { max-width: 56rem; margin: 0 auto; padding: 2rem 1rem; }
+h1 { font-size: 3rem; margin-bottom: 0.25rem; }
+h2 { color: #f0a04b; margin-top: 2.5rem; }
+.totals { display: flex; flex-wrap: wrap; gap: 1rem; }
+.total { background: #1f2228; border-radius: 0.5rem; padding: 1rem 1.5rem; }
+.total strong { display: block; font-size: 2rem; }
+ol { padding-left: 1.5rem; }
+li { margin: 0.25rem 0; }
+.count { color: #9a9ca5; }
+.months { display: grid; grid-template-columns: repeat(auto-fill, minmax(12rem, 1fr)); gap: 1rem; }
+.month { background: #1f2228; border-radius: 0.5rem; padding: 0.75rem 1rem; }
+.month h3 { margin: 0 0 0.5rem; }
+.bar { height: 0.4rem; background: #f0a04b; border-radius: 0.2rem; margin-bottom: 0.5rem; }
+footer { color: #9a9ca5; margin-top: 3rem; font-size: 0.85rem; }
+</style>
+</head>
+<body>
+<main>
+<h1>{{.Year}} in music</h1>
+<div class="totals">
+<div class="total"><strong>{{.Plays}}</strong>plays</div>
+<div class="total"><strong>{{hours .Listened}}</strong>hours, roughly</div>
+<div class="total"><strong>{{.TotalArtists}}</strong>artists</div>
+{{with .Streak}}{{if .Days}}<div class="total"><strong>{{.Days}}</strong>{{if eq .Days 1}}day of listening at most, on {{date .Start}}{{else}}days in a row, {{date .Start}} to {{date .End}}{{end}}</div>{{end}}{{end}}
+</div>
+{{define "list"}}<ol>{{range .}}<li>{{.Name}} <span class="count">{{.Plays}}</span></li>{{end}}</ol>{{end}}
+{{with .TopTracks}}<h2>Top tracks</h2>{{template "list" .}}{{end}}
+{{with .TopArtists}}<h2>Top artists</h2>{{template "list" .}}{{end}}
+<h2>Month by month</h2>
+<div class="months">
+{{$max := 1}}{{range .Months}}{{if gt .Plays $max}}{{$max = .Plays}}{{end}}{{end}}
+{{range .Months}}<div class="month">
+<h3>{{.Month}}</h3>
+<div class="bar" style="width: {{percent .Plays $max}}%"></div>
+<div class="count">{{.Plays}} plays</div>
+{{with .TopArtists}}{{template "list" .}}{{end}}
+</div>
+{{end}}
+</div>
+{{with .MostSkipped}}<h2>Most skipped</h2>{{template "list" .}}{{end}}
+<footer>Generated by music-watcher on {{.Generated.Format "2006-01-02"}}</footer>
+</main>
+</body>
+</html>
+`))
+
+// Write the recap as a self-contained HTML page
+func (w *Wrapped) Render(out io.Writer) error {
+	return wrappedPage.Execute(out, w)
+}
@@ -0,0 +1,244 @@
+package music_watch
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrQueueClosed = errors.New("write queue is closed")
+
+const (
+	// The most plays waiting to be written, unless configured
+	defaultWriteQueueSize = 256
+	// The most plays written in one transaction
+	maxWriteBatch = 64
+)
+
+// A play waiting to be written, with the context it was received with
+type queuedWrite struct {
+	ctx context.Context
+	m   *Metadata
+}
+
+// Stores plays in the SQLite database from a goroutine of its own, so that a slow disk does not hold up
+// the watcher while it handles signals. Plays that arrive while a transaction is being written are
+// written together in the next one. Queries are answered from the database, without the queued plays.
+type WriteQueue struct {
+	SQLiteStore
+	// Called from the writer with each play once it is stored
+	onStored func(ctx context.Context, m *Metadata)
+	// The IDs of the rows of recently played tracks, so that playing them again only adds to the log
+	ids   *idCache
+	stmts *statements
+
+	queue  chan queuedWrite
+	done   chan struct{}
+	lock   sync.Mutex
+	closed bool
+	// When each track's latest queued play was played, by user and trackKey, for finding duplicates before they are written
+	pending map[string]time.Time
+}
+
+// Start writing plays to the database, holding up to size plays at once; 0 uses the default.
+// onStored, which may be nil, is called with each play after it is written, such as to mirror it elsewhere.
+func NewWriteQueue(db *sql.DB, size int, onStored func(ctx context.Context, m *Metadata)) *WriteQueue {
+	if size <= 0 {
+		size = defaultWriteQueueSize
+	}
+	q := &WriteQueue{
+		SQLiteStore: SQLiteStore{DB: db},
+		onStored:    onStored,
+		ids:         newIDCache(idCacheSize),
+		stmts:       newStatements(db),
+		queue:       make(chan queuedWrite, size),
+		done:        make(chan struct{}),
+		pending:     make(map[string]time.Time),
+	}
+	go q.run()
+	return q
+}
+
+// Queue the play to be written, returning ErrDuplicatePlay if it was already stored or queued.
+// When the queue is full, the play is written at once.
+func (q *WriteQueue) StoreListen(ctx context.Context, m *Metadata) error {
+	// The play is written later, so its time is fixed now
+	played := playedAt(ctx)
+	ctx = q.context(withPlayedAt(ctx, played))
+	key := playUser(ctx) + "\x00" + trackKey(m.Url, m.Title)
+	window := max(time.Duration(m.Length)*time.Microsecond-duplicatePlaySlack, duplicatePlaySlack)
+	q.lock.Lock()
+	last, ok := q.pending[key]
+	q.lock.Unlock()
+	if ok && last.After(played.Add(-window)) && !last.After(played) {
+		return ErrDuplicatePlay
+	}
+	if duplicate, err := q.wasPlayed(ctx, m, played, window); err != nil {
+		return err
+	} else if duplicate {
+		return ErrDuplicatePlay
+	}
+	q.lock.Lock()
+	if q.closed {
+		q.lock.Unlock()
+		return ErrQueueClosed
+	}
+	select {
+	case q.queue <- queuedWrite{ctx: context.WithoutCancel(ctx), m: m}:
+		q.pending[key] = played
+		q.lock.Unlock()
+		return nil
+	default:
+		q.lock.Unlock()
+	}
+	slog.WarnContext(ctx, "Write queue is full, storing the play at once", "Track", m.Title, "Size", cap(q.queue))
+	if err := StoreData(ctx, m, q.DB); err != nil {
+		return err
+	}
+	if q.onStored != nil {
+		q.onStored(ctx, m)
+	}
+	return nil
+}
+
+// Get whether the track was stored within the window before it was played, without finding or creating the track
+func (q *WriteQueue) wasPlayed(ctx context.Context, m *Metadata, played time.Time, window time.Duration) (bool, error) {
+	track, args := trackIDQuery(m)
+	stmt, err := q.stmts.prepare(
+		ctx,
+		"SELECT COUNT(*) > 0 FROM TrackLog l WHERE l.track = "+track+" AND l.timestamp > ? AND l.timestamp <= ? AND l.user IS ? AND l.deleted IS NULL",
+	)
+	if err != nil {
+		return false, err
+	}
+	var duplicate bool
+	err = stmt.QueryRowContext(
+		ctx,
+		append(args, formatTimestamp(played.Add(-window)), formatTimestamp(played), userArg(ctx))...,
+	).Scan(&duplicate)
+	return duplicate, err
+}
+
+// Write the queued plays until the queue is closed, taking all that are waiting at once
+func (q *WriteQueue) run() {
+	defer close(q.done)
+	for w := range q.queue {
+		batch := []queuedWrite{w}
+	fill:
+		for len(batch) < maxWriteBatch {
+			select {
+			case w, ok := <-q.queue:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, w)
+			default:
+				break fill
+			}
+		}
+		q.write(batch)
+	}
+}
+
+// Write the plays in one transaction. Each is written in a savepoint, so that one failing does not lose the others.
+func (q *WriteQueue) write(batch []queuedWrite) {
+	ctx := context.Background()
+	errs := make([]error, len(batch))
+	err := retryLocked(ctx, func() error {
+		tx, err := q.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		txBatch := newWriteBatch(q.ids, q.stmts)
+		for i, w := range batch {
+			errs[i] = writeQueued(w.ctx, tx, w.m, txBatch)
+			if isBusy(errs[i]) {
+				return errs[i]
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		txBatch.commit()
+		return nil
+	}, "Count", len(batch))
+	q.lock.Lock()
+	for _, w := range batch {
+		key := playUser(w.ctx) + "\x00" + trackKey(w.m.Url, w.m.Title)
+		if q.pending[key].Equal(playedAt(w.ctx)) {
+			delete(q.pending, key)
+		}
+	}
+	q.lock.Unlock()
+	if err != nil {
+		slog.Error("Failed to store queued plays", "Count", len(batch), "Error", err)
+		return
+	}
+	for i, w := range batch {
+		switch {
+		case errors.Is(errs[i], ErrDuplicatePlay):
+			slog.InfoContext(w.ctx, "Track was already stored for this play, skipping it", "Title", w.m.Title, "Player", w.m.Player)
+		case errs[i] != nil:
+			slog.ErrorContext(w.ctx, "Failed to store value", "Track", w.m.Title, "Album", w.m.Album, "Error", errs[i])
+		case q.onStored != nil:
+			q.onStored(w.ctx, w.m)
+		}
+	}
+}
+
+// Write one play of a batch, undoing its changes if it fails
+func writeQueued(ctx context.Context, tx *sql.Tx, m *Metadata, txBatch *writeBatch) (err error) {
+	if len(m.Title) == 0 && len(m.Url) == 0 {
+		slog.Info("Received track with no title or url")
+		return nil
+	}
+	ctx, span := tracer.Start(ctx, "StoreData", trackAttributes(m))
+	defer func() {
+		if errors.Is(err, ErrDuplicatePlay) {
+			span.End()
+			return
+		}
+		endSpan(span, err)
+	}()
+	for retried := false; ; retried = true {
+		if _, err := txBatch.exec(ctx, tx, "SAVEPOINT play"); err != nil {
+			return err
+		}
+		batch := txBatch.begin()
+		err := insertPlay(ctx, tx, m, batch)
+		if err == nil {
+			if _, err := txBatch.exec(ctx, tx, "RELEASE play"); err != nil {
+				return err
+			}
+			batch.commit()
+			return nil
+		}
+		if _, rollbackErr := txBatch.exec(ctx, tx, "ROLLBACK TO play"); rollbackErr != nil {
+			return errors.Join(err, rollbackErr)
+		}
+		txBatch.exec(ctx, tx, "RELEASE play")
+		if retried || txBatch == nil || !strings.Contains(err.Error(), "FOREIGN KEY constraint failed") {
+			return err
+		}
+		// A cached row was merged or deleted, such as by the library command; look them up again
+		slog.DebugContext(ctx, "Cached IDs are out of date, clearing them", "Track", m.Title)
+		txBatch.cache.clear()
+	}
+}
+
+// Stop accepting plays and wait for the queued ones to be written. The database is left open.
+func (q *WriteQueue) Close() error {
+	q.lock.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.queue)
+	}
+	q.lock.Unlock()
+	<-q.done
+	return q.stmts.close()
+}